@@ -0,0 +1,76 @@
+// charon-key-read-keys prints an authorized_keys file charon-key can't read
+// itself when running unprivileged (--unprivileged --read-helper)
+//
+// It is the only privileged part of such a setup: install it executable by
+// the charon-key group only, with just the capability to read any file:
+//
+//	install -o root -g charon-key -m 0750 charon-key-read-keys /usr/local/libexec/
+//	setcap cap_dac_read_search+ep /usr/local/libexec/charon-key-read-keys
+//
+// It only prints the user's key files: the default authorized_keys files
+// in the home directory and the patterns listed in
+// /etc/charon-key/read-keys.conf, owned by the user or root and reached
+// without symbolic links below the home directory
+//
+// Usage: charon-key-read-keys USERNAME PATH
+// Exits 0 with the file on stdout, 2 if the file doesn't exist, 1 on errors
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/dgarifullin/charon-key/internal/ssh"
+)
+
+// maxSize caps the file size, as no authorized_keys file is this large
+const maxSize = 1 << 20
+
+// safePath replaces the caller's PATH, which getent is looked up in
+const safePath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+func main() {
+	if len(os.Args) != 3 || !filepath.IsAbs(os.Args[2]) {
+		fmt.Fprintln(os.Stderr, "usage: charon-key-read-keys USERNAME ABSOLUTE-PATH")
+		os.Exit(1)
+	}
+	username, path := os.Args[1], os.Args[2]
+	os.Setenv("PATH", safePath)
+
+	patterns, err := ssh.LoadKeyFilePatterns(ssh.KeyFilePatternsPath)
+	if err != nil {
+		fail(err)
+	}
+	u, err := ssh.LookupUser(username, ssh.PasswdAuto)
+	if err != nil {
+		fail(fmt.Errorf("failed to look up user %q: %w", username, err))
+	}
+	file, err := ssh.OpenUserKeyFile(u, path, patterns)
+	if errors.Is(err, os.ErrNotExist) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fail(err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		fail(err)
+	}
+	if info.Size() > maxSize {
+		fail(fmt.Errorf("%s: larger than %d bytes", path, maxSize))
+	}
+	if _, err := io.Copy(os.Stdout, io.LimitReader(file, maxSize)); err != nil {
+		fail(err)
+	}
+}
+
+// fail prints err and exits 1
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dgarifullin/charon-key/internal/access"
+	"github.com/dgarifullin/charon-key/internal/errors"
+)
+
+// checkAccess confirms the SSH user holds an active just-in-time grant
+// Denials and unreachable access systems are logged and returned as
+// *errors.AppError, so no GitHub keys are emitted (fail closed)
+func (a *app) checkAccess(username string) error {
+	if a.access == nil {
+		return nil
+	}
+	log := a.log
+
+	ctx, cancel := context.WithTimeout(context.Background(), access.DefaultTimeout)
+	defer cancel()
+	grant, err := a.access.Check(ctx, username, a.cfg.Hostname)
+	if err != nil {
+		log.Error("failed to check access grant", "ssh_username", username, "error", err)
+		a.alertResolutionFailed(username, err)
+		return errors.NewAppError("failed to check access grant", errors.ExitNetworkError, err)
+	}
+
+	if !grant.Active(time.Now()) {
+		log.Warn("no active access grant, denying access", "ssh_username", username, "approved", grant.Approved, "expires_at", grant.ExpiresAt, "reason", grant.Reason)
+		return errors.NewAppError("access denied", errors.ExitAccessDenied, fmt.Errorf("no active grant for SSH user %s", username))
+	}
+
+	log.Debug("access grant confirmed", "ssh_username", username, "expires_at", grant.ExpiresAt)
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dgarifullin/charon-key/internal/audit"
+)
+
+// Alert event types, sent to --alert-webhook when the auth path degrades
+const (
+	eventStaleCache       = "stale_cache"
+	eventResolutionFailed = "resolution_failed"
+	eventDenyOnEmpty      = "deny_on_empty"
+	eventPolicyViolation  = "policy_violation"
+	eventKeysetChanged    = "keyset_changed"
+)
+
+// alert sends an event to the alert webhook, if one is configured
+func (a *app) alert(event audit.Event) {
+	if a.cfg.AlertWebhook == "" {
+		return
+	}
+	a.postEvent(a.cfg.AlertWebhook, event)
+}
+
+// postEvent stamps the event with this host, the correlation ID and the
+// current time, and POSTs it
+// Delivery failures are logged; they never affect the keys served
+func (a *app) postEvent(url string, event audit.Event) {
+	event.Hostname = a.cfg.Hostname
+	event.CorrelationID = a.log.CorrelationID()
+	event.Time = time.Now().UTC()
+
+	ctx, cancel := context.WithTimeout(context.Background(), audit.DefaultWebhookTimeout)
+	defer cancel()
+	if err := audit.NewWebhook(url).Send(ctx, event); err != nil {
+		a.log.Error("failed to send webhook", "event", event.Type, "error", err)
+	}
+}
+
+// alertStaleCache reports expired cache served because GitHub failed
+func (a *app) alertStaleCache(githubUser string, cachedAt time.Time, err error) {
+	a.alert(audit.Event{
+		Type:       eventStaleCache,
+		Severity:   audit.SeverityWarning,
+		Text:       fmt.Sprintf("charon-key served keys of GitHub user %s from a stale cache (fetched %s)", githubUser, cachedAt.UTC().Format(time.RFC3339)),
+		GitHubUser: githubUser,
+		Error:      err.Error(),
+	})
+}
+
+// alertResolutionFailed reports an SSH user whose keys could not be resolved
+func (a *app) alertResolutionFailed(username string, err error) {
+	a.alert(audit.Event{
+		Type:        eventResolutionFailed,
+		Severity:    audit.SeverityError,
+		Text:        fmt.Sprintf("charon-key failed to resolve keys for SSH user %s", username),
+		SSHUsername: username,
+		Error:       err.Error(),
+	})
+}
+
+// alertPolicyRejections reports keys dropped by a policy (e.g. "FIPS")
+func (a *app) alertPolicyRejections(username, policy string, rejected int) {
+	if rejected == 0 {
+		return
+	}
+	a.alert(audit.Event{
+		Type:        eventPolicyViolation,
+		Severity:    audit.SeverityWarning,
+		Text:        fmt.Sprintf("charon-key rejected %d key(s) for SSH user %s by %s policy", rejected, username, policy),
+		SSHUsername: username,
+	})
+}
+
+// alertInvalidKey reports a malformed key, just before charon-key terminates
+func (a *app) alertInvalidKey(username string) {
+	a.alert(audit.Event{
+		Type:        eventPolicyViolation,
+		Severity:    audit.SeverityCritical,
+		Text:        fmt.Sprintf("charon-key got an invalid key for SSH user %s and terminated", username),
+		SSHUsername: username,
+	})
+}
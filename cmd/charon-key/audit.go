@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dgarifullin/charon-key/internal/errors"
+	"github.com/dgarifullin/charon-key/internal/report"
+	"github.com/dgarifullin/charon-key/internal/ssh"
+)
+
+// auditRules are the kinds of drift in SARIF audit reports
+var auditRules = []report.Rule{
+	{ID: "missing-key", Description: "A key charon-key serves is not in authorized_keys yet"},
+	{ID: "stale-key", Description: "A managed key in authorized_keys is no longer served"},
+	{ID: "unknown-key", Description: "A key outside the managed block is not served by charon-key"},
+	{ID: "audit-error", Description: "The SSH user could not be audited"},
+}
+
+// auditReport is the drift of one SSH user's authorized_keys
+type auditReport struct {
+	SSHUsername    string     `json:"ssh_username"`
+	AuthorizedKeys string     `json:"authorized_keys,omitempty"`
+	Served         int        `json:"served"`
+	Missing        []auditKey `json:"missing,omitempty"`
+	Stale          []auditKey `json:"stale,omitempty"`
+	Unknown        []auditKey `json:"unknown,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// auditKey identifies a key in a report
+type auditKey struct {
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Key         string `json:"key"`
+}
+
+// runAudit compares, for every SSH user of the static user map, the keys in
+// their authorized_keys with the keys charon-key would serve now, and
+// reports missing, stale and unknown keys
+// Usage: charon-key audit [OPTIONS] [--format text|json|csv|sarif]
+func runAudit(args []string) {
+	var opts options
+	var format string
+	fs := newFlagSet("charon-key audit", &opts)
+	fs.StringVar(&format, "format", "text", "Report format: text|json|csv|sarif")
+	fs.Parse(args)
+	handleInfoFlags(opts)
+	applyErrorFormat(opts)
+
+	log := newLogger(opts)
+
+	if format != "text" && format != "json" && format != "csv" && format != "sarif" {
+		err := fmt.Errorf("invalid format %q (expected text, json, csv or sarif)", format)
+		log.Error("configuration error", "error", err)
+		errors.ExitWithError(errors.NewAppError("configuration error", errors.ExitConfigError, err))
+	}
+
+	a, err := newApp(opts, log)
+	if err != nil {
+		errors.ExitWithError(err)
+	}
+	a.breakGlassKeys = loadBreakGlassKeys(opts, log)
+
+	usernames := a.mappedUsers()
+	log.Info("starting charon-key audit", "version", version, "users", len(usernames))
+
+	// Keep going after a failed user; the exit code reports the first failure
+	var firstErr error
+	reports := make([]auditReport, 0, len(usernames))
+	drifted := 0
+	for _, username := range usernames {
+		report, err := a.auditUser(username)
+		if err != nil {
+			log.Error("failed to audit SSH user", "ssh_username", username, "error", err)
+			report.Error = err.Error()
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if len(report.Missing)+len(report.Stale)+len(report.Unknown) > 0 {
+			drifted++
+		}
+		reports = append(reports, report)
+	}
+
+	hostname, _ := os.Hostname()
+	switch format {
+	case "json":
+		data, _ := json.MarshalIndent(map[string]any{"hostname": hostname, "users": reports}, "", "  ")
+		fmt.Println(string(data))
+	case "csv":
+		writeAuditCSV(hostname, reports)
+	case "sarif":
+		report.WriteSARIF(os.Stdout, "charon-key", version, auditRules, auditFindings(reports))
+	default:
+		printAuditReports(reports)
+	}
+
+	log.Info("audit complete", "users", len(reports), "drifted", drifted)
+	a.logSummary(firstErr)
+	if firstErr != nil {
+		errors.ExitWithError(firstErr)
+	}
+	errors.ExitWithCode(errors.ExitSuccess)
+}
+
+// auditUser builds the drift report of one SSH user
+// Missing keys are only reported in sync mode; otherwise served keys are
+// never written to authorized_keys
+func (a *app) auditUser(username string) (auditReport, error) {
+	report := auditReport{SSHUsername: username}
+
+	served, err := a.keysForUser(username)
+	if err != nil {
+		return report, err
+	}
+	report.Served = len(served)
+
+	sshManager, err := a.sshManagerFor(username)
+	if err != nil {
+		return report, errors.NewAppError("failed to initialize SSH manager", errors.ExitPermissionError, err)
+	}
+	report.AuthorizedKeys = sshManager.GetAuthorizedKeysPath()
+
+	managed, err := sshManager.ReadManagedKeys()
+	if err != nil {
+		return report, errors.NewAppError("failed to read authorized_keys", errors.ExitPermissionError, err)
+	}
+	unmanaged, err := sshManager.ReadExistingKeys()
+	if err != nil {
+		return report, errors.NewAppError("failed to read authorized_keys", errors.ExitPermissionError, err)
+	}
+
+	drift := ssh.CompareKeys(served, managed, unmanaged)
+	if a.cfg.Sync {
+		report.Missing = auditKeys(drift.Missing)
+	}
+	report.Stale = auditKeys(drift.Stale)
+	report.Unknown = auditKeys(drift.Unknown)
+	return report, nil
+}
+
+// auditKeys describes keys for a report
+func auditKeys(keys []string) []auditKey {
+	var result []auditKey
+	for _, key := range keys {
+		fingerprint, _ := ssh.Fingerprint(key)
+		result = append(result, auditKey{Fingerprint: fingerprint, Key: key})
+	}
+	return result
+}
+
+// printAuditReports writes the reports as text, one line per SSH user
+// followed by one line per drifted key
+func printAuditReports(reports []auditReport) {
+	for _, report := range reports {
+		if report.Error != "" {
+			fmt.Printf("%s: error: %s\n", report.SSHUsername, report.Error)
+			continue
+		}
+		fmt.Printf("%s: %d served, %d missing, %d stale, %d unknown (%s)\n", report.SSHUsername, report.Served,
+			len(report.Missing), len(report.Stale), len(report.Unknown), report.AuthorizedKeys)
+		for _, group := range []struct {
+			name string
+			keys []auditKey
+		}{{"missing", report.Missing}, {"stale", report.Stale}, {"unknown", report.Unknown}} {
+			for _, key := range group.keys {
+				fmt.Printf("  %-8s %s %s\n", group.name, key.Fingerprint, key.Key)
+			}
+		}
+	}
+}
+
+// writeAuditCSV writes one row per drifted key, and per SSH user that
+// couldn't be audited
+func writeAuditCSV(hostname string, reports []auditReport) {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"hostname", "ssh_username", "authorized_keys", "status", "fingerprint", "key", "error"})
+	for _, r := range reports {
+		if r.Error != "" {
+			w.Write([]string{hostname, r.SSHUsername, r.AuthorizedKeys, "error", "", "", r.Error})
+		}
+		for _, group := range []struct {
+			status string
+			keys   []auditKey
+		}{{"missing", r.Missing}, {"stale", r.Stale}, {"unknown", r.Unknown}} {
+			for _, key := range group.keys {
+				w.Write([]string{hostname, r.SSHUsername, r.AuthorizedKeys, group.status, key.Fingerprint, key.Key, ""})
+			}
+		}
+	}
+	w.Flush()
+}
+
+// auditFindings turns the reports into SARIF findings located at each
+// user's authorized_keys
+func auditFindings(reports []auditReport) []report.Finding {
+	var findings []report.Finding
+	for _, r := range reports {
+		if r.Error != "" {
+			findings = append(findings, report.Finding{
+				RuleID:   "audit-error",
+				Level:    report.LevelError,
+				Message:  fmt.Sprintf("SSH user %s could not be audited: %s", r.SSHUsername, r.Error),
+				Location: r.AuthorizedKeys,
+			})
+		}
+		for _, group := range []struct {
+			rule, level string
+			keys        []auditKey
+		}{
+			{"missing-key", report.LevelNote, r.Missing},
+			{"stale-key", report.LevelWarning, r.Stale},
+			{"unknown-key", report.LevelWarning, r.Unknown},
+		} {
+			for _, key := range group.keys {
+				findings = append(findings, report.Finding{
+					RuleID:     group.rule,
+					Level:      group.level,
+					Message:    fmt.Sprintf("SSH user %s: %s key %s", r.SSHUsername, strings.TrimSuffix(group.rule, "-key"), key.Fingerprint),
+					Location:   r.AuthorizedKeys,
+					Properties: map[string]string{"ssh_username": r.SSHUsername, "fingerprint": key.Fingerprint},
+				})
+			}
+		}
+	}
+	return findings
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/dgarifullin/charon-key/internal/cache"
+	"github.com/dgarifullin/charon-key/internal/config"
+	"github.com/dgarifullin/charon-key/internal/errors"
+	"github.com/dgarifullin/charon-key/internal/logger"
+)
+
+// debugBundle collects diagnostics during a run and, on a fatal error, writes
+// them to a file to attach to support tickets. A nil *debugBundle is disabled
+type debugBundle struct {
+	path     string
+	log      *logger.Logger
+	started  time.Time
+	requests []bundleRequest
+}
+
+// bundleRequest is one HTTP request made to GitHub
+type bundleRequest struct {
+	Method    string `json:"method"`
+	URL       string `json:"url"`
+	Status    int    `json:"status"`
+	ElapsedMS int64  `json:"elapsed_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// bundleError describes the fatal error
+type bundleError struct {
+	Message  string          `json:"message"`
+	ExitCode int             `json:"exit_code"`
+	ExitName string          `json:"exit_name"`
+	Category errors.Category `json:"category"`
+}
+
+// bundleCache summarizes the cache directory
+type bundleCache struct {
+	Dir              string      `json:"dir"`
+	Stats            cache.Stats `json:"stats"`
+	StatsError       string      `json:"stats_error,omitempty"`
+	RateLimitedUntil time.Time   `json:"rate_limited_until"`
+}
+
+// bundle is the file written by --debug-bundle
+type bundle struct {
+	Version        string          `json:"version"`
+	Commit         string          `json:"commit"`
+	Created        time.Time       `json:"created"`
+	CorrelationID  string          `json:"correlation_id"`
+	SSHUsername    string          `json:"ssh_username"`
+	Error          bundleError     `json:"error"`
+	ElapsedMS      int64           `json:"elapsed_ms"`
+	Config         *config.Config  `json:"config,omitempty"`
+	BreakGlassKeys int             `json:"break_glass_keys"`
+	Requests       []bundleRequest `json:"requests"`
+	Cache          *bundleCache    `json:"cache,omitempty"`
+}
+
+// newDebugBundle starts collecting diagnostics if path is set
+func newDebugBundle(path string, log *logger.Logger) *debugBundle {
+	if path == "" {
+		return nil
+	}
+	return &debugBundle{path: path, log: log, started: time.Now()}
+}
+
+// attach records the app's HTTP requests
+func (b *debugBundle) attach(a *app) {
+	if b == nil {
+		return
+	}
+	a.fetcher.SetRequestObserver(b.observe)
+}
+
+func (b *debugBundle) observe(method, url string, status int, elapsed time.Duration, err error) {
+	request := bundleRequest{Method: method, URL: url, Status: status, ElapsedMS: elapsed.Milliseconds()}
+	if err != nil {
+		request.Error = err.Error()
+	}
+	b.requests = append(b.requests, request)
+}
+
+// write saves the bundle for a fatal error; a is nil if initialization failed
+// Secrets are never included: the config only holds file paths for them, and
+// webhook URLs are reduced to their host
+func (b *debugBundle) write(a *app, username string, err error) {
+	if b == nil {
+		return
+	}
+
+	code := exitCodeOf(err)
+	out := bundle{
+		Version:       version,
+		Commit:        commit,
+		Created:       time.Now().UTC(),
+		CorrelationID: b.log.CorrelationID(),
+		SSHUsername:   username,
+		Error: bundleError{
+			Message:  err.Error(),
+			ExitCode: int(code),
+			ExitName: code.String(),
+			Category: code.Category(),
+		},
+		ElapsedMS: time.Since(b.started).Milliseconds(),
+		Requests:  b.requests,
+	}
+
+	if a != nil {
+		cfg := *a.cfg
+		cfg.CanaryWebhook = redactURL(cfg.CanaryWebhook)
+		cfg.AlertWebhook = redactURL(cfg.AlertWebhook)
+		out.Config = &cfg
+		out.BreakGlassKeys = len(a.breakGlassKeys)
+
+		stats, err := a.cache.Stats()
+		out.Cache = &bundleCache{
+			Dir:              a.cache.GetCacheDir(),
+			Stats:            stats,
+			RateLimitedUntil: a.cache.RateLimitedUntil(),
+		}
+		if err != nil {
+			out.Cache.StatsError = err.Error()
+		}
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		b.log.Error("failed to encode debug bundle", "error", err)
+		return
+	}
+	if err := os.WriteFile(b.path, append(data, '\n'), 0600); err != nil {
+		b.log.Error("failed to write debug bundle", "path", b.path, "error", err)
+		return
+	}
+	b.log.Info("wrote debug bundle", "path", b.path)
+}
+
+// redactURL reduces a URL to its scheme and host, since webhook paths often
+// embed tokens
+func redactURL(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "[redacted]"
+	}
+	return u.Scheme + "://" + u.Host + "/[redacted]"
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dgarifullin/charon-key/internal/cache"
+	"github.com/dgarifullin/charon-key/internal/errors"
+	"github.com/dgarifullin/charon-key/internal/github"
+)
+
+// runCache runs the cache subcommands
+// Usage: charon-key cache clear|refresh [OPTIONS]
+func runCache(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "clear":
+			runCacheClear(args[1:])
+			return
+		case "refresh":
+			runCacheRefresh(args[1:])
+			return
+		case "stats":
+			runCacheStats(args[1:])
+			return
+		}
+	}
+	fmt.Println("Usage: charon-key cache clear [OPTIONS] --github-user X | --ssh-user Y | --all")
+	fmt.Println("       charon-key cache refresh [OPTIONS] [--within PERCENT] [--snapshot]")
+	fmt.Println("       charon-key cache stats [OPTIONS] [--format text|json|prometheus]")
+	errors.ExitWithCode(errors.ExitConfigError)
+}
+
+// runCacheClear removes cache entries
+// Usage: charon-key cache clear [OPTIONS] --github-user X | --ssh-user Y | --all
+func runCacheClear(args []string) {
+	var opts options
+	var githubUsers, sshUsers stringList
+	var all bool
+	fs := newFlagSet("charon-key cache clear", &opts)
+	fs.Var(&githubUsers, "github-user", "Clear the cached keys of this GitHub user (repeatable)")
+	fs.Var(&sshUsers, "ssh-user", "Clear the cached keys of the GitHub users mapped to this SSH user (repeatable)")
+	fs.BoolVar(&all, "all", false, "Clear every cache entry")
+	fs.Parse(args)
+	handleInfoFlags(opts)
+	applyErrorFormat(opts)
+
+	log := newLogger(opts)
+
+	if !all && len(githubUsers) == 0 && len(sshUsers) == 0 {
+		err := fmt.Errorf("cache clear needs --github-user, --ssh-user or --all")
+		log.Error("configuration error", "error", err)
+		errors.ExitWithError(errors.NewAppError("configuration error", errors.ExitConfigError, err))
+	}
+
+	cacheManager, err := cache.NewManager(opts.cacheDir, time.Duration(opts.cacheTTLMinutes)*time.Minute)
+	if err != nil {
+		log.Error("failed to initialize cache", "error", err)
+		errors.ExitWithError(errors.NewAppError("failed to initialize cache", errors.ExitGeneralError, err))
+	}
+
+	if all {
+		removed, err := cacheManager.ClearAll()
+		if err != nil {
+			log.Error("failed to clear cache", "cache_dir", cacheManager.GetCacheDir(), "error", err)
+			errors.ExitWithError(errors.NewAppError("failed to clear cache", errors.ExitGeneralError, err))
+		}
+		fmt.Printf("Cleared %d cache entries in %s\n", removed, cacheManager.GetCacheDir())
+		errors.ExitWithCode(errors.ExitSuccess)
+	}
+
+	// SSH users need the mapping (static and LDAP) to find their GitHub users
+	users := append([]string{}, githubUsers...)
+	if len(sshUsers) > 0 {
+		a, err := newApp(opts, log)
+		if err != nil {
+			errors.ExitWithError(err)
+		}
+		for _, sshUser := range sshUsers {
+			mapped := a.resolver.GitHubUsers(sshUser)
+			if len(mapped) == 0 {
+				log.Warn("no GitHub users mapped", "ssh_username", sshUser)
+			}
+			users = append(users, mapped...)
+		}
+	}
+
+	for _, user := range users {
+		if err := cacheManager.Clear(user); err != nil {
+			log.Error("failed to clear cache entry", "github_user", user, "error", err)
+			errors.ExitWithError(errors.NewAppError("failed to clear cache entry", errors.ExitGeneralError, err))
+		}
+		fmt.Printf("Cleared cached keys of %s\n", user)
+	}
+
+	errors.ExitWithCode(errors.ExitSuccess)
+}
+
+// runCacheRefresh refreshes the cache entries that are expired or about to
+// expire, so logins find fresh entries instead of waiting on GitHub; run it
+// from cron with the options used in sshd_config
+// With --snapshot, every entry is then written into the snapshot logins read
+// from memory instead of decoding per-user JSON files
+// Usage: charon-key cache refresh [OPTIONS] [--within PERCENT] [--snapshot]
+func runCacheRefresh(args []string) {
+	var opts options
+	var within int
+	var snapshot bool
+	fs := newFlagSet("charon-key cache refresh", &opts)
+	fs.IntVar(&within, "within", 20, "Refresh entries within this percentage of their TTL of expiring")
+	fs.BoolVar(&snapshot, "snapshot", false, "Write the cache snapshot logins map into memory after refreshing")
+	fs.Parse(args)
+	handleInfoFlags(opts)
+	applyErrorFormat(opts)
+
+	log := newLogger(opts)
+
+	if within < 0 || within > 100 {
+		err := fmt.Errorf("within must be between 0 and 100 percent, got %d", within)
+		log.Error("configuration error", "error", err)
+		errors.ExitWithError(errors.NewAppError("configuration error", errors.ExitConfigError, err))
+	}
+
+	a, err := newApp(opts, log)
+	if err != nil {
+		errors.ExitWithError(err)
+	}
+
+	due, err := a.cache.DueForRefresh(within)
+	if err != nil {
+		log.Error("failed to list cache entries", "cache_dir", a.cache.GetCacheDir(), "error", err)
+		errors.ExitWithError(errors.NewAppError("failed to list cache entries", errors.ExitGeneralError, err))
+	}
+	log.Info("refreshing cache", "due", len(due), "within_percent", within)
+
+	// Keep going after a failed user; the exit code reports the first failure
+	var firstErr error
+	refreshed := 0
+	for _, user := range due {
+		ctx, cancel := context.WithTimeout(context.Background(), github.DefaultTimeout)
+		err := a.resolver.RefreshGitHubUser(ctx, user)
+		cancel()
+		if err != nil {
+			log.Warn("failed to refresh cached keys", "github_user", user, "error", err)
+			if firstErr == nil {
+				firstErr = errors.NewAppError("failed to refresh cached keys", errors.ExitNetworkError, err)
+			}
+			// A rate limit applies to every remaining user too
+			if github.KindOf(err) == github.KindRateLimited {
+				break
+			}
+			continue
+		}
+		refreshed++
+	}
+
+	log.Info("refreshed cache", "due", len(due), "refreshed", refreshed, "failed", len(due)-refreshed)
+
+	// Entries that failed to refresh are still snapshotted, as logins would
+	// serve them from their JSON files anyway
+	if snapshot {
+		written, err := a.cache.WriteSnapshot()
+		if err != nil {
+			log.Error("failed to write cache snapshot", "cache_dir", a.cache.GetCacheDir(), "error", err)
+			errors.ExitWithError(errors.NewAppError("failed to write cache snapshot", errors.ExitGeneralError, err))
+		}
+		log.Info("wrote cache snapshot", "entries", written)
+	}
+	a.logSummary(firstErr)
+	if firstErr != nil {
+		errors.ExitWithError(firstErr)
+	}
+	errors.ExitWithCode(errors.ExitSuccess)
+}
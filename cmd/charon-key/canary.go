@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dgarifullin/charon-key/internal/audit"
+	"github.com/dgarifullin/charon-key/internal/resolver"
+	"github.com/dgarifullin/charon-key/internal/ssh"
+)
+
+// Canary audit event types
+const (
+	eventCanaryServed   = "canary_key_served"
+	eventCanaryExisting = "canary_key_existing"
+)
+
+// checkServedKey records a GitHub key about to be served: keys with a
+// known GitHub key ID are logged with it, so the served key can be traced
+// to the key object in GitHub, and canaries raise an audit event
+func (a *app) checkServedKey(username string, key resolver.Key) {
+	if key.GitHubKeyID != "" {
+		fingerprint, _ := ssh.Fingerprint(key.Line)
+		a.log.Info("serving GitHub key", "ssh_username", username, "github_user", key.GitHubUser, "github_key_id", key.GitHubKeyID, "fingerprint", fingerprint)
+	}
+	a.checkCanary(eventCanaryServed, username, key.Source(), key.GitHubKeyID, key.Line)
+}
+
+// checkCanary raises an audit event if key is a canary
+// The key is still served: canaries detect misuse, they don't change access
+func (a *app) checkCanary(eventType, username, source, keyID, key string) {
+	if len(a.cfg.CanaryFingerprints) == 0 {
+		return
+	}
+	fingerprint, err := ssh.Fingerprint(key)
+	if err != nil || !a.cfg.IsCanary(fingerprint) {
+		return
+	}
+
+	a.log.Audit("canary key detected", "event", eventType, "ssh_username", username, "fingerprint", fingerprint, "source", source, "github_key_id", keyID)
+
+	if a.cfg.CanaryWebhook == "" {
+		return
+	}
+	a.postEvent(a.cfg.CanaryWebhook, audit.Event{
+		Type:        eventType,
+		Severity:    audit.SeverityCritical,
+		Text:        fmt.Sprintf("charon-key saw canary key %s for SSH user %s (%s)", fingerprint, username, source),
+		SSHUsername: username,
+		Fingerprint: fingerprint,
+		Source:      source,
+		GitHubKeyID: keyID,
+	})
+}
+
+// checkExistingCanaries looks for canary keys in the user's existing
+// authorized_keys, which would mean someone planted a honeypot key there
+func (a *app) checkExistingCanaries(sshManager *ssh.Manager, username string) {
+	if len(a.cfg.CanaryFingerprints) == 0 {
+		return
+	}
+	existing, err := sshManager.ReadExistingKeys()
+	if err != nil {
+		return
+	}
+	for _, key := range existing {
+		a.checkCanary(eventCanaryExisting, username, sshManager.GetAuthorizedKeysPath(), "", key)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dgarifullin/charon-key/internal/errors"
+)
+
+// runCheck answers whether an SSH user is currently mapped and resolvable,
+// with the exit code only, for PAM account-stage policies via pam_exec
+// Usage: charon-key check [OPTIONS] [SSH-USERNAME] (default: $PAM_USER)
+func runCheck(args []string) {
+	var opts options
+	fs := newFlagSet("charon-key check", &opts)
+	fs.Parse(args)
+	handleInfoFlags(opts)
+	applyErrorFormat(opts)
+
+	log := newLogger(opts)
+
+	username := os.Getenv("PAM_USER")
+	if fs.NArg() > 0 {
+		username = fs.Arg(0)
+	}
+	if username == "" {
+		err := fmt.Errorf("check needs an SSH username or PAM_USER")
+		log.Error("configuration error", "error", err)
+		errors.ExitWithError(errors.NewAppError("configuration error", errors.ExitConfigError, err))
+	}
+
+	a, err := newApp(opts, log)
+	if err != nil {
+		errors.ExitWithError(err)
+	}
+
+	if len(a.resolver.GitHubUsers(username)) == 0 {
+		log.Warn("SSH user is not mapped", "ssh_username", username)
+		a.logSummary(errors.NewAppError("not mapped", errors.ExitNotMapped, nil))
+		errors.ExitWithCode(errors.ExitNotMapped)
+	}
+
+	// Resolving applies the same access, policy and exclusion rules as a login
+	keys, err := a.keysForUser(username)
+	if err == nil && len(keys) == 0 {
+		err = errors.NewAppError("no keys resolved", errors.ExitNoKeys, nil)
+	}
+	a.logSummary(err)
+	if err != nil {
+		log.Warn("SSH user is not resolvable", "ssh_username", username, "error", err)
+		errors.ExitWithError(err)
+	}
+
+	log.Info("SSH user is mapped and resolvable", "ssh_username", username, "keys", len(keys))
+	errors.ExitWithCode(errors.ExitSuccess)
+}
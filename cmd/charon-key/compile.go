@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/dgarifullin/charon-key/internal/config"
+	"github.com/dgarifullin/charon-key/internal/errors"
+	"github.com/dgarifullin/charon-key/internal/logger"
+	"github.com/dgarifullin/charon-key/internal/redact"
+)
+
+// runCompileConfig validates a config file, including every profile,
+// condition and time window, and writes it as a snapshot --config loads
+// without JSON parsing
+// Usage: charon-key compile-config --config FILE --output SNAPSHOT
+func runCompileConfig(args []string) {
+	var configFile, output, logLevel string
+	fs := flag.NewFlagSet("charon-key compile-config", flag.ExitOnError)
+	fs.StringVar(&configFile, "config", "", "JSON config file to compile (required)")
+	fs.StringVar(&output, "output", "", "Snapshot file to write (required)")
+	fs.StringVar(&logLevel, "log-level", "info", "Log level: debug|info|warn|error")
+	fs.Parse(args)
+
+	log := logger.NewLogger(logLevel).WithRedactor(redact.Default())
+
+	fail := func(message string, code errors.ExitCode, err error) {
+		log.Error(message, "error", err)
+		errors.ExitWithError(errors.NewAppError(message, code, err))
+	}
+
+	if configFile == "" || output == "" {
+		fail("configuration error", errors.ExitConfigError, fmt.Errorf("compile-config needs --config and --output"))
+	}
+
+	file, err := config.LoadFile(configFile)
+	if err != nil {
+		fail("configuration error", errors.ExitConfigError, err)
+	}
+	if err := file.Validate(); err != nil {
+		fail("configuration error", errors.ExitConfigError, err)
+	}
+	conflicts := file.ProfileConflicts()
+	if file.MappingConflicts == config.ConflictsError && len(conflicts) > 0 {
+		fail("configuration error", errors.ExitConfigError, fmt.Errorf("conflicting mappings (mapping_conflicts is error): %s", conflicts[0]))
+	}
+	if file.MappingConflicts != config.ConflictsIgnore {
+		for _, conflict := range conflicts {
+			log.Warn("conflicting mappings", "setting", conflict.Setting, "sources", conflict.Sources)
+		}
+	}
+	for _, place := range file.WildcardConflicts() {
+		log.Warn("wildcard mapping is ignored because deny_wildcard is set", "mapping", place)
+	}
+	if err := file.WriteSnapshot(output); err != nil {
+		fail("failed to write config snapshot", errors.ExitGeneralError, err)
+	}
+
+	log.Info("compiled config snapshot", "config", configFile, "output", output, "profiles", len(file.Profiles), "conditional_mappings", len(file.ConditionalMappings))
+	errors.ExitWithCode(errors.ExitSuccess)
+}
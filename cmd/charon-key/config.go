@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dgarifullin/charon-key/internal/config"
+	"github.com/dgarifullin/charon-key/internal/errors"
+)
+
+// runConfig runs the config subcommands
+// Usage: charon-key config schema
+func runConfig(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "schema":
+			// The JSON Schema config files are validated against on load,
+			// for editors and CI checks
+			os.Stdout.Write(config.Schema())
+			errors.ExitWithCode(errors.ExitSuccess)
+		}
+	}
+	fmt.Println("Usage: charon-key config schema")
+	errors.ExitWithCode(errors.ExitConfigError)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dgarifullin/charon-key/internal/cache"
+	"github.com/dgarifullin/charon-key/internal/confine"
+	"github.com/dgarifullin/charon-key/internal/credentials"
+	"github.com/dgarifullin/charon-key/internal/errors"
+	"github.com/dgarifullin/charon-key/internal/ssh"
+)
+
+// runPrintPolicy prints an AppArmor profile or SELinux policy module for
+// the paths configured by the same options sshd runs charon-key with
+// Usage: charon-key print-apparmor|print-selinux-te [OPTIONS]
+func runPrintPolicy(name string, args []string, generate func(confine.Paths) string) {
+	var opts options
+	var executable string
+	fs := newFlagSet("charon-key "+name, &opts)
+	fs.StringVar(&executable, "executable", "", "Path of the charon-key binary sshd runs (optional, default: this binary)")
+	fs.Parse(args)
+	handleInfoFlags(opts)
+	applyErrorFormat(opts)
+
+	log := newLogger(opts)
+
+	if executable == "" {
+		path, err := os.Executable()
+		if err == nil {
+			path, err = filepath.EvalSymlinks(path)
+		}
+		if err != nil {
+			log.Error("failed to find the charon-key binary, pass --executable", "error", err)
+			errors.ExitWithError(errors.NewAppError("configuration error", errors.ExitConfigError, err))
+		}
+		executable = path
+	}
+	fmt.Print(generate(confinePaths(opts, executable)))
+	errors.ExitWithCode(errors.ExitSuccess)
+}
+
+// confinePaths collects the files charon-key reads and writes with opts
+func confinePaths(opts options, executable string) confine.Paths {
+	paths := confine.Paths{
+		Executable:   executable,
+		ReadHelper:   opts.readHelper,
+		Getent:       !strings.EqualFold(opts.passwdSource, string(ssh.PasswdOS)),
+		LDAP:         opts.ldapURL != "",
+		Unprivileged: opts.unprivileged,
+		CacheDir:     opts.cacheDir,
+		Chown:        opts.sync,
+	}
+	if paths.CacheDir == "" {
+		paths.CacheDir = cache.DefaultCacheDir()
+	}
+
+	paths.Config = []string{opts.configFile, opts.shadowConfigFile, opts.revocationFile, opts.breakGlassFile,
+		opts.outputTemplateFile, opts.githubTokenFile, opts.ldapPasswordFile}
+	if opts.readHelper != "" {
+		paths.Config = append(paths.Config, ssh.KeyFilePatternsPath)
+	}
+	for _, spec := range []string{opts.githubToken, opts.vaultTokenSource, opts.accessTokenSource} {
+		source, err := credentials.ParseSource(spec)
+		if err != nil {
+			continue
+		}
+		switch source.Kind {
+		case credentials.KindFile:
+			paths.Config = append(paths.Config, source.Location)
+		case credentials.KindKeyring:
+			paths.Keyring = true
+		}
+	}
+
+	authorizedKeys := "%h/.ssh/authorized_keys"
+	if opts.authorizedKeysFile != "" {
+		authorizedKeys = inHome(opts.authorizedKeysFile)
+	}
+	if opts.sync {
+		paths.WrittenKeyFiles = append(paths.WrittenKeyFiles, authorizedKeys, opts.principalsFile)
+	} else {
+		paths.KeyFiles = append(paths.KeyFiles, authorizedKeys)
+	}
+	for _, pattern := range opts.existingKeysFiles {
+		paths.KeyFiles = append(paths.KeyFiles, inHome(pattern))
+	}
+	if opts.chrootDir != "" {
+		paths.KeyFiles = inChroot(paths.KeyFiles, opts.chrootDir, len(opts.chrootUsers) > 0)
+		paths.WrittenKeyFiles = inChroot(paths.WrittenKeyFiles, opts.chrootDir, len(opts.chrootUsers) > 0)
+	}
+	paths.WrittenKeyFiles = append(paths.WrittenKeyFiles, opts.output)
+
+	paths.Logs = []string{opts.logFile, opts.debugBundle}
+	return paths
+}
+
+// inChroot moves the paths in home directories inside the chroot directory,
+// keeping them outside it too when only some users are chrooted
+func inChroot(paths []string, chrootDir string, keep bool) []string {
+	var result []string
+	for _, path := range paths {
+		if !strings.HasPrefix(path, "%h") {
+			result = append(result, path)
+			continue
+		}
+		if keep {
+			result = append(result, path)
+		}
+		result = append(result, strings.TrimRight(chrootDir, "/")+path)
+	}
+	return result
+}
+
+// inHome makes a relative path relative to the home directory, as sshd
+// does for AuthorizedKeysFile
+func inHome(pattern string) string {
+	if pattern == "" || filepath.IsAbs(pattern) || strings.HasPrefix(pattern, "%h") {
+		return pattern
+	}
+	return "%h/" + pattern
+}
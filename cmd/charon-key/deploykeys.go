@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/dgarifullin/charon-key/internal/config"
+	"github.com/dgarifullin/charon-key/internal/resolver"
+	"github.com/dgarifullin/charon-key/internal/ssh"
+)
+
+// restrictDeployKeys prefixes the deploy keys among keys with the SSH user's
+// deploy key options (forced command and restrict)
+// Applied last, since key validation and provenance expect bare keys
+func (a *app) restrictDeployKeys(username string, keys []string, sources []resolver.Key) []string {
+	deployKeys := make(map[string]bool)
+	for _, source := range sources {
+		if config.IsDeployKeys(source.GitHubUser) {
+			if fingerprint, err := ssh.Fingerprint(source.Line); err == nil {
+				deployKeys[fingerprint] = true
+			}
+		}
+	}
+	if len(deployKeys) == 0 {
+		return keys
+	}
+
+	options := a.cfg.DeployKeyOptions(username)
+	for i, key := range keys {
+		if fingerprint, err := ssh.Fingerprint(key); err == nil && deployKeys[fingerprint] {
+			keys[i] = options + " " + key
+		}
+	}
+	return keys
+}
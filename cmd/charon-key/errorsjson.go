@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dgarifullin/charon-key/internal/github"
+	"github.com/dgarifullin/charon-key/internal/ssh"
+)
+
+// errorsReport is the --errors-json file of a resolve run: the SSH users
+// that failed, and why, for pipelines that retry only those
+type errorsReport struct {
+	GeneratedAt time.Time   `json:"generated_at"`
+	Users       int         `json:"users"`
+	Failed      int         `json:"failed"`
+	Errors      []userError `json:"errors"`
+}
+
+// userError is an SSH user that failed, with the exit code it would have
+// exited with on its own
+type userError struct {
+	SSHUsername string `json:"ssh_username"`
+	Error       string `json:"error"`
+	Category    string `json:"category"`
+	ExitCode    int    `json:"exit_code"`
+	ExitName    string `json:"exit_name"`
+
+	// GitHubUsers are the SSH user's GitHub users whose fetch failed
+	GitHubUsers []githubUserError `json:"github_users,omitempty"`
+}
+
+// githubUserError is a GitHub user whose keys couldn't be resolved
+type githubUserError struct {
+	GitHubUser string `json:"github_user"`
+	Error      string `json:"error"`
+	ErrorKind  string `json:"error_kind"`
+	HTTPStatus int    `json:"http_status,omitempty"`
+	Attempts   int    `json:"attempts,omitempty"`
+}
+
+// userError describes why resolving an SSH user failed, redacting error
+// messages like the logs
+func (a *app) userError(username string, err error, redact func(string) string) userError {
+	code := exitCodeOf(err)
+	failure := userError{
+		SSHUsername: username,
+		Error:       redact(err.Error()),
+		Category:    string(code.Category()),
+		ExitCode:    int(code),
+		ExitName:    code.String(),
+	}
+	for _, githubUser := range a.resolver.GitHubUsers(username) {
+		fetchErr := a.resolver.FetchFailure(username, githubUser)
+		if fetchErr == nil {
+			continue
+		}
+		failure.GitHubUsers = append(failure.GitHubUsers, githubUserError{
+			GitHubUser: githubUser,
+			Error:      redact(fetchErr.Error()),
+			ErrorKind:  string(github.KindOf(fetchErr)),
+			HTTPStatus: github.StatusOf(fetchErr),
+			Attempts:   github.AttemptsOf(fetchErr),
+		})
+	}
+	return failure
+}
+
+// writeErrorsReport atomically writes the --errors-json file, also when
+// nothing failed, so pipelines never read a stale report
+func writeErrorsReport(path string, users int, failures []userError) error {
+	report := errorsReport{
+		GeneratedAt: time.Now().UTC(),
+		Users:       users,
+		Failed:      len(failures),
+		Errors:      failures,
+	}
+	if report.Errors == nil {
+		report.Errors = []userError{}
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode errors report: %w", err)
+	}
+	if err := ssh.WriteFileAtomic(path, append(data, '\n'), 0600, -1, -1); err != nil {
+		return fmt.Errorf("failed to write errors report: %w", err)
+	}
+	return nil
+}
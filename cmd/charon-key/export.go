@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dgarifullin/charon-key/internal/config"
+	"github.com/dgarifullin/charon-key/internal/errors"
+	"github.com/dgarifullin/charon-key/internal/github"
+	"github.com/dgarifullin/charon-key/internal/logger"
+	"github.com/dgarifullin/charon-key/internal/redact"
+)
+
+// exportTimeout bounds listing an org's members and teams
+const exportTimeout = 5 * time.Minute
+
+// runExportOrg writes a config file with a user_map entry per org member and
+// a role per team, to bootstrap mappings for a large org
+// Usage: charon-key export-org --org ORG --github-token-source SOURCE [--output FILE]
+func runExportOrg(args []string) {
+	var org, tokenSource, output, logLevel string
+	fs := flag.NewFlagSet("charon-key export-org", flag.ExitOnError)
+	fs.StringVar(&org, "org", "", "GitHub org to export (required)")
+	fs.StringVar(&tokenSource, "github-token-source", "", "Where to read a GitHub token of an org member with read:org: file:PATH|env:NAME|keyring:SERVICE/ACCOUNT|vault:PATH#FIELD (required)")
+	fs.StringVar(&output, "output", "", "Config file to write (optional, default: stdout)")
+	fs.StringVar(&logLevel, "log-level", "info", "Log level: debug|info|warn|error")
+	fs.Parse(args)
+
+	log := logger.NewLogger(logLevel).WithRedactor(redact.Default())
+
+	fail := func(message string, code errors.ExitCode, err error) {
+		log.Error(message, "error", err)
+		errors.ExitWithError(errors.NewAppError(message, code, err))
+	}
+
+	if org == "" || tokenSource == "" {
+		fail("configuration error", errors.ExitConfigError, fmt.Errorf("export-org needs --org and --github-token-source"))
+	}
+	token, err := loadCredential(&config.Config{}, tokenSource)
+	if err != nil {
+		fail("failed to load GitHub token", errors.ExitConfigError, err)
+	}
+
+	fetcher := github.NewFetcher()
+	fetcher.SetToken(token)
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	members, err := fetcher.ListOrgMembers(ctx, org)
+	if err != nil {
+		fail("failed to list org members", errors.ExitNetworkError, err)
+	}
+	teams, err := fetcher.ListOrgTeams(ctx, org)
+	if err != nil {
+		fail("failed to list org teams", errors.ExitNetworkError, err)
+	}
+
+	file := config.OrgFile(members, teams)
+	data, err := json.MarshalIndent(struct {
+		UserMap map[string][]string `json:"user_map"`
+		Roles   map[string][]string `json:"roles"`
+	}{file.UserMap, file.Roles}, "", "  ")
+	if err != nil {
+		fail("failed to encode config", errors.ExitGeneralError, err)
+	}
+	data = append(data, '\n')
+
+	if output == "" {
+		os.Stdout.Write(data)
+	} else if err := os.WriteFile(output, data, 0644); err != nil {
+		fail("failed to write config", errors.ExitGeneralError, err)
+	}
+
+	log.Info("exported org", "org", org, "members", len(file.UserMap), "roles", len(file.Roles))
+	errors.ExitWithCode(errors.ExitSuccess)
+}
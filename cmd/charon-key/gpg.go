@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/dgarifullin/charon-key/internal/config"
+	"github.com/dgarifullin/charon-key/internal/errors"
+	"github.com/dgarifullin/charon-key/internal/github"
+)
+
+// runGPG fetches the GPG keys of the GitHub users mapped to an SSH user and
+// prints them, or imports them into the gpg keyring, e.g. to verify commit
+// signatures on servers with the same mapping as SSH access
+// Usage: charon-key gpg [OPTIONS] [--import] SSH-USERNAME
+func runGPG(args []string) {
+	var opts options
+	var importKeys bool
+	fs := newFlagSet("charon-key gpg", &opts)
+	fs.BoolVar(&importKeys, "import", false, "Import the keys with gpg --import instead of printing them")
+	fs.Parse(args)
+	handleInfoFlags(opts)
+	applyErrorFormat(opts)
+
+	log := newLogger(opts)
+
+	if fs.NArg() != 1 {
+		err := fmt.Errorf("gpg needs one SSH username")
+		log.Error("configuration error", "error", err)
+		errors.ExitWithError(errors.NewAppError("configuration error", errors.ExitConfigError, err))
+	}
+	username := fs.Arg(0)
+
+	a, err := newApp(opts, log)
+	if err != nil {
+		errors.ExitWithError(err)
+	}
+
+	githubUsers := a.resolver.GitHubUsers(username)
+	if len(githubUsers) == 0 {
+		err := errors.NewAppError("not mapped", errors.ExitNotMapped, fmt.Errorf("no GitHub users mapped for SSH user %q", username))
+		log.Error("SSH user is not mapped", "ssh_username", username)
+		errors.ExitWithError(err)
+	}
+
+	// Keep going after a failed GitHub user; the exit code reports the first failure
+	var firstErr error
+	fetched := 0
+	for _, githubUser := range githubUsers {
+		if !config.IsGitHubUser(githubUser) {
+			log.Debug("skipping entry without GPG keys", "github_user", githubUser)
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), github.DefaultTimeout)
+		armored, err := a.fetcher.FetchGPGKeys(ctx, githubUser)
+		cancel()
+		if err == nil && importKeys {
+			err = importGPGKeys(armored)
+		} else if err == nil {
+			fmt.Print(armored)
+		}
+		if err != nil {
+			log.Error("failed to fetch GPG keys", "github_user", githubUser, "error", err)
+			if firstErr == nil {
+				firstErr = errors.NewAppError("failed to fetch GPG keys", errors.ExitNetworkError, err)
+			}
+			continue
+		}
+		fetched++
+	}
+
+	log.Info("fetched GPG keys", "ssh_username", username, "github_users", fetched, "imported", importKeys)
+	if firstErr != nil {
+		errors.ExitWithError(firstErr)
+	}
+	errors.ExitWithCode(errors.ExitSuccess)
+}
+
+// importGPGKeys imports armored keys into the keyring of the gpg on PATH
+// (GNUPGHOME selects another keyring)
+func importGPGKeys(armored string) error {
+	cmd := exec.Command("gpg", "--batch", "--import")
+	cmd.Stdin = strings.NewReader(armored)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("gpg --import failed: %w", err)
+	}
+	return nil
+}
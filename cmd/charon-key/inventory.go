@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dgarifullin/charon-key/internal/errors"
+	"github.com/dgarifullin/charon-key/internal/report"
+	"github.com/dgarifullin/charon-key/internal/ssh"
+)
+
+// inventoryRules are the kinds of results in SARIF inventories
+var inventoryRules = []report.Rule{
+	{ID: "authorized-key", Description: "A key is authorized for an SSH user"},
+	{ID: "resolution-error", Description: "The SSH user's keys could not be resolved"},
+}
+
+// inventory lists this host's mappings and the keys they resolve to
+type inventory struct {
+	Hostname    string                `json:"hostname"`
+	Version     string                `json:"version"`
+	GeneratedAt time.Time             `json:"generated_at"`
+	Users       []inventoryUser       `json:"users"`
+	Cache       []inventoryCacheEntry `json:"cache"`
+}
+
+// inventoryUser is one SSH user's mapping and resolved keys
+type inventoryUser struct {
+	SSHUsername string         `json:"ssh_username"`
+	Mapping     []string       `json:"mapping"`
+	GitHubUsers []string       `json:"github_users"`
+	Keys        []inventoryKey `json:"keys"`
+	Error       string         `json:"error,omitempty"`
+}
+
+// inventoryKey is a resolved key and where it came from
+type inventoryKey struct {
+	Fingerprint string    `json:"fingerprint"`
+	Type        string    `json:"type"`
+	Source      string    `json:"source"`
+	GitHubKeyID string    `json:"github_key_id,omitempty"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// inventoryCacheEntry is the cache state of a mapped GitHub user before the
+// inventory resolved it
+type inventoryCacheEntry struct {
+	GitHubUser string    `json:"github_user"`
+	Cached     bool      `json:"cached"`
+	CachedAt   time.Time `json:"cached_at,omitzero"`
+	Expired    bool      `json:"expired"`
+}
+
+// runInventory writes the mappings of every SSH user of the static user
+// map, their resolved key fingerprints and sources, and the cache freshness,
+// for central collection and compliance reporting
+// Usage: charon-key inventory [OPTIONS] [--format json|csv|sarif|table]
+func runInventory(args []string) {
+	var opts options
+	var format string
+	fs := newFlagSet("charon-key inventory", &opts)
+	fs.StringVar(&format, "format", "json", "Output format: json|csv|sarif|table")
+	fs.Parse(args)
+	handleInfoFlags(opts)
+	applyErrorFormat(opts)
+
+	log := newLogger(opts)
+
+	if format != "json" && format != "csv" && format != "sarif" && format != "table" {
+		err := fmt.Errorf("invalid format %q (expected json, csv, sarif or table)", format)
+		log.Error("configuration error", "error", err)
+		errors.ExitWithError(errors.NewAppError("configuration error", errors.ExitConfigError, err))
+	}
+
+	a, err := newApp(opts, log)
+	if err != nil {
+		errors.ExitWithError(err)
+	}
+
+	hostname, _ := os.Hostname()
+	inv := inventory{
+		Hostname:    hostname,
+		Version:     version,
+		GeneratedAt: time.Now().UTC(),
+	}
+
+	// Record the cache state before resolving refreshes it
+	usernames := a.mappedUsers()
+	seen := make(map[string]bool)
+	for _, username := range usernames {
+		for _, githubUser := range a.resolver.GitHubUsers(username) {
+			if name := strings.ToLower(githubUser); !seen[name] {
+				seen[name] = true
+				inv.Cache = append(inv.Cache, a.cacheState(githubUser))
+			}
+		}
+	}
+
+	// Keep going after a failed user; the exit code reports the first failure
+	var firstErr error
+	for _, username := range usernames {
+		user, err := a.inventoryUser(username)
+		if err != nil {
+			log.Error("failed to resolve SSH user", "ssh_username", username, "error", err)
+			user.Error = err.Error()
+			if firstErr == nil {
+				firstErr = errors.NewAppError("failed to resolve keys", errors.ExitNetworkError, err)
+			}
+		}
+		inv.Users = append(inv.Users, user)
+	}
+
+	switch format {
+	case "csv":
+		writeInventoryCSV(inv)
+	case "sarif":
+		report.WriteSARIF(os.Stdout, "charon-key", version, inventoryRules, inventoryFindings(inv))
+	case "table":
+		writeInventoryTable(os.Stdout, inv)
+	default:
+		data, _ := json.MarshalIndent(inv, "", "  ")
+		fmt.Println(string(data))
+	}
+
+	a.logSummary(firstErr)
+	if firstErr != nil {
+		errors.ExitWithError(firstErr)
+	}
+	errors.ExitWithCode(errors.ExitSuccess)
+}
+
+// inventoryUser resolves one SSH user for the inventory
+// Keys are listed as resolved, before login policies (FIPS, key age)
+func (a *app) inventoryUser(username string) (inventoryUser, error) {
+	mapping, ok := a.cfg.UserMap[username]
+	if !ok {
+		mapping = a.cfg.UserMap["*"]
+	}
+	user := inventoryUser{
+		SSHUsername: username,
+		Mapping:     mapping,
+		GitHubUsers: a.resolver.GitHubUsers(username),
+		Keys:        []inventoryKey{},
+	}
+
+	sources, err := a.resolver.ResolveKeySources(username)
+	if err != nil {
+		return user, err
+	}
+	for _, source := range sources {
+		fingerprint, _ := ssh.Fingerprint(source.Line)
+		keyType, _, _ := strings.Cut(source.Line, " ")
+		user.Keys = append(user.Keys, inventoryKey{
+			Fingerprint: fingerprint,
+			Type:        keyType,
+			Source:      source.Source(),
+			GitHubKeyID: source.GitHubKeyID,
+			FetchedAt:   source.FetchedAt.UTC(),
+		})
+	}
+	return user, nil
+}
+
+// cacheState reports whether a GitHub user is cached and how fresh it is
+func (a *app) cacheState(githubUser string) inventoryCacheEntry {
+	state := inventoryCacheEntry{GitHubUser: githubUser}
+	entry, expired, err := a.cache.ReadEntry(githubUser)
+	if err != nil || entry == nil {
+		return state
+	}
+	state.Cached = true
+	state.CachedAt = entry.Timestamp.UTC()
+	state.Expired = expired
+	return state
+}
+
+// writeInventoryCSV writes one row per resolved key, and per SSH user whose
+// keys couldn't be resolved; the cache state is left to the JSON output
+func writeInventoryCSV(inv inventory) {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"hostname", "ssh_username", "mapping", "source", "fingerprint", "type", "github_key_id", "fetched_at", "error"})
+	for _, user := range inv.Users {
+		mapping := strings.Join(user.Mapping, " ")
+		if user.Error != "" {
+			w.Write([]string{inv.Hostname, user.SSHUsername, mapping, "", "", "", "", "", user.Error})
+		}
+		for _, key := range user.Keys {
+			w.Write([]string{inv.Hostname, user.SSHUsername, mapping, key.Source, key.Fingerprint, key.Type, key.GitHubKeyID, key.FetchedAt.Format(time.RFC3339), ""})
+		}
+	}
+	w.Flush()
+}
+
+// writeInventoryTable writes one aligned row per resolved key, with the SSH
+// user, its GitHub users and key count on its first row, for reviewing
+// accounts by eye; failed SSH users get a row with the error
+func writeInventoryTable(w io.Writer, inv inventory) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SSH USER\tGITHUB USERS\tKEYS\tTYPE\tFINGERPRINT\tSOURCE")
+	for _, user := range inv.Users {
+		githubUsers := strings.Join(user.GitHubUsers, ",")
+		if githubUsers == "" {
+			githubUsers = "-"
+		}
+		if user.Error != "" {
+			fmt.Fprintf(tw, "%s\t%s\t-\terror: %s\n", user.SSHUsername, githubUsers, user.Error)
+			continue
+		}
+		if len(user.Keys) == 0 {
+			fmt.Fprintf(tw, "%s\t%s\t0\t-\t-\t-\n", user.SSHUsername, githubUsers)
+			continue
+		}
+		for i, key := range user.Keys {
+			if i == 0 {
+				fmt.Fprintf(tw, "%s\t%s\t%d\t", user.SSHUsername, githubUsers, len(user.Keys))
+			} else {
+				fmt.Fprint(tw, "\t\t\t")
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", key.Type, key.Fingerprint, key.Source)
+		}
+	}
+	tw.Flush()
+}
+
+// inventoryFindings turns the inventory into SARIF results, a note per
+// authorized key
+func inventoryFindings(inv inventory) []report.Finding {
+	var findings []report.Finding
+	for _, user := range inv.Users {
+		if user.Error != "" {
+			findings = append(findings, report.Finding{
+				RuleID:     "resolution-error",
+				Level:      report.LevelError,
+				Message:    fmt.Sprintf("Keys of SSH user %s on %s could not be resolved: %s", user.SSHUsername, inv.Hostname, user.Error),
+				Properties: map[string]string{"hostname": inv.Hostname, "ssh_username": user.SSHUsername},
+			})
+		}
+		for _, key := range user.Keys {
+			findings = append(findings, report.Finding{
+				RuleID:  "authorized-key",
+				Level:   report.LevelNote,
+				Message: fmt.Sprintf("SSH user %s on %s accepts %s key %s from %s", user.SSHUsername, inv.Hostname, key.Type, key.Fingerprint, key.Source),
+				Properties: map[string]string{
+					"hostname":     inv.Hostname,
+					"ssh_username": user.SSHUsername,
+					"fingerprint":  key.Fingerprint,
+					"source":       key.Source,
+				},
+			})
+		}
+	}
+	return findings
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"time"
+
+	"github.com/dgarifullin/charon-key/internal/resolver"
+)
+
+// keyAgeAllowed applies the SSH user's key age policy, if any, to a GitHub
+// key; rejected keys are logged like other policy rejections
+func (a *app) keyAgeAllowed(username string, key resolver.Key) bool {
+	policy, ok := a.cfg.KeyAgePolicyFor(username)
+	if !ok {
+		return true
+	}
+	if err := policy.Check(key.CreatedAt, time.Now()); err != nil {
+		a.log.Warn("key rejected by policy", "policy", "key_age", "key", key.Line, "github_user", key.GitHubUser, "reason", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/dgarifullin/charon-key/internal/config"
+	"github.com/dgarifullin/charon-key/internal/errors"
+	"github.com/dgarifullin/charon-key/internal/logger"
+	"github.com/dgarifullin/charon-key/internal/policy"
+	"github.com/dgarifullin/charon-key/internal/ssh"
+)
+
+// keyPolicy applies the per-key policies to GitHub keys, so keysForUser and
+// streamKeys serve the same keys: malformed keys are dropped with
+// --drop-invalid-keys and otherwise fail secure, then keys not approved by
+// --fips or allowed by --key-types/--min-rsa-bits are dropped
+// Rejections are counted per policy for alert
+type keyPolicy struct {
+	a        *app
+	username string
+
+	// fips and types are the policies in force (nil = none)
+	fips  *policy.Policy
+	types *policy.Policy
+
+	invalid, fipsRejected, typeRejected int
+}
+
+// newKeyPolicy returns the key policies in force for an SSH user
+func (a *app) newKeyPolicy(username string) *keyPolicy {
+	p := &keyPolicy{a: a, username: username}
+	if a.cfg.FIPS && a.cfg.FeatureEnabled(config.FeatureFIPS, username) {
+		p.fips = policy.FIPS()
+	}
+	if len(a.cfg.KeyTypes) > 0 || a.cfg.MinRSABits > 0 {
+		p.types = &policy.Policy{Name: "key-types", AllowedTypes: a.cfg.KeyTypes, MinRSABits: a.cfg.MinRSABits}
+	}
+	return p
+}
+
+// allow reports whether a GitHub key may be served, logging why not
+func (p *keyPolicy) allow(key string) bool {
+	cfg, log := p.a.cfg, p.a.log
+
+	// Validate keys (fail secure on invalid keys, unless they're dropped)
+	if cfg.DropInvalidKeys {
+		err := policy.ValidateKey(key)
+		if err == nil && !isValidKeyFormat(key) {
+			err = fmt.Errorf("key does not match valid SSH key format")
+		}
+		if err != nil {
+			log.Warn("dropped invalid key", "ssh_username", p.username, "key", key, "reason", err)
+			p.invalid++
+			return false
+		}
+	}
+	if !isValidKeyFormat(key) {
+		log.Error("invalid key format detected", "key", key)
+		p.a.alertInvalidKey(p.username)
+		errors.HandleInvalidKey(key, fmt.Errorf("key does not match valid SSH key format"))
+	}
+
+	// Drop keys using algorithms not approved by the FIPS policy
+	if p.fips != nil {
+		if err := p.fips.Check(key); err != nil {
+			log.Warn("key rejected by policy", "policy", "fips", "key", key, "reason", err)
+			p.fipsRejected++
+			return false
+		}
+	}
+
+	// Drop key types and RSA sizes not allowed by --key-types/--min-rsa-bits
+	if p.types != nil {
+		if err := p.types.Check(key); err != nil {
+			log.Warn("key rejected by policy", "policy", p.types.Name, "key", key, "reason", err)
+			p.typeRejected++
+			return false
+		}
+	}
+	return true
+}
+
+// alert reports the keys each policy rejected
+func (p *keyPolicy) alert() {
+	p.a.alertPolicyRejections(p.username, "key validation", p.invalid)
+	p.a.alertPolicyRejections(p.username, "FIPS", p.fipsRejected)
+	p.a.alertPolicyRejections(p.username, "key type", p.typeRejected)
+}
+
+// fipsApproved returns the authorized_keys lines whose key algorithm the
+// FIPS policy approves, so --fips covers keys charon-key didn't fetch too;
+// kind names the keys in logs
+func fipsApproved(lines []string, log *logger.Logger, kind string) []string {
+	fips := policy.FIPS()
+	var kept []string
+	for _, line := range lines {
+		key, err := ssh.ParseAuthorizedKey(line)
+		if err == nil {
+			err = fips.Check(key.Type + " " + key.Blob)
+		}
+		if err != nil {
+			log.Warn("key rejected by policy", "policy", "fips", "keys", kind, "key", line, "reason", err)
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return kept
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testEd25519Key = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"
+	testRSA1024Key = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAAgQDJfBCpVp6S5IyOa3jZMIvPZrEEg0qyiIdk9axQn9dLIsB+8lgL6SKNKhLyzh++NUEvCDY+S4MqX2TTHnav3BcTOKLNdmw73A0tuCdzeYCmHKIQSfEOMJsxJzAckDWt47xL80znQCpXaglOCfIsIeiX7deeine4mH3VGsNHOMf1lQ=="
+	testECDSAKey   = "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBIdxOpRRpb1LKSGWC17fXmXp6yZ3CjQg8FfRBzRwxLsFhWZqHbx6nXLnqsozt8wdr1nqwtJL9QTwHXKG743CatE="
+
+	testSKEd25519Key = "sk-ssh-ed25519@openssh.com AAAAGnNrLXNzaC1lZDI1NTE5QG9wZW5zc2guY29tAAAAIAABAgMEBQYHCAkKCwwNDg8QERITFBUWFxgZGhscHR4fAAAABHNzaDo="
+
+	// testMismatchedKey claims to be an RSA key, but holds an ed25519 one
+	testMismatchedKey = "ssh-rsa AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"
+)
+
+// testApp returns an app resolving SSH user nosuchuser to GitHub user alice,
+// whose keys a test server serves, with further command-line args
+func testApp(t *testing.T, keys []string, args ...string) *app {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/alice.keys" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, strings.Join(keys, "\n")+"\n")
+	}))
+	t.Cleanup(server.Close)
+
+	var opts options
+	fs := newFlagSet("charon-key", &opts)
+	args = append([]string{"--github-url", server.URL, "--cache-dir", t.TempDir(),
+		"--user-map", "nosuchuser:alice", "--log-level", "error"}, args...)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	a, err := newApp(opts, newLogger(opts))
+	if err != nil {
+		t.Fatalf("newApp() error = %v", err)
+	}
+	return a
+}
+
+// streamed returns the keys streamKeys writes to stdout
+func streamed(t *testing.T, a *app, username string) []string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	err = a.streamKeys(username)
+	os.Stdout = stdout
+	w.Close()
+	if err != nil {
+		t.Fatalf("streamKeys() error = %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	return strings.Fields(strings.ReplaceAll(string(data), "\n", " "))
+}
+
+// keyBlobs returns the type and blob of each key, dropping comments
+func keyBlobs(keys []string) []string {
+	var blobs []string
+	for _, key := range keys {
+		fields := strings.Fields(key)
+		blobs = append(blobs, fields[0], fields[1])
+	}
+	return blobs
+}
+
+// TestKeyPolicy checks that keysForUser and streamKeys apply the same
+// per-key policies
+func TestKeyPolicy(t *testing.T) {
+	keys := []string{testEd25519Key, testRSA1024Key, testECDSAKey}
+
+	tests := []struct {
+		name string
+		keys []string
+		args []string
+		want []string
+	}{
+		{name: "no policy", keys: keys, want: keys},
+		{name: "key types", keys: keys, args: []string{"--key-types", "ed25519,rsa"}, want: []string{testEd25519Key, testRSA1024Key}},
+		{name: "security keys", keys: append(keys, testSKEd25519Key), args: []string{"--key-types", "sk-ed25519", "--drop-invalid-keys"}, want: []string{testSKEd25519Key}},
+		{name: "min RSA bits", keys: keys, args: []string{"--min-rsa-bits", "2048"}, want: []string{testEd25519Key, testECDSAKey}},
+		{name: "key types and min RSA bits", keys: keys, args: []string{"--key-types", "rsa,ecdsa", "--min-rsa-bits", "2048"}, want: []string{testECDSAKey}},
+		{
+			name: "drop invalid keys",
+			keys: []string{testMismatchedKey, testECDSAKey},
+			args: []string{"--drop-invalid-keys"},
+			want: []string{testECDSAKey},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			batch, err := testApp(t, tt.keys, tt.args...).keysForUser("nosuchuser")
+			if err != nil {
+				t.Fatalf("keysForUser() error = %v", err)
+			}
+			if got := keyBlobs(batch); !reflect.DeepEqual(got, keyBlobs(tt.want)) {
+				t.Errorf("keysForUser() = %v, want %v", batch, tt.want)
+			}
+
+			stream := streamed(t, testApp(t, tt.keys, tt.args...), "nosuchuser")
+			if !reflect.DeepEqual(stream, keyBlobs(tt.want)) {
+				t.Errorf("streamKeys() wrote %v, want %v", stream, tt.want)
+			}
+		})
+	}
+}
+
+// TestFIPSLocalKeys checks that --fips applies to existing and break-glass
+// keys, not only to GitHub keys
+func TestFIPSLocalKeys(t *testing.T) {
+	a := testApp(t, []string{testECDSAKey})
+	a.cfg.FIPS = true
+
+	t.Run("existing keys", func(t *testing.T) {
+		existing := []string{`no-pty ` + testRSA1024Key, testEd25519Key, testSKEd25519Key}
+		if got := a.filterExisting("nosuchuser", existing); !reflect.DeepEqual(got, []string{testEd25519Key}) {
+			t.Errorf("filterExisting() = %v, want only the approved key", got)
+		}
+	})
+
+	t.Run("break-glass keys", func(t *testing.T) {
+		var opts options
+		fs := newFlagSet("charon-key", &opts)
+		if err := fs.Parse([]string{"--fips",
+			"--break-glass-key", testRSA1024Key, "--break-glass-key", testECDSAKey}); err != nil {
+			t.Fatal(err)
+		}
+		if got := loadBreakGlassKeys(opts, a.log); !reflect.DeepEqual(got, []string{testECDSAKey}) {
+			t.Errorf("loadBreakGlassKeys() = %v, want only the approved key", got)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dgarifullin/charon-key/internal/errors"
+	"github.com/dgarifullin/charon-key/internal/logger"
+	"github.com/dgarifullin/charon-key/internal/redact"
+	"github.com/dgarifullin/charon-key/internal/ssh"
+)
+
+// runLintAuthorizedKeys reports malformed lines, duplicate keys, weak
+// algorithms and risky options in an authorized_keys file, e.g. before
+// enabling merge or sync mode on legacy hosts; exits 1 if any are found
+// Usage: charon-key lint-authorized-keys [--format text|json] PATH
+func runLintAuthorizedKeys(args []string) {
+	var format, logLevel string
+	fs := flag.NewFlagSet("charon-key lint-authorized-keys", flag.ExitOnError)
+	fs.StringVar(&format, "format", "text", "Report format: text|json")
+	fs.StringVar(&logLevel, "log-level", "info", "Log level: debug|info|warn|error")
+	fs.Parse(args)
+
+	log := logger.NewLogger(logLevel).WithRedactor(redact.Default())
+
+	fail := func(message string, code errors.ExitCode, err error) {
+		log.Error(message, "error", err)
+		errors.ExitWithError(errors.NewAppError(message, code, err))
+	}
+
+	if fs.NArg() != 1 {
+		fail("configuration error", errors.ExitConfigError, fmt.Errorf("lint-authorized-keys needs exactly one file"))
+	}
+	if format != "text" && format != "json" {
+		fail("configuration error", errors.ExitConfigError, fmt.Errorf("invalid format %q (expected text or json)", format))
+	}
+	path := fs.Arg(0)
+
+	file, err := os.Open(path)
+	if err != nil {
+		fail("failed to read authorized_keys", errors.ExitPermissionError, err)
+	}
+	issues, err := ssh.LintAuthorizedKeys(file)
+	file.Close()
+	if err != nil {
+		fail("failed to read authorized_keys", errors.ExitPermissionError, err)
+	}
+
+	if format == "json" {
+		if issues == nil {
+			issues = []ssh.LintIssue{}
+		}
+		data, _ := json.MarshalIndent(map[string]any{"path": path, "issues": issues}, "", "  ")
+		fmt.Println(string(data))
+	} else {
+		for _, issue := range issues {
+			fmt.Printf("%s:%d: %s: %s: %s", path, issue.Line, issue.Severity, issue.Check, issue.Message)
+			if issue.Fingerprint != "" {
+				fmt.Printf(" (%s)", issue.Fingerprint)
+			}
+			fmt.Println()
+		}
+	}
+
+	log.Info("linted authorized_keys", "path", path, "issues", len(issues))
+	if len(issues) > 0 {
+		errors.ExitWithCode(errors.ExitGeneralError)
+	}
+	errors.ExitWithCode(errors.ExitSuccess)
+}
@@ -1,18 +1,31 @@
 package main
 
 import (
+	stderrors "errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
+	"github.com/dgarifullin/charon-key/internal/access"
+	"github.com/dgarifullin/charon-key/internal/audit"
 	"github.com/dgarifullin/charon-key/internal/cache"
 	"github.com/dgarifullin/charon-key/internal/config"
+	"github.com/dgarifullin/charon-key/internal/confine"
+	"github.com/dgarifullin/charon-key/internal/credentials"
 	"github.com/dgarifullin/charon-key/internal/errors"
+	"github.com/dgarifullin/charon-key/internal/fault"
 	"github.com/dgarifullin/charon-key/internal/github"
+	"github.com/dgarifullin/charon-key/internal/ldap"
 	"github.com/dgarifullin/charon-key/internal/logger"
+	"github.com/dgarifullin/charon-key/internal/policy"
+	"github.com/dgarifullin/charon-key/internal/redact"
 	"github.com/dgarifullin/charon-key/internal/resolver"
+	"github.com/dgarifullin/charon-key/internal/revoke"
 	"github.com/dgarifullin/charon-key/internal/ssh"
 )
 
@@ -23,125 +36,963 @@ var (
 )
 
 func main() {
-	var showVersion bool
-	var showHelp bool
-	var userMapStr string
-	var cacheDir string
-	var cacheTTLMinutes int
-	var logLevel string
-
-	flag.BoolVar(&showVersion, "version", false, "Show version information")
-	flag.BoolVar(&showVersion, "v", false, "Show version information (shorthand)")
-	flag.BoolVar(&showHelp, "help", false, "Show help information")
-	flag.BoolVar(&showHelp, "h", false, "Show help information (shorthand)")
-	flag.StringVar(&userMapStr, "user-map", "", "User mapping (required): sshuser1:githubuser1,sshuser1:githubuser2")
-	flag.StringVar(&cacheDir, "cache-dir", "", "Cache directory (optional, default: OS temp)")
-	flag.IntVar(&cacheTTLMinutes, "cache-ttl", 5, "Cache TTL in minutes (optional, default: 5)")
-	flag.StringVar(&logLevel, "log-level", "info", "Log level: debug|info|warn|error (optional, default: info)")
-
-	flag.Parse()
-
-	if showVersion {
+	// Subcommands; anything else is an AuthorizedKeysCommand invocation
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "resolve":
+			runResolve(os.Args[2:])
+			return
+		case "cache":
+			runCache(os.Args[2:])
+			return
+		case "check":
+			runCheck(os.Args[2:])
+			return
+		case "audit":
+			runAudit(os.Args[2:])
+			return
+		case "inventory":
+			runInventory(os.Args[2:])
+			return
+		case "gpg":
+			runGPG(os.Args[2:])
+			return
+		case "mock-github":
+			runMockGitHub(os.Args[2:])
+			return
+		case "compile-config":
+			runCompileConfig(os.Args[2:])
+			return
+		case "config":
+			runConfig(os.Args[2:])
+			return
+		case "export-org":
+			runExportOrg(os.Args[2:])
+			return
+		case "lint-authorized-keys":
+			runLintAuthorizedKeys(os.Args[2:])
+			return
+		case "rollback":
+			runRollback(os.Args[2:])
+			return
+		case "revoke":
+			runRevoke(os.Args[2:])
+			return
+		case "print-apparmor":
+			runPrintPolicy("print-apparmor", os.Args[2:], confine.AppArmor)
+			return
+		case "print-selinux-te":
+			runPrintPolicy("print-selinux-te", os.Args[2:], confine.SELinuxTE)
+			return
+		}
+	}
+	runAuthorizedKeys(os.Args[1:])
+}
+
+// newFlagSet creates a flag set with the options shared by all commands
+func newFlagSet(name string, opts *options) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = printHelp
+
+	fs.BoolVar(&opts.showVersion, "version", false, "Show version information")
+	fs.BoolVar(&opts.showVersion, "v", false, "Show version information (shorthand)")
+	fs.BoolVar(&opts.showHelp, "help", false, "Show help information")
+	fs.BoolVar(&opts.showHelp, "h", false, "Show help information (shorthand)")
+	fs.StringVar(&opts.explainExitCode, "explain-exit-code", "", "Describe an exit code (or \"all\") and exit")
+	fs.BoolVar(&opts.profileStartup, "profile-startup", false, "Log how long each startup and resolution phase took (optional)")
+	fs.StringVar(&opts.debugBundle, "debug-bundle", "", "On fatal errors, write a redacted debug bundle (JSON) to this file (optional)")
+	fs.StringVar(&opts.errorFormat, "error-format", string(errors.FormatText), "Error report on stderr at exit: text|json (optional, default: text)")
+	fs.StringVar(&opts.userMap, "user-map", "", "User mapping (required unless --config): sshuser1:githubuser1,sshuser1:githubuser2")
+	fs.StringVar(&opts.shadowConfigFile, "shadow-config", "", "Candidate JSON config to evaluate and log differences for, without affecting output (optional)")
+	fs.StringVar(&opts.configFile, "config", "", "JSON config file with user_map and roles (optional)")
+	fs.StringVar(&opts.hostClass, "host-class", "", "Host class for conditional mappings, e.g. prod (optional)")
+	fs.StringVar(&opts.profile, "profile", "", "Config file profile to apply (optional, default: the profile matching the hostname)")
+	fs.Var(&opts.excludeComments, "exclude-comment", "Drop keys whose comment matches: sshuser:pattern (optional, repeatable)")
+	fs.Var(&opts.excludeExisting, "exclude-existing", "Drop existing keys matching a fingerprint or comment pattern: sshuser:SHA256:...|sshuser:pattern (optional, repeatable)")
+	fs.StringVar(&opts.cacheDir, "cache-dir", "", "Cache directory (optional, default: OS temp)")
+	fs.IntVar(&opts.cacheTTLMinutes, "cache-ttl", 5, "Cache TTL in minutes (optional, default: 5)")
+	fs.IntVar(&opts.cacheTTLJitter, "cache-ttl-jitter", 0, "Shorten each cache entry's TTL by a stable per-host 0-N% (optional, default: 0)")
+	fs.BoolVar(&opts.cachePerUser, "cache-per-user", false, "Cache each SSH user's keys in its own subdirectory instead of sharing them (optional)")
+	fs.BoolVar(&opts.refresh, "refresh", false, "Bypass cached keys and fetch from GitHub; the cache is still updated (optional)")
+	fs.DurationVar(&opts.keyGracePeriod, "key-grace-period", 0, "Keep serving keys removed from GitHub for this long, e.g. 24h (optional, default: 0 = disabled)")
+	fs.StringVar(&opts.revocationFile, "revocation-file", revoke.DefaultPath, "Fingerprints and GitHub users whose keys are never served (optional)")
+	fs.StringVar(&opts.logLevel, "log-level", "info", "Log level: debug|info|warn|error (optional, default: info)")
+	fs.StringVar(&opts.correlationID, "correlation-id", "", "ID included in every log record and alert (optional, default: random per invocation)")
+	fs.StringVar(&opts.logFile, "log-file", "", "Also write logs to this file, e.g. where sshd discards stderr (optional)")
+	fs.IntVar(&opts.logFileMaxSize, "log-file-max-size", 10, "Rotate the log file before it exceeds this many MB, 0 for no limit (optional, default: 10)")
+	fs.DurationVar(&opts.logFileMaxAge, "log-file-max-age", 0, "Rotate the log file when a period of this length ends, e.g. 24h (optional)")
+	fs.IntVar(&opts.logFileKeep, "log-file-keep", 5, "Number of rotated log files kept (optional, default: 5)")
+	fs.IntVar(&opts.debugOnError, "debug-on-error", 0, "Keep the last N records below the log level and write them to stderr if the run fails (optional)")
+	fs.IntVar(&opts.logSample, "log-sample", 0, "Log the first and every Nth debug or info record of each message (optional, default: all)")
+	fs.IntVar(&opts.logRateLimit, "log-rate-limit", 0, "Log at most N records of each message per second (optional, default: unlimited)")
+	fs.StringVar(&opts.redact, "redact", "tokens,keys", "Scrub from logs and error reports: tokens,keys,usernames or none (optional, default: tokens,keys)")
+	fs.StringVar(&opts.githubURL, "github-url", github.BaseURL, "Base URL serving <user>.keys, e.g. a mock-github server (optional)")
+	fs.StringVar(&opts.githubTokenFile, "github-token-file", "", "File containing a GitHub token; enables GraphQL bulk fetches (optional)")
+	fs.StringVar(&opts.githubToken, "github-token-source", "", "Where to read the GitHub token: file:PATH|env:NAME|keyring:SERVICE/ACCOUNT|vault:PATH#FIELD (optional)")
+	fs.StringVar(&opts.vaultAddr, "vault-addr", "", "Vault server for vault: credential sources (optional, default: $VAULT_ADDR)")
+	fs.StringVar(&opts.vaultTokenSource, "vault-token-source", "", "Where to read the Vault token: file:PATH|env:NAME|keyring:SERVICE/ACCOUNT (optional, default: $VAULT_TOKEN)")
+	fs.BoolVar(&opts.fips, "fips", false, "Restrict key algorithms and TLS to a FIPS-approved set (optional)")
+	fs.StringVar(&opts.keyTypes, "key-types", "", "Comma-separated key types to serve, e.g. ed25519,ecdsa (optional, default: all)")
+	fs.IntVar(&opts.minRSABits, "min-rsa-bits", 0, "Drop RSA keys with fewer bits (optional, default: 0 = no minimum)")
+	fs.BoolVar(&opts.dropInvalidKeys, "drop-invalid-keys", false, "Decode every key and drop malformed ones instead of failing (optional)")
+	fs.Var(&opts.breakGlassKeys, "break-glass-key", "Key always included in output, even on failures (optional, repeatable)")
+	fs.StringVar(&opts.breakGlassFile, "break-glass-file", "", "File of keys always included in output, even on failures (optional)")
+	fs.BoolVar(&opts.denyOnEmpty, "deny-on-empty", false, "Emit nothing and exit with a distinct code when no keys resolve (optional)")
+	fs.BoolVar(&opts.allowEmpty, "allow-empty", false, "Still emit local authorized_keys when no keys resolve (optional, default)")
+	fs.BoolVar(&opts.provenance, "provenance", false, "Append the key's source to each emitted key's comment (optional)")
+	fs.StringVar(&opts.outputTemplate, "output-template", "", "Go text/template rendering the output from .SSHUser and .Keys (optional)")
+	fs.StringVar(&opts.outputTemplateFile, "output-template-file", "", "File containing the --output-template (optional)")
+	fs.StringVar(&opts.passwdSource, "passwd-source", string(ssh.PasswdAuto), "User lookup: auto|os|getent (optional, default: auto)")
+	fs.StringVar(&opts.authorizedKeysFile, "authorized-keys-file", "", "authorized_keys file read and synced, like sshd's AuthorizedKeysFile; %u is the SSH username, %h its home (optional, default: %h/.ssh/authorized_keys)")
+	fs.StringVar(&opts.chrootDir, "chroot-dir", "", "Chroot directory the home directory is inside, like sshd's ChrootDirectory; %u is the SSH username, %h its home (optional)")
+	fs.Var(&opts.chrootUsers, "chroot-user", "SSH user whose home is inside --chroot-dir (optional, repeatable, default: every user)")
+	fs.Var(&opts.existingKeysFiles, "existing-keys-file", "Further key file merged after authorized_keys, e.g. %h/.ssh/authorized_keys2; %u is the SSH username, %h its home (optional, repeatable)")
+	fs.StringVar(&opts.dedup, "dedup", string(ssh.DedupPreferLocal), "Copy kept of keys both local and on GitHub: prefer-local|prefer-github|keep-both-with-comment (optional)")
+	fs.BoolVar(&opts.fixPermissions, "fix-permissions", false, "Fix unsafe authorized_keys permissions/ownership instead of only reporting them (optional)")
+	fs.BoolVar(&opts.stream, "stream", false, "Write keys to stdout as they're resolved instead of sorted at the end (optional)")
+	fs.BoolVar(&opts.unprivileged, "unprivileged", false, "Run as a dedicated non-root AuthorizedKeysCommandUser: skip, or use --read-helper for, unreadable authorized_keys (optional)")
+	fs.StringVar(&opts.readHelper, "read-helper", "", "charon-key-read-keys binary reading authorized_keys files with --unprivileged (optional)")
+	fs.BoolVar(&opts.sync, "sync", false, "Write keys into the user's authorized_keys instead of stdout (optional)")
+	fs.IntVar(&opts.syncBackups, "sync-backups", 3, "Number of authorized_keys backups to keep in sync mode (optional, default: 3)")
+	fs.StringVar(&opts.output, "output", "", "Atomically write keys to this file instead of stdout; %u is the SSH username, %h its home (optional)")
+	fs.StringVar(&opts.outputMode, "output-mode", "0600", "Permissions of the --output file (optional, default: 0600)")
+	fs.StringVar(&opts.principalsFile, "principals-file", "", "AuthorizedPrincipalsFile to write in sync mode; %u is the SSH username, %h its home (optional)")
+	fs.IntVar(&opts.timeoutSeconds, "timeout", 0, "Overall key resolution timeout in seconds (optional, default: 0 = none)")
+	fs.IntVar(&opts.userTimeoutSeconds, "user-timeout", 0, "Per-GitHub-user fetch timeout in seconds (optional, default: 0 = none)")
+	fs.IntVar(&opts.httpTimeoutSeconds, "http-timeout", int(github.DefaultTimeout/time.Second), "Timeout of each HTTP request to GitHub in seconds (optional, default: 10)")
+	fs.IntVar(&opts.retries, "retries", github.MaxRetries, "Retries of network errors, timeouts and 5xx responses per GitHub user (optional, default: 3)")
+	fs.DurationVar(&opts.retryDelay, "retry-delay", github.RetryDelay, "Delay before the first retry; retry n waits n times as long (optional, default: 1s)")
+	fs.StringVar(&opts.ldapURL, "ldap-url", "", "LDAP server URL for dynamic user mapping (optional)")
+	fs.StringVar(&opts.ldapBaseDN, "ldap-base-dn", "", "LDAP search base (required with --ldap-url)")
+	fs.StringVar(&opts.ldapFilter, "ldap-filter", ldap.DefaultFilter, "LDAP filter; %s is the SSH username (optional)")
+	fs.StringVar(&opts.ldapAttribute, "ldap-attribute", ldap.DefaultAttribute, "LDAP attribute holding GitHub usernames (optional)")
+	fs.StringVar(&opts.ldapBindDN, "ldap-bind-dn", "", "LDAP bind DN (optional, default: anonymous)")
+	fs.StringVar(&opts.ldapPasswordFile, "ldap-password-file", "", "File containing the LDAP bind password (optional)")
+	fs.Var(&opts.canaryFingerprints, "canary-fingerprint", "SHA256 fingerprint of a honeypot key to raise audit events for (optional, repeatable)")
+	fs.StringVar(&opts.canaryWebhook, "canary-webhook", "", "URL to POST canary audit events to (optional)")
+	// Hidden: fault injection for rehearsing failure modes in staging
+	fs.StringVar(&opts.fault, "fault", os.Getenv(fault.EnvVar), "Inject faults: network-timeout|network-error|slow[:DURATION]|server-error|rate-limit|corrupt-cache")
+	fs.StringVar(&opts.accessURL, "access-url", "", "Access-request system confirming a just-in-time grant before keys are emitted (optional)")
+	fs.StringVar(&opts.accessTokenSource, "access-token-source", "", "Where to read the access system's bearer token: file:PATH|env:NAME|keyring:SERVICE/ACCOUNT|vault:PATH#FIELD (optional)")
+	fs.StringVar(&opts.mappingConflicts, "mapping-conflicts", "", "When config file, profile and --user-map configure an SSH user differently: ignore|warn|error (optional, default: warn)")
+	fs.BoolVar(&opts.denyWildcard, "deny-wildcard", false, "Ignore \"*\" mappings from any source; only explicitly mapped SSH users are resolved (optional)")
+	fs.StringVar(&opts.wildcardOrg, "wildcard-org", "", "Only resolve SSH users mapped by the \"*\" wildcard if they are active members of this GitHub org (optional, requires --github-token-source)")
+	fs.StringVar(&opts.alertWebhook, "alert-webhook", "", "URL to POST an alert to on stale cache, resolution failures, deny-on-empty and policy violations (optional)")
+	fs.BoolVar(&opts.alertKeysetChanges, "alert-keyset-changes", false, "Also alert when an SSH user's keys change (optional)")
+
+	return fs
+}
+
+// handleInfoFlags prints version, help or exit code information and exits if
+// requested
+func handleInfoFlags(opts options) {
+	if opts.explainExitCode != "" {
+		explainExitCode(opts.explainExitCode)
+	}
+
+	if opts.showVersion {
 		fmt.Printf("charon-key version %s\n", version)
 		fmt.Printf("commit: %s\n", commit)
 		fmt.Printf("date: %s\n", date)
 		os.Exit(0)
 	}
 
-	if showHelp {
+	if opts.showHelp {
 		printHelp()
 		os.Exit(0)
 	}
+}
 
-	// Initialize logger first (for error logging)
-	log := logger.NewLogger(logLevel)
+// explainExitCode prints the description of an exit code, or of all of them,
+// and exits
+func explainExitCode(value string) {
+	codes := errors.ExitCodes()
+	if value != "all" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid exit code: %q\n", value)
+			errors.ExitWithCode(errors.ExitConfigError)
+		}
+		codes = []errors.ExitCode{errors.ExitCode(n)}
+	}
 
-	// Parse configuration
-	cfg, err := parseConfig(userMapStr, cacheDir, cacheTTLMinutes, logLevel)
+	for _, code := range codes {
+		explanation, err := code.Explain()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			errors.ExitWithCode(errors.ExitConfigError)
+		}
+		fmt.Println(explanation)
+	}
+	os.Exit(0)
+}
+
+// applyErrorFormat sets how errors are reported at exit
+func applyErrorFormat(opts options) {
+	format, err := errors.ParseFormat(opts.errorFormat)
 	if err != nil {
-		log.Error("configuration error", "error", err)
+		fmt.Fprintln(os.Stderr, err)
+		errors.ExitWithCode(errors.ExitConfigError)
+	}
+	errors.SetFormat(format)
+}
+
+// newLogger creates the logger, tagging every record with the invocation's
+// correlation ID, scrubbing what --redact selects from it and from error
+// reports, and sampling and rate limiting records as configured
+// With --log-file, records are written to stderr and to the (rotated) file
+// With --debug-on-error, records below the log level are kept in memory and
+// written out if the invocation exits with a failure
+func newLogger(opts options) *logger.Logger {
+	if opts.debugOnError < 0 || opts.logSample < 0 || opts.logRateLimit < 0 {
+		fmt.Fprintln(os.Stderr, "debug-on-error, log-sample and log-rate-limit must not be negative")
+		errors.ExitWithCode(errors.ExitConfigError)
+	}
+	if opts.logFileMaxSize < 0 || opts.logFileMaxAge < 0 || opts.logFileKeep < 0 {
+		fmt.Fprintln(os.Stderr, "log-file-max-size, log-file-max-age and log-file-keep must not be negative")
+		errors.ExitWithCode(errors.ExitConfigError)
+	}
+	redactor, err := redact.Parse(opts.redact)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
 		errors.ExitWithCode(errors.ExitConfigError)
 	}
+	errors.SetRedact(redactor.String)
+
+	id := opts.correlationID
+	if id == "" {
+		id = logger.NewCorrelationID()
+	}
+	var out io.Writer = os.Stderr
+	if opts.logFile != "" {
+		out = io.MultiWriter(os.Stderr, &logger.RotatingFile{
+			Path:    opts.logFile,
+			MaxSize: int64(opts.logFileMaxSize) * 1024 * 1024,
+			MaxAge:  opts.logFileMaxAge,
+			Keep:    opts.logFileKeep,
+		})
+	}
+
+	log := logger.NewLoggerTo(opts.logLevel, out)
+	if opts.debugOnError > 0 {
+		var buffer *logger.DebugBuffer
+		log, buffer = logger.NewBufferedLogger(opts.logLevel, out, opts.debugOnError)
+		errors.SetOnFailure(func() {
+			fmt.Fprintf(out, "--- last debug records before the failure ---\n")
+			buffer.Dump(out)
+			fmt.Fprintf(out, "--- end of debug records ---\n")
+		})
+	}
+	return log.WithCorrelationID(id).WithRedactor(redactor).
+		WithSampling(opts.logSample, opts.logRateLimit)
+}
+
+// runAuthorizedKeys resolves keys for the SSH user passed by sshd and prints
+// them (or syncs them into authorized_keys)
+func runAuthorizedKeys(args []string) {
+	var opts options
+	fs := newFlagSet("charon-key", &opts)
+	fs.Parse(args)
+	handleInfoFlags(opts)
+	applyErrorFormat(opts)
+
+	// Initialize logger first (for error logging)
+	log := newLogger(opts)
+
+	// Load break-glass keys early so every failure path below can emit them
+	breakGlassKeys := loadBreakGlassKeys(opts, log)
+	bundle := newDebugBundle(opts.debugBundle, log)
+
+	a, err := newApp(opts, log)
+	if err != nil {
+		bundle.write(nil, fs.Arg(0), err)
+		if !isConfigError(err) {
+			emitBreakGlass(breakGlassKeys, log)
+		}
+		errors.ExitWithError(err)
+	}
+	a.breakGlassKeys = breakGlassKeys
+	bundle.attach(a)
+	cfg := a.cfg
 
 	// Get SSH username from positional arguments (passed by SSH daemon)
-	args := flag.Args()
-	if len(args) > 0 {
-		cfg.SSHUsername = args[0]
+	if fs.NArg() > 0 {
+		cfg.SSHUsername = fs.Arg(0)
 	}
 
 	// Log startup configuration
 	log.Info("starting charon-key", "version", version, "ssh_username", cfg.SSHUsername)
-	log.Debug("configuration", "user_map", cfg.UserMap, "cache_dir", cfg.CacheDir, "cache_ttl", cfg.CacheTTL, "log_level", cfg.LogLevel)
+	log.Debug("configuration", "profile", cfg.Profile, "user_map", cfg.UserMap, "cache_dir", cfg.CacheDir, "cache_ttl", cfg.CacheTTL, "log_level", cfg.LogLevel)
+
+	// Streaming mode: write keys to stdout as they're resolved
+	if cfg.Stream {
+		if err := a.streamKeys(cfg.SSHUsername); err != nil {
+			bundle.write(a, cfg.SSHUsername, err)
+			a.logSummary(err)
+			emitBreakGlass(breakGlassKeys, log)
+			errors.ExitWithError(err)
+		}
+		a.exitIfEmpty()
+		a.logSummary(nil)
+		log.Debug("completed successfully")
+		errors.ExitWithCode(errors.ExitSuccess)
+	}
+
+	// Resolve keys (an empty username will use the wildcard mapping if available)
+	keys, err := a.keysForUser(cfg.SSHUsername)
+	if err != nil {
+		bundle.write(a, cfg.SSHUsername, err)
+		a.logSummary(err)
+		emitBreakGlass(breakGlassKeys, log)
+		errors.ExitWithError(err)
+	}
+
+	// Initialize SSH manager
+	sshManager, err := a.sshManagerFor(cfg.SSHUsername)
+	if err != nil {
+		log.Warn("failed to initialize SSH manager, using current user", "error", err)
+		sshManager, err = ssh.NewManager("")
+		if err != nil {
+			log.Error("failed to initialize SSH manager with current user", "error", err)
+			err = errors.NewAppError("failed to initialize SSH manager", errors.ExitPermissionError, err)
+			bundle.write(a, cfg.SSHUsername, err)
+			a.logSummary(err)
+			emitBreakGlass(breakGlassKeys, log)
+			errors.ExitWithError(err)
+		}
+	}
+
+	if err := a.writeKeys(sshManager, cfg.SSHUsername, keys); err != nil {
+		bundle.write(a, cfg.SSHUsername, err)
+		a.logSummary(err)
+		errors.ExitWithError(err)
+	}
+
+	// Compare with the candidate configuration once the output is written
+	a.evaluateShadow(cfg.SSHUsername, keys)
+	a.exitIfEmpty()
+	a.logSummary(nil)
+
+	log.Debug("completed successfully", "total_keys", len(keys))
+	errors.ExitWithCode(errors.ExitSuccess)
+}
+
+// exitIfEmpty exits with ExitEmpty if resolution succeeded but no key was
+// output, so monitoring can tell users locked out by design (nothing
+// mapped on GitHub, nothing local) from failures
+// Sync mode writes authorized_keys rather than stdout and always exits 0
+func (a *app) exitIfEmpty() {
+	if a.cfg.Sync || a.keysServed > 0 {
+		return
+	}
+	err := errors.NewAppError("no keys to output", errors.ExitEmpty, nil)
+	a.logSummary(err)
+	errors.ExitWithError(err)
+}
+
+// app holds the state shared by every SSH user resolved in one invocation,
+// so batch runs reuse the cache, resolver and HTTP connections
+type app struct {
+	cfg            *config.Config
+	log            *logger.Logger
+	cache          *cache.Manager
+	fetcher        *github.Fetcher
+	resolver       *resolver.Resolver
+	breakGlassKeys []string
+
+	// access confirms just-in-time grants (nil = disabled)
+	access *access.Checker
+
+	// shadow resolves the candidate configuration of --shadow-config (nil = disabled)
+	shadow *resolver.Resolver
+
+	// revoked is the revocation list, also applied to the keys the resolver
+	// doesn't serve: existing, rolled back and break-glass keys
+	revoked *revoke.List
+
+	// outputTemplate renders the output (nil = one key per line), given
+	// the sources of the keys served by the last keysForUser call
+	outputTemplate *template.Template
+	served         map[string]resolver.Key
+
+	// profile times startup phases for --profile-startup (nil = disabled)
+	profile *startupProfile
+
+	// started, sshUsers and keysServed feed the invocation summary
+	started    time.Time
+	sshUsers   int
+	keysServed int
+}
+
+// newApp validates the configuration and initializes the cache, fetcher and
+// resolver; errors are logged and returned as *errors.AppError
+func newApp(opts options, log *logger.Logger) (*app, error) {
+	started := time.Now()
+	profile := newStartupProfile(opts.profileStartup)
+	profile.mark("init")
+
+	// Parse configuration
+	cfg, err := parseConfig(opts)
+	if err != nil {
+		log.Error("configuration error", "error", err)
+		return nil, errors.NewAppError("configuration error", errors.ExitConfigError, err)
+	}
+	profile.mark("config")
+
+	for _, conflict := range cfg.MappingConflicts {
+		log.Warn("conflicting mappings merged", "setting", conflict.Setting, "sources", conflict.Sources)
+	}
+
+	if cfg.Unprivileged && os.Geteuid() == 0 {
+		log.Warn("--unprivileged set but running as root; set AuthorizedKeysCommandUser to a dedicated user")
+	}
+
+	if faults := cfg.Faults.String(); faults != "" {
+		log.Warn("fault injection active, do not use in production", "faults", faults)
+	}
+
+	// FIPS mode refuses to run unless the approved crypto module is active
+	if cfg.FIPS {
+		if err := policy.CheckFIPSRuntime(); err != nil {
+			log.Error("FIPS policy cannot be satisfied", "error", err)
+			return nil, errors.NewAppError("FIPS policy cannot be satisfied", errors.ExitConfigError, err)
+		}
+	}
 
 	// Initialize cache manager
 	cacheManager, err := cache.NewManager(cfg.CacheDir, cfg.CacheTTL)
 	if err != nil {
 		log.Error("failed to initialize cache", "error", err)
-		errors.ExitWithCode(errors.ExitGeneralError)
+		return nil, errors.NewAppError("failed to initialize cache", errors.ExitGeneralError, err)
 	}
 	log.Debug("cache initialized", "cache_dir", cacheManager.GetCacheDir())
+	if cfg.CacheTTLJitter > 0 {
+		cacheManager.SetTTLJitter(cfg.CacheTTLJitter, cfg.Hostname)
+	}
+	if cfg.Faults.Has(fault.CorruptCache) {
+		cacheManager.SetCorruptReads(true)
+	}
+	profile.mark("cache")
 
 	// Initialize GitHub fetcher
 	fetcher := github.NewFetcher()
 	fetcher.SetLogger(log)
+	fetcher.SetRetries(cfg.Retries, cfg.RetryDelay)
+	if cfg.HTTPTimeout > 0 {
+		fetcher.SetTimeout(cfg.HTTPTimeout)
+	}
+	if cfg.GitHubURL != "" {
+		fetcher.SetBaseURL(cfg.GitHubURL)
+	}
+	if cfg.FIPS {
+		if err := fetcher.SetTLSConfig(policy.FIPSTLSConfig()); err != nil {
+			log.Error("FIPS policy cannot be satisfied", "error", err)
+			return nil, errors.NewAppError("FIPS policy cannot be satisfied", errors.ExitConfigError, err)
+		}
+	}
+	if cfg.Faults.HasNetworkFaults() {
+		fetcher.WrapTransport(cfg.Faults.Transport)
+	}
+
+	// A token enables fetching many users per GraphQL request
+	if cfg.GitHubToken != "" {
+		token, err := loadCredential(cfg, cfg.GitHubToken)
+		if err != nil {
+			log.Error("failed to load GitHub token", "source", cfg.GitHubToken, "error", err)
+			return nil, errors.NewAppError("failed to load GitHub token", errors.ExitConfigError, err)
+		}
+		fetcher.SetToken(token)
+	}
+	profile.mark("fetcher")
+
+	// Just-in-time access: grants are checked before resolving each SSH user
+	var accessChecker *access.Checker
+	if cfg.AccessURL != "" {
+		accessChecker = access.NewChecker(cfg.AccessURL)
+		if cfg.AccessTokenSource != "" {
+			token, err := loadCredential(cfg, cfg.AccessTokenSource)
+			if err != nil {
+				log.Error("failed to load access token", "source", cfg.AccessTokenSource, "error", err)
+				return nil, errors.NewAppError("failed to load access token", errors.ExitConfigError, err)
+			}
+			accessChecker.Token = token
+		}
+	}
 
 	// Initialize resolver
-	resolver := resolver.NewResolver(cfg, fetcher, cacheManager, log)
+	resolverOpts := resolver.DefaultResolverOptions()
+	resolverOpts.Timeout = cfg.Timeout
+	resolverOpts.UserTimeout = cfg.UserTimeout
+	resolverOpts.Refresh = cfg.Refresh
+	resolverOpts.KeyMetadata = len(cfg.KeyAge) > 0
+	resolverOpts.GracePeriod = cfg.KeyGracePeriod
+	r := resolver.NewResolverWithOptions(cfg, fetcher, cacheManager, log, resolverOpts)
 
-	// Resolve keys
-	var githubKeys []string
-	var resolveErr error
+	// An unreadable revocation list fails closed, like any config error
+	revoked, err := revoke.Load(cfg.RevocationFile)
+	if err != nil {
+		log.Error("configuration error", "error", err)
+		return nil, errors.NewAppError("configuration error", errors.ExitConfigError, err)
+	}
+	if revoked.Len() > 0 {
+		log.Debug("revocation list loaded", "path", cfg.RevocationFile, "entries", revoked.Len())
+	}
+	r.SetRevocations(revoked)
+	if cfg.CachePerUser {
+		r.SetCacheIsolation(userCache(cacheManager, ssh.PasswdSource(cfg.PasswdSource)))
+	}
+	if cfg.LDAP != nil {
+		source := ldap.NewSource(cfg.LDAP.URL, cfg.LDAP.BaseDN)
+		source.Filter = cfg.LDAP.Filter
+		source.Attribute = cfg.LDAP.Attribute
+		source.BindDN = cfg.LDAP.BindDN
+		source.PasswordFile = cfg.LDAP.PasswordFile
+		r.SetMappingSource(source)
+	}
 
-	if cfg.SSHUsername != "" {
-		// Resolve for specific SSH user
-		githubKeys, resolveErr = resolver.ResolveKeysForSSHUser()
-	} else {
-		// Try to resolve with empty username (will use wildcard if available)
-		githubKeys, resolveErr = resolver.ResolveKeys("")
+	// A broken shadow configuration disables the evaluation, never the login
+	var shadow *resolver.Resolver
+	if cfg.ShadowConfigFile != "" {
+		shadow, err = newShadowResolver(cfg, fetcher, cacheManager, log, resolverOpts)
+		if err != nil {
+			log.Error("shadow configuration error, shadow evaluation disabled", "path", cfg.ShadowConfigFile, "error", err)
+		} else {
+			shadow.SetRevocations(revoked)
+			if cfg.CachePerUser {
+				shadow.SetCacheIsolation(userCache(cacheManager, ssh.PasswdSource(cfg.PasswdSource)))
+			}
+		}
 	}
 
-	if resolveErr != nil {
-		log.Error("failed to resolve keys", "error", resolveErr, "ssh_username", cfg.SSHUsername)
-		errors.ExitWithCode(errors.ExitNetworkError)
+	outputTemplate, err := loadOutputTemplate(cfg.OutputTemplate, cfg.OutputTemplateFile)
+	if err != nil {
+		log.Error("configuration error", "error", err)
+		return nil, errors.NewAppError("configuration error", errors.ExitConfigError, err)
 	}
 
-	if len(githubKeys) == 0 {
-		log.Warn("no keys resolved", "ssh_username", cfg.SSHUsername)
-		// Still exit successfully with empty output (SSH will deny access)
-		errors.ExitWithCode(errors.ExitSuccess)
+	a := &app{
+		cfg:            cfg,
+		log:            log,
+		cache:          cacheManager,
+		fetcher:        fetcher,
+		resolver:       r,
+		access:         accessChecker,
+		shadow:         shadow,
+		revoked:        revoked,
+		outputTemplate: outputTemplate,
+		started:        started,
+		profile:        profile,
+	}
+	profile.mark("resolver")
+	if cfg.AlertWebhook != "" {
+		r.SetStaleCacheHandler(a.alertStaleCache)
 	}
+	return a, nil
+}
 
-	// Validate keys (fail secure on invalid keys)
-	for _, key := range githubKeys {
-		if !isValidKeyFormat(key) {
-			log.Error("invalid key format detected", "key", key)
-			errors.HandleInvalidKey(key, fmt.Errorf("key does not match valid SSH key format"))
+// userCache returns the function giving each SSH user its own cache,
+// readable by the user's group when it's a local account
+func userCache(cacheManager *cache.Manager, source ssh.PasswdSource) func(string) (*cache.Manager, error) {
+	return func(sshUsername string) (*cache.Manager, error) {
+		gid := -1
+		if u, err := ssh.LookupUser(sshUsername, source); err == nil {
+			if id, err := strconv.Atoi(u.Gid); err == nil {
+				gid = id
+			}
 		}
+		return cacheManager.ForSSHUser(sshUsername, gid)
 	}
+}
 
-	// Initialize SSH manager
-	sshManager, err := ssh.NewManager(cfg.SSHUsername)
+// loadCredential reads the secret at a credentials source
+func loadCredential(cfg *config.Config, s string) (string, error) {
+	source, err := credentials.ParseSource(s)
 	if err != nil {
-		log.Warn("failed to initialize SSH manager, using current user", "error", err)
-		sshManager, err = ssh.NewManager("")
-		if err != nil {
-			log.Error("failed to initialize SSH manager with current user", "error", err)
-			errors.ExitWithCode(errors.ExitPermissionError)
+		return "", err
+	}
+	loader, err := credentialsLoader(cfg)
+	if err != nil {
+		return "", err
+	}
+	return loader.Load(source)
+}
+
+// credentialsLoader creates the loader for credential sources, with the
+// Vault server given by --vault-addr (others fall back to the environment)
+func credentialsLoader(cfg *config.Config) (credentials.Loader, error) {
+	if cfg.VaultAddr == "" {
+		return credentials.Loader{}, nil
+	}
+
+	tokenSource := credentials.Source{Kind: credentials.KindEnv, Location: "VAULT_TOKEN"}
+	if cfg.VaultTokenSource != "" {
+		var err error
+		if tokenSource, err = credentials.ParseSource(cfg.VaultTokenSource); err != nil {
+			return credentials.Loader{}, err
 		}
 	}
+	token, err := tokenSource.Load()
+	if err != nil {
+		return credentials.Loader{}, fmt.Errorf("failed to load Vault token: %w", err)
+	}
 
-	// Get all keys (merge with existing authorized_keys)
-	output, err := sshManager.GetAllKeys(githubKeys)
+	vault := credentials.NewVault(cfg.VaultAddr, token)
+	vault.Namespace = os.Getenv("VAULT_NAMESPACE")
+	return credentials.Loader{Vault: vault}, nil
+}
+
+// keysForUser resolves, validates and filters the keys for one SSH user
+// Returns the GitHub keys followed by the break-glass keys
+// Errors are logged and returned as *errors.AppError
+func (a *app) keysForUser(username string) ([]string, error) {
+	cfg, log := a.cfg, a.log
+	a.sshUsers++
+
+	if err := a.checkAccess(username); err != nil {
+		return nil, err
+	}
+	if err := a.checkWildcardOrg(username); err != nil {
+		return nil, err
+	}
+
+	sources, err := a.resolver.ResolveKeySources(username)
+	a.profile.mark("resolve")
+	if err != nil {
+		log.Error("failed to resolve keys", "error", err, "error_kind", github.KindOf(err), "ssh_username", username)
+		a.alertResolutionFailed(username, err)
+		return nil, errors.NewAppError("failed to resolve keys", errors.ExitNetworkError, err)
+	}
+	if a.outputTemplate != nil {
+		a.served = servedSources(sources)
+	}
+
+	githubKeys := make([]string, 0, len(sources))
+	keyPolicy := a.newKeyPolicy(username)
+	tooOld := 0
+	for _, source := range sources {
+		if !a.keyAgeAllowed(username, source) {
+			tooOld++
+			continue
+		}
+		a.checkServedKey(username, source)
+		if keyPolicy.allow(source.Line) {
+			githubKeys = append(githubKeys, source.Line)
+		}
+	}
+	a.alertPolicyRejections(username, "key age", tooOld)
+	keyPolicy.alert()
+
+	if len(githubKeys) == 0 {
+		if cfg.DenyOnEmpty && cfg.FeatureEnabled(config.FeatureDenyOnEmpty, username) {
+			log.Warn("no keys resolved, denying access (deny-on-empty)", "ssh_username", username)
+			a.alert(audit.Event{
+				Type:        eventDenyOnEmpty,
+				Severity:    audit.SeverityError,
+				Text:        fmt.Sprintf("charon-key resolved no keys for SSH user %s and denied access", username),
+				SSHUsername: username,
+			})
+			return nil, errors.NewAppError("no keys resolved", errors.ExitNoKeys, nil)
+		}
+		log.Warn("no keys resolved, emitting local keys only (allow-empty)", "ssh_username", username)
+	}
+
+	// Rewrite comments to record where each key came from
+	if cfg.Provenance {
+		githubKeys = annotateProvenance(githubKeys, sources)
+	}
+	githubKeys = a.restrictDeployKeys(username, githubKeys, sources)
+
+	// Break-glass keys are always appended, after policy filtering
+	return append(githubKeys, a.breakGlassKeys...), nil
+}
+
+// sshManagerFor creates the authorized_keys manager for an SSH user
+func (a *app) sshManagerFor(username string) (*ssh.Manager, error) {
+	sshManager, err := ssh.NewManagerWithSource(username, ssh.PasswdSource(a.cfg.PasswdSource))
+	if err != nil {
+		return nil, err
+	}
+	sshManager.SetDedupStrategy(ssh.DedupStrategy(a.cfg.Dedup))
+	if a.cfg.Unprivileged {
+		sshManager.SetUnprivileged(a.cfg.ReadHelper)
+	}
+	if chroot := chrootFor(a.cfg.ChrootDir, a.cfg.ChrootUsers, username); chroot != "" {
+		sshManager.SetChroot(chroot, username)
+	}
+	if a.cfg.AuthorizedKeysFile != "" {
+		sshManager.SetAuthorizedKeysFile(a.cfg.AuthorizedKeysFile, username)
+	}
+	sshManager.SetExtraKeyFiles(a.cfg.ExistingKeysFiles, username)
+	return sshManager, nil
+}
+
+// chrootFor returns the chroot directory pattern an SSH user's home is
+// inside: dir, if the user is one of users or users is empty, else none
+func chrootFor(dir string, users []string, username string) string {
+	if dir == "" || len(users) == 0 {
+		return dir
+	}
+	for _, user := range users {
+		if user == username {
+			return dir
+		}
+	}
+	return ""
+}
+
+// writeKeys audits the user's authorized_keys, then syncs the keys into it
+// (sync mode) or prints them merged with its existing keys
+// Errors are logged and returned as *errors.AppError
+func (a *app) writeKeys(sshManager *ssh.Manager, username string, keys []string) error {
+	cfg, log := a.cfg, a.log
+
+	a.auditPermissions(sshManager)
+	a.checkExistingCanaries(sshManager, username)
+	a.recordKeyset(username, keys)
+
+	// Sync mode: write keys into the managed block of authorized_keys
+	if cfg.Sync {
+		keys = a.syncedKeys(username, keys)
+		writeOpts := ssh.DefaultWriteOptions()
+		writeOpts.Backups = cfg.SyncBackups
+		if err := sshManager.SyncKeys(keys, writeOpts); err != nil {
+			log.Error("failed to sync authorized_keys", "path", sshManager.GetAuthorizedKeysPath(), "error", err)
+			return errors.NewAppError("failed to sync authorized_keys", errors.ExitPermissionError, err)
+		}
+		log.Info("synced authorized_keys", "path", sshManager.GetAuthorizedKeysPath(), "total_keys", len(keys))
+		a.keysServed += len(keys)
+		a.profile.mark("write")
+
+		if cfg.PrincipalsFile != "" {
+			path := sshManager.ExpandPath(cfg.PrincipalsFile, username)
+			principals := cfg.GetPrincipals(username)
+			if err := sshManager.SyncPrincipals(path, principals, writeOpts); err != nil {
+				log.Error("failed to sync principals file", "path", path, "error", err)
+				return errors.NewAppError("failed to sync principals file", errors.ExitPermissionError, err)
+			}
+			log.Info("synced principals file", "path", path, "principals", principals)
+		}
+		return nil
+	}
+
+	// Merge with existing authorized_keys
+	existing, err := sshManager.ReadExistingKeys()
 	if err != nil {
 		log.Warn("failed to read existing authorized_keys, using GitHub keys only", "error", err)
 		// Still output GitHub keys even if we can't read existing file
-		output = ssh.FormatKeys(githubKeys)
+		existing = nil
+	}
+	existing = a.filterExisting(username, existing)
+	merged, duplicates := sshManager.MergeKeysReport(keys, existing)
+	for _, duplicate := range duplicates {
+		fingerprint, _ := ssh.Fingerprint(duplicate.GitHub)
+		log.Info("collapsed key both in authorized_keys and on GitHub", "ssh_username", username, "fingerprint", fingerprint,
+			"strategy", duplicate.Strategy, "local", duplicate.Local, "github", duplicate.GitHub)
+	}
+	if len(merged) == 0 {
+		// Stay silent on stdout, even with --output-template; the caller
+		// tells this apart by keysServed
+		log.Info("no keys to output", "ssh_username", username)
+		if cfg.Output != "" {
+			// Empty the file, so removed keys don't linger in it
+			return a.writeOutputFile(sshManager, username, "")
+		}
+		a.profile.mark("write")
+		return nil
+	}
+	output := ssh.FormatKeys(merged)
+	if a.outputTemplate != nil {
+		if output, err = a.renderOutputTemplate(username, merged, existing); err != nil {
+			log.Error("failed to render output template", "error", err)
+			return errors.NewAppError("failed to render output template", errors.ExitGeneralError, err)
+		}
+	}
+
+	if cfg.Output != "" {
+		return a.writeOutputFile(sshManager, username, output)
 	}
 
 	// Output to stdout (SSH daemon reads from here)
 	fmt.Print(output)
+	a.keysServed += strings.Count(output, "\n")
+	a.profile.mark("write")
+	return nil
+}
+
+// writeOutputFile atomically replaces the --output file with the output,
+// so cron jobs never leave sshd a partially written file
+func (a *app) writeOutputFile(sshManager *ssh.Manager, username, output string) error {
+	path := sshManager.ExpandPath(a.cfg.Output, username)
+	if err := sshManager.WriteOutput(path, []byte(output), a.cfg.OutputMode); err != nil {
+		a.log.Error("failed to write output file", "path", path, "error", err)
+		return errors.NewAppError("failed to write output file", errors.ExitPermissionError, err)
+	}
+	keys := strings.Count(output, "\n")
+	a.log.Info("wrote output file", "path", path, "ssh_username", username, "total_keys", keys)
+	a.keysServed += keys
+	a.profile.mark("write")
+	return nil
+}
+
+// filterExisting drops existing keys on the revocation list, not approved
+// by --fips, or excluded for the SSH user by exclude_existing, so revoked
+// keys lingering in authorized_keys or older key files stop being output
+func (a *app) filterExisting(username string, existing []string) []string {
+	existing = withoutRevoked(a.revoked, existing, a.log, "existing")
+	if a.cfg.FIPS && a.cfg.FeatureEnabled(config.FeatureFIPS, username) {
+		existing = fipsApproved(existing, a.log, "existing")
+	}
+	if len(a.cfg.ExcludeExisting) == 0 {
+		return existing
+	}
+	var kept []string
+	for _, line := range existing {
+		fingerprint, _ := ssh.Fingerprint(line)
+		var comment string
+		if key, err := ssh.ParseAuthorizedKey(line); err == nil {
+			comment = key.Comment
+		}
+		if a.cfg.IsExcludedExisting(username, fingerprint, comment) {
+			a.log.Info("excluded existing key", "ssh_username", username, "fingerprint", fingerprint, "comment", comment)
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return kept
+}
+
+// auditPermissions reports (and optionally fixes) authorized_keys
+// permissions that would make sshd ignore the file, so merging into it
+// would be useless
+func (a *app) auditPermissions(sshManager *ssh.Manager) {
+	cfg, log := a.cfg, a.log
+
+	// Unprivileged, other users' .ssh directories can't be inspected, and
+	// sshd checks the permissions itself anyway
+	if cfg.Unprivileged {
+		return
+	}
+
+	issues, err := sshManager.AuditPermissions()
+	if err != nil {
+		log.Warn("failed to audit authorized_keys permissions", "error", err)
+	}
+	for _, issue := range issues {
+		log.Warn("unsafe authorized_keys permissions, sshd will ignore the file", "path", issue.Path, "problem", issue.Problem)
+	}
+	if len(issues) > 0 && cfg.FixPermissions {
+		if err := sshManager.FixPermissions(issues); err != nil {
+			log.Error("failed to fix authorized_keys permissions", "error", err)
+		} else {
+			log.Info("fixed authorized_keys permissions", "issues", len(issues))
+		}
+	}
+}
+
+// logSummary logs one line summarizing the invocation, so fleets can derive
+// SLOs (latency, cache hit rate, failures) from standard logs
+// err is the invocation's fatal error, if any
+func (a *app) logSummary(err error) {
+	stats := a.resolver.Stats()
+	exitCode := errors.ExitSuccess
+	if err != nil {
+		exitCode = exitCodeOf(err)
+	}
+	a.log.Info("summary",
+		"ssh_users", a.sshUsers,
+		"github_users", stats.GitHubUsers,
+		"keys_served", a.keysServed,
+		"cache_hits", stats.CacheHits,
+		"fetches", stats.Fetches,
+		"stale_cache", stats.StaleCache,
+		"fetch_ms", stats.FetchTime.Milliseconds(),
+		"total_ms", time.Since(a.started).Milliseconds(),
+		"exit_code", int(exitCode),
+	)
+	a.profile.report(a.log)
+}
+
+// isConfigError reports whether err is an *errors.AppError for a configuration problem
+func isConfigError(err error) bool {
+	return exitCodeOf(err) == errors.ExitConfigError
+}
+
+// exitCodeOf returns the exit code carried by an *errors.AppError
+// (ExitGeneralError for other errors)
+func exitCodeOf(err error) errors.ExitCode {
+	var appErr *errors.AppError
+	if stderrors.As(err, &appErr) {
+		return appErr.ExitCode
+	}
+	return errors.ExitGeneralError
+}
+
+// loadBreakGlassKeys collects the break-glass keys from flags and file
+// Invalid or unreadable entries are logged and skipped rather than failing,
+// since break-glass keys exist precisely to keep access working
+func loadBreakGlassKeys(opts options, log *logger.Logger) []string {
+	candidates := append([]string{}, opts.breakGlassKeys...)
 
-	log.Debug("completed successfully", "total_keys", len(githubKeys))
+	if opts.breakGlassFile != "" {
+		fileKeys, err := ssh.ReadKeyFile(opts.breakGlassFile)
+		if err != nil {
+			log.Error("failed to read break-glass file", "path", opts.breakGlassFile, "error", err)
+		}
+		candidates = append(candidates, fileKeys...)
+	}
+
+	var keys []string
+	for _, key := range candidates {
+		key = strings.TrimSpace(key)
+		if !isValidKeyFormat(key) {
+			log.Error("ignoring invalid break-glass key", "key", key)
+			continue
+		}
+		if opts.provenance {
+			key = ssh.AnnotateKey(key, "via charon-key break-glass")
+		}
+		keys = append(keys, key)
+	}
+
+	// An unreadable revocation list is a config error, and config errors
+	// don't emit break-glass keys
+	if revoked, err := revoke.Load(opts.revocationFile); err == nil {
+		keys = withoutRevoked(revoked, keys, log, "break-glass")
+	}
+	// Break-glass keys are served to every SSH user, so --fips applies
+	// whatever its rollout
+	if opts.fips {
+		keys = fipsApproved(keys, log, "break-glass")
+	}
+	return keys
+}
+
+// annotateProvenance appends each key's source to its comment
+func annotateProvenance(keys []string, sources []resolver.Key) []string {
+	bySource := make(map[string]resolver.Key, len(sources))
+	for _, source := range sources {
+		bySource[source.Line] = source
+	}
+
+	annotated := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if source, ok := bySource[key]; ok {
+			key = ssh.AnnotateKey(key, source.Provenance())
+		}
+		annotated = append(annotated, key)
+	}
+	return annotated
+}
+
+// emitBreakGlass writes the break-glass keys to stdout and exits successfully
+// (sshd discards output from a command that exits non-zero)
+// Does nothing if no break-glass keys are configured
+func emitBreakGlass(keys []string, log *logger.Logger) {
+	if len(keys) == 0 {
+		return
+	}
+	log.Warn("emitting break-glass keys only", "keys_count", len(keys))
+	fmt.Print(ssh.FormatKeys(keys))
 	errors.ExitWithCode(errors.ExitSuccess)
 }
 
@@ -160,6 +1011,8 @@ func isValidKeyFormat(key string) bool {
 		"ecdsa-sha2-nistp384",
 		"ecdsa-sha2-nistp521",
 		"ssh-dss",
+		"sk-ssh-ed25519@openssh.com",
+		"sk-ecdsa-sha2-nistp256@openssh.com",
 	}
 
 	for _, prefix := range validPrefixes {
@@ -171,33 +1024,519 @@ func isValidKeyFormat(key string) bool {
 	return false
 }
 
-func parseConfig(userMapStr, cacheDir string, cacheTTLMinutes int, logLevel string) (*config.Config, error) {
+// options holds the raw command-line flag values
+type options struct {
+	showVersion     bool
+	showHelp        bool
+	explainExitCode string
+	errorFormat     string
+	debugBundle     string
+	profileStartup  bool
+
+	userMap          string
+	configFile       string
+	profile          string
+	hostClass        string
+	shadowConfigFile string
+	excludeComments  stringList
+	excludeExisting  stringList
+	cacheDir         string
+	cacheTTLMinutes  int
+	cacheTTLJitter   int
+	cachePerUser     bool
+	refresh          bool
+	keyGracePeriod   time.Duration
+	revocationFile   string
+	logLevel         string
+	correlationID    string
+	logFile          string
+	logFileMaxSize   int
+	logFileMaxAge    time.Duration
+	logFileKeep      int
+	debugOnError     int
+	logSample        int
+	logRateLimit     int
+	redact           string
+	githubURL        string
+	githubTokenFile  string
+	githubToken      string
+	vaultAddr        string
+	vaultTokenSource string
+	fips             bool
+	keyTypes         string
+	minRSABits       int
+	dropInvalidKeys  bool
+	breakGlassKeys   stringList
+	breakGlassFile   string
+	denyOnEmpty      bool
+	allowEmpty       bool
+	provenance       bool
+
+	outputTemplate     string
+	outputTemplateFile string
+
+	passwdSource       string
+	existingKeysFiles  stringList
+	authorizedKeysFile string
+	chrootDir          string
+	chrootUsers        stringList
+	dedup              string
+	fixPermissions     bool
+	unprivileged       bool
+	readHelper         string
+	stream             bool
+	sync               bool
+	syncBackups        int
+	principalsFile     string
+	output             string
+	outputMode         string
+
+	timeoutSeconds     int
+	userTimeoutSeconds int
+	httpTimeoutSeconds int
+	retries            int
+	retryDelay         time.Duration
+
+	ldapURL          string
+	ldapBaseDN       string
+	ldapFilter       string
+	ldapAttribute    string
+	ldapBindDN       string
+	ldapPasswordFile string
+
+	canaryFingerprints stringList
+	canaryWebhook      string
+	alertWebhook       string
+	alertKeysetChanges bool
+
+	accessURL         string
+	accessTokenSource string
+
+	wildcardOrg      string
+	denyWildcard     bool
+	mappingConflicts string
+
+	fault string
+}
+
+// stringList is a flag.Value collecting repeated string flags
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func parseConfig(opts options) (*config.Config, error) {
 	// Validate required user-map
-	if userMapStr == "" {
-		return nil, fmt.Errorf("--user-map is required")
+	if opts.userMap == "" && opts.configFile == "" && opts.ldapURL == "" {
+		return nil, fmt.Errorf("--user-map, --config or --ldap-url is required")
+	}
+
+	if opts.profile != "" && opts.configFile == "" {
+		return nil, fmt.Errorf("--profile requires --config")
+	}
+
+	// Only needed for profile, conditional mapping and rollout selection and
+	// webhooks, so a failure is reported only then
+	hostname, hostnameErr := os.Hostname()
+
+	userMap := make(map[string][]string)
+	excludeComments := make(map[string][]string)
+	excludeExisting := make(map[string][]string)
+	var keyAge map[string]config.KeyAgePolicy
+	var deployKeyCommand map[string]string
+	denyWildcard := opts.denyWildcard
+	conflictPolicy := opts.mappingConflicts
+	var mappingSources []config.MappingSource
+	var roles map[string][]string
+	var rollout map[string]config.Rollout
+	var profile string
+	canaryFingerprints := append([]string{}, opts.canaryFingerprints...)
+	canaryWebhook := opts.canaryWebhook
+	alertWebhook := opts.alertWebhook
+
+	// Load config file first; --user-map entries are added on top
+	if opts.configFile != "" {
+		file, err := config.LoadFile(opts.configFile)
+		if err != nil {
+			return nil, err
+		}
+		if len(file.Profiles) > 0 {
+			if opts.profile == "" && hostnameErr != nil {
+				return nil, fmt.Errorf("failed to get hostname for profile selection: %w", hostnameErr)
+			}
+			if profile, err = file.SelectProfile(opts.profile, hostname); err != nil {
+				return nil, err
+			}
+			mappingSources = append(mappingSources, file.MappingSource("config file"))
+			if profile != "" {
+				profileFile := file.Profiles[profile].File
+				mappingSources = append(mappingSources, profileFile.MappingSource(fmt.Sprintf("profile %q", profile)))
+			}
+			if file, err = file.WithProfile(profile); err != nil {
+				return nil, err
+			}
+		} else if opts.profile != "" {
+			return nil, fmt.Errorf("profile %q is not defined", opts.profile)
+		} else {
+			mappingSources = append(mappingSources, file.MappingSource("config file"))
+		}
+		for sshUser, entries := range file.UserMap {
+			userMap[sshUser] = append(userMap[sshUser], entries...)
+		}
+		if len(file.ConditionalMappings) > 0 {
+			if hostnameErr != nil {
+				return nil, fmt.Errorf("failed to get hostname for conditional mappings: %w", hostnameErr)
+			}
+			conditional, err := file.ConditionalUserMap(hostname, opts.hostClass, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			for sshUser, entries := range conditional {
+				userMap[sshUser] = append(userMap[sshUser], entries...)
+			}
+		}
+		roles = file.Roles
+		rollout = file.Rollout
+		canaryFingerprints = append(canaryFingerprints, file.CanaryFingerprints...)
+		if canaryWebhook == "" {
+			canaryWebhook = file.CanaryWebhook
+		}
+		if alertWebhook == "" {
+			alertWebhook = file.AlertWebhook
+		}
+		for sshUser, patterns := range file.ExcludeComments {
+			excludeComments[sshUser] = append(excludeComments[sshUser], patterns...)
+		}
+		for sshUser, entries := range file.ExcludeExisting {
+			excludeExisting[sshUser] = append(excludeExisting[sshUser], entries...)
+		}
+		keyAge = file.KeyAge
+		deployKeyCommand = file.DeployKeyCommand
+		denyWildcard = denyWildcard || file.DenyWildcard
+		if conflictPolicy == "" {
+			conflictPolicy = file.MappingConflicts
+		}
+	}
+
+	for _, exclusion := range opts.excludeComments {
+		sshUser, pattern, ok := strings.Cut(exclusion, ":")
+		if !ok || strings.TrimSpace(sshUser) == "" || pattern == "" {
+			return nil, fmt.Errorf("invalid exclude-comment %q (expected sshuser:pattern)", exclusion)
+		}
+		sshUser = strings.TrimSpace(sshUser)
+		excludeComments[sshUser] = append(excludeComments[sshUser], pattern)
+	}
+	for _, exclusion := range opts.excludeExisting {
+		sshUser, entry, ok := strings.Cut(exclusion, ":")
+		if !ok || strings.TrimSpace(sshUser) == "" || entry == "" {
+			return nil, fmt.Errorf("invalid exclude-existing %q (expected sshuser:SHA256:... or sshuser:pattern)", exclusion)
+		}
+		sshUser = strings.TrimSpace(sshUser)
+		excludeExisting[sshUser] = append(excludeExisting[sshUser], entry)
 	}
 
 	// Parse user mapping
-	userMap, err := config.ParseUserMap(userMapStr)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse user-map: %w", err)
+	if opts.userMap != "" {
+		flagMap, err := config.ParseUserMap(opts.userMap)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse user-map: %w", err)
+		}
+		for sshUser, entries := range flagMap {
+			userMap[sshUser] = append(userMap[sshUser], entries...)
+		}
+		mappingSources = append(mappingSources, config.MappingSource{Name: "--user-map", UserMap: flagMap})
+	}
+
+	// Sources are merged regardless; conflicts are reported, or fail with
+	// mapping_conflicts=error
+	if err := config.ValidateConflictPolicy(conflictPolicy); err != nil {
+		return nil, err
+	}
+	var mappingConflicts []config.MappingConflict
+	if conflictPolicy != config.ConflictsIgnore {
+		mappingConflicts = config.FindConflicts(mappingSources)
+	}
+	if conflictPolicy == config.ConflictsError && len(mappingConflicts) > 0 {
+		return nil, fmt.Errorf("conflicting mappings (mapping_conflicts is error): %s", mappingConflicts[0])
+	}
+
+	// Explicit allow: "*" entries are dropped whichever source added them
+	if denyWildcard {
+		delete(userMap, "*")
+	}
+
+	if len(userMap) == 0 && opts.ldapURL == "" {
+		return nil, fmt.Errorf("no user mappings configured")
 	}
 
 	// Validate log level
-	if err := config.ValidateLogLevel(logLevel); err != nil {
+	if err := config.ValidateLogLevel(opts.logLevel); err != nil {
 		return nil, fmt.Errorf("invalid log level: %w", err)
 	}
 
+	// Tokens are never accepted as flag values (they would show in ps), only
+	// as a credentials source; --github-token-file is shorthand for file:
+	if opts.githubTokenFile != "" {
+		if opts.githubToken != "" {
+			return nil, fmt.Errorf("--github-token-file and --github-token-source are mutually exclusive")
+		}
+		opts.githubToken = credentials.KindFile + ":" + opts.githubTokenFile
+	}
+	if opts.githubToken != "" {
+		if _, err := credentials.ParseSource(opts.githubToken); err != nil {
+			return nil, fmt.Errorf("invalid github-token-source: %w", err)
+		}
+	}
+	if opts.vaultTokenSource != "" {
+		if opts.vaultAddr == "" {
+			return nil, fmt.Errorf("--vault-token-source requires --vault-addr")
+		}
+		source, err := credentials.ParseSource(opts.vaultTokenSource)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vault-token-source: %w", err)
+		}
+		if source.Kind == credentials.KindVault {
+			return nil, fmt.Errorf("--vault-token-source cannot itself be a vault source")
+		}
+	}
+
+	if opts.accessTokenSource != "" {
+		if _, err := credentials.ParseSource(opts.accessTokenSource); err != nil {
+			return nil, fmt.Errorf("invalid access-token-source: %w", err)
+		}
+	}
+
+	faults, err := fault.Parse(opts.fault)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.denyOnEmpty && opts.allowEmpty {
+		return nil, fmt.Errorf("--deny-on-empty and --allow-empty are mutually exclusive")
+	}
+
+	// Validate timeouts
+	if opts.timeoutSeconds < 0 {
+		return nil, fmt.Errorf("timeout cannot be negative, got %d", opts.timeoutSeconds)
+	}
+	if opts.userTimeoutSeconds < 0 {
+		return nil, fmt.Errorf("user-timeout cannot be negative, got %d", opts.userTimeoutSeconds)
+	}
+	var keyTypes []string
+	if opts.keyTypes != "" {
+		if keyTypes, err = policy.ParseKeyTypes(opts.keyTypes); err != nil {
+			return nil, fmt.Errorf("invalid key-types: %w", err)
+		}
+	}
+	if opts.minRSABits < 0 {
+		return nil, fmt.Errorf("min-rsa-bits cannot be negative, got %d", opts.minRSABits)
+	}
+	if opts.httpTimeoutSeconds < 1 {
+		return nil, fmt.Errorf("http-timeout must be at least 1 second, got %d", opts.httpTimeoutSeconds)
+	}
+	if opts.retries < 0 {
+		return nil, fmt.Errorf("retries cannot be negative, got %d", opts.retries)
+	}
+	if opts.retryDelay < 0 {
+		return nil, fmt.Errorf("retry-delay cannot be negative, got %s", opts.retryDelay)
+	}
+	if opts.keyGracePeriod < 0 || opts.keyGracePeriod > cache.RemovedKeyRetention {
+		return nil, fmt.Errorf("key-grace-period must be between 0 and %s, got %s", cache.RemovedKeyRetention, opts.keyGracePeriod)
+	}
+
+	passwdSource, err := ssh.ParsePasswdSource(opts.passwdSource)
+	if err != nil {
+		return nil, err
+	}
+	dedup, err := ssh.ParseDedupStrategy(opts.dedup)
+	if err != nil {
+		return nil, err
+	}
+	if dedup != ssh.DedupPreferLocal && (opts.stream || opts.sync) {
+		return nil, fmt.Errorf("--dedup %s cannot be combined with --stream or --sync (local keys always win there)", dedup)
+	}
+
+	if opts.syncBackups < 0 {
+		return nil, fmt.Errorf("sync-backups cannot be negative, got %d", opts.syncBackups)
+	}
+	if opts.stream && (opts.sync || opts.denyOnEmpty) {
+		return nil, fmt.Errorf("--stream cannot be combined with --sync or --deny-on-empty")
+	}
+	if opts.outputTemplate != "" && opts.outputTemplateFile != "" {
+		return nil, fmt.Errorf("--output-template and --output-template-file are mutually exclusive")
+	}
+	if (opts.outputTemplate != "" || opts.outputTemplateFile != "") && (opts.stream || opts.sync) {
+		return nil, fmt.Errorf("--output-template cannot be combined with --stream or --sync")
+	}
+	if opts.unprivileged && (opts.sync || opts.fixPermissions) {
+		return nil, fmt.Errorf("--unprivileged cannot be combined with --sync or --fix-permissions, which write other users' files")
+	}
+	if opts.readHelper != "" && !opts.unprivileged {
+		return nil, fmt.Errorf("--read-helper requires --unprivileged")
+	}
+	if opts.readHelper != "" && !strings.HasPrefix(opts.readHelper, "/") {
+		return nil, fmt.Errorf("invalid read-helper %q: must be an absolute path", opts.readHelper)
+	}
+	if opts.principalsFile != "" && !opts.sync {
+		return nil, fmt.Errorf("--principals-file requires --sync")
+	}
+	if opts.output != "" && (opts.stream || opts.sync) {
+		return nil, fmt.Errorf("--output cannot be combined with --stream or --sync")
+	}
+	if opts.chrootDir != "" && !strings.HasPrefix(opts.chrootDir, "%h") && !strings.HasPrefix(opts.chrootDir, "/") {
+		return nil, fmt.Errorf("invalid chroot-dir %q: must be an absolute path", opts.chrootDir)
+	}
+	if len(opts.chrootUsers) > 0 && opts.chrootDir == "" {
+		return nil, fmt.Errorf("--chroot-user requires --chroot-dir")
+	}
+	outputMode, err := strconv.ParseUint(opts.outputMode, 8, 32)
+	if err != nil || outputMode > 0777 {
+		return nil, fmt.Errorf("invalid output-mode %q: must be octal permissions such as 0600", opts.outputMode)
+	}
+
 	// Validate cache TTL
-	if cacheTTLMinutes < 1 {
-		return nil, fmt.Errorf("cache-ttl must be at least 1 minute, got %d", cacheTTLMinutes)
+	if opts.cacheTTLMinutes < 1 {
+		return nil, fmt.Errorf("cache-ttl must be at least 1 minute, got %d", opts.cacheTTLMinutes)
+	}
+	if opts.cacheTTLJitter < 0 || opts.cacheTTLJitter > 50 {
+		return nil, fmt.Errorf("cache-ttl-jitter must be between 0 and 50 percent, got %d", opts.cacheTTLJitter)
 	}
 
 	cfg := &config.Config{
-		UserMap:  userMap,
-		CacheDir: cacheDir, // Empty means use OS temp (handled in cache package)
-		CacheTTL: time.Duration(cacheTTLMinutes) * time.Minute,
-		LogLevel: logLevel,
+		UserMap:          userMap,
+		Roles:            roles,
+		ExcludeComments:  excludeComments,
+		ExcludeExisting:  excludeExisting,
+		KeyAge:           keyAge,
+		DeployKeyCommand: deployKeyCommand,
+		CacheDir:         opts.cacheDir, // Empty means use OS temp (handled in cache package)
+		CacheTTL:         time.Duration(opts.cacheTTLMinutes) * time.Minute,
+		CacheTTLJitter:   opts.cacheTTLJitter,
+		CachePerUser:     opts.cachePerUser,
+		Refresh:          opts.refresh,
+		KeyGracePeriod:   opts.keyGracePeriod,
+		RevocationFile:   opts.revocationFile,
+		LogLevel:         opts.logLevel,
+		GitHubURL:        strings.TrimRight(opts.githubURL, "/"),
+		GitHubToken:      opts.githubToken,
+		VaultAddr:        opts.vaultAddr,
+		VaultTokenSource: opts.vaultTokenSource,
+		ShadowConfigFile: opts.shadowConfigFile,
+		FIPS:             opts.fips,
+		KeyTypes:         keyTypes,
+		MinRSABits:       opts.minRSABits,
+		DropInvalidKeys:  opts.dropInvalidKeys,
+		DenyOnEmpty:      opts.denyOnEmpty,
+		Provenance:       opts.provenance,
+		Timeout:          time.Duration(opts.timeoutSeconds) * time.Second,
+		UserTimeout:      time.Duration(opts.userTimeoutSeconds) * time.Second,
+		HTTPTimeout:      time.Duration(opts.httpTimeoutSeconds) * time.Second,
+		Retries:          opts.retries,
+		RetryDelay:       opts.retryDelay,
+		PasswdSource:     string(passwdSource),
+		Dedup:            string(dedup),
+		FixPermissions:   opts.fixPermissions,
+		Unprivileged:     opts.unprivileged,
+		ReadHelper:       opts.readHelper,
+		Stream:           opts.stream,
+		Sync:             opts.sync,
+		SyncBackups:      opts.syncBackups,
+		PrincipalsFile:   opts.principalsFile,
+		Output:           opts.output,
+		OutputMode:       os.FileMode(outputMode),
+		Profile:          profile,
+		HostClass:        opts.hostClass,
+
+		ExistingKeysFiles:  opts.existingKeysFiles,
+		AuthorizedKeysFile: opts.authorizedKeysFile,
+		ChrootDir:          opts.chrootDir,
+		ChrootUsers:        opts.chrootUsers,
+		OutputTemplate:     opts.outputTemplate,
+		OutputTemplateFile: opts.outputTemplateFile,
+
+		CanaryFingerprints: canaryFingerprints,
+		CanaryWebhook:      canaryWebhook,
+		AlertWebhook:       alertWebhook,
+		AlertKeysetChanges: opts.alertKeysetChanges,
+
+		AccessURL:         opts.accessURL,
+		AccessTokenSource: opts.accessTokenSource,
+		WildcardOrg:       opts.wildcardOrg,
+		DenyWildcard:      denyWildcard,
+		MappingConflicts:  mappingConflicts,
+
+		Faults: faults,
+	}
+
+	if err := cfg.ValidateRoles(); err != nil {
+		return nil, fmt.Errorf("invalid roles: %w", err)
+	}
+
+	if err := cfg.ValidateCanaries(); err != nil {
+		return nil, fmt.Errorf("invalid canaries: %w", err)
+	}
+	if err := cfg.ValidateAlertWebhook(); err != nil {
+		return nil, err
+	}
+	if cfg.GitHubURL != "" && !strings.HasPrefix(cfg.GitHubURL, "https://") && !strings.HasPrefix(cfg.GitHubURL, "http://") {
+		return nil, fmt.Errorf("invalid github-url %q (expected an http(s) URL)", cfg.GitHubURL)
+	}
+	if err := cfg.ValidateAccess(); err != nil {
+		return nil, err
+	}
+	if err := cfg.ValidateKeyAge(); err != nil {
+		return nil, err
+	}
+	if cfg.WildcardOrg != "" && cfg.GitHubToken == "" {
+		return nil, fmt.Errorf("--wildcard-org requires --github-token-source (org membership may be private)")
+	}
+	if len(cfg.KeyAge) > 0 && cfg.GitHubToken == "" {
+		return nil, fmt.Errorf("key_age requires --github-token-source (key ages come from GitHub's API)")
+	}
+	if err := cfg.ValidateDeployKeys(); err != nil {
+		return nil, err
+	}
+	if err := cfg.ValidateExcludeExisting(); err != nil {
+		return nil, err
+	}
+	if cfg.UsesDeployKeys() && cfg.GitHubToken == "" {
+		return nil, fmt.Errorf("deploy-keys: mappings require --github-token-source (deploy keys are listed with GitHub's API)")
+	}
+
+	if len(rollout) > 0 {
+		cfg.Rollout = rollout
+		if err := cfg.ValidateRollout(); err != nil {
+			return nil, fmt.Errorf("invalid rollout: %w", err)
+		}
+	}
+	if len(rollout) > 0 || cfg.CanaryWebhook != "" || cfg.AlertWebhook != "" || cfg.AccessURL != "" {
+		if hostnameErr != nil {
+			return nil, fmt.Errorf("failed to get hostname: %w", hostnameErr)
+		}
+	}
+	cfg.Hostname = hostname
+
+	if opts.ldapURL != "" {
+		if opts.ldapBaseDN == "" {
+			return nil, fmt.Errorf("--ldap-base-dn is required with --ldap-url")
+		}
+		if strings.Count(opts.ldapFilter, "%s") != 1 {
+			return nil, fmt.Errorf("--ldap-filter must contain exactly one %%s, got %q", opts.ldapFilter)
+		}
+		cfg.LDAP = &config.LDAPConfig{
+			URL:          opts.ldapURL,
+			BaseDN:       opts.ldapBaseDN,
+			Filter:       opts.ldapFilter,
+			Attribute:    opts.ldapAttribute,
+			BindDN:       opts.ldapBindDN,
+			PasswordFile: opts.ldapPasswordFile,
+		}
 	}
 
 	return cfg, nil
@@ -208,21 +1547,232 @@ func printHelp() {
 	fmt.Println()
 	fmt.Println("Usage:")
 	fmt.Println("  charon-key [OPTIONS] [SSH-USERNAME]")
+	fmt.Println("  charon-key resolve [OPTIONS] [--concurrency N] [--progress] [--errors-json FILE]")
+	fmt.Println("                     --all | SSH-USERNAME... | -")
+	fmt.Println("  charon-key cache clear [OPTIONS] --github-user X | --ssh-user Y | --all")
+	fmt.Println("  charon-key cache refresh [OPTIONS] [--within PERCENT] [--snapshot]")
+	fmt.Println("  charon-key cache stats [OPTIONS] [--format text|json|prometheus]")
+	fmt.Println("  charon-key check [OPTIONS] [SSH-USERNAME]")
+	fmt.Println("  charon-key audit [OPTIONS] [--format text|json|csv|sarif]")
+	fmt.Println("  charon-key inventory [OPTIONS] [--format json|csv|sarif|table]")
+	fmt.Println("  charon-key gpg [OPTIONS] [--import] SSH-USERNAME")
+	fmt.Println("  charon-key compile-config --config FILE --output SNAPSHOT")
+	fmt.Println("  charon-key config schema")
+	fmt.Println("  charon-key export-org --org ORG --github-token-source SOURCE [--output FILE]")
+	fmt.Println("  charon-key lint-authorized-keys [--format text|json] PATH")
+	fmt.Println("  charon-key rollback [OPTIONS] [--release] SSH-USERNAME [KEYSET]")
+	fmt.Println("  charon-key revoke [--revocation-file F] [--reason R] [--remove] SHA256:...|github:USER...")
+	fmt.Println("  charon-key revoke --list [--revocation-file F]")
+	fmt.Println("  charon-key print-apparmor|print-selinux-te [OPTIONS] [--executable PATH]")
+	fmt.Println("  charon-key mock-github --fixtures DIR [--listen ADDR] [--latency D] [--error-rate F] [--rate-limit N]")
 	fmt.Println()
 	fmt.Println("Description:")
 	fmt.Println("  Fetches SSH public keys from GitHub and merges them with existing")
 	fmt.Println("  authorized_keys file. Designed to be used as AuthorizedKeysCommand")
 	fmt.Println("  in sshd_config.")
 	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Println("  resolve                 Resolve several SSH users in one process, sharing the")
+	fmt.Println("                          cache and HTTP connections; --all resolves every user")
+	fmt.Println("                          in the static user map. Combine with --sync for batch")
+	fmt.Println("                          sync jobs")
+	fmt.Println("  cache clear             Remove cached keys of GitHub users (--github-user), of")
+	fmt.Println("                          the GitHub users mapped to SSH users (--ssh-user; needs")
+	fmt.Println("                          the mapping options), or all of them (--all)")
+	fmt.Println("  cache refresh           Refresh cached keys that are expired or within --within")
+	fmt.Println("                          percent (default: 20) of expiring, e.g. from cron, so")
+	fmt.Println("                          logins don't wait on GitHub; --snapshot then writes a")
+	fmt.Println("                          memory-mapped snapshot logins read instead of JSON files")
+	fmt.Println("  cache stats             Show cache entries and the recent fetch success rate and")
+	fmt.Println("                          latency per provider and GitHub user (text, JSON or")
+	fmt.Println("                          Prometheus metrics)")
+	fmt.Println("  check                   Exit 0 if the SSH user (default: $PAM_USER) is mapped and")
+	fmt.Println("                          has keys, for pam_exec account checks; prints nothing")
+	fmt.Println("  audit                   Report, per SSH user of the static user map, keys in")
+	fmt.Println("                          authorized_keys that are stale (managed but no longer")
+	fmt.Println("                          served), unknown (added by hand) or, with --sync,")
+	fmt.Println("                          missing (served but not written yet)")
+	fmt.Println("  inventory               List every mapping of the static user map with its")
+	fmt.Println("                          resolved key fingerprints, sources and cache freshness,")
+	fmt.Println("                          for central compliance reporting")
+	fmt.Println("  gpg                     Print (or with --import, gpg --import) the GPG keys of")
+	fmt.Println("                          the GitHub users mapped to the SSH user")
+	fmt.Println("  compile-config          Validate a config file with all its profiles, conditions")
+	fmt.Println("                          and time windows, and write a snapshot for --config")
+	fmt.Println("  config schema           Print the JSON Schema config files are validated against")
+	fmt.Println("  export-org              Write a config file mapping each org member's lowercased")
+	fmt.Println("                          login to their GitHub user, with a role per team")
+	fmt.Println("  lint-authorized-keys    Report malformed lines, duplicate keys, weak algorithms")
+	fmt.Println("                          and risky options in an authorized_keys file; exits 1")
+	fmt.Println("                          if any are found")
+	fmt.Println("  rollback                List the keysets recorded for an SSH user, or sync one of")
+	fmt.Println("                          them and keep sync mode on it until --release")
+	fmt.Println("  revoke                  Add key fingerprints or GitHub users to the revocation")
+	fmt.Println("                          list (or --remove, or --list them); revoked keys are")
+	fmt.Println("                          never served, even from cache or the grace period")
+	fmt.Println("  print-apparmor          Print an AppArmor profile allowing the paths the given")
+	fmt.Println("                          options make charon-key read and write")
+	fmt.Println("  print-selinux-te        Print an SELinux policy module (.te) for the same paths,")
+	fmt.Println("                          with the semanage commands labeling them")
+	fmt.Println("  mock-github             Serve <user>.keys from fixture files, with optional latency,")
+	fmt.Println("                          HTTP 500s and rate limits, for integration tests")
+	fmt.Println()
 	fmt.Println("Options:")
-	fmt.Println("  --user-map <mapping>     User mapping (required)")
+	fmt.Println("  --user-map <mapping>     User mapping (required unless --config is given)")
 	fmt.Println("                          Format: sshuser1:githubuser1,sshuser1:githubuser2")
+	fmt.Println("                          Use @role to grant every GitHub user in a role")
+	fmt.Println("  --config <file>         JSON config file with user_map and roles (optional)")
+	fmt.Println("  --host-class <class>    This host's class for conditional_mappings conditions such")
+	fmt.Println("                          as class == \"prod\" (optional)")
+	fmt.Println("  --profile <name>        Apply this profile of the config file (optional, default:")
+	fmt.Println("                          the profile whose hosts patterns match the hostname)")
+	fmt.Println("  --shadow-config <file>  Also resolve with this candidate config and log which keys")
+	fmt.Println("                          it would add or remove; output is unaffected (optional)")
+	fmt.Println("  --exclude-comment <m>   Drop keys whose comment matches a glob, per SSH user:")
+	fmt.Println("                          sshuser:pattern, e.g. *:*@personal-laptop (repeatable)")
+	fmt.Println("  --exclude-existing <m>  Don't output keys from authorized_keys or existing key files")
+	fmt.Println("                          matching a fingerprint or comment glob, per SSH user:")
+	fmt.Println("                          sshuser:SHA256:... or sshuser:pattern (repeatable)")
 	fmt.Println("  --cache-dir <dir>       Cache directory (optional, default: OS temp)")
 	fmt.Println("  --cache-ttl <minutes>   Cache TTL in minutes (optional, default: 5)")
+	fmt.Println("  --cache-ttl-jitter <pct> Shorten each entry's TTL by a stable 0-pct%, different per")
+	fmt.Println("                          host and GitHub user, so a fleet doesn't refresh in sync")
+	fmt.Println("  --cache-per-user        Cache keys per SSH user, in users/<ssh user> subdirectories")
+	fmt.Println("                          readable by that user's group (default: shared)")
+	fmt.Println("  --refresh               Ignore cached keys and fetch from GitHub now, e.g. right")
+	fmt.Println("                          after an offboarding (the cache is still updated)")
+	fmt.Println("  --key-grace-period <d>  Keep serving keys removed from a GitHub user for this long,")
+	fmt.Println("                          logging a warning, e.g. 24h (default: 0 = disabled)")
+	fmt.Println("  --revocation-file <f>   Fingerprints and github:<user> entries whose keys are never")
+	fmt.Println("                          served (default: /etc/charon-key/revoked)")
 	fmt.Println("  --log-level <level>     Log level: debug|info|warn|error (optional, default: info)")
+	fmt.Println("  --correlation-id <id>   ID added to every log record and alert of this run")
+	fmt.Println("                          (optional, default: random)")
+	fmt.Println("  --log-file <path>       Also write logs to this file (mode 0600), for hosts without")
+	fmt.Println("                          journald where sshd discards stderr (optional)")
+	fmt.Println("  --log-file-max-size <mb> Rotate the log file before it exceeds this size, 0 for no")
+	fmt.Println("                          limit (optional, default: 10)")
+	fmt.Println("  --log-file-max-age <d>  Rotate the log file when a period of this length ends, e.g.")
+	fmt.Println("                          24h for daily files (optional, default: none)")
+	fmt.Println("  --log-file-keep <n>     Rotated log files kept as <path>.1..n (optional, default: 5)")
+	fmt.Println("  --debug-on-error <n>    Keep the last n records below the log level in memory and")
+	fmt.Println("                          write them to stderr only if the run fails (optional)")
+	fmt.Println("  --log-sample <n>        Log the first and every nth debug or info record of each")
+	fmt.Println("                          message, e.g. \"cache hit\" (optional, default: all)")
+	fmt.Println("  --log-rate-limit <n>    Log at most n records of each message per second; the next")
+	fmt.Println("                          one logged counts the dropped ones (optional)")
+	fmt.Println("  --redact <kinds>        Scrub from logs and error reports: tokens, keys (blobs are")
+	fmt.Println("                          replaced by fingerprints), usernames, or none")
+	fmt.Println("                          (optional, default: tokens,keys)")
+	fmt.Println("  --github-url <url>      Base URL serving <user>.keys (default: https://github.com)")
+	fmt.Println("  --github-token-source <s> Where to read a GitHub token: file:PATH, env:NAME,")
+	fmt.Println("                          keyring:SERVICE/ACCOUNT (secret-tool or macOS keychain) or")
+	fmt.Println("                          vault:PATH#FIELD; uncached users are then fetched in bulk")
+	fmt.Println("  --vault-addr <url>      Vault server for vault: sources (default: $VAULT_ADDR)")
+	fmt.Println("  --vault-token-source <s> Where to read the Vault token (default: $VAULT_TOKEN)")
+	fmt.Println("  --github-token-file <f> Same as --github-token-source file:<f> (optional)")
+	fmt.Println("  --fips                  Accept only FIPS-approved key algorithms and TLS ciphers;")
+	fmt.Println("                          requires GODEBUG=fips140=on (optional)")
+	fmt.Println("  --key-types <list>      Serve only these key types: rsa, dsa, ecdsa, ed25519,")
+	fmt.Println("                          sk-ecdsa, sk-ed25519 or full names (optional, default: all)")
+	fmt.Println("  --min-rsa-bits <n>      Drop RSA keys with fewer bits (optional, default: none)")
+	fmt.Println("  --drop-invalid-keys     Decode every GitHub key and drop malformed ones instead of")
+	fmt.Println("                          failing with exit code 2 (optional)")
+	fmt.Println("  --break-glass-key <key> Key always emitted, even if GitHub and cache fail")
+	fmt.Println("                          (optional, repeatable)")
+	fmt.Println("  --break-glass-file <f>  File of keys always emitted, even on failures (optional)")
+	fmt.Println("  --allow-empty           When no keys resolve, still emit local authorized_keys")
+	fmt.Println("                          and exit 0 (default)")
+	fmt.Println("  --deny-on-empty         When no keys resolve, emit nothing and exit with code 6")
+	fmt.Println("  --provenance            Append each key's source to its comment, e.g.")
+	fmt.Println("                          \"via charon-key github:alice 2024-05-01\" (optional)")
+	fmt.Println("  --output-template <t>   Render the output with a Go text/template over .SSHUser and")
+	fmt.Println("                          .Keys (.Line, .Options, .Type, .Blob, .Comment, .Source, ...)")
+	fmt.Println("  --output-template-file <f> Read the --output-template from a file (optional)")
+	fmt.Println("  --ldap-url <url>        Also map SSH users to GitHub users via LDAP (optional)")
+	fmt.Println("  --ldap-base-dn <dn>     LDAP search base (required with --ldap-url)")
+	fmt.Printf("  --ldap-filter <filter>  LDAP filter, %%s is the SSH username (default: %s)\n", ldap.DefaultFilter)
+	fmt.Println("  --ldap-attribute <a>    Attribute holding GitHub usernames (default: githubUsername)")
+	fmt.Println("  --ldap-bind-dn <dn>     LDAP bind DN (optional, default: anonymous bind)")
+	fmt.Println("  --ldap-password-file <f> File containing the LDAP bind password (optional)")
+	fmt.Println("  --passwd-source <src>   How to look up SSH users' home directories: auto|os|getent")
+	fmt.Println("                          (optional, default: auto = os, then getent for NSS users)")
+	fmt.Println("  --authorized-keys-file <f> authorized_keys file to read and sync instead of")
+	fmt.Println("                          %h/.ssh/authorized_keys, like AuthorizedKeysFile, e.g.")
+	fmt.Println("                          /etc/ssh/authorized_keys/%u (optional)")
+	fmt.Println("  --chroot-dir <dir>      Chroot directory, like ChrootDirectory, that passwd home")
+	fmt.Println("                          directories are inside, e.g. /srv/sftp/%u for sftp-only")
+	fmt.Println("                          accounts (optional)")
+	fmt.Println("  --chroot-user <user>    SSH user chrooted in --chroot-dir (optional, repeatable,")
+	fmt.Println("                          default: every user)")
+	fmt.Println("  --existing-keys-file <f> Further key file merged after authorized_keys, like the")
+	fmt.Println("                          extra paths of AuthorizedKeysFile; %u is the SSH")
+	fmt.Println("                          username, %h its home, relative paths are in the home")
+	fmt.Println("                          (optional, repeatable)")
+	fmt.Println("  --dedup <strategy>      Copy kept of a key both in authorized_keys and on GitHub:")
+	fmt.Println("                          prefer-local (default), prefer-github or")
+	fmt.Println("                          keep-both-with-comment (not with --stream or --sync)")
+	fmt.Println("  --fix-permissions       Fix group/world-writable or wrongly owned authorized_keys,")
+	fmt.Println("                          .ssh and home, which sshd ignores (default: only warn)")
+	fmt.Println("  --unprivileged          Run as a dedicated non-root AuthorizedKeysCommandUser;")
+	fmt.Println("                          authorized_keys it can't read are skipped (not with")
+	fmt.Println("                          --sync or --fix-permissions)")
+	fmt.Println("  --read-helper <path>    charon-key-read-keys, with CAP_DAC_READ_SEARCH, reading")
+	fmt.Println("                          authorized_keys for --unprivileged instead (optional)")
+	fmt.Println("  --stream                Write keys as soon as each GitHub user resolves, keeping")
+	fmt.Println("                          memory bounded for huge mappings; output is in mapping")
+	fmt.Println("                          order (optional, not with --sync or --deny-on-empty)")
+	fmt.Println("  --sync                  Write keys into the user's authorized_keys (managed block)")
+	fmt.Println("                          instead of stdout, e.g. from cron (optional)")
+	fmt.Println("  --sync-backups <n>      Backups of authorized_keys kept by --sync (default: 3)")
+	fmt.Println("  --output <path>         Atomically write keys to this file (temp file and rename)")
+	fmt.Println("                          instead of stdout; %u is the SSH username, %h its home")
+	fmt.Println("                          (optional, not with --stream or --sync)")
+	fmt.Println("  --output-mode <mode>    Permissions of the --output file (default: 0600)")
+	fmt.Println("  --principals-file <f>   With --sync, also write role names and GitHub users as")
+	fmt.Println("                          certificate principals to this AuthorizedPrincipalsFile;")
+	fmt.Println("                          %u is the SSH username, %h its home (optional)")
+	fmt.Println("  --timeout <seconds>     Overall key resolution timeout (optional, default: none)")
+	fmt.Println("  --user-timeout <secs>   Per-GitHub-user fetch timeout, so one slow user can't")
+	fmt.Println("                          starve the others (optional, default: none)")
+	fmt.Println("  --http-timeout <secs>   Timeout of each HTTP request to GitHub (default: 10)")
+	fmt.Println("  --retries <n>           Retries of network errors, timeouts and 5xx responses per")
+	fmt.Println("                          GitHub user (default: 3, 0 = no retries)")
+	fmt.Println("  --retry-delay <d>       Delay before the first retry, e.g. 500ms; retry n waits")
+	fmt.Println("                          n times as long (default: 1s)")
+	fmt.Println("  --canary-fingerprint <f> SHA256 fingerprint of a honeypot key; serving it or finding")
+	fmt.Println("                          it in authorized_keys logs an AUDIT event (repeatable)")
+	fmt.Println("  --canary-webhook <url>  Also POST canary events as JSON to this URL (optional)")
+	fmt.Println("  --alert-webhook <url>   POST a JSON alert (Slack-compatible \"text\") when stale cache")
+	fmt.Println("                          is served, resolution fails, deny-on-empty triggers or")
+	fmt.Println("                          keys violate policy (optional)")
+	fmt.Println("  --alert-keyset-changes  Also alert when keys are added to or removed from an SSH")
+	fmt.Println("                          user's keyset (see Rolling Back Keys)")
+	fmt.Println("  --access-url <url>      Ask this access-request system for an approved, unexpired")
+	fmt.Println("                          just-in-time grant before emitting keys (optional)")
+	fmt.Println("  --access-token-source <s> Where to read its bearer token (optional)")
+	fmt.Println("  --wildcard-org <org>    Only resolve SSH users mapped by \"*\" if they are active")
+	fmt.Println("                          members of this GitHub org (optional)")
+	fmt.Println("  --mapping-conflicts <p> When the config file, its profile and --user-map configure")
+	fmt.Println("                          an SSH user differently: ignore|warn|error (default: warn)")
+	fmt.Println("  --deny-wildcard         Ignore \"*\" mappings from flags, config file, profiles and")
+	fmt.Println("                          conditional mappings alike (optional)")
+	fmt.Println("  --profile-startup       Log the time spent per phase (config, cache, resolve, ...)")
+	fmt.Println("                          against the 100ms warm-cache budget (optional)")
+	fmt.Println("  --debug-bundle <file>   On fatal errors, write a redacted JSON debug bundle (config,")
+	fmt.Println("                          timings, HTTP status codes, cache summary) to attach to")
+	fmt.Println("                          support tickets (optional)")
+	fmt.Println("  --error-format <fmt>    text|json; json also writes the fatal error as a JSON")
+	fmt.Println("                          object (error, category, exit_code) to stderr")
+	fmt.Println("  --explain-exit-code <n> Describe exit code n (or all) and exit")
 	fmt.Println("  -h, --help              Show this help message")
 	fmt.Println("  -v, --version           Show version information")
 	fmt.Println()
+	fmt.Println("Exit Codes:")
+	for _, code := range errors.ExitCodes() {
+		fmt.Printf("  %d  %s (%s)\n", int(code), code, code.Category())
+	}
+	fmt.Println()
 	fmt.Println("Examples:")
 	fmt.Println("  charon-key --user-map alice:alice-github,bob:bob-github")
 	fmt.Println("  charon-key --user-map *:dgarifullin --cache-dir /var/cache/charon-key")
@@ -232,4 +1782,3 @@ func printHelp() {
 	fmt.Println("    AuthorizedKeysCommand /path/to/charon-key --user-map <mapping>")
 	fmt.Println("    AuthorizedKeysCommandUser root")
 }
-
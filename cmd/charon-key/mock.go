@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dgarifullin/charon-key/internal/errors"
+	"github.com/dgarifullin/charon-key/internal/logger"
+	"github.com/dgarifullin/charon-key/internal/mockgithub"
+	"github.com/dgarifullin/charon-key/internal/redact"
+)
+
+// runMockGitHub serves GitHub's .keys endpoints from fixture files, for
+// integration tests pointing --github-url at it
+// Usage: charon-key mock-github --fixtures DIR [OPTIONS]
+func runMockGitHub(args []string) {
+	var fixtures, listen, logLevel string
+	var latency time.Duration
+	var errorRate float64
+	var rateLimit int
+	fs := flag.NewFlagSet("charon-key mock-github", flag.ExitOnError)
+	fs.StringVar(&fixtures, "fixtures", "", "Directory of <github-user>.keys fixture files (required)")
+	fs.StringVar(&listen, "listen", "127.0.0.1:8080", "Address to listen on")
+	fs.DurationVar(&latency, "latency", 0, "Delay added to every response, e.g. 500ms")
+	fs.Float64Var(&errorRate, "error-rate", 0, "Fraction of requests answered with HTTP 500 (0 to 1)")
+	fs.IntVar(&rateLimit, "rate-limit", 0, "Requests allowed per minute before HTTP 429 (0 = unlimited)")
+	fs.StringVar(&logLevel, "log-level", "info", "Log level: debug|info|warn|error")
+	fs.Parse(args)
+
+	log := logger.NewLogger(logLevel).WithRedactor(redact.Default())
+
+	var err error
+	switch {
+	case fixtures == "":
+		err = fmt.Errorf("--fixtures is required")
+	case errorRate < 0 || errorRate > 1:
+		err = fmt.Errorf("error-rate must be between 0 and 1, got %g", errorRate)
+	case latency < 0 || rateLimit < 0:
+		err = fmt.Errorf("latency and rate-limit cannot be negative")
+	}
+	if err == nil {
+		if info, statErr := os.Stat(fixtures); statErr != nil || !info.IsDir() {
+			err = fmt.Errorf("fixtures directory %q not found", fixtures)
+		}
+	}
+	if err != nil {
+		log.Error("configuration error", "error", err)
+		errors.ExitWithError(errors.NewAppError("configuration error", errors.ExitConfigError, err))
+	}
+
+	server := mockgithub.NewServer(fixtures)
+	server.Latency = latency
+	server.ErrorRate = errorRate
+	server.RateLimit = rateLimit
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Debug("request", "method", r.Method, "path", r.URL.Path)
+		server.ServeHTTP(w, r)
+	})
+
+	log.Info("serving mock GitHub", "listen", listen, "fixtures", fixtures, "latency", latency, "error_rate", errorRate, "rate_limit", rateLimit)
+	if err := http.ListenAndServe(listen, handler); err != nil {
+		log.Error("mock GitHub server failed", "error", err)
+		errors.ExitWithError(errors.NewAppError("mock GitHub server failed", errors.ExitGeneralError, err))
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/dgarifullin/charon-key/internal/errors"
+	"github.com/dgarifullin/charon-key/internal/github"
+)
+
+// checkWildcardOrg confirms that an SSH user mapped only by the "*" wildcard
+// is an active member of --wildcard-org, so typo'd or removed accounts get
+// no keys. Failed lookups are logged and returned as *errors.AppError
+// (fail closed), like denials
+func (a *app) checkWildcardOrg(username string) error {
+	cfg, log := a.cfg, a.log
+	if cfg.WildcardOrg == "" || !cfg.IsWildcardMapped(username) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), github.DefaultTimeout)
+	defer cancel()
+	member, err := a.fetcher.IsOrgMember(ctx, cfg.WildcardOrg, username)
+	if err != nil {
+		log.Error("failed to check org membership", "ssh_username", username, "org", cfg.WildcardOrg, "error", err)
+		a.alertResolutionFailed(username, err)
+		return errors.NewAppError("failed to check org membership", errors.ExitNetworkError, err)
+	}
+
+	if !member {
+		log.Warn("SSH user is not an active org member, denying wildcard mapping", "ssh_username", username, "org", cfg.WildcardOrg)
+		return errors.NewAppError("access denied", errors.ExitAccessDenied, fmt.Errorf("SSH user %s is not an active member of GitHub org %s", username, cfg.WildcardOrg))
+	}
+
+	log.Debug("org membership confirmed", "ssh_username", username, "org", cfg.WildcardOrg)
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"time"
+
+	"github.com/dgarifullin/charon-key/internal/logger"
+)
+
+// processStarted approximates when the process started, as package
+// variables are initialized before main
+var processStarted = time.Now()
+
+// startupBudget is the latency goal of the AuthorizedKeysCommand path with a
+// warm cache
+const startupBudget = 100 * time.Millisecond
+
+// startupProfile records how long each phase of an invocation took, for
+// --profile-startup. A nil *startupProfile is disabled
+type startupProfile struct {
+	last   time.Time
+	phases []string
+	times  map[string]time.Duration
+}
+
+// newStartupProfile starts a profile at process start (nil if disabled)
+func newStartupProfile(enabled bool) *startupProfile {
+	if !enabled {
+		return nil
+	}
+	return &startupProfile{last: processStarted, times: make(map[string]time.Duration)}
+}
+
+// mark ends the current phase, attributing the time since the previous mark
+// to it; repeated phases (one per SSH user) add up
+func (p *startupProfile) mark(phase string) {
+	if p == nil {
+		return
+	}
+	now := time.Now()
+	if _, seen := p.times[phase]; !seen {
+		p.phases = append(p.phases, phase)
+	}
+	p.times[phase] += now.Sub(p.last)
+	p.last = now
+}
+
+// report logs the phase timings and whether the run met the latency budget
+func (p *startupProfile) report(log *logger.Logger) {
+	if p == nil {
+		return
+	}
+	total := time.Since(processStarted)
+	args := make([]any, 0, 2*len(p.phases)+6)
+	for _, phase := range p.phases {
+		args = append(args, phase, p.times[phase])
+	}
+	args = append(args, "total", total, "budget", startupBudget, "within_budget", total <= startupBudget)
+	log.Info("startup profile", args...)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dgarifullin/charon-key/internal/errors"
+	"github.com/dgarifullin/charon-key/internal/redact"
+)
+
+// runResolve resolves several SSH users in one process, sharing the cache,
+// resolver and HTTP connections, for batch sync and cache warm-up jobs
+// With --concurrency, GitHub users are fetched in parallel first; SSH users
+// are still output in order
+// An SSH username of "-" reads SSH usernames from stdin (see readUsernames)
+// Exits 0 if every SSH user succeeded, ExitPartialFailure if only some did,
+// and with the first failure's code if none did
+// Usage: charon-key resolve [OPTIONS] [--concurrency N] [--progress] [--errors-json FILE] --all | SSH-USERNAME... | -
+func runResolve(args []string) {
+	var opts options
+	var all bool
+	var concurrency int
+	var showProgress bool
+	var errorsJSON string
+	fs := newFlagSet("charon-key resolve", &opts)
+	fs.BoolVar(&all, "all", false, "Resolve every SSH user in the static user map")
+	fs.IntVar(&concurrency, "concurrency", 1, "Fetch up to this many GitHub users in parallel")
+	fs.BoolVar(&showProgress, "progress", false, "Report progress on stderr after each SSH user")
+	fs.StringVar(&errorsJSON, "errors-json", "", "Write the failed SSH users and why as JSON to this file")
+	fs.Parse(args)
+	handleInfoFlags(opts)
+	applyErrorFormat(opts)
+
+	log := newLogger(opts)
+
+	if all == (fs.NArg() > 0) {
+		err := fmt.Errorf("resolve needs either --all or SSH usernames")
+		log.Error("configuration error", "error", err)
+		errors.ExitWithError(errors.NewAppError("configuration error", errors.ExitConfigError, err))
+	}
+	if concurrency < 1 {
+		err := fmt.Errorf("concurrency must be at least 1, got %d", concurrency)
+		log.Error("configuration error", "error", err)
+		errors.ExitWithError(errors.NewAppError("configuration error", errors.ExitConfigError, err))
+	}
+
+	bundle := newDebugBundle(opts.debugBundle, log)
+	a, err := newApp(opts, log)
+	if err != nil {
+		bundle.write(nil, "", err)
+		errors.ExitWithError(err)
+	}
+	a.breakGlassKeys = loadBreakGlassKeys(opts, log)
+	bundle.attach(a)
+
+	usernames := fs.Args()
+	if all {
+		usernames = a.mappedUsers()
+	} else if usernames, err = expandStdinUsernames(usernames, os.Stdin); err != nil {
+		log.Error("configuration error", "error", err)
+		errors.ExitWithError(errors.NewAppError("configuration error", errors.ExitConfigError, err))
+	}
+
+	if len(usernames) > 1 && opts.output != "" && !strings.Contains(opts.output, "%u") && !strings.Contains(opts.output, "%h") {
+		err := fmt.Errorf("--output must contain %%u or %%h when resolving several SSH users")
+		log.Error("configuration error", "error", err)
+		errors.ExitWithError(errors.NewAppError("configuration error", errors.ExitConfigError, err))
+	}
+
+	log.Info("starting charon-key resolve", "version", version, "users", len(usernames))
+
+	if concurrency > 1 {
+		var githubUsers []string
+		for _, username := range usernames {
+			githubUsers = append(githubUsers, a.resolver.GitHubUsers(username)...)
+		}
+		a.resolver.Prefetch(githubUsers, concurrency)
+	}
+
+	// Keep going after a failed user; the exit code tells partial from total failure
+	var firstErr error
+	var firstUser string
+	var failures []string
+	var userErrors []userError
+	redactor, _ := redact.Parse(opts.redact) // Validated by newLogger
+	progress := newProgress(os.Stderr, showProgress, len(usernames))
+	for _, username := range usernames {
+		if err := a.resolveUser(username, len(usernames) > 1); err != nil {
+			log.Error("failed to resolve SSH user", "ssh_username", username, "error", err)
+			failures = append(failures, fmt.Sprintf("%s: %v", username, err))
+			userErrors = append(userErrors, a.userError(username, err, redactor.String))
+			if firstErr == nil {
+				firstErr, firstUser = err, username
+			}
+		}
+		progress.update(len(failures))
+	}
+	progress.finish()
+	if len(failures) > 0 {
+		log.Error("failed to resolve some SSH users", "failed", len(failures), "users", len(usernames), "errors", strings.Join(failures, "; "))
+	}
+	if errorsJSON != "" {
+		if err := writeErrorsReport(errorsJSON, len(usernames), userErrors); err != nil {
+			log.Error("failed to write errors report", "path", errorsJSON, "error", err)
+			if firstErr == nil {
+				firstErr = errors.NewAppError("failed to write errors report", errors.ExitGeneralError, err)
+			}
+		}
+	}
+	if len(failures) > 0 && len(failures) < len(usernames) {
+		firstErr = errors.NewAppError("failed to resolve some SSH users", errors.ExitPartialFailure,
+			fmt.Errorf("%d of %d failed, first %s: %w", len(failures), len(usernames), firstUser, firstErr))
+	}
+
+	a.logSummary(firstErr)
+	if firstErr != nil {
+		bundle.write(a, firstUser, firstErr)
+		errors.ExitWithError(firstErr)
+	}
+	errors.ExitWithCode(errors.ExitSuccess)
+}
+
+// progress reports how many SSH users a resolve run has done on stderr,
+// rewriting one line on a terminal and writing a line per SSH user otherwise
+type progress struct {
+	w        io.Writer
+	enabled  bool
+	terminal bool
+	total    int
+	count    int
+}
+
+// newProgress returns a progress reporter for total SSH users, doing
+// nothing unless enabled
+func newProgress(w *os.File, enabled bool, total int) *progress {
+	p := &progress{w: w, enabled: enabled, total: total}
+	if info, err := w.Stat(); err == nil {
+		p.terminal = info.Mode()&os.ModeCharDevice != 0
+	}
+	return p
+}
+
+// update records one more SSH user done, with the number failed so far
+func (p *progress) update(failed int) {
+	p.count++
+	if !p.enabled {
+		return
+	}
+	line := fmt.Sprintf("resolved %d/%d SSH users (%d failed)", p.count, p.total, failed)
+	if p.terminal {
+		fmt.Fprintf(p.w, "\r%s", line)
+		return
+	}
+	fmt.Fprintln(p.w, line)
+}
+
+// finish ends the rewritten line on a terminal
+func (p *progress) finish() {
+	if p.enabled && p.terminal && p.count > 0 {
+		fmt.Fprintln(p.w)
+	}
+}
+
+// expandStdinUsernames replaces a "-" argument with the SSH usernames read
+// from stdin, and drops repeated usernames
+func expandStdinUsernames(args []string, stdin io.Reader) ([]string, error) {
+	seen := make(map[string]bool)
+	var usernames []string
+	for _, arg := range args {
+		names := []string{arg}
+		if arg == "-" {
+			var err error
+			if names, err = readUsernames(stdin); err != nil {
+				return nil, err
+			}
+		}
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				usernames = append(usernames, name)
+			}
+		}
+	}
+	return usernames, nil
+}
+
+// readUsernames reads one SSH username per line, ignoring surrounding
+// whitespace, empty lines and lines starting with "#"
+func readUsernames(r io.Reader) ([]string, error) {
+	var usernames []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		usernames = append(usernames, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read SSH usernames from stdin: %w", err)
+	}
+	return usernames, nil
+}
+
+// resolveUser resolves one SSH user and syncs or prints its keys
+// When printing several users, each user's keys follow a "# <user>" header
+func (a *app) resolveUser(username string, header bool) error {
+	if a.cfg.Stream {
+		if header {
+			fmt.Printf("# %s\n", username)
+		}
+		return a.streamKeys(username)
+	}
+
+	keys, err := a.keysForUser(username)
+	if err != nil {
+		return err
+	}
+
+	sshManager, err := a.sshManagerFor(username)
+	if err != nil {
+		return errors.NewAppError("failed to initialize SSH manager", errors.ExitPermissionError, err)
+	}
+
+	if header && !a.cfg.Sync && a.cfg.Output == "" {
+		fmt.Printf("# %s\n", username)
+	}
+	if err := a.writeKeys(sshManager, username, keys); err != nil {
+		return err
+	}
+
+	a.evaluateShadow(username, keys)
+	return nil
+}
+
+// mappedUsers returns the SSH users of the static user map, sorted
+// The "*" wildcard is not a user, and LDAP mappings cannot be enumerated
+func (a *app) mappedUsers() []string {
+	var usernames []string
+	for username := range a.cfg.UserMap {
+		if username != "*" {
+			usernames = append(usernames, username)
+		}
+	}
+	sort.Strings(usernames)
+	return usernames
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	stderrors "errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dgarifullin/charon-key/internal/errors"
+	"github.com/dgarifullin/charon-key/internal/logger"
+	"github.com/dgarifullin/charon-key/internal/revoke"
+	"github.com/dgarifullin/charon-key/internal/ssh"
+)
+
+// runRevoke adds key fingerprints and GitHub users to the revocation list,
+// removes them with --remove, or prints the list with --list
+// Revocations apply to the next login or sync, whatever is cached
+// Usage: charon-key revoke [OPTIONS] [--reason R] [--remove] ENTRY... | --list
+func runRevoke(args []string) {
+	var opts options
+	var reason string
+	var remove, list bool
+	fs := newFlagSet("charon-key revoke", &opts)
+	fs.StringVar(&reason, "reason", "", "Why the entries are revoked, recorded next to them (optional)")
+	fs.BoolVar(&remove, "remove", false, "Remove the entries from the revocation list instead")
+	fs.BoolVar(&list, "list", false, "Print the revocation list")
+	fs.Parse(args)
+	handleInfoFlags(opts)
+	applyErrorFormat(opts)
+
+	log := newLogger(opts)
+	path := opts.revocationFile
+
+	if list == (fs.NArg() > 0) {
+		err := fmt.Errorf("revoke needs SHA256:<fingerprint> or github:<user> entries, or --list")
+		log.Error("configuration error", "error", err)
+		errors.ExitWithError(errors.NewAppError("configuration error", errors.ExitConfigError, err))
+	}
+
+	switch {
+	case list:
+		revoked, err := revoke.Load(path)
+		if err != nil {
+			log.Error("failed to read revocation list", "path", path, "error", err)
+			errors.ExitWithError(errors.NewAppError("failed to read revocation list", errors.ExitConfigError, err))
+		}
+		if revoked.Len() == 0 {
+			fmt.Printf("Nothing revoked in %s\n", path)
+			break
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, entry := range revoked.Entries() {
+			fmt.Fprintf(tw, "%s\t%s\n", entry.Entry, entry.Comment)
+		}
+		tw.Flush()
+
+	case remove:
+		removed, err := revoke.Remove(path, fs.Args())
+		if err != nil {
+			log.Error("failed to update revocation list", "path", path, "error", err)
+			errors.ExitWithError(errors.NewAppError("failed to update revocation list", revocationExitCode(err), err))
+		}
+		log.Info("removed revocations", "path", path, "entries", fs.Args(), "removed", removed)
+		fmt.Printf("Removed %d of %d entries from %s\n", removed, fs.NArg(), path)
+
+	default:
+		added, err := revoke.Add(path, fs.Args(), reason, time.Now())
+		if err != nil {
+			log.Error("failed to update revocation list", "path", path, "error", err)
+			errors.ExitWithError(errors.NewAppError("failed to update revocation list", revocationExitCode(err), err))
+		}
+		log.Warn("revoked", "path", path, "entries", fs.Args(), "added", added, "reason", reason)
+		fmt.Printf("Revoked %d new of %d entries in %s\n", added, fs.NArg(), path)
+	}
+	errors.ExitWithCode(errors.ExitSuccess)
+}
+
+// revocationExitCode maps a revocation list update failure to an exit code:
+// a permission error for files it can't write, a config error otherwise
+func revocationExitCode(err error) errors.ExitCode {
+	if stderrors.Is(err, os.ErrPermission) {
+		return errors.ExitPermissionError
+	}
+	return errors.ExitConfigError
+}
+
+// withoutRevoked returns keys without those whose fingerprint is on the
+// revocation list, logging each one dropped; kind names the keys in logs
+func withoutRevoked(list *revoke.List, keys []string, log *logger.Logger, kind string) []string {
+	if list.Len() == 0 {
+		return keys
+	}
+	var kept []string
+	for _, key := range keys {
+		if fingerprint, err := ssh.Fingerprint(key); err == nil && list.KeyRevoked(fingerprint) {
+			log.Warn("key revoked, not serving it", "keys", kind, "fingerprint", fingerprint)
+			continue
+		}
+		kept = append(kept, key)
+	}
+	return kept
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/dgarifullin/charon-key/internal/ssh"
+)
+
+// revocationFile writes a revocation list revoking key
+func revocationFile(t *testing.T, key string) string {
+	t.Helper()
+	fingerprint, err := ssh.Fingerprint(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "revoked")
+	if err := os.WriteFile(path, []byte(fingerprint+" # lost laptop\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestRevokedKeys checks that the revocation list applies to the keys the
+// resolver doesn't serve
+func TestRevokedKeys(t *testing.T) {
+	revoked := revocationFile(t, testEd25519Key)
+	a := testApp(t, []string{testECDSAKey}, "--revocation-file", revoked)
+
+	t.Run("rolled back keyset", func(t *testing.T) {
+		keyset, _, err := a.cache.RecordKeyset("deploy", []string{testEd25519Key, testRSA1024Key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := a.cache.RecordKeyset("deploy", []string{testECDSAKey}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.cache.PinKeyset("deploy", keyset.ID); err != nil {
+			t.Fatal(err)
+		}
+		if got := a.syncedKeys("deploy", []string{testECDSAKey}); !reflect.DeepEqual(got, []string{testRSA1024Key}) {
+			t.Errorf("syncedKeys() = %v, want the rolled back keyset without the revoked key", got)
+		}
+	})
+
+	t.Run("existing keys", func(t *testing.T) {
+		existing := []string{`command="uptime" ` + testEd25519Key + " old", testRSA1024Key}
+		if got := a.filterExisting("deploy", existing); !reflect.DeepEqual(got, []string{testRSA1024Key}) {
+			t.Errorf("filterExisting() = %v, want the revoked key dropped", got)
+		}
+	})
+
+	t.Run("break-glass keys", func(t *testing.T) {
+		var opts options
+		fs := newFlagSet("charon-key", &opts)
+		if err := fs.Parse([]string{"--revocation-file", revoked,
+			"--break-glass-key", testEd25519Key, "--break-glass-key", testECDSAKey}); err != nil {
+			t.Fatal(err)
+		}
+		if got := loadBreakGlassKeys(opts, a.log); !reflect.DeepEqual(got, []string{testECDSAKey}) {
+			t.Errorf("loadBreakGlassKeys() = %v, want the revoked key dropped", got)
+		}
+	})
+}
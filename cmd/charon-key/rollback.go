@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dgarifullin/charon-key/internal/audit"
+	"github.com/dgarifullin/charon-key/internal/cache"
+	"github.com/dgarifullin/charon-key/internal/errors"
+	"github.com/dgarifullin/charon-key/internal/revoke"
+	"github.com/dgarifullin/charon-key/internal/ssh"
+)
+
+// runRollback lists the keysets recorded for an SSH user, or rolls sync
+// mode back to one of them, so a bad upstream change can be reverted locally
+// while it's investigated; --release returns to the resolved keys
+// Usage: charon-key rollback [OPTIONS] [--release] SSH-USERNAME [KEYSET]
+func runRollback(args []string) {
+	var opts options
+	var release bool
+	fs := newFlagSet("charon-key rollback", &opts)
+	fs.BoolVar(&release, "release", false, "Stop serving the rolled back keyset; the next sync writes the resolved keys")
+	fs.Parse(args)
+	handleInfoFlags(opts)
+	applyErrorFormat(opts)
+
+	log := newLogger(opts)
+
+	if fs.NArg() < 1 || fs.NArg() > 2 || (release && fs.NArg() != 1) {
+		err := fmt.Errorf("rollback needs an SSH username and a keyset, or --release and an SSH username")
+		log.Error("configuration error", "error", err)
+		errors.ExitWithError(errors.NewAppError("configuration error", errors.ExitConfigError, err))
+	}
+	username := fs.Arg(0)
+
+	cacheManager, err := cache.NewManager(opts.cacheDir, time.Duration(opts.cacheTTLMinutes)*time.Minute)
+	if err != nil {
+		log.Error("failed to initialize cache", "error", err)
+		errors.ExitWithError(errors.NewAppError("failed to initialize cache", errors.ExitGeneralError, err))
+	}
+
+	switch {
+	case release:
+		if err := cacheManager.UnpinKeyset(username); err != nil {
+			log.Error("failed to release keyset", "ssh_username", username, "error", err)
+			errors.ExitWithError(errors.NewAppError("failed to release keyset", errors.ExitGeneralError, err))
+		}
+		log.Info("released rolled back keyset", "ssh_username", username)
+		fmt.Printf("Released %s; the next sync writes its resolved keys\n", username)
+
+	case fs.NArg() == 1:
+		history, err := cacheManager.Keysets(username)
+		if err != nil {
+			log.Error("failed to read keysets", "ssh_username", username, "error", err)
+			errors.ExitWithError(errors.NewAppError("failed to read keysets", errors.ExitGeneralError, err))
+		}
+		printKeysets(history)
+
+	default:
+		keyset, err := cacheManager.PinKeyset(username, fs.Arg(1))
+		if err != nil {
+			log.Error("failed to roll back", "ssh_username", username, "error", err)
+			errors.ExitWithError(errors.NewAppError("failed to roll back", errors.ExitConfigError, err))
+		}
+		source, err := ssh.ParsePasswdSource(opts.passwdSource)
+		if err != nil {
+			log.Error("configuration error", "error", err)
+			errors.ExitWithError(errors.NewAppError("configuration error", errors.ExitConfigError, err))
+		}
+		sshManager, err := ssh.NewManagerWithSource(username, source)
+		if err != nil {
+			log.Error("failed to initialize SSH manager", "ssh_username", username, "error", err)
+			errors.ExitWithError(errors.NewAppError("failed to initialize SSH manager", errors.ExitPermissionError, err))
+		}
+		if chroot := chrootFor(opts.chrootDir, opts.chrootUsers, username); chroot != "" {
+			sshManager.SetChroot(chroot, username)
+		}
+		if opts.authorizedKeysFile != "" {
+			sshManager.SetAuthorizedKeysFile(opts.authorizedKeysFile, username)
+		}
+		revoked, err := revoke.Load(opts.revocationFile)
+		if err != nil {
+			log.Error("configuration error", "error", err)
+			errors.ExitWithError(errors.NewAppError("configuration error", errors.ExitConfigError, err))
+		}
+		writeOpts := ssh.DefaultWriteOptions()
+		writeOpts.Backups = opts.syncBackups
+		if err := sshManager.SyncKeys(withoutRevoked(revoked, keyset.Keys, log, "rolled back"), writeOpts); err != nil {
+			log.Error("failed to sync authorized_keys", "path", sshManager.GetAuthorizedKeysPath(), "error", err)
+			errors.ExitWithError(errors.NewAppError("failed to sync authorized_keys", errors.ExitPermissionError, err))
+		}
+		log.Warn("rolled back keyset", "ssh_username", username, "keyset", keyset.ID, "recorded_at", keyset.At, "keys", len(keyset.Keys))
+		fmt.Printf("Rolled %s back to keyset %s from %s (%d keys); sync mode keeps writing it until\n",
+			username, keyset.ID, keyset.At.Format(time.RFC3339), len(keyset.Keys))
+		fmt.Printf("  charon-key rollback --release %s\n", username)
+	}
+	errors.ExitWithCode(errors.ExitSuccess)
+}
+
+// printKeysets lists an SSH user's keysets, newest first, marking the one
+// it is rolled back to
+func printKeysets(history cache.KeysetHistory) {
+	if len(history.Keysets) == 0 {
+		fmt.Printf("No keysets recorded for %s\n", history.SSHUser)
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEYSET\tRECORDED\tKEYS\t")
+	for i := len(history.Keysets) - 1; i >= 0; i-- {
+		keyset := history.Keysets[i]
+		var note string
+		if keyset.ID == history.Pinned {
+			note = "rolled back to"
+		} else if i == len(history.Keysets)-1 {
+			note = "latest"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", keyset.ID, keyset.At.Format(time.RFC3339), len(keyset.Keys), note)
+	}
+	tw.Flush()
+}
+
+// recordKeyset records the keys resolved for an SSH user, so it can be
+// rolled back to them later, and reports keys added or removed since the
+// previous keyset
+func (a *app) recordKeyset(username string, keys []string) {
+	history, err := a.cache.Keysets(username)
+	if err != nil {
+		a.log.Warn("failed to read keysets", "ssh_username", username, "error", err)
+	}
+	previous := history.Latest()
+
+	keyset, recorded, err := a.cache.RecordKeyset(username, keys)
+	if err != nil {
+		a.log.Warn("failed to record keyset", "ssh_username", username, "error", err)
+		return
+	}
+	if !recorded {
+		return
+	}
+	a.log.Info("recorded new keyset", "ssh_username", username, "keyset", keyset.ID, "keys", len(keys))
+	if previous != nil {
+		a.reportKeysetChange(username, *previous, keyset)
+	}
+}
+
+// reportKeysetChange logs the fingerprints added and removed between two
+// keysets of an SSH user, and alerts on them with --alert-keyset-changes
+// Keysets differing only in key options aren't a change
+func (a *app) reportKeysetChange(username string, previous, keyset cache.Keyset) {
+	added, removed := diffFingerprints(fingerprintSet(previous.Keys), fingerprintSet(keyset.Keys))
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	a.log.Warn("keyset changed", "ssh_username", username, "previous_keyset", previous.ID, "keyset", keyset.ID,
+		"added", added, "removed", removed)
+	if !a.cfg.AlertKeysetChanges {
+		return
+	}
+	a.alert(audit.Event{
+		Type:     eventKeysetChanged,
+		Severity: audit.SeverityWarning,
+		Text: fmt.Sprintf("charon-key keys of SSH user %s changed: %d added, %d removed (keyset %s, was %s)",
+			username, len(added), len(removed), keyset.ID, previous.ID),
+		SSHUsername:         username,
+		AddedFingerprints:   added,
+		RemovedFingerprints: removed,
+	})
+}
+
+// syncedKeys returns the keys sync mode writes for an SSH user: the keyset
+// it is rolled back to, if any, or else the resolved keys
+func (a *app) syncedKeys(username string, keys []string) []string {
+	pinned, err := a.cache.PinnedKeyset(username)
+	if err != nil {
+		a.log.Warn("failed to read rolled back keyset, syncing resolved keys", "ssh_username", username, "error", err)
+		return keys
+	}
+	if pinned == nil {
+		return keys
+	}
+	a.log.Warn("syncing rolled back keyset instead of resolved keys", "ssh_username", username,
+		"keyset", pinned.ID, "resolved_keyset", cache.KeysetID(keys))
+	// Keys revoked since the keyset was recorded are still dropped
+	return withoutRevoked(a.revoked, pinned.Keys, a.log, "rolled back")
+}
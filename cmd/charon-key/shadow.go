@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/dgarifullin/charon-key/internal/cache"
+	"github.com/dgarifullin/charon-key/internal/config"
+	"github.com/dgarifullin/charon-key/internal/github"
+	"github.com/dgarifullin/charon-key/internal/logger"
+	"github.com/dgarifullin/charon-key/internal/policy"
+	"github.com/dgarifullin/charon-key/internal/resolver"
+	"github.com/dgarifullin/charon-key/internal/ssh"
+)
+
+// newShadowResolver creates a resolver for the candidate configuration in
+// cfg.ShadowConfigFile: the live configuration with its user map, roles and
+// comment exclusions replaced by the file's. It shares the live fetcher and
+// cache; dynamic (LDAP) mappings are not used. If the file has profiles, the
+// live profile is applied, or else the one matching the hostname.
+func newShadowResolver(cfg *config.Config, fetcher *github.Fetcher, cacheManager *cache.Manager, log *logger.Logger, opts resolver.ResolverOptions) (*resolver.Resolver, error) {
+	file, err := config.LoadFile(cfg.ShadowConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(file.Profiles) > 0 {
+		name := cfg.Profile
+		if _, ok := file.Profiles[name]; !ok {
+			if name, err = file.SelectProfile("", cfg.Hostname); err != nil {
+				return nil, err
+			}
+		}
+		if file, err = file.WithProfile(name); err != nil {
+			return nil, err
+		}
+	}
+
+	conditional, err := file.ConditionalUserMap(cfg.Hostname, cfg.HostClass, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	shadowCfg := *cfg
+	shadowCfg.UserMap = make(map[string][]string)
+	for _, userMap := range []map[string][]string{file.UserMap, conditional} {
+		for sshUser, entries := range userMap {
+			shadowCfg.UserMap[sshUser] = append(shadowCfg.UserMap[sshUser], entries...)
+		}
+	}
+	if shadowCfg.DenyWildcard || file.DenyWildcard {
+		delete(shadowCfg.UserMap, "*")
+	}
+	shadowCfg.Roles = file.Roles
+	shadowCfg.ExcludeComments = file.ExcludeComments
+	if err := shadowCfg.ValidateRoles(); err != nil {
+		return nil, fmt.Errorf("invalid roles: %w", err)
+	}
+
+	return resolver.NewResolverWithOptions(&shadowCfg, fetcher, cacheManager, log, opts), nil
+}
+
+// evaluateShadow resolves the user with the shadow configuration and logs
+// which keys it would add or remove compared to keys (the live output)
+// It never affects the output: failures are only logged
+func (a *app) evaluateShadow(username string, keys []string) {
+	if a.shadow == nil {
+		return
+	}
+	log := a.log
+
+	sources, err := a.shadow.ResolveKeySources(username)
+	if err != nil {
+		log.Warn("shadow evaluation failed", "ssh_username", username, "error", err)
+		return
+	}
+
+	candidate := make([]string, 0, len(sources))
+	for _, source := range sources {
+		if isValidKeyFormat(source.Line) {
+			candidate = append(candidate, source.Line)
+		}
+	}
+	if a.cfg.FIPS && a.cfg.FeatureEnabled(config.FeatureFIPS, username) {
+		candidate, _ = policy.FIPS().Filter(candidate)
+	}
+
+	// Break-glass keys are emitted either way, so they aren't a difference
+	live := fingerprintSet(keys)
+	for fingerprint := range fingerprintSet(a.breakGlassKeys) {
+		delete(live, fingerprint)
+	}
+	added, removed := diffFingerprints(live, fingerprintSet(candidate))
+
+	if len(added) == 0 && len(removed) == 0 {
+		log.Debug("shadow evaluation matches", "ssh_username", username, "keys", len(live))
+		return
+	}
+	log.Info("shadow evaluation differs", "ssh_username", username, "would_add", added, "would_remove", removed)
+}
+
+// diffFingerprints returns the sorted fingerprints in after but not before
+// (added), and in before but not after (removed)
+func diffFingerprints(before, after map[string]bool) (added, removed []string) {
+	for fingerprint := range after {
+		if !before[fingerprint] {
+			added = append(added, fingerprint)
+		}
+	}
+	for fingerprint := range before {
+		if !after[fingerprint] {
+			removed = append(removed, fingerprint)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// fingerprintSet returns the SHA256 fingerprints of keys (invalid keys are skipped)
+func fingerprintSet(keys []string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if fingerprint, err := ssh.Fingerprint(key); err == nil {
+			set[fingerprint] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dgarifullin/charon-key/internal/cache"
+	"github.com/dgarifullin/charon-key/internal/errors"
+)
+
+// cacheStatsReport is the JSON output of cache stats
+type cacheStatsReport struct {
+	CacheDir  string      `json:"cache_dir"`
+	Cache     cache.Stats `json:"cache"`
+	Providers []sloReport `json:"providers"`
+	Users     []sloReport `json:"users"`
+}
+
+// sloReport is a cache.SLO with durations in milliseconds
+type sloReport struct {
+	Name          string    `json:"name"`
+	Fetches       int       `json:"fetches"`
+	Failures      int       `json:"failures"`
+	SuccessRate   float64   `json:"success_rate"`
+	P50Ms         int64     `json:"p50_ms"`
+	P95Ms         int64     `json:"p95_ms"`
+	LastErrorKind string    `json:"last_error_kind,omitempty"`
+	LastFailure   time.Time `json:"last_failure,omitzero"`
+}
+
+// runCacheStats reports the cache contents and the recent fetch success
+// rate and latency per provider and per GitHub user, as a table, JSON or
+// Prometheus metrics (e.g. for node_exporter's textfile collector)
+// Usage: charon-key cache stats [OPTIONS] [--format text|json|prometheus]
+func runCacheStats(args []string) {
+	var opts options
+	var format string
+	fs := newFlagSet("charon-key cache stats", &opts)
+	fs.StringVar(&format, "format", "text", "Output format: text|json|prometheus")
+	fs.Parse(args)
+	handleInfoFlags(opts)
+	applyErrorFormat(opts)
+
+	log := newLogger(opts)
+
+	if format != "text" && format != "json" && format != "prometheus" {
+		err := fmt.Errorf("invalid format %q (expected text, json or prometheus)", format)
+		log.Error("configuration error", "error", err)
+		errors.ExitWithError(errors.NewAppError("configuration error", errors.ExitConfigError, err))
+	}
+
+	cacheManager, err := cache.NewManager(opts.cacheDir, time.Duration(opts.cacheTTLMinutes)*time.Minute)
+	if err != nil {
+		log.Error("failed to initialize cache", "error", err)
+		errors.ExitWithError(errors.NewAppError("failed to initialize cache", errors.ExitGeneralError, err))
+	}
+	stats, err := cacheManager.Stats()
+	if err != nil {
+		log.Error("failed to read cache", "cache_dir", cacheManager.GetCacheDir(), "error", err)
+		errors.ExitWithError(errors.NewAppError("failed to read cache", errors.ExitGeneralError, err))
+	}
+	histories, err := cacheManager.FetchHistories()
+	if err != nil {
+		log.Error("failed to read fetch histories", "cache_dir", cacheManager.GetCacheDir(), "error", err)
+		errors.ExitWithError(errors.NewAppError("failed to read fetch histories", errors.ExitGeneralError, err))
+	}
+
+	providers := cache.ProviderSLOs(histories)
+	users := make([]cache.SLO, len(histories))
+	for i, history := range histories {
+		users[i] = cache.Summarize(history.GitHubUser, history.Fetches)
+	}
+
+	switch format {
+	case "json":
+		report := cacheStatsReport{
+			CacheDir:  cacheManager.GetCacheDir(),
+			Cache:     stats,
+			Providers: sloReports(providers),
+			Users:     sloReports(users),
+		}
+		data, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(data))
+	case "prometheus":
+		writeStatsMetrics(os.Stdout, stats, providers, users)
+	default:
+		writeStatsTable(os.Stdout, cacheManager.GetCacheDir(), stats, providers, users)
+	}
+	errors.ExitWithCode(errors.ExitSuccess)
+}
+
+// sloReports converts SLOs for JSON output
+func sloReports(slos []cache.SLO) []sloReport {
+	reports := make([]sloReport, len(slos))
+	for i, slo := range slos {
+		reports[i] = sloReport{
+			Name:          slo.Name,
+			Fetches:       slo.Fetches,
+			Failures:      slo.Failures,
+			SuccessRate:   slo.SuccessRate,
+			P50Ms:         slo.P50.Milliseconds(),
+			P95Ms:         slo.P95.Milliseconds(),
+			LastErrorKind: slo.LastErrorKind,
+			LastFailure:   slo.LastFailure,
+		}
+	}
+	return reports
+}
+
+// writeStatsTable writes the cache stats for people
+func writeStatsTable(w io.Writer, cacheDir string, stats cache.Stats, providers, users []cache.SLO) {
+	fmt.Fprintf(w, "Cache: %s\n", cacheDir)
+	fmt.Fprintf(w, "Entries: %d (%d expired, %d unreadable)\n", stats.Entries, stats.Expired, stats.Invalid)
+	if stats.Entries > 0 {
+		fmt.Fprintf(w, "Fetched: oldest %s, newest %s\n", stats.Oldest.UTC().Format(time.RFC3339), stats.Newest.UTC().Format(time.RFC3339))
+	}
+
+	for _, section := range []struct {
+		title string
+		slos  []cache.SLO
+	}{{"PROVIDER", providers}, {"GITHUB USER", users}} {
+		if len(section.slos) == 0 {
+			continue
+		}
+		fmt.Fprintln(w)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "%s\tFETCHES\tSUCCESS\tP50\tP95\tLAST FAILURE\n", section.title)
+		for _, slo := range section.slos {
+			lastFailure := "-"
+			if !slo.LastFailure.IsZero() {
+				lastFailure = fmt.Sprintf("%s at %s", slo.LastErrorKind, slo.LastFailure.UTC().Format(time.RFC3339))
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%.1f%%\t%s\t%s\t%s\n", slo.Name, slo.Fetches, slo.SuccessRate*100,
+				slo.P50.Round(time.Millisecond), slo.P95.Round(time.Millisecond), lastFailure)
+		}
+		tw.Flush()
+	}
+}
+
+// writeStatsMetrics writes the cache stats in the Prometheus text format
+func writeStatsMetrics(w io.Writer, stats cache.Stats, providers, users []cache.SLO) {
+	gauge := func(name, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	gauge("charon_key_cache_entries", "Cache entries, by state")
+	fmt.Fprintf(w, "charon_key_cache_entries{state=\"fresh\"} %d\n", stats.Entries-stats.Expired)
+	fmt.Fprintf(w, "charon_key_cache_entries{state=\"expired\"} %d\n", stats.Expired)
+	fmt.Fprintf(w, "charon_key_cache_entries{state=\"unreadable\"} %d\n", stats.Invalid)
+
+	for _, scope := range []struct {
+		name  string
+		label string
+		slos  []cache.SLO
+	}{{"provider", "provider", providers}, {"user", "github_user", users}} {
+		prefix := "charon_key_" + scope.name + "_"
+		labels := func(slo cache.SLO) string {
+			return fmt.Sprintf("%s=\"%s\"", scope.label, metricLabel(slo.Name))
+		}
+
+		gauge(prefix+"fetches", "Recent fetches (last 50 per GitHub user)")
+		for _, slo := range scope.slos {
+			fmt.Fprintf(w, "%sfetches{%s} %d\n", prefix, labels(slo), slo.Fetches)
+		}
+		gauge(prefix+"fetch_success_ratio", "Share of recent fetches that succeeded")
+		for _, slo := range scope.slos {
+			fmt.Fprintf(w, "%sfetch_success_ratio{%s} %g\n", prefix, labels(slo), slo.SuccessRate)
+		}
+		gauge(prefix+"fetch_duration_seconds", "Recent fetch duration quantiles")
+		for _, slo := range scope.slos {
+			fmt.Fprintf(w, "%sfetch_duration_seconds{%s,quantile=\"0.5\"} %g\n", prefix, labels(slo), slo.P50.Seconds())
+			fmt.Fprintf(w, "%sfetch_duration_seconds{%s,quantile=\"0.95\"} %g\n", prefix, labels(slo), slo.P95.Seconds())
+		}
+	}
+}
+
+// metricLabel escapes a Prometheus label value
+func metricLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
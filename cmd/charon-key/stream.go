@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+
+	"github.com/dgarifullin/charon-key/internal/config"
+	"github.com/dgarifullin/charon-key/internal/errors"
+	"github.com/dgarifullin/charon-key/internal/github"
+	"github.com/dgarifullin/charon-key/internal/resolver"
+	"github.com/dgarifullin/charon-key/internal/ssh"
+)
+
+// streamKeys writes the user's keys to stdout as they're resolved: local
+// authorized_keys entries first, then GitHub keys as each GitHub user
+// resolves, then break-glass keys. Only a set of seen keys is kept.
+// Errors are logged and returned as *errors.AppError
+func (a *app) streamKeys(username string) error {
+	cfg, log := a.cfg, a.log
+	out := ssh.NewKeyWriter(os.Stdout)
+	a.sshUsers++
+	defer func() { a.keysServed += out.Count() }()
+
+	if err := a.checkAccess(username); err != nil {
+		return err
+	}
+	if err := a.checkWildcardOrg(username); err != nil {
+		return err
+	}
+
+	sshManager, err := a.sshManagerFor(username)
+	if err != nil {
+		log.Warn("failed to initialize SSH manager, streaming GitHub keys only", "error", err)
+	} else {
+		a.auditPermissions(sshManager)
+		existing, err := sshManager.ReadExistingKeys()
+		if err != nil {
+			log.Warn("failed to read existing authorized_keys, streaming GitHub keys only", "error", err)
+		}
+		existing = a.filterExisting(username, existing)
+		for _, key := range existing {
+			a.checkCanary(eventCanaryExisting, username, sshManager.GetAuthorizedKeysPath(), "", key)
+			if err := out.Write(key); err != nil {
+				return errors.NewAppError("failed to write keys", errors.ExitGeneralError, err)
+			}
+		}
+	}
+
+	keyPolicy := a.newKeyPolicy(username)
+	resolved, tooOld := 0, 0
+	err = a.resolver.StreamKeySources(username, func(key resolver.Key) error {
+		line := key.Line
+		if !a.keyAgeAllowed(username, key) {
+			tooOld++
+			return nil
+		}
+		a.checkServedKey(username, key)
+		if !keyPolicy.allow(line) {
+			return nil
+		}
+
+		if cfg.Provenance {
+			line = ssh.AnnotateKey(line, key.Provenance())
+		}
+		if config.IsDeployKeys(key.GitHubUser) {
+			line = cfg.DeployKeyOptions(username) + " " + line
+		}
+		resolved++
+		return out.Write(line)
+	})
+	a.alertPolicyRejections(username, "key age", tooOld)
+	keyPolicy.alert()
+	a.profile.mark("stream")
+	if err != nil {
+		log.Error("failed to resolve keys", "error", err, "error_kind", github.KindOf(err), "ssh_username", username)
+		a.alertResolutionFailed(username, err)
+		return errors.NewAppError("failed to resolve keys", errors.ExitNetworkError, err)
+	}
+
+	if resolved == 0 {
+		log.Warn("no keys resolved, emitting local keys only (allow-empty)", "ssh_username", username)
+	}
+
+	// Break-glass keys are always appended, after policy filtering
+	for _, key := range a.breakGlassKeys {
+		if err := out.Write(key); err != nil {
+			return errors.NewAppError("failed to write keys", errors.ExitGeneralError, err)
+		}
+	}
+
+	log.Debug("streamed keys", "ssh_username", username, "total_keys", out.Count())
+	return nil
+}
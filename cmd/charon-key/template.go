@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/dgarifullin/charon-key/internal/resolver"
+	"github.com/dgarifullin/charon-key/internal/ssh"
+)
+
+// Sources of output template keys that weren't resolved from a mapping
+const (
+	templateSourceLocal      = "local"
+	templateSourceBreakGlass = "break-glass"
+)
+
+// templateFuncs are the functions available to --output-template
+var templateFuncs = template.FuncMap{
+	"join":      func(elems []string, sep string) string { return strings.Join(elems, sep) },
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+}
+
+// templateData is the data --output-template is executed with
+type templateData struct {
+	// SSHUser is the SSH username keys are output for
+	SSHUser string
+
+	// Keys are the keys charon-key would otherwise output, in that order
+	Keys []templateKey
+}
+
+// templateKey is one key of the output, parsed and with its source
+type templateKey struct {
+	// Line is the key as charon-key would otherwise output it
+	Line string
+
+	// Options, Type, Blob and Comment are Line's fields
+	Options []string
+	Type    string
+	Blob    string
+	Comment string
+
+	Fingerprint string
+
+	// Source is "local" for keys from authorized_keys or existing key
+	// files, "break-glass" for break-glass keys, or the mapping source
+	// such as "github:alice" or "gist:aa5a315d61ae9438b18d"
+	Source string
+
+	// GitHubUser, GitHubKeyID, FetchedAt and CreatedAt are set for keys
+	// resolved from a mapping (see resolver.Key)
+	GitHubUser  string
+	GitHubKeyID string
+	FetchedAt   time.Time
+	CreatedAt   time.Time
+}
+
+// loadOutputTemplate parses the --output-template text or the
+// --output-template-file contents (nil if neither is set)
+func loadOutputTemplate(text, path string) (*template.Template, error) {
+	name := "output-template"
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read output template: %w", err)
+		}
+		text, name = string(data), path
+	}
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// renderOutputTemplate executes the output template over the merged keys,
+// telling local, break-glass and resolved keys apart by fingerprint
+func (a *app) renderOutputTemplate(username string, merged, existing []string) (string, error) {
+	local := make(map[string]bool, len(existing))
+	for _, key := range existing {
+		local[strings.TrimSpace(key)] = true
+	}
+	breakGlass := fingerprintSet(a.breakGlassKeys)
+
+	data := templateData{SSHUser: username}
+	for _, line := range merged {
+		key := templateKey{Line: line, Source: templateSourceLocal}
+		if parsed, err := ssh.ParseAuthorizedKey(line); err == nil {
+			key.Options, key.Type, key.Blob, key.Comment = parsed.Options, parsed.Type, parsed.Blob, parsed.Comment
+		}
+		key.Fingerprint, _ = ssh.Fingerprint(line)
+
+		if source, ok := a.served[key.Fingerprint]; ok && !local[line] {
+			key.Source = source.Source()
+			key.GitHubUser = source.GitHubUser
+			key.GitHubKeyID = source.GitHubKeyID
+			key.FetchedAt = source.FetchedAt
+			key.CreatedAt = source.CreatedAt
+		} else if breakGlass[key.Fingerprint] && !local[line] {
+			key.Source = templateSourceBreakGlass
+		}
+		data.Keys = append(data.Keys, key)
+	}
+
+	var out bytes.Buffer
+	if err := a.outputTemplate.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("failed to execute output template: %w", err)
+	}
+	return out.String(), nil
+}
+
+// servedSources maps the fingerprints of resolved keys to their sources
+func servedSources(sources []resolver.Key) map[string]resolver.Key {
+	served := make(map[string]resolver.Key, len(sources))
+	for _, source := range sources {
+		if fingerprint, err := ssh.Fingerprint(source.Line); err == nil {
+			served[fingerprint] = source
+		}
+	}
+	return served
+}
@@ -0,0 +1,94 @@
+package access
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultTimeout bounds a grant check, since it runs in the sshd login path
+const DefaultTimeout = 5 * time.Second
+
+// Checker asks an access-request system whether an SSH user currently holds
+// an approved just-in-time grant
+//
+// The request is a JSON POST of {"ssh_username": ..., "hostname": ...}; the
+// response is {"approved": bool, "expires_at": RFC 3339 time (optional),
+// "reason": string (optional)}. HTTP 403 and 404 mean no grant
+type Checker struct {
+	// URL is the grant check endpoint
+	URL string
+
+	// Token is sent as a bearer token (optional)
+	Token string
+
+	client *http.Client
+}
+
+// Grant is the access-request system's answer for an SSH user
+type Grant struct {
+	Approved  bool      `json:"approved"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Reason    string    `json:"reason"`
+}
+
+// Active reports whether the grant is approved and unexpired at now
+func (g Grant) Active(now time.Time) bool {
+	return g.Approved && (g.ExpiresAt.IsZero() || now.Before(g.ExpiresAt))
+}
+
+// NewChecker creates a checker for the endpoint at url
+func NewChecker(url string) *Checker {
+	return &Checker{
+		URL:    url,
+		client: &http.Client{Timeout: DefaultTimeout},
+	}
+}
+
+// Check returns the grant of the SSH user on hostname
+// An error means the system could not be asked; callers should fail closed
+func (c *Checker) Check(ctx context.Context, sshUsername, hostname string) (Grant, error) {
+	body, err := json.Marshal(map[string]string{
+		"ssh_username": sshUsername,
+		"hostname":     hostname,
+	})
+	if err != nil {
+		return Grant{}, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.URL, bytes.NewReader(body))
+	if err != nil {
+		return Grant{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "charon-key/1.0")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return Grant{}, fmt.Errorf("access request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden, http.StatusNotFound:
+		io.Copy(io.Discard, resp.Body)
+		return Grant{Reason: fmt.Sprintf("HTTP %d", resp.StatusCode)}, nil
+	default:
+		io.Copy(io.Discard, resp.Body)
+		return Grant{}, fmt.Errorf("access system returned HTTP %d", resp.StatusCode)
+	}
+
+	var grant Grant
+	if err := json.NewDecoder(resp.Body).Decode(&grant); err != nil {
+		return Grant{}, fmt.Errorf("failed to parse access response: %w", err)
+	}
+	return grant, nil
+}
@@ -0,0 +1,88 @@
+package access
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChecker_Check(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantActive bool
+		wantError  bool
+	}{
+		{
+			name:       "approved",
+			status:     http.StatusOK,
+			body:       `{"approved": true, "expires_at": "2999-01-01T00:00:00Z"}`,
+			wantActive: true,
+		},
+		{
+			name:       "approved without expiry",
+			status:     http.StatusOK,
+			body:       `{"approved": true}`,
+			wantActive: true,
+		},
+		{
+			name:   "expired",
+			status: http.StatusOK,
+			body:   `{"approved": true, "expires_at": "2000-01-01T00:00:00Z"}`,
+		},
+		{
+			name:   "not approved",
+			status: http.StatusOK,
+			body:   `{"approved": false, "reason": "pending"}`,
+		},
+		{
+			name:   "no grant",
+			status: http.StatusNotFound,
+		},
+		{
+			name:      "server error",
+			status:    http.StatusInternalServerError,
+			wantError: true,
+		},
+		{
+			name:      "invalid response",
+			status:    http.StatusOK,
+			body:      `not json`,
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var request map[string]string
+			var auth string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auth = r.Header.Get("Authorization")
+				json.NewDecoder(r.Body).Decode(&request)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			checker := NewChecker(server.URL)
+			checker.Token = "secret"
+			grant, err := checker.Check(context.Background(), "alice", "web-1")
+			if (err != nil) != tt.wantError {
+				t.Fatalf("Check() error = %v, wantError %v", err, tt.wantError)
+			}
+			if got := grant.Active(time.Now()); got != tt.wantActive {
+				t.Errorf("Active() = %v, want %v (grant %+v)", got, tt.wantActive, grant)
+			}
+			if request["ssh_username"] != "alice" || request["hostname"] != "web-1" {
+				t.Errorf("request = %v, want ssh_username alice and hostname web-1", request)
+			}
+			if auth != "Bearer secret" {
+				t.Errorf("Authorization = %q, want %q", auth, "Bearer secret")
+			}
+		})
+	}
+}
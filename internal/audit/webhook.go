@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// DefaultWebhookTimeout bounds a webhook delivery, since it may run in the
+// sshd login path
+const DefaultWebhookTimeout = 5 * time.Second
+
+// Event severities, using PagerDuty's names
+const (
+	SeverityCritical = "critical"
+	SeverityError    = "error"
+	SeverityWarning  = "warning"
+)
+
+// Event is a security- or availability-relevant occurrence reported to a
+// webhook. Text is a one-line summary, which chat webhooks such as Slack's
+// display as the message
+type Event struct {
+	// Type names the event, e.g. "canary_key_served"
+	Type     string `json:"event"`
+	Severity string `json:"severity"`
+	Text     string `json:"text"`
+
+	SSHUsername string `json:"ssh_username,omitempty"`
+	GitHubUser  string `json:"github_user,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Error       string `json:"error,omitempty"`
+
+	// Source is where the key was seen, e.g. "github:alice" or "authorized_keys"
+	Source string `json:"source,omitempty"`
+
+	// GitHubKeyID is GitHub's ID for the key object, when known
+	GitHubKeyID string `json:"github_key_id,omitempty"`
+
+	// AddedFingerprints and RemovedFingerprints are the keys that appeared
+	// and disappeared when an SSH user's keys changed
+	AddedFingerprints   []string `json:"added_fingerprints,omitempty"`
+	RemovedFingerprints []string `json:"removed_fingerprints,omitempty"`
+
+	Hostname      string    `json:"hostname"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// Webhook delivers events as JSON POST requests
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhook creates a webhook posting to url
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		url:    url,
+		client: &http.Client{Timeout: DefaultWebhookTimeout},
+	}
+}
+
+// Send posts the event; any non-2xx response is an error
+func (w *Webhook) Send(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "charon-key/1.0")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWebhook_Send(t *testing.T) {
+	var received Event
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request: %s %s", r.Method, r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("invalid event: %v", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	event := Event{
+		Type:        "canary_key_served",
+		Severity:    SeverityCritical,
+		Text:        "canary key served for deploy",
+		SSHUsername: "deploy",
+		Fingerprint: "SHA256:abc",
+		Source:      "github:alice",
+		Hostname:    "web-1",
+		Time:        time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if err := NewWebhook(server.URL).Send(context.Background(), event); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if !reflect.DeepEqual(received, event) {
+		t.Errorf("received %+v, want %+v", received, event)
+	}
+}
+
+func TestWebhook_SendError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	if err := NewWebhook(server.URL).Send(context.Background(), Event{Type: "test"}); err == nil {
+		t.Error("Send() expected error for HTTP 500")
+	}
+}
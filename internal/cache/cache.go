@@ -1,11 +1,20 @@
 package cache
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
@@ -32,17 +41,136 @@ type CacheEntry struct {
 	GitHubUser string    `json:"github_user"`
 	Keys       []string  `json:"keys"`
 	Timestamp  time.Time `json:"timestamp"`
+
+	// SSHUser is the SSH user a per-user entry was cached for (empty for
+	// entries shared by every SSH user, see ForSSHUser)
+	SSHUser string `json:"ssh_user,omitempty"`
+
+	// Metadata holds what GitHub's API reported about each key, keyed by
+	// key line; only set when keys came from the authenticated API
+	Metadata map[string]KeyMetadata `json:"metadata,omitempty"`
+
+	// Removed are keys that disappeared from the GitHub user in the last
+	// RemovedKeyRetention, for the grace period
+	Removed []RemovedKey `json:"removed,omitempty"`
+}
+
+// RemovedKeyRetention is how long removed keys are remembered, and so the
+// longest grace period
+const RemovedKeyRetention = 30 * 24 * time.Hour
+
+// RemovedKey is a key that disappeared from a GitHub user's keys
+type RemovedKey struct {
+	Key       string    `json:"key"`
+	RemovedAt time.Time `json:"removed_at"`
+}
+
+// KeyMetadata is what GitHub's API reports about one key
+type KeyMetadata struct {
+	// GitHubID is GitHub's ID for the key object
+	GitHubID string `json:"github_id,omitempty"`
+
+	// CreatedAt is when the key was added to GitHub
+	CreatedAt time.Time `json:"created_at,omitzero"`
 }
 
 // Cache represents the cache structure
 type Cache struct {
+	// Version is the schema version the file was written with (0 for files
+	// written before versioning)
+	Version int `json:"version,omitempty"`
+
 	Entries []CacheEntry `json:"entries"`
 }
 
+// SchemaVersion is the version of the cache file format written
+// Bump it on format changes and add a migration from the previous version
+const SchemaVersion = 1
+
+// migrations upgrade a cache file from the version it is keyed by to the
+// next one; files written before versioning have the version 1 format
+var migrations = map[int]func(*Cache){
+	0: func(*Cache) {},
+}
+
+// errUnknownVersion is returned for cache files of a version this release
+// can't migrate, e.g. written by a newer release; they are treated as misses
+var errUnknownVersion = errors.New("unknown cache schema version")
+
+// decodeCache decodes a cache file, migrating it to SchemaVersion
+func decodeCache(data []byte) (*Cache, error) {
+	var cache Cache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cache: %w", err)
+	}
+	version := cache.Version
+	for cache.Version != SchemaVersion {
+		migrate, ok := migrations[cache.Version]
+		if !ok {
+			return nil, fmt.Errorf("%w %d (this release reads up to %d)", errUnknownVersion, version, SchemaVersion)
+		}
+		migrate(&cache)
+		cache.Version++
+	}
+	return &cache, nil
+}
+
 // Manager handles cache operations
 type Manager struct {
 	cacheDir string
 	ttl      time.Duration
+
+	// sshUser is the SSH user whose entries this manager reads and writes
+	// (empty = the shared entries)
+	sshUser string
+
+	// corruptReads makes every cache file read as corrupt (fault injection)
+	corruptReads bool
+
+	// jitterPercent shortens each entry's TTL by up to this percentage, by a
+	// stable amount derived from jitterSeed and the GitHub user
+	jitterPercent int
+	jitterSeed    string
+
+	// snapshot is the memory-mapped snapshot file (nil if there is none),
+	// loaded on the first ReadEntry
+	snapshot     []byte
+	snapshotOnce sync.Once
+}
+
+// SetTTLJitter shortens each entry's TTL by a stable 0 to percent% derived
+// from seed (e.g. the hostname) and the GitHub user, so hosts provisioned
+// together don't all refresh the same users in the same second
+func (m *Manager) SetTTLJitter(percent int, seed string) {
+	m.jitterPercent = percent
+	m.jitterSeed = seed
+}
+
+// ttlFor returns the TTL of a GitHub user's entry, with jitter applied
+func (m *Manager) ttlFor(githubUser string) time.Duration {
+	if m.jitterPercent <= 0 {
+		return m.ttl
+	}
+	h := fnv.New32a()
+	h.Write([]byte(m.jitterSeed + "/" + githubUser))
+	// Permille resolution spreads refreshes across the jitter range
+	reduction := time.Duration(h.Sum32()%1000) * time.Duration(m.jitterPercent) * m.ttl / 100000
+	return m.ttl - reduction
+}
+
+// SetCorruptReads makes every cache file read as truncated, to rehearse
+// corrupt cache handling
+func (m *Manager) SetCorruptReads(corrupt bool) {
+	m.corruptReads = corrupt
+}
+
+// readCacheFile reads a cache file, truncated when corruptReads is set
+func (m *Manager) readCacheFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil && m.corruptReads {
+		data = data[:len(data)/2]
+	}
+	return data, err
 }
 
 // NewManager creates a new cache manager
@@ -87,11 +215,89 @@ func resolveDefaultCacheDir() (string, error) {
 	return filepath.Join(tempDir, "charon-key"), nil
 }
 
+// userCacheDir is the subdirectory holding per-SSH-user cache directories
+const userCacheDir = "users"
+
+// ForSSHUser returns a manager for the entries cached for one SSH user
+// only, in its own subdirectory of the cache directory, so its resolutions
+// are never served entries cached for another SSH user
+// The subdirectory is readable by gid (the SSH user's group; -1 = leave
+// the group alone), but only writable by charon-key
+func (m *Manager) ForSSHUser(sshUser string, gid int) (*Manager, error) {
+	if sshUser == "" {
+		return nil, fmt.Errorf("SSH username cannot be empty")
+	}
+	// The parent is traversable, so each user can reach its own directory
+	parent := filepath.Join(m.cacheDir, userCacheDir)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create SSH user cache directory: %w", err)
+	}
+	dir := filepath.Join(parent, hashedName(sshUserKind, sshUser))
+	if err := os.Mkdir(dir, 0750); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("failed to create SSH user cache directory: %w", err)
+	}
+	if gid >= 0 && os.Geteuid() == 0 {
+		if err := os.Chown(dir, -1, gid); err != nil {
+			return nil, fmt.Errorf("failed to set SSH user cache directory group: %w", err)
+		}
+	}
+	return &Manager{
+		cacheDir:      dir,
+		ttl:           m.ttl,
+		sshUser:       sshUser,
+		corruptReads:  m.corruptReads,
+		jitterPercent: m.jitterPercent,
+		jitterSeed:    m.jitterSeed,
+	}, nil
+}
+
+// Kinds of names hashed into file names, so a GitHub user and an SSH user
+// of the same name never share a file either
+const (
+	// keysKind names GitHub users and the "gist:" and "deploy-keys:"
+	// sources, whose prefixes keep them apart
+	keysKind    = "keys"
+	sshUserKind = "ssh-user"
+)
+
+// hashedName returns the file name for a name: the SHA-256 of its kind and
+// the name, so distinct names never share a file (the files record the
+// name itself, readably)
+func hashedName(kind, name string) string {
+	sum := sha256.Sum256([]byte(kind + "\x00" + name))
+	return hex.EncodeToString(sum[:])
+}
+
 // getCacheFilePath returns the cache file path for a GitHub username
 func (m *Manager) getCacheFilePath(githubUser string) string {
-	// Sanitize username for filename (basic sanitization)
-	safeName := sanitizeFilename(githubUser)
-	return filepath.Join(m.cacheDir, fmt.Sprintf("%s.json", safeName))
+	return filepath.Join(m.cacheDir, hashedName(keysKind, githubUser)+".json")
+}
+
+// legacyFilePath returns where earlier releases kept the file of a name:
+// the name sanitized, so e.g. "user@x" and "user#x" shared a file
+// Files are still read from there until rewritten under their hashed name
+func (m *Manager) legacyFilePath(name, extension string) string {
+	return filepath.Join(m.cacheDir, sanitizeFilename(name)+extension)
+}
+
+// readMigrating reads the file at path or, if it doesn't exist, at the
+// legacy path
+func (m *Manager) readMigrating(path, legacy string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return os.ReadFile(legacy)
+	}
+	return data, err
+}
+
+// readEntryFile reads a GitHub user's cache file like readCacheFile, from
+// its legacy path if it wasn't rewritten yet
+func (m *Manager) readEntryFile(githubUser string) ([]byte, error) {
+	data, err := m.readMigrating(m.getCacheFilePath(githubUser), m.legacyFilePath(githubUser, ".json"))
+	if err == nil && m.corruptReads {
+		data = data[:len(data)/2]
+	}
+	return data, err
 }
 
 // sanitizeFilename sanitizes a string for use as a filename
@@ -113,6 +319,12 @@ func sanitizeFilename(name string) string {
 
 // Write stores keys for a GitHub user in the cache
 func (m *Manager) Write(githubUser string, keys []string) error {
+	return m.WriteWithMetadata(githubUser, keys, nil)
+}
+
+// WriteWithMetadata stores keys for a GitHub user in the cache together
+// with per-key metadata from GitHub's API
+func (m *Manager) WriteWithMetadata(githubUser string, keys []string, metadata map[string]KeyMetadata) error {
 	if githubUser == "" {
 		return fmt.Errorf("GitHub username cannot be empty")
 	}
@@ -121,9 +333,13 @@ func (m *Manager) Write(githubUser string, keys []string) error {
 		GitHubUser: githubUser,
 		Keys:       keys,
 		Timestamp:  time.Now(),
+		SSHUser:    m.sshUser,
+		Metadata:   metadata,
+		Removed:    m.removedSince(githubUser, keys),
 	}
 
 	cache := Cache{
+		Version: SchemaVersion,
 		Entries: []CacheEntry{entry},
 	}
 
@@ -136,20 +352,115 @@ func (m *Manager) Write(githubUser string, keys []string) error {
 	if err := os.WriteFile(cachePath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write cache file: %w", err)
 	}
+	m.removeLegacy(githubUser, ".json")
 
 	return nil
 }
 
+// removeLegacy removes a name's legacy file once it is rewritten under its
+// hashed name, if the file is the name's and not another colliding one's
+func (m *Manager) removeLegacy(name, extension string) {
+	legacy := m.legacyFilePath(name, extension)
+	data, err := os.ReadFile(legacy)
+	if err != nil {
+		return
+	}
+	var owner struct {
+		Entries []struct {
+			GitHubUser string `json:"github_user"`
+		} `json:"entries"`
+		GitHubUser string `json:"github_user"`
+		SSHUser    string `json:"ssh_user"`
+	}
+	if json.Unmarshal(data, &owner) != nil {
+		return
+	}
+	if owner.GitHubUser == name || owner.SSHUser == name || (len(owner.Entries) == 1 && owner.Entries[0].GitHubUser == name) {
+		os.Remove(legacy)
+	}
+}
+
+// removedSince returns the keys of a GitHub user's cache entry missing
+// from keys, together with the keys removed before and not back since,
+// forgetting those removed more than RemovedKeyRetention ago
+func (m *Manager) removedSince(githubUser string, keys []string) []RemovedKey {
+	previous, _, err := m.ReadEntry(githubUser)
+	if err != nil || previous == nil {
+		return nil
+	}
+
+	// Keys are compared by key data, so a changed comment isn't a removal
+	known := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		known[keyData(key)] = true
+	}
+	now := time.Now()
+	var removed []RemovedKey
+	for _, key := range previous.Removed {
+		if !known[keyData(key.Key)] && now.Sub(key.RemovedAt) < RemovedKeyRetention {
+			known[keyData(key.Key)] = true
+			removed = append(removed, key)
+		}
+	}
+	for _, key := range previous.Keys {
+		if !known[keyData(key)] {
+			known[keyData(key)] = true
+			removed = append(removed, RemovedKey{Key: key, RemovedAt: now})
+		}
+	}
+	return removed
+}
+
+// keyData returns the base64 key data of a key line
+func keyData(key string) string {
+	if fields := strings.Fields(key); len(fields) >= 2 {
+		return fields[1]
+	}
+	return key
+}
+
+// RemovedKeys returns the keys removed from a GitHub user less than grace
+// ago, oldest first
+func (m *Manager) RemovedKeys(githubUser string, grace time.Duration) ([]RemovedKey, error) {
+	entry, _, err := m.ReadEntry(githubUser)
+	if err != nil || entry == nil {
+		return nil, err
+	}
+	var removed []RemovedKey
+	for _, key := range entry.Removed {
+		if time.Since(key.RemovedAt) < grace {
+			removed = append(removed, key)
+		}
+	}
+	return removed, nil
+}
+
 // Read retrieves keys for a GitHub user from the cache
 // Returns keys, isExpired, error
 // isExpired indicates if the cache entry exists but is expired (useful for fallback)
 func (m *Manager) Read(githubUser string) ([]string, bool, error) {
+	entry, isExpired, err := m.ReadEntry(githubUser)
+	if err != nil || entry == nil {
+		return nil, false, err
+	}
+	return entry.Keys, isExpired, nil
+}
+
+// ReadEntry retrieves the full cache entry for a GitHub user
+// Returns a nil entry on cache miss
+func (m *Manager) ReadEntry(githubUser string) (*CacheEntry, bool, error) {
 	if githubUser == "" {
 		return nil, false, fmt.Errorf("GitHub username cannot be empty")
 	}
 
 	cachePath := m.getCacheFilePath(githubUser)
-	data, err := os.ReadFile(cachePath)
+	if !m.corruptReads {
+		if entry, ok := m.snapshotEntry(githubUser, cachePath); ok {
+			return entry, time.Since(entry.Timestamp) > m.ttlFor(githubUser), nil
+		}
+	}
+
+	data, err := m.readEntryFile(githubUser)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, false, nil // Cache miss, not an error
@@ -157,19 +468,22 @@ func (m *Manager) Read(githubUser string) ([]string, bool, error) {
 		return nil, false, fmt.Errorf("failed to read cache file: %w", err)
 	}
 
-	var cache Cache
-	if err := json.Unmarshal(data, &cache); err != nil {
-		return nil, false, fmt.Errorf("failed to unmarshal cache: %w", err)
+	cache, err := decodeCache(data)
+	if errors.Is(err, errUnknownVersion) {
+		return nil, false, nil // Unreadable by this release, a cache miss
+	}
+	if err != nil {
+		return nil, false, err
 	}
 
-	// Find entry for this GitHub user
-	for _, entry := range cache.Entries {
-		if entry.GitHubUser == githubUser {
+	// Find entry for this GitHub user (and SSH user)
+	for i, entry := range cache.Entries {
+		if entry.GitHubUser == githubUser && entry.SSHUser == m.sshUser {
 			// Check if expired
 			age := time.Since(entry.Timestamp)
-			isExpired := age > m.ttl
+			isExpired := age > m.ttlFor(githubUser)
 
-			return entry.Keys, isExpired, nil
+			return &cache.Entries[i], isExpired, nil
 		}
 	}
 
@@ -183,8 +497,7 @@ func (m *Manager) IsExpired(githubUser string) (bool, error) {
 		return false, fmt.Errorf("GitHub username cannot be empty")
 	}
 
-	cachePath := m.getCacheFilePath(githubUser)
-	data, err := os.ReadFile(cachePath)
+	data, err := m.readEntryFile(githubUser)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return true, nil // Cache doesn't exist, consider it expired
@@ -192,16 +505,16 @@ func (m *Manager) IsExpired(githubUser string) (bool, error) {
 		return false, fmt.Errorf("failed to read cache file: %w", err)
 	}
 
-	var cache Cache
-	if err := json.Unmarshal(data, &cache); err != nil {
-		return true, nil // Invalid cache, consider expired
+	cache, err := decodeCache(data)
+	if err != nil {
+		return true, nil // Invalid or unknown version, consider expired
 	}
 
-	// Find entry for this GitHub user
+	// Find entry for this GitHub user (and SSH user)
 	for _, entry := range cache.Entries {
-		if entry.GitHubUser == githubUser {
+		if entry.GitHubUser == githubUser && entry.SSHUser == m.sshUser {
 			age := time.Since(entry.Timestamp)
-			return age > m.ttl, nil
+			return age > m.ttlFor(githubUser), nil
 		}
 	}
 
@@ -213,19 +526,222 @@ func (m *Manager) GetCacheDir() string {
 	return m.cacheDir
 }
 
-// Clear removes the cache entry for a GitHub user
+// lockPollInterval is how often Lock retries a held lock
+const lockPollInterval = 50 * time.Millisecond
+
+// Lock takes an exclusive per-user lock shared by all charon-key processes
+// using this cache directory, so a burst of simultaneous logins results in a
+// single upstream fetch. Waits for the lock to become free until ctx is done.
+// Returns an unlock function, or an error if the lock could not be taken.
+func (m *Manager) Lock(ctx context.Context, githubUser string) (func(), error) {
+	if githubUser == "" {
+		return nil, fmt.Errorf("GitHub username cannot be empty")
+	}
+	return lockFile(ctx, strings.TrimSuffix(m.getCacheFilePath(githubUser), ".json")+".lock")
+}
+
+// lockFile takes an exclusive flock on path, created if missing, waiting
+// for it to become free until ctx is done
+func lockFile(ctx context.Context, lockPath string) (func(), error) {
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for {
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			file.Close()
+			return nil, fmt.Errorf("failed to acquire cache lock: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			file.Close()
+			return nil, fmt.Errorf("failed to acquire cache lock: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+// rateLimitFile records until when GitHub asked us to back off
+// (the leading dot keeps it apart from sanitized user cache files)
+const rateLimitFile = ".rate-limited-until"
+
+// SetRateLimitedUntil records that GitHub should not be contacted before t,
+// so later charon-key processes honor the back-off too
+func (m *Manager) SetRateLimitedUntil(t time.Time) error {
+	path := filepath.Join(m.cacheDir, rateLimitFile)
+	if err := os.WriteFile(path, []byte(t.UTC().Format(time.RFC3339)), 0644); err != nil {
+		return fmt.Errorf("failed to write rate limit file: %w", err)
+	}
+	return nil
+}
+
+// RateLimitedUntil returns the time recorded by SetRateLimitedUntil
+// Returns the zero time if none is recorded (or it cannot be read)
+func (m *Manager) RateLimitedUntil() time.Time {
+	data, err := os.ReadFile(filepath.Join(m.cacheDir, rateLimitFile))
+	if err != nil {
+		return time.Time{}
+	}
+	until, err := time.Parse(time.RFC3339, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}
+	}
+	return until
+}
+
+// Clear removes the cache entry for a GitHub user, and those cached for
+// each SSH user
 func (m *Manager) Clear(githubUser string) error {
 	if githubUser == "" {
 		return fmt.Errorf("GitHub username cannot be empty")
 	}
 
 	cachePath := m.getCacheFilePath(githubUser)
-	if err := os.Remove(cachePath); err != nil {
-		if os.IsNotExist(err) {
-			return nil // Already removed, not an error
+	perUser, err := filepath.Glob(filepath.Join(m.cacheDir, userCacheDir, "*", filepath.Base(cachePath)))
+	if err != nil {
+		return fmt.Errorf("failed to list cache files: %w", err)
+	}
+	for _, path := range append([]string{cachePath}, perUser...) {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove cache file: %w", err)
 		}
-		return fmt.Errorf("failed to remove cache file: %w", err)
 	}
+	m.removeLegacy(githubUser, ".json")
 
 	return nil
 }
+
+// ClearAll removes every cache entry and the snapshot (lock files are left
+// alone, since a running process may hold them)
+// Returns the number of entries removed
+func (m *Manager) ClearAll() (int, error) {
+	paths, err := m.entryFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("failed to remove cache file: %w", err)
+		}
+		removed++
+	}
+	os.Remove(filepath.Join(m.cacheDir, snapshotFile))
+
+	return removed, nil
+}
+
+// Stats summarizes the cache directory's contents
+type Stats struct {
+	Entries int       `json:"entries"`
+	Expired int       `json:"expired"`
+	Invalid int       `json:"invalid"`
+	Oldest  time.Time `json:"oldest"`
+	Newest  time.Time `json:"newest"`
+}
+
+// Stats counts the cache entries, how many are expired or unreadable, and
+// the oldest and newest fetch times
+func (m *Manager) Stats() (Stats, error) {
+	var stats Stats
+	entries, invalid, err := m.readAll()
+	if err != nil {
+		return stats, err
+	}
+
+	stats.Invalid = invalid
+	for _, entry := range entries {
+		stats.Entries++
+		if time.Since(entry.Timestamp) > m.ttlFor(entry.GitHubUser) {
+			stats.Expired++
+		}
+		if stats.Oldest.IsZero() || entry.Timestamp.Before(stats.Oldest) {
+			stats.Oldest = entry.Timestamp
+		}
+		if entry.Timestamp.After(stats.Newest) {
+			stats.Newest = entry.Timestamp
+		}
+	}
+
+	return stats, nil
+}
+
+// DueForRefresh returns the GitHub users whose entries are expired or
+// within percent% of their TTL of expiring, oldest first, so they can be
+// refreshed before a login has to wait on GitHub
+// Per-SSH-user entries are left to the logins of their SSH users
+func (m *Manager) DueForRefresh(percent int) ([]string, error) {
+	entries, _, err := m.readAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var due []CacheEntry
+	for _, entry := range entries {
+		if entry.SSHUser != "" {
+			continue
+		}
+		ttl := m.ttlFor(entry.GitHubUser)
+		if time.Since(entry.Timestamp) >= ttl-ttl*time.Duration(percent)/100 {
+			due = append(due, entry)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].Timestamp.Before(due[j].Timestamp) })
+
+	users := make([]string, len(due))
+	for i, entry := range due {
+		users[i] = entry.GitHubUser
+	}
+	return users, nil
+}
+
+// entryFiles lists the cache entry files, shared and per SSH user
+func (m *Manager) entryFiles() ([]string, error) {
+	shared, err := filepath.Glob(filepath.Join(m.cacheDir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cache files: %w", err)
+	}
+	perUser, err := filepath.Glob(filepath.Join(m.cacheDir, userCacheDir, "*", "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cache files: %w", err)
+	}
+	return append(shared, perUser...), nil
+}
+
+// readAll reads every cache entry, counting unreadable files
+func (m *Manager) readAll() ([]CacheEntry, int, error) {
+	paths, err := m.entryFiles()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries []CacheEntry
+	invalid := 0
+	for _, path := range paths {
+		data, err := m.readCacheFile(path)
+		if err != nil {
+			invalid++
+			continue
+		}
+		cache, err := decodeCache(data)
+		if err != nil {
+			invalid++
+			continue
+		}
+		entries = append(entries, cache.Entries...)
+	}
+	return entries, invalid, nil
+}
@@ -1,8 +1,14 @@
 package cache
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sync"
 	"testing"
 	"time"
 )
@@ -166,6 +172,91 @@ func TestManager_CacheMiss(t *testing.T) {
 	}
 }
 
+func TestManager_TTLJitter(t *testing.T) {
+	manager, err := NewManager(t.TempDir(), 100*time.Minute)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	if got := manager.ttlFor("alice"); got != 100*time.Minute {
+		t.Errorf("ttlFor() without jitter = %s, want 100m", got)
+	}
+
+	manager.SetTTLJitter(20, "web-1")
+	distinct := make(map[time.Duration]bool)
+	for i := 0; i < 50; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		ttl := manager.ttlFor(user)
+		if ttl > 100*time.Minute || ttl < 80*time.Minute {
+			t.Errorf("ttlFor(%q) = %s, want between 80m and 100m", user, ttl)
+		}
+		if ttl != manager.ttlFor(user) {
+			t.Errorf("ttlFor(%q) is not stable", user)
+		}
+		distinct[ttl] = true
+	}
+	if len(distinct) < 10 {
+		t.Errorf("ttlFor() gave %d distinct TTLs for 50 users, want them spread", len(distinct))
+	}
+
+	// Another host expires the same user at a different time
+	other, _ := NewManager(t.TempDir(), 100*time.Minute)
+	other.SetTTLJitter(20, "web-2")
+	if other.ttlFor("user-1") == manager.ttlFor("user-1") && other.ttlFor("user-2") == manager.ttlFor("user-2") {
+		t.Error("ttlFor() is the same on different hosts")
+	}
+}
+
+func TestManager_CorruptReads(t *testing.T) {
+	manager, err := NewManager(t.TempDir(), 5*time.Minute)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	if err := manager.Write("alice", []string{"ssh-ed25519 AAAA alice"}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	manager.SetCorruptReads(true)
+	if _, _, err := manager.Read("alice"); err == nil {
+		t.Error("Read() error = nil, want a corrupt cache error")
+	}
+	if expired, _ := manager.IsExpired("alice"); !expired {
+		t.Error("IsExpired() = false, want corrupt cache treated as expired")
+	}
+
+	manager.SetCorruptReads(false)
+	if keys, _, err := manager.Read("alice"); err != nil || len(keys) != 1 {
+		t.Errorf("Read() = %v, %v, want the cached key", keys, err)
+	}
+}
+
+func TestManager_WriteWithMetadata(t *testing.T) {
+	manager, err := NewManager(t.TempDir(), 5*time.Minute)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	key := "ssh-ed25519 AAAA alice"
+	metadata := map[string]KeyMetadata{key: {GitHubID: "MDk6UHVibGljS2V5MQ=="}}
+	if err := manager.WriteWithMetadata("alice", []string{key}, metadata); err != nil {
+		t.Fatalf("WriteWithMetadata() error = %v", err)
+	}
+
+	entry, _, err := manager.ReadEntry("alice")
+	if err != nil || entry == nil {
+		t.Fatalf("ReadEntry() = %v, %v", entry, err)
+	}
+	if got := entry.Metadata[key].GitHubID; got != "MDk6UHVibGljS2V5MQ==" {
+		t.Errorf("ReadEntry() key ID = %q, want MDk6UHVibGljS2V5MQ==", got)
+	}
+
+	// Plain writes carry no metadata
+	manager.Write("alice", []string{key})
+	if entry, _, _ := manager.ReadEntry("alice"); entry.Metadata != nil {
+		t.Errorf("ReadEntry() metadata = %v after Write(), want nil", entry.Metadata)
+	}
+}
+
 func TestManager_Clear(t *testing.T) {
 	cacheDir := "/tmp/test-charon-key-clear"
 	defer os.RemoveAll(cacheDir)
@@ -326,3 +417,551 @@ func TestManager_EmptyCacheDir(t *testing.T) {
 		defer os.RemoveAll(cacheDir)
 	}
 }
+
+func TestManager_Lock(t *testing.T) {
+	manager, err := NewManager(t.TempDir(), 5*time.Minute)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	unlock, err := manager.Lock(context.Background(), "testuser")
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+
+	// A second holder must wait, and gives up once its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := manager.Lock(ctx, "testuser"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Lock() while lock was held error = %v, want deadline exceeded", err)
+	}
+
+	// Other users are unaffected
+	unlockOther, err := manager.Lock(ctx, "otheruser")
+	if err != nil {
+		t.Errorf("Lock() for another user error = %v", err)
+	} else {
+		unlockOther()
+	}
+
+	// Waiters acquire the lock once it's released
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		unlock()
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	unlock2, err := manager.Lock(ctx, "testuser")
+	if err != nil {
+		t.Fatalf("Lock() after release error = %v", err)
+	}
+	unlock2()
+}
+
+func TestManager_RateLimitedUntil(t *testing.T) {
+	manager, err := NewManager(t.TempDir(), 5*time.Minute)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	if until := manager.RateLimitedUntil(); !until.IsZero() {
+		t.Errorf("RateLimitedUntil() = %v, want zero time", until)
+	}
+
+	want := time.Now().Add(time.Minute).Truncate(time.Second)
+	if err := manager.SetRateLimitedUntil(want); err != nil {
+		t.Fatalf("SetRateLimitedUntil() error = %v", err)
+	}
+	if until := manager.RateLimitedUntil(); !until.Equal(want) {
+		t.Errorf("RateLimitedUntil() = %v, want %v", until, want)
+	}
+}
+
+func TestManager_ClearAll(t *testing.T) {
+	manager, err := NewManager(t.TempDir(), 5*time.Minute)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	keys := []string{"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB test@example.com"}
+	for _, user := range []string{"user1", "user2"} {
+		if err := manager.Write(user, keys); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	unlock, err := manager.Lock(context.Background(), "user1")
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+	defer unlock()
+
+	removed, err := manager.ClearAll()
+	if err != nil {
+		t.Fatalf("ClearAll() error = %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("ClearAll() removed %d entries, want 2", removed)
+	}
+	for _, user := range []string{"user1", "user2"} {
+		if readKeys, _, _ := manager.Read(user); readKeys != nil {
+			t.Errorf("Read(%q) returned keys after ClearAll()", user)
+		}
+	}
+}
+
+func TestManager_Stats(t *testing.T) {
+	dir := t.TempDir()
+	manager, err := NewManager(dir, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	keys := []string{"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB test@example.com"}
+	for _, user := range []string{"user1", "user2"} {
+		if err := manager.Write(user, keys); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0600); err != nil {
+		t.Fatalf("Failed to write cache file: %v", err)
+	}
+
+	stats, err := manager.Stats()
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.Entries != 2 || stats.Expired != 0 || stats.Invalid != 1 {
+		t.Errorf("Stats() = %+v, want 2 entries, 0 expired, 1 invalid", stats)
+	}
+	if stats.Oldest.IsZero() || stats.Newest.Before(stats.Oldest) {
+		t.Errorf("Stats() oldest = %v, newest = %v", stats.Oldest, stats.Newest)
+	}
+}
+
+func TestManager_DueForRefresh(t *testing.T) {
+	manager, err := NewManager(t.TempDir(), 10*time.Minute)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	// Entries fetched 1, 9 and 20 minutes ago
+	writeEntry := func(user string, age time.Duration) {
+		data, _ := json.Marshal(Cache{Entries: []CacheEntry{
+			{GitHubUser: user, Keys: []string{"ssh-ed25519 AAAA " + user}, Timestamp: time.Now().Add(-age)},
+		}})
+		if err := os.WriteFile(manager.getCacheFilePath(user), data, 0600); err != nil {
+			t.Fatalf("Failed to write cache file: %v", err)
+		}
+	}
+	writeEntry("fresh", time.Minute)
+	writeEntry("expiring", 9*time.Minute)
+	writeEntry("expired", 20*time.Minute)
+
+	tests := []struct {
+		percent int
+		want    []string
+	}{
+		{percent: 0, want: []string{"expired"}},
+		{percent: 20, want: []string{"expired", "expiring"}},
+		{percent: 100, want: []string{"expired", "expiring", "fresh"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d%%", tt.percent), func(t *testing.T) {
+			got, err := manager.DueForRefresh(tt.percent)
+			if err != nil {
+				t.Fatalf("DueForRefresh() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DueForRefresh() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_Snapshot(t *testing.T) {
+	dir := t.TempDir()
+	writer, err := NewManager(dir, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+
+	created := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	aliceKeys := []string{"ssh-ed25519 AAAA alice@laptop", "ssh-rsa BBBB alice@desktop"}
+	writer.WriteWithMetadata("alice", aliceKeys, map[string]KeyMetadata{
+		aliceKeys[0]: {GitHubID: "101", CreatedAt: created},
+	})
+	writer.Write("bob", []string{"ssh-ed25519 CCCC bob"})
+	writer.Write("carol", nil)
+
+	written, err := writer.WriteSnapshot()
+	if err != nil || written != 3 {
+		t.Fatalf("WriteSnapshot() = %d, %v, want 3 entries", written, err)
+	}
+
+	// Make the JSON files unreadable without changing their mtime, so
+	// entries can only come from the snapshot
+	for _, user := range []string{"alice", "bob"} {
+		path := writer.getCacheFilePath(user)
+		info, _ := os.Stat(path)
+		os.WriteFile(path, []byte("{"), 0644)
+		os.Chtimes(path, info.ModTime(), info.ModTime())
+	}
+
+	reader, _ := NewManager(dir, 5*time.Minute)
+	entry, expired, err := reader.ReadEntry("alice")
+	if err != nil || entry == nil || expired {
+		t.Fatalf("ReadEntry(alice) = %v, %v, %v, want a fresh entry from the snapshot", entry, expired, err)
+	}
+	if !reflect.DeepEqual(entry.Keys, aliceKeys) {
+		t.Errorf("ReadEntry(alice) keys = %v, want %v", entry.Keys, aliceKeys)
+	}
+	if got := entry.Metadata[aliceKeys[0]]; got.GitHubID != "101" || !got.CreatedAt.Equal(created) {
+		t.Errorf("ReadEntry(alice) metadata = %+v, want ID 101 created %v", got, created)
+	}
+	if _, ok := entry.Metadata[aliceKeys[1]]; ok {
+		t.Errorf("ReadEntry(alice) has metadata for a key without any")
+	}
+	if entry, _, err := reader.ReadEntry("carol"); err != nil || entry == nil || len(entry.Keys) != 0 {
+		t.Errorf("ReadEntry(carol) = %v, %v, want an entry without keys", entry, err)
+	}
+
+	// Entries written after the snapshot are read from their JSON file
+	reader.Write("bob", []string{"ssh-ed25519 DDDD bob"})
+	entry, _, err = reader.ReadEntry("bob")
+	if err != nil || entry == nil || !reflect.DeepEqual(entry.Keys, []string{"ssh-ed25519 DDDD bob"}) {
+		t.Errorf("ReadEntry(bob) = %v, %v, want the keys written after the snapshot", entry, err)
+	}
+
+	// Cleared entries are misses
+	reader.Clear("alice")
+	if entry, _, err := reader.ReadEntry("alice"); err != nil || entry != nil {
+		t.Errorf("ReadEntry(alice) = %v, %v after Clear(), want a miss", entry, err)
+	}
+
+	if entry, _, err := reader.ReadEntry("dave"); err != nil || entry != nil {
+		t.Errorf("ReadEntry(dave) = %v, %v, want a miss", entry, err)
+	}
+}
+
+func TestManager_RecordFetch(t *testing.T) {
+	manager, _ := NewManager(t.TempDir(), 5*time.Minute)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < sloWindow+5; i++ {
+		record := FetchRecord{At: start.Add(time.Duration(i) * time.Minute), Duration: time.Duration(i) * time.Millisecond, OK: i%10 != 0}
+		if !record.OK {
+			record.ErrorKind = "timeout"
+		}
+		if err := manager.RecordFetch("alice", record); err != nil {
+			t.Fatalf("RecordFetch() error = %v", err)
+		}
+	}
+	manager.RecordFetch("gist:aa5a315d61ae9438b18d", FetchRecord{At: start, Duration: time.Second, OK: true})
+
+	// History files are not cache entries
+	if stats, _ := manager.Stats(); stats.Entries != 0 || stats.Invalid != 0 {
+		t.Errorf("Stats() = %+v, want no entries", stats)
+	}
+
+	histories, err := manager.FetchHistories()
+	if err != nil || len(histories) != 2 {
+		t.Fatalf("FetchHistories() = %v, %v, want 2 histories", histories, err)
+	}
+	alice := histories[0]
+	if alice.GitHubUser != "alice" || len(alice.Fetches) != sloWindow || alice.Fetches[0].Duration != 5*time.Millisecond {
+		t.Errorf("alice's history = %d fetches from %v, want the last %d", len(alice.Fetches), alice.Fetches[0].Duration, sloWindow)
+	}
+
+	slo := Summarize("alice", alice.Fetches)
+	want := SLO{
+		Name: "alice", Fetches: 50, Failures: 5, SuccessRate: 0.9,
+		P50: 29 * time.Millisecond, P95: 51 * time.Millisecond,
+		LastErrorKind: "timeout", LastFailure: start.Add(50 * time.Minute),
+	}
+	if !reflect.DeepEqual(slo, want) {
+		t.Errorf("Summarize() = %+v, want %+v", slo, want)
+	}
+
+	providers := ProviderSLOs(histories)
+	if len(providers) != 2 || providers[0].Name != "gist" || providers[0].Fetches != 1 || providers[1].Name != "github" || providers[1].Fetches != 50 {
+		t.Errorf("ProviderSLOs() = %+v, want gist and github", providers)
+	}
+}
+
+func TestManager_Keysets(t *testing.T) {
+	manager, _ := NewManager(t.TempDir(), 5*time.Minute)
+	v1 := []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIold alice@example.com"}
+	v2 := []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAInew alice@example.com"}
+
+	first, added, err := manager.RecordKeyset("alice", v1)
+	if err != nil || !added {
+		t.Fatalf("RecordKeyset() = %v, %v, want a new keyset", added, err)
+	}
+	if _, added, _ := manager.RecordKeyset("alice", v1); added {
+		t.Error("RecordKeyset() recorded an unchanged keyset again")
+	}
+	annotated := []string{v1[0] + " (via github:alice, fetched 2026-10-15)"}
+	if _, added, _ := manager.RecordKeyset("alice", annotated); added {
+		t.Error("RecordKeyset() recorded a keyset whose provenance annotation changed")
+	}
+	restricted := []string{`command="git-shell" ` + v1[0]}
+	if KeysetID(restricted) == first.ID {
+		t.Error("KeysetID() ignores key options")
+	}
+	manager.RecordKeyset("alice", v2)
+
+	history, err := manager.Keysets("alice")
+	if err != nil || len(history.Keysets) != 2 || history.Keysets[0].ID != first.ID || history.Keysets[0].ID != KeysetID(v1) {
+		t.Fatalf("Keysets() = %+v, %v, want v1 then v2", history, err)
+	}
+	if latest := history.Latest(); latest == nil || latest.ID != KeysetID(v2) {
+		t.Errorf("Latest() = %+v, want v2", latest)
+	}
+	if latest := (KeysetHistory{}).Latest(); latest != nil {
+		t.Errorf("Latest() of an empty history = %+v, want nil", latest)
+	}
+
+	// Roll back to v1 by ID prefix
+	if _, err := manager.PinKeyset("alice", "zzzz"); err == nil {
+		t.Error("PinKeyset() of an unknown keyset error = nil")
+	}
+	if _, err := manager.PinKeyset("alice", first.ID[:6]); err != nil {
+		t.Fatalf("PinKeyset() error = %v", err)
+	}
+
+	// The pinned keyset outlives the window
+	for i := 0; i < keysetWindow+2; i++ {
+		manager.RecordKeyset("alice", []string{fmt.Sprintf("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI%d", i)})
+	}
+	pinned, err := manager.PinnedKeyset("alice")
+	if err != nil || pinned == nil || pinned.Keys[0] != v1[0] {
+		t.Fatalf("PinnedKeyset() = %+v, %v, want v1", pinned, err)
+	}
+	if history, _ := manager.Keysets("alice"); len(history.Keysets) != keysetWindow+1 {
+		t.Errorf("Keysets() kept %d keysets, want %d", len(history.Keysets), keysetWindow+1)
+	}
+
+	if err := manager.UnpinKeyset("alice"); err != nil {
+		t.Fatalf("UnpinKeyset() error = %v", err)
+	}
+	if pinned, err := manager.PinnedKeyset("alice"); pinned != nil || err != nil {
+		t.Errorf("PinnedKeyset() after UnpinKeyset() = %+v, %v, want nil", pinned, err)
+	}
+
+	// An unreadable history is left alone, keeping its pin
+	manager.PinKeyset("alice", first.ID)
+	path := manager.getKeysetFilePath("alice")
+	data, _ := os.ReadFile(path)
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := manager.RecordKeyset("alice", v2); err == nil {
+		t.Error("RecordKeyset() over an unparseable history error = nil")
+	}
+	if got, _ := os.ReadFile(path); string(got) != "{" {
+		t.Errorf("RecordKeyset() overwrote an unparseable history with %s", got)
+	}
+	os.WriteFile(path, data, 0644)
+	manager.UnpinKeyset("alice")
+
+	// Keyset histories are not cache entries
+	if stats, _ := manager.Stats(); stats.Entries != 0 || stats.Invalid != 0 {
+		t.Errorf("Stats() = %+v, want no entries", stats)
+	}
+}
+
+func TestManager_RecordKeysetConcurrent(t *testing.T) {
+	manager, _ := NewManager(t.TempDir(), 5*time.Minute)
+	if _, _, err := manager.RecordKeyset("alice", []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIpinned"}); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := manager.Keysets("alice")
+	if _, err := manager.PinKeyset("alice", first.Keysets[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simultaneous logins each record their keyset, and keep the pin
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, _, err := manager.RecordKeyset("alice", []string{fmt.Sprintf("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI%d", i)}); err != nil {
+				t.Errorf("RecordKeyset() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	history, err := manager.Keysets("alice")
+	if err != nil || len(history.Keysets) != 11 || history.Pinned != first.Keysets[0].ID {
+		t.Errorf("Keysets() = %d keysets pinned to %q, %v, want 11 pinned to %q", len(history.Keysets), history.Pinned, err, first.Keysets[0].ID)
+	}
+}
+
+func TestManager_RemovedKeys(t *testing.T) {
+	manager, _ := NewManager(t.TempDir(), 5*time.Minute)
+	oldKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIold alice@example.com"
+	newKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAInew alice@example.com"
+
+	manager.Write("alice", []string{oldKey})
+	manager.Write("alice", []string{newKey})
+	removed, err := manager.RemovedKeys("alice", time.Hour)
+	if err != nil || len(removed) != 1 || removed[0].Key != oldKey {
+		t.Fatalf("RemovedKeys() = %+v, %v, want the old key", removed, err)
+	}
+	if removed, _ := manager.RemovedKeys("alice", 0); len(removed) != 0 {
+		t.Errorf("RemovedKeys() without a grace period = %+v, want none", removed)
+	}
+
+	// Removals are remembered across writes, until the key comes back
+	manager.Write("alice", []string{newKey})
+	removedAt := removed[0].RemovedAt
+	if removed, _ := manager.RemovedKeys("alice", time.Hour); len(removed) != 1 || !removed[0].RemovedAt.Equal(removedAt) {
+		t.Errorf("RemovedKeys() after another write = %+v, want the old key", removed)
+	}
+	manager.Write("alice", []string{newKey, "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIold alice-laptop"})
+	if removed, _ := manager.RemovedKeys("alice", time.Hour); len(removed) != 0 {
+		t.Errorf("RemovedKeys() after the key came back = %+v, want none", removed)
+	}
+}
+
+func TestManager_ForSSHUser(t *testing.T) {
+	tmpDir := t.TempDir()
+	manager, _ := NewManager(tmpDir, time.Hour)
+	key := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"
+
+	alice, err := manager.ForSSHUser("alice", -1)
+	if err != nil {
+		t.Fatalf("ForSSHUser() error = %v", err)
+	}
+	if info, err := os.Stat(alice.GetCacheDir()); err != nil || info.Mode().Perm() != 0750 {
+		t.Fatalf("SSH user cache directory = %v, %v, want mode 0750", info, err)
+	}
+	if err := alice.Write("octocat", []string{key}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	if keys, _, _ := alice.Read("octocat"); len(keys) != 1 {
+		t.Errorf("Read() = %v, want the SSH user's entry", keys)
+	}
+	if keys, _, _ := manager.Read("octocat"); keys != nil {
+		t.Errorf("shared Read() = %v, want a miss", keys)
+	}
+	// An entry cached for another SSH user is a miss, even in its directory
+	other, _ := manager.ForSSHUser("bob", -1)
+	os.Rename(alice.getCacheFilePath("octocat"), other.getCacheFilePath("octocat"))
+	if keys, _, _ := other.Read("octocat"); keys != nil {
+		t.Errorf("Read() by another SSH user = %v, want a miss", keys)
+	}
+
+	if stats, _ := manager.Stats(); stats.Entries != 1 {
+		t.Errorf("Stats().Entries = %d, want the per-user entry counted", stats.Entries)
+	}
+	if due, _ := manager.DueForRefresh(100); len(due) != 0 {
+		t.Errorf("DueForRefresh() = %v, want per-user entries left out", due)
+	}
+	if err := manager.Clear("octocat"); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	if stats, _ := manager.Stats(); stats.Entries != 0 {
+		t.Errorf("Stats().Entries after Clear() = %d, want 0", stats.Entries)
+	}
+}
+
+func TestManager_HashedFileNames(t *testing.T) {
+	tmpDir := t.TempDir()
+	manager, _ := NewManager(tmpDir, time.Hour)
+
+	// Names that sanitized to the same file no longer collide
+	if err := manager.Write("user@x", []string{"ssh-ed25519 AAAA at"}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := manager.Write("user#x", []string{"ssh-ed25519 BBBB hash"}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	for user, want := range map[string]string{"user@x": "ssh-ed25519 AAAA at", "user#x": "ssh-ed25519 BBBB hash"} {
+		if keys, _, _ := manager.Read(user); !reflect.DeepEqual(keys, []string{want}) {
+			t.Errorf("Read(%q) = %v, want [%s]", user, keys, want)
+		}
+	}
+	if manager.getCacheFilePath("alice") == manager.getKeysetFilePath("alice") {
+		t.Error("GitHub user and SSH user alice share a file")
+	}
+
+	// Legacy files are read until rewritten under their hashed name
+	legacy := filepath.Join(tmpDir, "octo_cat.json")
+	data, _ := json.Marshal(Cache{Entries: []CacheEntry{{GitHubUser: "octo.cat", Keys: []string{"ssh-ed25519 CCCC old"}, Timestamp: time.Now()}}})
+	if err := os.WriteFile(legacy, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _, _ := manager.Read("octo-cat"); keys != nil {
+		t.Errorf("Read() of another user's legacy file = %v, want a miss", keys)
+	}
+	if keys, _, _ := manager.Read("octo.cat"); len(keys) != 1 {
+		t.Fatalf("Read() of a legacy file = %v, want its key", keys)
+	}
+	if err := manager.Write("octo-cat", []string{"ssh-ed25519 DDDD new"}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := os.Stat(legacy); err != nil {
+		t.Error("Write() removed another user's legacy file")
+	}
+	if err := manager.Write("octo.cat", []string{"ssh-ed25519 EEEE new"}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("legacy file after rewrite: %v, want it removed", err)
+	}
+}
+
+func TestManager_SchemaVersion(t *testing.T) {
+	tmpDir := t.TempDir()
+	manager, _ := NewManager(tmpDir, time.Hour)
+
+	if err := manager.Write("alice", []string{"ssh-ed25519 AAAA alice"}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	data, _ := os.ReadFile(manager.getCacheFilePath("alice"))
+	var written Cache
+	if err := json.Unmarshal(data, &written); err != nil || written.Version != SchemaVersion {
+		t.Errorf("written version = %d, %v, want %d", written.Version, err, SchemaVersion)
+	}
+
+	tests := []struct {
+		name    string
+		version int
+		wantHit bool
+	}{
+		{name: "unversioned", version: 0, wantHit: true},
+		{name: "current", version: SchemaVersion, wantHit: true},
+		{name: "newer", version: SchemaVersion + 1, wantHit: false},
+		{name: "negative", version: -1, wantHit: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, _ := json.Marshal(Cache{Version: tt.version, Entries: []CacheEntry{
+				{GitHubUser: "bob", Keys: []string{"ssh-ed25519 BBBB bob"}, Timestamp: time.Now()},
+			}})
+			if err := os.WriteFile(manager.getCacheFilePath("bob"), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			entry, _, err := manager.ReadEntry("bob")
+			if err != nil {
+				t.Fatalf("ReadEntry() error = %v", err)
+			}
+			if (entry != nil) != tt.wantHit {
+				t.Errorf("ReadEntry() = %v, want hit %v", entry, tt.wantHit)
+			}
+			if expired, _ := manager.IsExpired("bob"); expired == tt.wantHit {
+				t.Errorf("IsExpired() = %v, want %v", expired, !tt.wantHit)
+			}
+			if stats, _ := manager.Stats(); (stats.Invalid == 0) != tt.wantHit {
+				t.Errorf("Stats().Invalid = %d, want unknown versions counted", stats.Invalid)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dgarifullin/charon-key/internal/ssh"
+)
+
+// keysetWindow is the number of distinct keysets kept per SSH user
+const keysetWindow = 20
+
+// keysetLockTimeout bounds the wait for another charon-key process updating
+// the same keyset history
+const keysetLockTimeout = 5 * time.Second
+
+// keysetExtension is the extension of keyset history files, kept apart from
+// the *.json cache entries
+const keysetExtension = ".keysets"
+
+// Keyset is the set of keys resolved for an SSH user at some point
+type Keyset struct {
+	// ID is a hash of the keys, identifying the keyset
+	ID string `json:"id"`
+
+	// At is when the keyset was first resolved
+	At time.Time `json:"at"`
+
+	Keys []string `json:"keys"`
+}
+
+// KeysetHistory is an SSH user's recent keysets, oldest first, and the
+// keyset it is rolled back to, if any
+type KeysetHistory struct {
+	SSHUser string   `json:"ssh_user"`
+	Keysets []Keyset `json:"keysets"`
+
+	// Pinned is the ID of the keyset sync mode writes instead of the
+	// resolved keys (empty = none)
+	Pinned string `json:"pinned,omitempty"`
+}
+
+// Latest returns the most recently recorded keyset (nil if none)
+func (h KeysetHistory) Latest() *Keyset {
+	if len(h.Keysets) == 0 {
+		return nil
+	}
+	return &h.Keysets[len(h.Keysets)-1]
+}
+
+// KeysetID returns the ID of a list of keys: the first 12 hex digits of
+// the SHA-256 of the keys, one per line, without their comments, so keys
+// only annotated differently (e.g. with --provenance fetch dates) keep
+// their keyset; options are kept, as they restrict the key
+func KeysetID(keys []string) string {
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = strings.TrimSpace(key)
+		if parsed, err := ssh.ParseAuthorizedKey(key); err == nil {
+			parsed.Comment = ""
+			lines[i] = parsed.String()
+		}
+	}
+	sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// getKeysetFilePath returns the keyset history path for an SSH username
+func (m *Manager) getKeysetFilePath(sshUser string) string {
+	return filepath.Join(m.cacheDir, hashedName(sshUserKind, sshUser)+keysetExtension)
+}
+
+// Keysets reads an SSH user's keyset history (empty if none was recorded)
+func (m *Manager) Keysets(sshUser string) (KeysetHistory, error) {
+	history := KeysetHistory{SSHUser: sshUser}
+	data, err := m.readMigrating(m.getKeysetFilePath(sshUser), m.legacyFilePath(sshUser, keysetExtension))
+	if os.IsNotExist(err) {
+		return history, nil
+	}
+	if err != nil {
+		return history, fmt.Errorf("failed to read keyset history: %w", err)
+	}
+	var existing KeysetHistory
+	if err := json.Unmarshal(data, &existing); err != nil {
+		return history, fmt.Errorf("failed to parse keyset history: %w", err)
+	}
+	if existing.SSHUser != sshUser {
+		return history, nil // Another SSH user's legacy file, with the same sanitized name
+	}
+	return existing, nil
+}
+
+// lockKeysets takes the lock serializing updates of an SSH user's keyset
+// history across charon-key processes, as logins record keysets while
+// rollback pins them
+func (m *Manager) lockKeysets(sshUser string) (func(), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), keysetLockTimeout)
+	defer cancel()
+	return lockFile(ctx, m.getKeysetFilePath(sshUser)+".lock")
+}
+
+// RecordKeyset appends the keys resolved for an SSH user to its history,
+// unless they're the latest keyset already, keeping the last keysetWindow
+// keysets (and the pinned one)
+// Returns the keyset and whether it was new. A history that can't be read
+// is left alone rather than started over, which would drop its pin
+func (m *Manager) RecordKeyset(sshUser string, keys []string) (Keyset, bool, error) {
+	if sshUser == "" {
+		return Keyset{}, false, fmt.Errorf("SSH username cannot be empty")
+	}
+	unlock, err := m.lockKeysets(sshUser)
+	if err != nil {
+		return Keyset{}, false, err
+	}
+	defer unlock()
+
+	history, err := m.Keysets(sshUser)
+	if err != nil {
+		return Keyset{}, false, err
+	}
+	id := KeysetID(keys)
+	if n := len(history.Keysets); n > 0 && history.Keysets[n-1].ID == id {
+		return history.Keysets[n-1], false, nil
+	}
+
+	keyset := Keyset{ID: id, At: time.Now().UTC(), Keys: keys}
+	history.Keysets = append(history.Keysets, keyset)
+	if drop := len(history.Keysets) - keysetWindow; drop > 0 {
+		var kept []Keyset
+		for i, k := range history.Keysets {
+			if i >= drop || k.ID == history.Pinned {
+				kept = append(kept, k)
+			}
+		}
+		history.Keysets = kept
+	}
+	return keyset, true, m.writeKeysets(history)
+}
+
+// FindKeyset returns the keyset of an SSH user whose ID starts with id
+// (at least 4 hex digits), the latest if several match
+func (m *Manager) FindKeyset(sshUser, id string) (Keyset, error) {
+	if len(id) < 4 {
+		return Keyset{}, fmt.Errorf("keyset ID %q is too short, give at least 4 characters", id)
+	}
+	history, err := m.Keysets(sshUser)
+	if err != nil {
+		return Keyset{}, err
+	}
+	for i := len(history.Keysets) - 1; i >= 0; i-- {
+		if strings.HasPrefix(history.Keysets[i].ID, id) {
+			return history.Keysets[i], nil
+		}
+	}
+	return Keyset{}, fmt.Errorf("no keyset %q recorded for SSH user %q", id, sshUser)
+}
+
+// PinKeyset makes sync mode write the given keyset for an SSH user instead
+// of the resolved keys, until UnpinKeyset
+func (m *Manager) PinKeyset(sshUser, id string) (Keyset, error) {
+	unlock, err := m.lockKeysets(sshUser)
+	if err != nil {
+		return Keyset{}, err
+	}
+	defer unlock()
+
+	keyset, err := m.FindKeyset(sshUser, id)
+	if err != nil {
+		return Keyset{}, err
+	}
+	history, err := m.Keysets(sshUser)
+	if err != nil {
+		return Keyset{}, err
+	}
+	history.Pinned = keyset.ID
+	return keyset, m.writeKeysets(history)
+}
+
+// UnpinKeyset returns an SSH user to its resolved keys
+func (m *Manager) UnpinKeyset(sshUser string) error {
+	unlock, err := m.lockKeysets(sshUser)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	history, err := m.Keysets(sshUser)
+	if err != nil {
+		return err
+	}
+	if history.Pinned == "" {
+		return nil
+	}
+	history.Pinned = ""
+	return m.writeKeysets(history)
+}
+
+// PinnedKeyset returns the keyset an SSH user is pinned to (nil if none)
+func (m *Manager) PinnedKeyset(sshUser string) (*Keyset, error) {
+	history, err := m.Keysets(sshUser)
+	if err != nil || history.Pinned == "" {
+		return nil, err
+	}
+	for _, keyset := range history.Keysets {
+		if keyset.ID == history.Pinned {
+			return &keyset, nil
+		}
+	}
+	return nil, fmt.Errorf("pinned keyset %q of SSH user %q is missing", history.Pinned, sshUser)
+}
+
+// writeKeysets writes an SSH user's keyset history
+func (m *Manager) writeKeysets(history KeysetHistory) error {
+	data, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal keyset history: %w", err)
+	}
+	// Write and rename, so concurrent readers never see a partial file
+	path := m.getKeysetFilePath(history.SSHUser)
+	tmp := fmt.Sprintf("%s.tmp-%d", path, os.Getpid())
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write keyset history: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write keyset history: %w", err)
+	}
+	m.removeLegacy(history.SSHUser, keysetExtension)
+	return nil
+}
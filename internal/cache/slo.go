@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sloWindow is the number of recent fetches kept per GitHub user
+const sloWindow = 50
+
+// sloExtension is the extension of fetch history files, kept apart from
+// the *.json cache entries
+const sloExtension = ".slo"
+
+// FetchRecord is the outcome of one fetch of a GitHub user's keys
+type FetchRecord struct {
+	At       time.Time     `json:"at"`
+	Duration time.Duration `json:"duration"`
+	OK       bool          `json:"ok"`
+
+	// ErrorKind is the category of a failed fetch (see github.KindOf)
+	ErrorKind string `json:"error_kind,omitempty"`
+}
+
+// FetchHistory is a GitHub user's recent fetches, oldest first
+type FetchHistory struct {
+	GitHubUser string        `json:"github_user"`
+	Fetches    []FetchRecord `json:"fetches"`
+}
+
+// SLO summarizes the recent fetches of a GitHub user or provider
+type SLO struct {
+	Name        string
+	Fetches     int
+	Failures    int
+	SuccessRate float64
+
+	// P50 and P95 are the median and 95th percentile fetch durations
+	P50 time.Duration
+	P95 time.Duration
+
+	// LastErrorKind and LastFailure describe the latest failed fetch
+	LastErrorKind string
+	LastFailure   time.Time
+}
+
+// getHistoryFilePath returns the fetch history path for a GitHub username
+func (m *Manager) getHistoryFilePath(githubUser string) string {
+	return filepath.Join(m.cacheDir, hashedName(keysKind, githubUser)+sloExtension)
+}
+
+// RecordFetch appends a fetch outcome to the GitHub user's history, keeping
+// the last sloWindow fetches
+func (m *Manager) RecordFetch(githubUser string, record FetchRecord) error {
+	if githubUser == "" {
+		return fmt.Errorf("GitHub username cannot be empty")
+	}
+
+	path := m.getHistoryFilePath(githubUser)
+	history := FetchHistory{GitHubUser: githubUser}
+	if data, err := m.readMigrating(path, m.legacyFilePath(githubUser, sloExtension)); err == nil {
+		var existing FetchHistory
+		if json.Unmarshal(data, &existing) == nil && existing.GitHubUser == githubUser {
+			history.Fetches = existing.Fetches
+		}
+	}
+	history.Fetches = append(history.Fetches, record)
+	if len(history.Fetches) > sloWindow {
+		history.Fetches = history.Fetches[len(history.Fetches)-sloWindow:]
+	}
+
+	data, err := json.Marshal(history)
+	if err != nil {
+		return fmt.Errorf("failed to marshal fetch history: %w", err)
+	}
+	// Write and rename, so concurrent readers never see a partial file
+	tmp := fmt.Sprintf("%s.tmp-%d", path, os.Getpid())
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write fetch history: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write fetch history: %w", err)
+	}
+	m.removeLegacy(githubUser, sloExtension)
+	return nil
+}
+
+// FetchHistories reads the fetch history of every GitHub user, sorted by
+// user; unreadable files are skipped
+func (m *Manager) FetchHistories() ([]FetchHistory, error) {
+	paths, err := filepath.Glob(filepath.Join(m.cacheDir, "*"+sloExtension))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list fetch histories: %w", err)
+	}
+
+	var histories []FetchHistory
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var history FetchHistory
+		if json.Unmarshal(data, &history) != nil || history.GitHubUser == "" {
+			continue
+		}
+		histories = append(histories, history)
+	}
+	sort.Slice(histories, func(i, j int) bool { return histories[i].GitHubUser < histories[j].GitHubUser })
+	return histories, nil
+}
+
+// Provider returns the key source a cache entry name belongs to: "gist",
+// "deploy-keys" or "github" (the per-user keys endpoint)
+func Provider(githubUser string) string {
+	if prefix, _, ok := strings.Cut(githubUser, ":"); ok {
+		return prefix
+	}
+	return "github"
+}
+
+// Summarize computes the success rate, median and 95th percentile latency
+// and latest failure of fetch records
+func Summarize(name string, records []FetchRecord) SLO {
+	slo := SLO{Name: name, Fetches: len(records)}
+	if len(records) == 0 {
+		return slo
+	}
+
+	durations := make([]time.Duration, len(records))
+	for i, record := range records {
+		durations[i] = record.Duration
+		if !record.OK {
+			slo.Failures++
+			if !record.At.Before(slo.LastFailure) {
+				slo.LastFailure, slo.LastErrorKind = record.At, record.ErrorKind
+			}
+		}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+
+	slo.SuccessRate = float64(len(records)-slo.Failures) / float64(len(records))
+	slo.P50 = durations[(len(durations)-1)*50/100]
+	slo.P95 = durations[(len(durations)-1)*95/100]
+	return slo
+}
+
+// ProviderSLOs summarizes fetch histories per provider (see Provider),
+// sorted by provider
+func ProviderSLOs(histories []FetchHistory) []SLO {
+	records := make(map[string][]FetchRecord)
+	for _, history := range histories {
+		provider := Provider(history.GitHubUser)
+		records[provider] = append(records[provider], history.Fetches...)
+	}
+
+	providers := make([]string, 0, len(records))
+	for provider := range records {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+
+	slos := make([]SLO, len(providers))
+	for i, provider := range providers {
+		slos[i] = Summarize(provider, records[provider])
+	}
+	return slos
+}
@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// snapshotFile holds every entry in one read-only file, mapped into memory
+// by ReadEntry (the leading dot keeps it apart from user cache files)
+const snapshotFile = ".snapshot"
+
+// snapshotHeader starts a snapshot file; the number is its format version
+const snapshotHeader = "charon-key-snapshot 1\n"
+
+// Snapshot lines: an entry line per GitHub user, followed by a line per key
+//
+//	U\t<github user>\t<cache file mtime ns>\t<fetched at ns>
+//	K\t<GitHub key ID>\t<created at ns>\t<key>
+const (
+	snapshotEntryPrefix = "U\t"
+	snapshotKeyPrefix   = "K\t"
+)
+
+// WriteSnapshot writes every readable cache entry into the snapshot file,
+// so logins can look entries up in a memory-mapped file instead of reading
+// and decoding per-user JSON files; run it after refreshing the cache
+// An entry is only served from the snapshot while its cache file is
+// unchanged, so entries written since fall back to their JSON file
+// Returns the number of entries written
+func (m *Manager) WriteSnapshot() (int, error) {
+	paths, err := filepath.Glob(filepath.Join(m.cacheDir, "*.json"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list cache files: %w", err)
+	}
+
+	type snapshotEntry struct {
+		entry CacheEntry
+		mtime time.Time
+	}
+	var entries []snapshotEntry
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		cache, err := decodeCache(data)
+		if err != nil || len(cache.Entries) != 1 {
+			continue
+		}
+		entry := cache.Entries[0]
+		if m.getCacheFilePath(entry.GitHubUser) != path || !snapshotSafe(entry) {
+			continue
+		}
+		entries = append(entries, snapshotEntry{entry: entry, mtime: info.ModTime()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].entry.GitHubUser < entries[j].entry.GitHubUser })
+
+	tmp, err := os.CreateTemp(m.cacheDir, snapshotFile+".tmp-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	w.WriteString(snapshotHeader)
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s%s\t%d\t%d\n", snapshotEntryPrefix, e.entry.GitHubUser, e.mtime.UnixNano(), e.entry.Timestamp.UnixNano())
+		for _, key := range e.entry.Keys {
+			metadata := e.entry.Metadata[key]
+			var createdAt int64
+			if !metadata.CreatedAt.IsZero() {
+				createdAt = metadata.CreatedAt.UnixNano()
+			}
+			fmt.Fprintf(w, "%s%s\t%d\t%s\n", snapshotKeyPrefix, metadata.GitHubID, createdAt, key)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return 0, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(m.cacheDir, snapshotFile)); err != nil {
+		return 0, fmt.Errorf("failed to replace snapshot: %w", err)
+	}
+	return len(entries), nil
+}
+
+// snapshotSafe reports whether an entry can be written as snapshot lines
+func snapshotSafe(entry CacheEntry) bool {
+	if strings.ContainsAny(entry.GitHubUser, "\t\n") || entry.SSHUser != "" {
+		return false
+	}
+	// Removed keys have no snapshot lines; such entries are read from JSON
+	if len(entry.Removed) > 0 {
+		return false
+	}
+	for _, key := range entry.Keys {
+		if strings.Contains(key, "\n") || strings.ContainsAny(entry.Metadata[key].GitHubID, "\t\n") {
+			return false
+		}
+	}
+	return true
+}
+
+// loadSnapshot maps the snapshot file into memory, once per manager
+// A missing or unreadable snapshot leaves it nil, and every entry is read
+// from its JSON file
+func (m *Manager) loadSnapshot() []byte {
+	m.snapshotOnce.Do(func() {
+		file, err := os.Open(filepath.Join(m.cacheDir, snapshotFile))
+		if err != nil {
+			return
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil || info.Size() < int64(len(snapshotHeader)) {
+			return
+		}
+		data, err := syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			return
+		}
+		if !bytes.HasPrefix(data, []byte(snapshotHeader)) {
+			syscall.Munmap(data)
+			return
+		}
+		m.snapshot = data
+	})
+	return m.snapshot
+}
+
+// snapshotEntry looks a GitHub user's entry up in the snapshot, if it's
+// there and the user's cache file hasn't changed since it was taken
+func (m *Manager) snapshotEntry(githubUser, cachePath string) (*CacheEntry, bool) {
+	data := m.loadSnapshot()
+	if data == nil {
+		return nil, false
+	}
+
+	start := bytes.Index(data, []byte("\n"+snapshotEntryPrefix+githubUser+"\t"))
+	if start < 0 {
+		return nil, false
+	}
+	lines := data[start+1:]
+	line, lines := nextLine(lines)
+	fields := strings.Split(string(line), "\t")
+	if len(fields) != 4 {
+		return nil, false
+	}
+	mtime, err1 := strconv.ParseInt(fields[2], 10, 64)
+	fetchedAt, err2 := strconv.ParseInt(fields[3], 10, 64)
+	if err1 != nil || err2 != nil {
+		return nil, false
+	}
+	if info, err := os.Stat(cachePath); err != nil || info.ModTime().UnixNano() != mtime {
+		return nil, false
+	}
+
+	entry := &CacheEntry{GitHubUser: githubUser, Timestamp: time.Unix(0, fetchedAt)}
+	for len(lines) > 0 && bytes.HasPrefix(lines, []byte(snapshotKeyPrefix)) {
+		line, lines = nextLine(lines)
+		fields := strings.SplitN(string(line), "\t", 4)
+		if len(fields) != 4 {
+			return nil, false
+		}
+		key := fields[3]
+		entry.Keys = append(entry.Keys, key)
+		if fields[1] != "" || fields[2] != "0" {
+			if entry.Metadata == nil {
+				entry.Metadata = make(map[string]KeyMetadata)
+			}
+			metadata := KeyMetadata{GitHubID: fields[1]}
+			if createdAt, err := strconv.ParseInt(fields[2], 10, 64); err == nil && createdAt != 0 {
+				metadata.CreatedAt = time.Unix(0, createdAt)
+			}
+			entry.Metadata[key] = metadata
+		}
+	}
+	return entry, true
+}
+
+// nextLine splits off the first line of data, without its newline
+func nextLine(data []byte) ([]byte, []byte) {
+	line, rest, _ := bytes.Cut(data, []byte("\n"))
+	return line, rest
+}
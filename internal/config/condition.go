@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConditionalMapping is a user map that only applies on hosts matching When,
+// during one of its Windows
+type ConditionalMapping struct {
+	// When is a condition on the host, e.g. `host =~ "bastion-*"` or
+	// `class == "prod" && host !~ "*-canary"` (empty = every host)
+	When string `json:"when"`
+
+	// Windows limit the mapping to time windows (empty = always)
+	Windows []TimeWindow `json:"windows"`
+
+	// UserMap maps SSH usernames to GitHub usernames and/or "@role" references
+	UserMap map[string][]string `json:"user_map"`
+}
+
+// Condition variables
+const (
+	// ConditionHost is the hostname
+	ConditionHost = "host"
+	// ConditionClass is the host class given with --host-class
+	ConditionClass = "class"
+)
+
+// Condition is a parsed When expression: comparisons joined by "&&"
+type Condition []comparison
+
+// comparison is one "<variable> <operator> <quoted value>" term
+// Operators: == and != compare exactly, =~ and !~ match a glob (see MatchGlob)
+type comparison struct {
+	variable string
+	operator string
+	value    string
+}
+
+// ParseCondition parses a When expression
+func ParseCondition(expr string) (Condition, error) {
+	var condition Condition
+	for _, term := range strings.Split(expr, "&&") {
+		fields := strings.SplitN(strings.TrimSpace(term), " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid condition %q: expected <variable> <operator> \"<value>\"", term)
+		}
+		variable, operator := fields[0], fields[1]
+		if variable != ConditionHost && variable != ConditionClass {
+			return nil, fmt.Errorf("invalid condition %q: unknown variable %q (valid: host, class)", term, variable)
+		}
+		switch operator {
+		case "==", "!=", "=~", "!~":
+		default:
+			return nil, fmt.Errorf("invalid condition %q: unknown operator %q (valid: ==, !=, =~, !~)", term, operator)
+		}
+		value, err := strconv.Unquote(strings.TrimSpace(fields[2]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition %q: value must be a quoted string", term)
+		}
+		condition = append(condition, comparison{variable: variable, operator: operator, value: value})
+	}
+	return condition, nil
+}
+
+// Match reports whether the condition holds for a host and host class
+func (c Condition) Match(host, class string) bool {
+	for _, cmp := range c {
+		actual := host
+		if cmp.variable == ConditionClass {
+			actual = class
+		}
+
+		var ok bool
+		switch cmp.operator {
+		case "==":
+			ok = actual == cmp.value
+		case "!=":
+			ok = actual != cmp.value
+		case "=~":
+			ok = MatchGlob(cmp.value, actual)
+		case "!~":
+			ok = !MatchGlob(cmp.value, actual)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ConditionalUserMap returns the combined user map of the conditional
+// mappings matching the host and host class and active at now
+func (f *File) ConditionalUserMap(host, class string, now time.Time) (map[string][]string, error) {
+	result := make(map[string][]string)
+	for i, mapping := range f.ConditionalMappings {
+		if mapping.When != "" {
+			condition, err := ParseCondition(mapping.When)
+			if err != nil {
+				return nil, fmt.Errorf("conditional_mappings[%d]: %w", i, err)
+			}
+			if !condition.Match(host, class) {
+				continue
+			}
+		}
+		active, err := activeWindow(mapping.Windows, now)
+		if err != nil {
+			return nil, fmt.Errorf("conditional_mappings[%d]: %w", i, err)
+		}
+		if !active {
+			continue
+		}
+		for sshUser, entries := range mapping.UserMap {
+			result[sshUser] = append(result[sshUser], entries...)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCondition_Match(t *testing.T) {
+	tests := []struct {
+		expr  string
+		host  string
+		class string
+		want  bool
+	}{
+		{expr: `host =~ "bastion-*"`, host: "bastion-1", want: true},
+		{expr: `host =~ "bastion-*"`, host: "web-1", want: false},
+		{expr: `host !~ "*-canary"`, host: "web-canary", want: false},
+		{expr: `class == "prod"`, host: "web-1", class: "prod", want: true},
+		{expr: `class != "prod"`, host: "web-1", class: "", want: true},
+		{expr: `class == "prod" && host =~ "db-*"`, host: "db-1", class: "prod", want: true},
+		{expr: `class == "prod" && host =~ "db-*"`, host: "web-1", class: "prod", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			condition, err := ParseCondition(tt.expr)
+			if err != nil {
+				if tt.want {
+					t.Fatalf("ParseCondition() error = %v", err)
+				}
+				return
+			}
+			if got := condition.Match(tt.host, tt.class); got != tt.want {
+				t.Errorf("Match(%q, %q) = %v, want %v", tt.host, tt.class, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCondition_Invalid(t *testing.T) {
+	for _, expr := range []string{
+		``,
+		`host`,
+		`host =~ bastion-*`,
+		`region == "eu"`,
+		`host ~= "bastion-*"`,
+	} {
+		if _, err := ParseCondition(expr); err == nil {
+			t.Errorf("ParseCondition(%q) expected error", expr)
+		}
+	}
+}
+
+func TestFile_ConditionalUserMap(t *testing.T) {
+	file := &File{
+		ConditionalMappings: []ConditionalMapping{
+			{When: `host =~ "bastion-*"`, UserMap: map[string][]string{"jump": {"@ops"}}},
+			{When: `class == "prod"`, UserMap: map[string][]string{"jump": {"oncall-github"}, "deploy": {"ci-bot"}}},
+		},
+	}
+
+	now := time.Now()
+	got, err := file.ConditionalUserMap("bastion-1", "prod", now)
+	if err != nil {
+		t.Fatalf("ConditionalUserMap() error = %v", err)
+	}
+	want := map[string][]string{"jump": {"@ops", "oncall-github"}, "deploy": {"ci-bot"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ConditionalUserMap() = %v, want %v", got, want)
+	}
+
+	if got, _ := file.ConditionalUserMap("web-1", "staging", now); len(got) != 0 {
+		t.Errorf("ConditionalUserMap() = %v, want no mappings", got)
+	}
+
+	file.ConditionalMappings = append(file.ConditionalMappings, ConditionalMapping{When: "host"})
+	if _, err := file.ConditionalUserMap("web-1", "", now); err == nil {
+		t.Error("ConditionalUserMap() expected error for invalid condition")
+	}
+}
+
+func BenchmarkFile_ConditionalUserMap(b *testing.B) {
+	file := &File{}
+	for i := 0; i < 20; i++ {
+		file.ConditionalMappings = append(file.ConditionalMappings, ConditionalMapping{
+			When:    fmt.Sprintf(`class == "prod" && host =~ "web-%d-*"`, i),
+			Windows: []TimeWindow{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, From: "09:00", To: "17:00"}},
+			UserMap: map[string][]string{"deploy": {"@release"}},
+		})
+	}
+	now := time.Now()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := file.ConditionalUserMap("web-7-eu", "prod", now); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
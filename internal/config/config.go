@@ -2,28 +2,267 @@ package config
 
 import (
 	"fmt"
+	"os"
 	"strings"
 	"time"
+
+	"github.com/dgarifullin/charon-key/internal/fault"
 )
 
+// RolePrefix marks a user-map entry as a reference to a role
+// e.g. "alice:@ops" grants alice the keys of every GitHub user in role "ops"
+const RolePrefix = "@"
+
+// GistPrefix marks user map entries that source keys from a gist instead of
+// a GitHub user's profile, by gist ID or raw URL
+// e.g. "deploy:gist:aa5a315d61ae9438b18d"
+const GistPrefix = "gist:"
+
+// IsGist reports whether a user map entry names a gist
+func IsGist(entry string) bool {
+	return strings.HasPrefix(entry, GistPrefix)
+}
+
+// IsGitHubUser reports whether a user map entry (after role expansion)
+// names a GitHub user rather than a gist or deploy keys
+func IsGitHubUser(entry string) bool {
+	return !IsGist(entry) && !IsDeployKeys(entry)
+}
+
 // Config holds the application configuration
 type Config struct {
 	// UserMap maps SSH usernames to GitHub usernames
 	// Key: SSH username (or "*" for wildcard)
-	// Value: List of GitHub usernames
+	// Value: List of GitHub usernames and/or "@role" references
 	UserMap map[string][]string
 
+	// Roles maps role names to GitHub usernames
+	Roles map[string][]string
+
+	// ExcludeComments maps SSH usernames (or "*" for all) to glob patterns;
+	// keys whose comment matches a pattern are not authorized for that user
+	ExcludeComments map[string][]string
+
+	// ExcludeExisting maps SSH usernames (or "*" for all) to fingerprints
+	// and comment patterns of keys in authorized_keys or existing key files
+	// that must not be output, e.g. revoked keys lingering in old files
+	ExcludeExisting map[string][]string
+
+	// KeyAge maps SSH usernames (or "*" for all) to key age policies
+	KeyAge map[string]KeyAgePolicy
+
+	// DeployKeyCommand maps SSH usernames (or "*" for all) to the forced
+	// command of deploy keys served to them
+	DeployKeyCommand map[string]string
+
 	// CacheDir is the directory for caching keys
 	CacheDir string
 
 	// CacheTTL is the cache time-to-live in minutes
 	CacheTTL time.Duration
 
+	// CacheTTLJitter shortens each entry's TTL by a stable per-host 0 to
+	// CacheTTLJitter percent (0 = disabled)
+	CacheTTLJitter int
+
+	// CachePerUser caches each SSH user's keys in its own subdirectory, so
+	// no SSH user is served entries cached for another
+	CachePerUser bool
+
+	// Refresh bypasses cached keys and always fetches from GitHub
+	Refresh bool
+
+	// KeyGracePeriod keeps serving keys removed from a GitHub user for this
+	// long (0 = disabled)
+	KeyGracePeriod time.Duration
+
+	// RevocationFile lists fingerprints and GitHub users whose keys are
+	// never served (a missing file revokes nothing)
+	RevocationFile string
+
 	// LogLevel is the logging level (debug, info, warn, error)
 	LogLevel string
 
+	// ShadowConfigFile is a candidate config file resolved alongside the live
+	// configuration to log what would change (empty = disabled)
+	ShadowConfigFile string
+
+	// GitHubURL is the base URL serving <user>.keys (empty = GitHub)
+	GitHubURL string
+
+	// GitHubToken locates a GitHub token enabling GraphQL bulk fetches, as a
+	// credentials source ("file:PATH", "env:NAME" or "keyring:SERVICE/ACCOUNT";
+	// empty = unauthenticated, one request per GitHub user)
+	GitHubToken string
+
+	// VaultAddr is the Vault server for "vault:" credential sources
+	// (empty = VAULT_ADDR), and VaultTokenSource locates its token
+	// (empty = VAULT_TOKEN)
+	VaultAddr        string
+	VaultTokenSource string
+
+	// AccessURL is an access-request system confirming that an SSH user
+	// holds an approved just-in-time grant before any keys are emitted
+	// (empty = disabled), and AccessTokenSource locates its bearer token
+	AccessURL         string
+	AccessTokenSource string
+
+	// WildcardOrg requires SSH users mapped only by the "*" wildcard to be
+	// active members of this GitHub org (empty = disabled)
+	WildcardOrg string
+
+	// DenyWildcard drops "*" user map entries, so only explicitly mapped SSH
+	// users are resolved
+	DenyWildcard bool
+
+	// MappingConflicts are the SSH users the config file, its profile and
+	// --user-map configure differently, logged at startup
+	MappingConflicts []MappingConflict
+
+	// Rollout limits features to part of the fleet, keyed by feature name
+	// (see FeatureEnabled); features without an entry apply everywhere
+	Rollout map[string]Rollout
+
+	// Hostname is this host's name, used for rollout decisions
+	Hostname string
+
+	// Profile is the config file profile applied (empty = none)
+	Profile string
+
+	// HostClass is this host's class (e.g. "prod"), for conditional mappings
+	HostClass string
+
+	// CanaryFingerprints are SHA256 fingerprints of honeypot keys; seeing one
+	// served or in an existing authorized_keys raises an audit event
+	CanaryFingerprints []string
+
+	// CanaryWebhook is POSTed each canary audit event (empty = log only)
+	CanaryWebhook string
+
+	// AlertWebhook is POSTed an event when resolution degrades: stale cache,
+	// failures, deny-on-empty and policy violations (empty = disabled)
+	AlertWebhook string
+
+	// AlertKeysetChanges also sends an alert when an SSH user's resolved
+	// keys change
+	AlertKeysetChanges bool
+
+	// Faults are failures injected to rehearse failure modes (nil = none)
+	Faults *fault.Set
+
 	// SSHUsername is the SSH username passed by the SSH daemon
 	SSHUsername string
+
+	// FIPS restricts key algorithms and TLS to a FIPS-approved set
+	FIPS bool
+
+	// KeyTypes and MinRSABits restrict the GitHub keys served (empty and 0 =
+	// no restriction), like the FIPS policy but configurable
+	KeyTypes   []string
+	MinRSABits int
+
+	// DropInvalidKeys drops GitHub keys whose key data doesn't decode instead
+	// of failing with ExitInvalidKey
+	DropInvalidKeys bool
+
+	// DenyOnEmpty emits nothing (and exits with ExitNoKeys) when resolution
+	// yields zero keys, instead of still emitting local authorized_keys
+	DenyOnEmpty bool
+
+	// Provenance appends each emitted key's source to its comment
+	Provenance bool
+
+	// OutputTemplate is a text/template rendering the output keys, given
+	// inline or read from OutputTemplateFile (empty = one key per line)
+	OutputTemplate     string
+	OutputTemplateFile string
+
+	// Timeout bounds the time spent resolving keys for all mapped GitHub users
+	Timeout time.Duration
+
+	// UserTimeout bounds the time spent fetching keys for one GitHub user
+	UserTimeout time.Duration
+
+	// HTTPTimeout bounds each HTTP request to GitHub (0 = the fetcher's default)
+	HTTPTimeout time.Duration
+
+	// Retries and RetryDelay bound retries of transient fetch failures
+	Retries    int
+	RetryDelay time.Duration
+
+	// LDAP enables dynamic user mapping from a directory (nil = disabled)
+	LDAP *LDAPConfig
+
+	// PasswdSource selects how SSH users' home directories are looked up
+	// (auto, os or getent)
+	PasswdSource string
+
+	// Dedup selects which copy of a key both in authorized_keys and on GitHub
+	// is emitted (prefer-local, prefer-github or keep-both-with-comment)
+	Dedup string
+
+	// FixPermissions fixes unsafe authorized_keys permissions and ownership
+	// instead of only reporting them
+	FixPermissions bool
+
+	// Unprivileged runs charon-key as a dedicated non-root user: key files
+	// it can't open are read through ReadHelper, or skipped without one
+	Unprivileged bool
+	ReadHelper   string
+
+	// Stream writes keys to stdout as they're resolved instead of collecting
+	// and sorting them first
+	Stream bool
+
+	// Sync writes keys into the user's authorized_keys instead of stdout
+	Sync bool
+
+	// SyncBackups is the number of authorized_keys backups kept in sync mode
+	SyncBackups int
+
+	// PrincipalsFile is the AuthorizedPrincipalsFile written in sync mode
+	// (%u and %h are expanded; empty = disabled)
+	PrincipalsFile string
+
+	// Output is the file keys are atomically written to instead of stdout
+	// (%u and %h are expanded; empty = stdout), with OutputMode
+	Output     string
+	OutputMode os.FileMode
+
+	// ExistingKeysFiles are further key files merged after authorized_keys
+	// (%u and %h are expanded; relative paths are in the home directory)
+	ExistingKeysFiles []string
+
+	// AuthorizedKeysFile replaces ~/.ssh/authorized_keys, for sshd setups
+	// whose AuthorizedKeysFile lives elsewhere (empty = the default)
+	AuthorizedKeysFile string
+
+	// ChrootDir is the chroot directory home directories are inside, for
+	// sftp-only accounts whose passwd home is the path in their jail (%u and
+	// %h are expanded; empty = none)
+	ChrootDir string
+
+	// ChrootUsers limits ChrootDir to these SSH users (empty = every user)
+	ChrootUsers []string
+}
+
+// LDAPConfig configures dynamic SSH user to GitHub user mapping from LDAP
+type LDAPConfig struct {
+	// URL is the LDAP server URL (e.g. ldaps://ldap.example.com)
+	URL string
+
+	// BaseDN is the search base
+	BaseDN string
+
+	// Filter selects the SSH user's entry; %s is the escaped SSH username
+	Filter string
+
+	// Attribute holds the GitHub username(s)
+	Attribute string
+
+	// BindDN and PasswordFile are used for authenticated binds (optional)
+	BindDN       string
+	PasswordFile string
 }
 
 // ParseUserMap parses the user mapping string into a map
@@ -44,9 +283,10 @@ func ParseUserMap(userMapStr string) (map[string][]string, error) {
 			continue
 		}
 
-		// Split by colon to get sshuser:githubuser
-		parts := strings.Split(pair, ":")
-		if len(parts) != 2 {
+		// Split by colon to get sshuser:githubuser; only "gist:" and
+		// "deploy-keys:" entries may contain more colons
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || (strings.Contains(parts[1], ":") && IsGitHubUser(strings.TrimSpace(parts[1]))) {
 			return nil, fmt.Errorf("invalid mapping format: %q (expected sshuser:githubuser)", pair)
 		}
 
@@ -83,20 +323,211 @@ func ValidateLogLevel(level string) error {
 	return fmt.Errorf("invalid log level: %q (valid: %s)", level, strings.Join(validLevels, ", "))
 }
 
+// ValidateRoles checks that every "@role" reference in the user map names a
+// defined, non-empty role
+func (c *Config) ValidateRoles() error {
+	for role, users := range c.Roles {
+		if role == "" {
+			return fmt.Errorf("role name cannot be empty")
+		}
+		if len(users) == 0 {
+			return fmt.Errorf("role %q has no GitHub users", role)
+		}
+		for _, user := range users {
+			if user == "" || strings.HasPrefix(user, RolePrefix) {
+				return fmt.Errorf("role %q: invalid GitHub username %q (roles cannot reference roles)", role, user)
+			}
+		}
+	}
+
+	for sshUser, entries := range c.UserMap {
+		for _, entry := range entries {
+			role, ok := strings.CutPrefix(entry, RolePrefix)
+			if !ok {
+				continue
+			}
+			if _, defined := c.Roles[role]; !defined {
+				return fmt.Errorf("SSH user %q references undefined role %q", sshUser, role)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidateCanaries checks that canary fingerprints use the SHA256 format
+// printed by ssh-keygen -l
+func (c *Config) ValidateCanaries() error {
+	for _, fingerprint := range c.CanaryFingerprints {
+		if !strings.HasPrefix(fingerprint, "SHA256:") || len(fingerprint) == len("SHA256:") {
+			return fmt.Errorf("invalid canary fingerprint %q (expected SHA256:...)", fingerprint)
+		}
+	}
+	if c.CanaryWebhook != "" && !isHTTPURL(c.CanaryWebhook) {
+		return fmt.Errorf("invalid canary webhook %q (expected an http(s) URL)", c.CanaryWebhook)
+	}
+	return nil
+}
+
+// ValidateAlertWebhook checks that the alert webhook is an http(s) URL
+func (c *Config) ValidateAlertWebhook() error {
+	if c.AlertWebhook != "" && !isHTTPURL(c.AlertWebhook) {
+		return fmt.Errorf("invalid alert webhook %q (expected an http(s) URL)", c.AlertWebhook)
+	}
+	return nil
+}
+
+func isHTTPURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
+
+// ValidateAccess checks the just-in-time access settings
+func (c *Config) ValidateAccess() error {
+	if c.AccessURL != "" && !isHTTPURL(c.AccessURL) {
+		return fmt.Errorf("invalid access URL %q (expected an http(s) URL)", c.AccessURL)
+	}
+	if c.AccessTokenSource != "" && c.AccessURL == "" {
+		return fmt.Errorf("access token source requires an access URL")
+	}
+	return nil
+}
+
+// IsCanary reports whether a key fingerprint is a canary
+func (c *Config) IsCanary(fingerprint string) bool {
+	return contains(c.CanaryFingerprints, fingerprint)
+}
+
+// IsExcludedComment reports whether a key comment matches one of the
+// exclusion patterns for the SSH user (or the "*" patterns)
+// Patterns are globs where "*" matches any run of characters and "?" any one
+func (c *Config) IsExcludedComment(sshUsername, comment string) bool {
+	if comment == "" {
+		return false
+	}
+	for _, user := range []string{sshUsername, "*"} {
+		for _, pattern := range c.ExcludeComments[user] {
+			if MatchGlob(pattern, comment) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MatchGlob matches s against a glob pattern where "*" matches any run of
+// characters (including "/") and "?" matches exactly one character
+func MatchGlob(pattern, s string) bool {
+	px, sx := 0, 0
+	nextPx, nextSx := -1, -1
+	for px < len(pattern) || sx < len(s) {
+		if px < len(pattern) {
+			switch c := pattern[px]; c {
+			case '*':
+				// Try matching an empty run first; remember where to resume
+				nextPx, nextSx = px, sx+1
+				px++
+				continue
+			case '?':
+				if sx < len(s) {
+					px++
+					sx++
+					continue
+				}
+			default:
+				if sx < len(s) && s[sx] == c {
+					px++
+					sx++
+					continue
+				}
+			}
+		}
+		// Mismatch: let the last "*" swallow one more character
+		if nextSx > 0 && nextSx <= len(s) {
+			px, sx = nextPx, nextSx
+			continue
+		}
+		return false
+	}
+	return true
+}
+
 // GetGitHubUsers returns the GitHub users for a given SSH username
 // Returns empty slice if SSH user not found
-// Handles wildcard "*" mapping
+// Handles wildcard "*" mapping and expands "@role" references
 func (c *Config) GetGitHubUsers(sshUsername string) []string {
 	// Check for exact match first
-	if users, ok := c.UserMap[sshUsername]; ok {
-		return users
+	if entries, ok := c.UserMap[sshUsername]; ok {
+		return c.expandRoles(entries)
 	}
 
 	// Check for wildcard match
-	if users, ok := c.UserMap["*"]; ok {
-		return users
+	if entries, ok := c.UserMap["*"]; ok {
+		return c.expandRoles(entries)
 	}
 
 	return []string{}
 }
 
+// IsWildcardMapped reports whether the SSH user is mapped only by the "*"
+// wildcard, i.e. has no user map entry of its own
+func (c *Config) IsWildcardMapped(sshUsername string) bool {
+	if _, ok := c.UserMap[sshUsername]; ok {
+		return false
+	}
+	_, ok := c.UserMap["*"]
+	return ok
+}
+
+// GetPrincipals returns the certificate principals for a given SSH username:
+// the names of the roles it is granted plus its GitHub users
+// Used to generate AuthorizedPrincipalsFile entries in sync mode
+func (c *Config) GetPrincipals(sshUsername string) []string {
+	entries, ok := c.UserMap[sshUsername]
+	if !ok {
+		entries = c.UserMap["*"]
+	}
+
+	seen := make(map[string]bool)
+	var principals []string
+	for _, entry := range entries {
+		if role, ok := strings.CutPrefix(entry, RolePrefix); ok && !seen[role] {
+			seen[role] = true
+			principals = append(principals, role)
+		}
+	}
+	for _, user := range c.expandRoles(entries) {
+		if !seen[user] && IsGitHubUser(user) {
+			seen[user] = true
+			principals = append(principals, user)
+		}
+	}
+
+	return principals
+}
+
+// expandRoles replaces "@role" references with the role's GitHub users
+// Duplicates are dropped, keeping the first occurrence
+func (c *Config) expandRoles(entries []string) []string {
+	seen := make(map[string]bool)
+	var users []string
+
+	add := func(user string) {
+		if !seen[user] {
+			seen[user] = true
+			users = append(users, user)
+		}
+	}
+
+	for _, entry := range entries {
+		if role, ok := strings.CutPrefix(entry, RolePrefix); ok {
+			for _, user := range c.Roles[role] {
+				add(user)
+			}
+			continue
+		}
+		add(entry)
+	}
+
+	return users
+}
+
@@ -1,6 +1,9 @@
 package config
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -77,6 +80,14 @@ func TestParseUserMap(t *testing.T) {
 			want:      nil,
 			wantError: true,
 		},
+		{
+			name:  "gist entry",
+			input: "deploy:gist:https://gist.githubusercontent.com/ci-bot/aa5a315d61ae9438b18d/raw/keys",
+			want: map[string][]string{
+				"deploy": {"gist:https://gist.githubusercontent.com/ci-bot/aa5a315d61ae9438b18d/raw/keys"},
+			},
+			wantError: false,
+		},
 		{
 			name:      "invalid format - multiple colons",
 			input:     "alice:github:extra",
@@ -179,3 +190,267 @@ func TestConfig_GetGitHubUsers(t *testing.T) {
 	}
 }
 
+
+func TestConfig_GetGitHubUsers_Roles(t *testing.T) {
+	cfg := &Config{
+		UserMap: map[string][]string{
+			"alice":  {"alice-github", "@ops"},
+			"deploy": {"@ops", "@ci"},
+		},
+		Roles: map[string][]string{
+			"ops": {"bob-github", "alice-github"},
+			"ci":  {"ci-bot"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		sshUsername string
+		want        []string
+	}{
+		{"user and role deduplicated", "alice", []string{"alice-github", "bob-github"}},
+		{"multiple roles", "deploy", []string{"bob-github", "alice-github", "ci-bot"}},
+		{"unmapped", "mallory", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cfg.GetGitHubUsers(tt.sshUsername)
+			if len(got) != len(tt.want) {
+				t.Fatalf("GetGitHubUsers(%q) = %v, want %v", tt.sshUsername, got, tt.want)
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("GetGitHubUsers(%q)[%d] = %q, want %q", tt.sshUsername, i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestConfig_IsWildcardMapped(t *testing.T) {
+	cfg := &Config{UserMap: map[string][]string{
+		"alice": {"alice-github"},
+		"*":     {"@ops"},
+	}}
+
+	if cfg.IsWildcardMapped("alice") {
+		t.Error("IsWildcardMapped(alice) = true, want false (own entry)")
+	}
+	if !cfg.IsWildcardMapped("bob") {
+		t.Error("IsWildcardMapped(bob) = false, want true")
+	}
+	if (&Config{UserMap: map[string][]string{"alice": {"a"}}}).IsWildcardMapped("bob") {
+		t.Error("IsWildcardMapped(bob) without a wildcard = true")
+	}
+}
+
+func TestConfig_GetPrincipals(t *testing.T) {
+	cfg := &Config{
+		UserMap: map[string][]string{
+			"alice":  {"alice-github", "@ops"},
+			"deploy": {"@ops", "@ci"},
+		},
+		Roles: map[string][]string{
+			"ops": {"bob-github", "alice-github"},
+			"ci":  {"ci-bot"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		sshUsername string
+		want        []string
+	}{
+		{"roles then users", "alice", []string{"ops", "alice-github", "bob-github"}},
+		{"multiple roles", "deploy", []string{"ops", "ci", "bob-github", "alice-github", "ci-bot"}},
+		{"unmapped", "mallory", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cfg.GetPrincipals(tt.sshUsername)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("GetPrincipals(%q) = %v, want %v", tt.sshUsername, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateRoles(t *testing.T) {
+	tests := []struct {
+		name      string
+		userMap   map[string][]string
+		roles     map[string][]string
+		wantError bool
+	}{
+		{
+			name:      "valid",
+			userMap:   map[string][]string{"alice": {"@ops"}},
+			roles:     map[string][]string{"ops": {"bob-github"}},
+			wantError: false,
+		},
+		{
+			name:      "undefined role",
+			userMap:   map[string][]string{"alice": {"@ops"}},
+			roles:     nil,
+			wantError: true,
+		},
+		{
+			name:      "empty role",
+			userMap:   map[string][]string{"alice": {"@ops"}},
+			roles:     map[string][]string{"ops": {}},
+			wantError: true,
+		},
+		{
+			name:      "nested role",
+			userMap:   map[string][]string{"alice": {"@ops"}},
+			roles:     map[string][]string{"ops": {"@admins"}, "admins": {"bob-github"}},
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{UserMap: tt.userMap, Roles: tt.roles}
+			err := cfg.ValidateRoles()
+			if (err != nil) != tt.wantError {
+				t.Errorf("ValidateRoles() error = %v, wantError %v", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateCanaries(t *testing.T) {
+	tests := []struct {
+		name         string
+		fingerprints []string
+		webhook      string
+		wantError    bool
+	}{
+		{
+			name:         "valid",
+			fingerprints: []string{"SHA256:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU"},
+			webhook:      "https://alerts.example.com/hook",
+			wantError:    false,
+		},
+		{
+			name:         "md5 fingerprint",
+			fingerprints: []string{"MD5:16:27:ac:a5:76:28:2d:36:63:1b:56:4d:eb:df:a6:48"},
+			wantError:    true,
+		},
+		{
+			name:         "empty hash",
+			fingerprints: []string{"SHA256:"},
+			wantError:    true,
+		},
+		{
+			name:      "webhook not a URL",
+			webhook:   "alerts.example.com",
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{CanaryFingerprints: tt.fingerprints, CanaryWebhook: tt.webhook}
+			err := cfg.ValidateCanaries()
+			if (err != nil) != tt.wantError {
+				t.Errorf("ValidateCanaries() error = %v, wantError %v", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestConfig_ValidateAlertWebhook(t *testing.T) {
+	for webhook, wantError := range map[string]bool{
+		"":                           false,
+		"https://hooks.example.com/": false,
+		"ftp://hooks.example.com/":   true,
+	} {
+		cfg := &Config{AlertWebhook: webhook}
+		if err := cfg.ValidateAlertWebhook(); (err != nil) != wantError {
+			t.Errorf("ValidateAlertWebhook(%q) error = %v, wantError %v", webhook, err, wantError)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	content := `{"user_map": {"alice": ["@ops"]}, "roles": {"ops": ["bob-github"]}}`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	file, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	if len(file.UserMap["alice"]) != 1 || file.UserMap["alice"][0] != "@ops" {
+		t.Errorf("LoadFile() user_map = %v", file.UserMap)
+	}
+	if len(file.Roles["ops"]) != 1 || file.Roles["ops"][0] != "bob-github" {
+		t.Errorf("LoadFile() roles = %v", file.Roles)
+	}
+
+	if err := os.WriteFile(path, []byte("{not json"), 0644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := LoadFile(path); err == nil {
+		t.Error("LoadFile() expected error for invalid JSON")
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"*@personal-laptop", "alice@personal-laptop", true},
+		{"*@personal-laptop", "alice@work-laptop", false},
+		{"alice@*", "alice@host/with/slashes", true},
+		{"a?ice", "alice", true},
+		{"a?ice", "aice", false},
+		{"*", "", true},
+		{"exact", "exact", true},
+		{"exact", "exactly", false},
+		{"*laptop*", "old laptop key", true},
+	}
+
+	for _, tt := range tests {
+		if got := MatchGlob(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("MatchGlob(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestConfig_IsExcludedComment(t *testing.T) {
+	cfg := &Config{
+		ExcludeComments: map[string][]string{
+			"alice": {"*@personal-laptop"},
+			"*":     {"*@compromised"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		ssh     string
+		comment string
+		want    bool
+	}{
+		{"per-user pattern", "alice", "alice@personal-laptop", true},
+		{"per-user pattern other user", "bob", "bob@personal-laptop", false},
+		{"wildcard pattern", "bob", "bob@compromised", true},
+		{"no match", "alice", "alice@work", false},
+		{"no comment", "alice", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.IsExcludedComment(tt.ssh, tt.comment); got != tt.want {
+				t.Errorf("IsExcludedComment(%q, %q) = %v, want %v", tt.ssh, tt.comment, got, tt.want)
+			}
+		})
+	}
+}
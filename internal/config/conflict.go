@@ -0,0 +1,158 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Mapping conflict policies: what happens when sources configure the same
+// SSH user differently
+const (
+	ConflictsIgnore = "ignore"
+	ConflictsWarn   = "warn"
+	ConflictsError  = "error"
+)
+
+// ValidateConflictPolicy checks a mapping_conflicts value ("" = warn)
+func ValidateConflictPolicy(policy string) error {
+	switch policy {
+	case "", ConflictsIgnore, ConflictsWarn, ConflictsError:
+		return nil
+	}
+	return fmt.Errorf("invalid mapping conflicts policy %q (valid: ignore, warn, error)", policy)
+}
+
+// MappingSource is one source of per-SSH-user settings, such as the config
+// file, a profile or --user-map, named for conflict reports
+type MappingSource struct {
+	Name             string
+	UserMap          map[string][]string
+	KeyAge           map[string]KeyAgePolicy
+	DeployKeyCommand map[string]string
+}
+
+// MappingConflict is an SSH user configured differently by several sources
+type MappingConflict struct {
+	// Setting is the setting that differs, e.g. `user_map["deploy"]`
+	Setting string
+
+	// Sources are the names of the sources setting it, in merge order
+	Sources []string
+}
+
+func (c MappingConflict) String() string {
+	return fmt.Sprintf("%s differs between %s", c.Setting, strings.Join(c.Sources, " and "))
+}
+
+// FindConflicts reports SSH users that two or more sources map to different
+// GitHub users (entries are compared as sets) or give different key age
+// policies or deploy key commands
+// Sources are merged anyway, user maps by appending and other settings by
+// letting later sources win, so conflicts are easy to miss
+func FindConflicts(sources []MappingSource) []MappingConflict {
+	var conflicts []MappingConflict
+	conflicts = append(conflicts, findConflicts(sources, "user_map", func(s MappingSource) map[string]string {
+		return entrySets(s.UserMap)
+	})...)
+	conflicts = append(conflicts, findConflicts(sources, "key_age", func(s MappingSource) map[string]string {
+		values := make(map[string]string, len(s.KeyAge))
+		for sshUser, policy := range s.KeyAge {
+			values[sshUser] = fmt.Sprintf("%+v", policy)
+		}
+		return values
+	})...)
+	conflicts = append(conflicts, findConflicts(sources, "deploy_key_command", func(s MappingSource) map[string]string {
+		return s.DeployKeyCommand
+	})...)
+	return conflicts
+}
+
+// findConflicts compares one setting, reduced to a string per SSH user,
+// across sources
+func findConflicts(sources []MappingSource, setting string, values func(MappingSource) map[string]string) []MappingConflict {
+	type definition struct {
+		source string
+		value  string
+	}
+	definitions := make(map[string][]definition)
+	for _, source := range sources {
+		for sshUser, value := range values(source) {
+			definitions[sshUser] = append(definitions[sshUser], definition{source.Name, value})
+		}
+	}
+
+	sshUsers := make([]string, 0, len(definitions))
+	for sshUser := range definitions {
+		sshUsers = append(sshUsers, sshUser)
+	}
+	sort.Strings(sshUsers)
+
+	var conflicts []MappingConflict
+	for _, sshUser := range sshUsers {
+		defs := definitions[sshUser]
+		differs := false
+		for _, def := range defs[1:] {
+			differs = differs || def.value != defs[0].value
+		}
+		if !differs {
+			continue
+		}
+		conflict := MappingConflict{Setting: fmt.Sprintf("%s[%q]", setting, sshUser)}
+		for _, def := range defs {
+			conflict.Sources = append(conflict.Sources, def.source)
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	return conflicts
+}
+
+// entrySets returns each SSH user's entries, sorted and deduplicated, as
+// one string
+func entrySets(userMap map[string][]string) map[string]string {
+	sets := make(map[string]string, len(userMap))
+	for sshUser, entries := range userMap {
+		seen := make(map[string]bool, len(entries))
+		var set []string
+		for _, entry := range entries {
+			if !seen[entry] {
+				seen[entry] = true
+				set = append(set, entry)
+			}
+		}
+		sort.Strings(set)
+		sets[sshUser] = strings.Join(set, ",")
+	}
+	return sets
+}
+
+// ProfileConflicts reports, for each profile, the SSH users it configures
+// differently from the base file (see FindConflicts)
+func (f *File) ProfileConflicts() []MappingConflict {
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var conflicts []MappingConflict
+	for _, name := range names {
+		profile := f.Profiles[name]
+		conflicts = append(conflicts, FindConflicts([]MappingSource{
+			f.MappingSource("config file"),
+			profile.MappingSource(fmt.Sprintf("profile %q", name)),
+		})...)
+	}
+	return conflicts
+}
+
+// MappingSource returns the file's per-SSH-user settings as a source named
+// name (conditional mappings, which only ever add entries, are left out)
+func (f *File) MappingSource(name string) MappingSource {
+	return MappingSource{
+		Name:             name,
+		UserMap:          f.UserMap,
+		KeyAge:           f.KeyAge,
+		DeployKeyCommand: f.DeployKeyCommand,
+	}
+}
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindConflicts(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []MappingSource
+		want    []string
+	}{
+		{
+			name: "disjoint users",
+			sources: []MappingSource{
+				{Name: "config file", UserMap: map[string][]string{"alice": {"alice-gh"}}},
+				{Name: "--user-map", UserMap: map[string][]string{"bob": {"bob-gh"}}},
+			},
+		},
+		{
+			name: "same entries in another order",
+			sources: []MappingSource{
+				{Name: "config file", UserMap: map[string][]string{"deploy": {"alice", "@ops"}}},
+				{Name: "--user-map", UserMap: map[string][]string{"deploy": {"@ops", "alice", "alice"}}},
+			},
+		},
+		{
+			name: "different GitHub users",
+			sources: []MappingSource{
+				{Name: "config file", UserMap: map[string][]string{"deploy": {"alice"}, "root": {"@ops"}}},
+				{Name: `profile "bastion"`, UserMap: map[string][]string{"deploy": {"alice"}}},
+				{Name: "--user-map", UserMap: map[string][]string{"deploy": {"bob"}}},
+			},
+			want: []string{`user_map["deploy"] differs between config file and profile "bastion" and --user-map`},
+		},
+		{
+			name: "different policies",
+			sources: []MappingSource{
+				{
+					Name:             "config file",
+					KeyAge:           map[string]KeyAgePolicy{"*": {MaxAgeDays: 365}},
+					DeployKeyCommand: map[string]string{"ci": "git-receive"},
+				},
+				{
+					Name:             `profile "ci"`,
+					KeyAge:           map[string]KeyAgePolicy{"*": {MaxAgeDays: 90}},
+					DeployKeyCommand: map[string]string{"ci": "git-receive"},
+				},
+			},
+			want: []string{`key_age["*"] differs between config file and profile "ci"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, conflict := range FindConflicts(tt.sources) {
+				got = append(got, conflict.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindConflicts() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFile_ProfileConflicts(t *testing.T) {
+	file := &File{
+		UserMap: map[string][]string{"deploy": {"@ops"}},
+		Profiles: map[string]Profile{
+			"bastion": {File: File{UserMap: map[string][]string{"deploy": {"alice"}}}},
+			"dev":     {File: File{UserMap: map[string][]string{"dev": {"alice"}}}},
+		},
+	}
+
+	conflicts := file.ProfileConflicts()
+	if len(conflicts) != 1 || conflicts[0].String() != `user_map["deploy"] differs between config file and profile "bastion"` {
+		t.Errorf("ProfileConflicts() = %v, want the deploy mapping of profile bastion", conflicts)
+	}
+
+	if err := ValidateConflictPolicy("fail"); err == nil {
+		t.Error("ValidateConflictPolicy(\"fail\") error = nil, want error")
+	}
+}
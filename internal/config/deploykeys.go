@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DeployKeysPrefix marks user map entries that aggregate GitHub deploy keys,
+// of a repository ("deploy-keys:acme/app") or of every repository of an org
+// ("deploy-keys:acme"), e.g. for CI runner hosts accepting pushes
+const DeployKeysPrefix = "deploy-keys:"
+
+// IsDeployKeys reports whether a user map entry names deploy keys
+func IsDeployKeys(entry string) bool {
+	return strings.HasPrefix(entry, DeployKeysPrefix)
+}
+
+// UsesDeployKeys reports whether any user map entry or role member names
+// deploy keys
+func (c *Config) UsesDeployKeys() bool {
+	for _, entries := range c.UserMap {
+		for _, entry := range entries {
+			if IsDeployKeys(entry) {
+				return true
+			}
+		}
+	}
+	for _, users := range c.Roles {
+		for _, user := range users {
+			if IsDeployKeys(user) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DeployKeyOptions returns the authorized_keys options of deploy keys served
+// to the SSH user: `restrict`, with the forced command configured for the
+// user (or "*") if any
+func (c *Config) DeployKeyOptions(sshUsername string) string {
+	command, ok := c.DeployKeyCommand[sshUsername]
+	if !ok {
+		command, ok = c.DeployKeyCommand["*"]
+	}
+	if !ok {
+		return "restrict"
+	}
+	command = strings.ReplaceAll(command, `\`, `\\`)
+	command = strings.ReplaceAll(command, `"`, `\"`)
+	return fmt.Sprintf(`command="%s",restrict`, command)
+}
+
+// ValidateDeployKeys checks that forced commands are single, non-empty lines
+func (c *Config) ValidateDeployKeys() error {
+	for sshUser, command := range c.DeployKeyCommand {
+		if strings.TrimSpace(command) == "" || strings.ContainsAny(command, "\r\n") {
+			return fmt.Errorf("deploy_key_command %q: command must be a non-empty single line", sshUser)
+		}
+	}
+	return nil
+}
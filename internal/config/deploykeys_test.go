@@ -0,0 +1,54 @@
+package config
+
+import "testing"
+
+func TestConfig_DeployKeyOptions(t *testing.T) {
+	cfg := &Config{DeployKeyCommand: map[string]string{
+		"*":      "/usr/local/bin/ci-receive",
+		"runner": `/usr/bin/git-shell -c "$SSH_ORIGINAL_COMMAND"`,
+	}}
+
+	tests := []struct {
+		name        string
+		sshUsername string
+		config      *Config
+		want        string
+	}{
+		{"wildcard command", "ci", cfg, `command="/usr/local/bin/ci-receive",restrict`},
+		{"quotes escaped", "runner", cfg, `command="/usr/bin/git-shell -c \"$SSH_ORIGINAL_COMMAND\"",restrict`},
+		{"no command", "ci", &Config{}, "restrict"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.DeployKeyOptions(tt.sshUsername); got != tt.want {
+				t.Errorf("DeployKeyOptions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_UsesDeployKeys(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *Config
+		want   bool
+	}{
+		{"user map entry", &Config{UserMap: map[string][]string{"ci": {"deploy-keys:acme/app"}}}, true},
+		{"role member", &Config{Roles: map[string][]string{"ci": {"deploy-keys:acme"}}}, true},
+		{"GitHub users only", &Config{UserMap: map[string][]string{"alice": {"alice", "gist:aa5a315d61ae9438b18d"}}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.UsesDeployKeys(); got != tt.want {
+				t.Errorf("UsesDeployKeys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	bad := &Config{DeployKeyCommand: map[string]string{"ci": "receive\nrm -rf /"}}
+	if err := bad.ValidateDeployKeys(); err == nil {
+		t.Error("ValidateDeployKeys() with a multi-line command error = nil")
+	}
+}
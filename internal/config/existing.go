@@ -0,0 +1,38 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IsExcludedExisting reports whether a key read from authorized_keys or an
+// existing key file is excluded for the SSH user (or by the "*" entries):
+// entries starting with "SHA256:" match the key's fingerprint, others are
+// globs matched against its comment (see MatchGlob)
+func (c *Config) IsExcludedExisting(sshUsername, fingerprint, comment string) bool {
+	for _, user := range []string{sshUsername, "*"} {
+		for _, entry := range c.ExcludeExisting[user] {
+			if strings.HasPrefix(entry, "SHA256:") {
+				if entry == fingerprint {
+					return true
+				}
+			} else if comment != "" && MatchGlob(entry, comment) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidateExcludeExisting checks that exclude_existing entries are non-empty
+// fingerprints or comment patterns
+func (c *Config) ValidateExcludeExisting() error {
+	for sshUser, entries := range c.ExcludeExisting {
+		for _, entry := range entries {
+			if entry == "" || entry == "SHA256:" {
+				return fmt.Errorf("exclude_existing %q: invalid entry %q (expected SHA256:... or a comment pattern)", sshUser, entry)
+			}
+		}
+	}
+	return nil
+}
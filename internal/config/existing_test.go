@@ -0,0 +1,43 @@
+package config
+
+import "testing"
+
+func TestConfig_IsExcludedExisting(t *testing.T) {
+	cfg := &Config{
+		ExcludeExisting: map[string][]string{
+			"alice": {"SHA256:revoked", "*@old-laptop"},
+			"*":     {"contractor-*"},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		ssh         string
+		fingerprint string
+		comment     string
+		want        bool
+	}{
+		{"fingerprint", "alice", "SHA256:revoked", "alice@work", true},
+		{"fingerprint other user", "bob", "SHA256:revoked", "", false},
+		{"comment pattern", "alice", "SHA256:other", "alice@old-laptop", true},
+		{"wildcard pattern", "bob", "SHA256:other", "contractor-dave", true},
+		{"no match", "alice", "SHA256:other", "alice@work", false},
+		{"no comment", "alice", "SHA256:other", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cfg.IsExcludedExisting(tt.ssh, tt.fingerprint, tt.comment); got != tt.want {
+				t.Errorf("IsExcludedExisting(%q, %q, %q) = %v, want %v", tt.ssh, tt.fingerprint, tt.comment, got, tt.want)
+			}
+		})
+	}
+
+	bad := &Config{ExcludeExisting: map[string][]string{"*": {"SHA256:"}}}
+	if err := bad.ValidateExcludeExisting(); err == nil {
+		t.Error("ValidateExcludeExisting() error = nil, want error for an empty fingerprint")
+	}
+	if err := cfg.ValidateExcludeExisting(); err != nil {
+		t.Errorf("ValidateExcludeExisting() error = %v", err)
+	}
+}
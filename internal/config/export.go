@@ -0,0 +1,29 @@
+package config
+
+import (
+	"sort"
+	"strings"
+)
+
+// OrgFile builds a config file bootstrapping mappings from a GitHub org:
+// each member's SSH username is their lowercased login, mapped to the
+// login, and each team with members becomes a role named after its slug
+// (and so a certificate principal in sync mode)
+func OrgFile(members []string, teams map[string][]string) *File {
+	file := &File{
+		UserMap: make(map[string][]string, len(members)),
+		Roles:   make(map[string][]string, len(teams)),
+	}
+	for _, member := range members {
+		file.UserMap[strings.ToLower(member)] = []string{member}
+	}
+	for slug, logins := range teams {
+		if len(logins) == 0 {
+			continue // Roles cannot be empty
+		}
+		role := append([]string{}, logins...)
+		sort.Strings(role)
+		file.Roles[slug] = role
+	}
+	return file
+}
@@ -0,0 +1,25 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOrgFile(t *testing.T) {
+	file := OrgFile(
+		[]string{"Alice", "bob"},
+		map[string][]string{"ops": {"bob", "Alice"}, "empty": nil},
+	)
+
+	wantUserMap := map[string][]string{"alice": {"Alice"}, "bob": {"bob"}}
+	if !reflect.DeepEqual(file.UserMap, wantUserMap) {
+		t.Errorf("OrgFile() user_map = %v, want %v", file.UserMap, wantUserMap)
+	}
+	wantRoles := map[string][]string{"ops": {"Alice", "bob"}}
+	if !reflect.DeepEqual(file.Roles, wantRoles) {
+		t.Errorf("OrgFile() roles = %v, want %v", file.Roles, wantRoles)
+	}
+	if err := file.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+}
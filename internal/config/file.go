@@ -0,0 +1,125 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// File is the on-disk configuration format (JSON)
+//
+//	{
+//	  "user_map": {
+//	    "alice": ["alice-github", "@ops"],
+//	    "deploy": ["@ops"]
+//	  },
+//	  "roles": {
+//	    "ops": ["bob-github", "carol-github"]
+//	  },
+//	  "exclude_comments": {
+//	    "*": ["*@personal-laptop"]
+//	  },
+//	  "exclude_existing": {
+//	    "*": ["SHA256:uNiVztksCsDhcc0u9e8BujQXVUpKZIDTMczCvj3tD2s", "*@old-laptop"]
+//	  },
+//	  "key_age": {
+//	    "*": {"max_age_days": 730, "min_age_hours": 24}
+//	  },
+//	  "rollout": {
+//	    "fips": {"percent": 10, "hosts": ["canary-*"], "users": ["deploy"]}
+//	  },
+//	  "canary_fingerprints": ["SHA256:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU"],
+//	  "canary_webhook": "https://alerts.example.com/charon-key",
+//	  "alert_webhook": "https://hooks.slack.com/services/...",
+//	  "conditional_mappings": [
+//	    {"when": "class == \"prod\" && host =~ \"db-*\"", "user_map": {"dba": ["@ops"]}}
+//	  ],
+//	  "profiles": {
+//	    "bastion": {"hosts": ["bastion-*"], "user_map": {"ops": ["@ops"]}}
+//	  }
+//	}
+type File struct {
+	// UserMap maps SSH usernames to GitHub usernames and/or "@role" references
+	UserMap map[string][]string `json:"user_map"`
+
+	// Roles maps role names to GitHub usernames
+	Roles map[string][]string `json:"roles"`
+
+	// DenyWildcard ignores "*" user map entries, wherever they come from, so
+	// only explicitly mapped SSH users are authorized
+	DenyWildcard bool `json:"deny_wildcard"`
+
+	// ExcludeComments maps SSH usernames (or "*") to key comment glob patterns
+	ExcludeComments map[string][]string `json:"exclude_comments"`
+
+	// ExcludeExisting maps SSH usernames (or "*") to fingerprints (SHA256:...)
+	// or comment glob patterns of existing keys not to output
+	ExcludeExisting map[string][]string `json:"exclude_existing"`
+
+	// KeyAge maps SSH usernames (or "*") to key age policies
+	KeyAge map[string]KeyAgePolicy `json:"key_age"`
+
+	// DeployKeyCommand maps SSH usernames (or "*") to the forced command of
+	// deploy keys served to them
+	DeployKeyCommand map[string]string `json:"deploy_key_command"`
+
+	// Rollout limits features to part of the fleet, keyed by feature name
+	Rollout map[string]Rollout `json:"rollout"`
+
+	// CanaryFingerprints are SHA256 fingerprints of keys that must never show up
+	CanaryFingerprints []string `json:"canary_fingerprints"`
+
+	// CanaryWebhook is called when a canary key is seen (optional)
+	CanaryWebhook string `json:"canary_webhook"`
+
+	// AlertWebhook is called when resolution degrades (optional)
+	AlertWebhook string `json:"alert_webhook"`
+
+	// ConditionalMappings are user maps applied only on matching hosts
+	ConditionalMappings []ConditionalMapping `json:"conditional_mappings"`
+
+	// Profiles override settings per class of hosts, keyed by profile name
+	Profiles map[string]Profile `json:"profiles"`
+
+	// MappingConflicts is what happens when the file, the applied profile
+	// and --user-map configure an SSH user differently: ignore, warn (the
+	// default) or error
+	MappingConflicts string `json:"mapping_conflicts"`
+}
+
+// LoadFile reads and parses a configuration file, or a snapshot of one
+func LoadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// Snapshots written by compile-config skip JSON parsing
+	if isSnapshot(data) {
+		file, err := decodeSnapshot(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config snapshot %s: %w", path, err)
+		}
+		return file, nil
+	}
+
+	// Schema problems are reported with their line and field, which
+	// json.Unmarshal errors lack
+	if err := ValidateSchema(data); err != nil {
+		var problems SchemaErrors
+		if errors.As(err, &problems) {
+			for _, problem := range problems {
+				problem.File = path
+			}
+		}
+		return nil, fmt.Errorf("invalid config file: %w", err)
+	}
+
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return &file, nil
+}
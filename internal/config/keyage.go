@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// KeyAgePolicy rejects GitHub keys by when GitHub reports they were created
+// Key ages are only known for keys fetched with the GraphQL API (a GitHub
+// token is required); keys of unknown age are rejected
+type KeyAgePolicy struct {
+	// MaxAgeDays rejects keys created more than this many days ago, forcing
+	// rotation (0 = no limit)
+	MaxAgeDays int `json:"max_age_days"`
+
+	// MinAgeHours rejects keys created less than this many hours ago, a
+	// cooling-off period against just-added keys (0 = none)
+	MinAgeHours int `json:"min_age_hours"`
+}
+
+// Check returns nil if a key created at createdAt is accepted at now, or an
+// error describing why it was rejected
+func (p KeyAgePolicy) Check(createdAt, now time.Time) error {
+	if createdAt.IsZero() {
+		return fmt.Errorf("key age unknown")
+	}
+	age := now.Sub(createdAt)
+	if p.MaxAgeDays > 0 && age > time.Duration(p.MaxAgeDays)*24*time.Hour {
+		return fmt.Errorf("key created %s is older than %d days", createdAt.Format("2006-01-02"), p.MaxAgeDays)
+	}
+	if p.MinAgeHours > 0 && age < time.Duration(p.MinAgeHours)*time.Hour {
+		return fmt.Errorf("key created %s is newer than %d hours", createdAt.Format(time.RFC3339), p.MinAgeHours)
+	}
+	return nil
+}
+
+// KeyAgePolicyFor returns the key age policy for the SSH user, falling back
+// to the "*" policy; ok is false if neither is configured
+func (c *Config) KeyAgePolicyFor(sshUsername string) (policy KeyAgePolicy, ok bool) {
+	if policy, ok := c.KeyAge[sshUsername]; ok {
+		return policy, true
+	}
+	policy, ok = c.KeyAge["*"]
+	return policy, ok
+}
+
+// ValidateKeyAge checks that key age limits are not negative
+func (c *Config) ValidateKeyAge() error {
+	for sshUser, policy := range c.KeyAge {
+		if policy.MaxAgeDays < 0 || policy.MinAgeHours < 0 {
+			return fmt.Errorf("key_age %q: limits cannot be negative", sshUser)
+		}
+	}
+	return nil
+}
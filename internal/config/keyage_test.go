@@ -0,0 +1,56 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyAgePolicy_Check(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	policy := KeyAgePolicy{MaxAgeDays: 730, MinAgeHours: 24}
+
+	tests := []struct {
+		name      string
+		createdAt time.Time
+		wantErr   bool
+	}{
+		{"within limits", now.AddDate(-1, 0, 0), false},
+		{"too old", now.AddDate(-3, 0, 0), true},
+		{"too new", now.Add(-time.Hour), true},
+		{"unknown age", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := policy.Check(tt.createdAt, now); (err != nil) != tt.wantErr {
+				t.Errorf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := (KeyAgePolicy{}).Check(now.AddDate(-10, 0, 0), now); err != nil {
+		t.Errorf("Check() without limits error = %v", err)
+	}
+}
+
+func TestConfig_KeyAgePolicyFor(t *testing.T) {
+	cfg := &Config{KeyAge: map[string]KeyAgePolicy{
+		"*":      {MaxAgeDays: 365},
+		"deploy": {MinAgeHours: 48},
+	}}
+
+	if policy, ok := cfg.KeyAgePolicyFor("deploy"); !ok || policy.MinAgeHours != 48 || policy.MaxAgeDays != 0 {
+		t.Errorf("KeyAgePolicyFor(deploy) = %+v, %v", policy, ok)
+	}
+	if policy, ok := cfg.KeyAgePolicyFor("alice"); !ok || policy.MaxAgeDays != 365 {
+		t.Errorf("KeyAgePolicyFor(alice) = %+v, %v, want the * policy", policy, ok)
+	}
+	if _, ok := (&Config{}).KeyAgePolicyFor("alice"); ok {
+		t.Error("KeyAgePolicyFor() without policies ok = true")
+	}
+
+	cfg.KeyAge["bad"] = KeyAgePolicy{MaxAgeDays: -1}
+	if err := cfg.ValidateKeyAge(); err == nil {
+		t.Error("ValidateKeyAge() with a negative limit error = nil")
+	}
+}
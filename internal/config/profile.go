@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Profile overrides part of a config file for a class of hosts (e.g.
+// bastions, build hosts, prod nodes), so one file can serve the whole fleet
+// A profile applies when selected by name or when the hostname matches one
+// of its host patterns
+type Profile struct {
+	// Hosts are hostname glob patterns selecting the profile
+	Hosts []string `json:"hosts"`
+
+	// File holds the profile's settings, combined with the base file's by
+	// WithProfile; profiles cannot be nested
+	File
+}
+
+// SelectProfile returns the profile to apply: name if given (it must exist),
+// otherwise the profile whose host patterns match hostname
+// Returns "" if no profile matches; more than one match is an error
+func (f *File) SelectProfile(name, hostname string) (string, error) {
+	if name != "" {
+		if _, ok := f.Profiles[name]; !ok {
+			return "", fmt.Errorf("profile %q is not defined", name)
+		}
+		return name, nil
+	}
+
+	var matches []string
+	for profileName, profile := range f.Profiles {
+		for _, pattern := range profile.Hosts {
+			if MatchGlob(pattern, hostname) {
+				matches = append(matches, profileName)
+				break
+			}
+		}
+	}
+	sort.Strings(matches)
+
+	switch len(matches) {
+	case 0:
+		return "", nil
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("host %q matches several profiles: %v (select one with --profile)", hostname, matches)
+}
+
+// WithProfile returns the file with the named profile applied ("" = none):
+// user_map entries, conditional mappings, exclude_comments and
+// exclude_existing entries and canary fingerprints are added to the base
+// file's, while roles, rollout features, key age policies, deploy key
+// commands and webhooks replace the base file's entries of the same name;
+// deny_wildcard applies if either sets it, and the profile's
+// mapping_conflicts policy replaces the base file's
+func (f *File) WithProfile(name string) (*File, error) {
+	if name == "" {
+		return f, nil
+	}
+	profile, ok := f.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %q is not defined", name)
+	}
+	if len(profile.Profiles) > 0 {
+		return nil, fmt.Errorf("profile %q: profiles cannot be nested", name)
+	}
+
+	merged := &File{
+		UserMap:             appendMap(f.UserMap, profile.UserMap),
+		Roles:               replaceMap(f.Roles, profile.Roles),
+		ExcludeComments:     appendMap(f.ExcludeComments, profile.ExcludeComments),
+		ExcludeExisting:     appendMap(f.ExcludeExisting, profile.ExcludeExisting),
+		KeyAge:              replaceMap(f.KeyAge, profile.KeyAge),
+		DeployKeyCommand:    replaceMap(f.DeployKeyCommand, profile.DeployKeyCommand),
+		Rollout:             replaceMap(f.Rollout, profile.Rollout),
+		CanaryFingerprints:  append(append([]string{}, f.CanaryFingerprints...), profile.CanaryFingerprints...),
+		ConditionalMappings: append(append([]ConditionalMapping{}, f.ConditionalMappings...), profile.ConditionalMappings...),
+		CanaryWebhook:       f.CanaryWebhook,
+		AlertWebhook:        f.AlertWebhook,
+		DenyWildcard:        f.DenyWildcard || profile.DenyWildcard,
+		MappingConflicts:    f.MappingConflicts,
+	}
+	if profile.MappingConflicts != "" {
+		merged.MappingConflicts = profile.MappingConflicts
+	}
+	if profile.CanaryWebhook != "" {
+		merged.CanaryWebhook = profile.CanaryWebhook
+	}
+	if profile.AlertWebhook != "" {
+		merged.AlertWebhook = profile.AlertWebhook
+	}
+	return merged, nil
+}
+
+// appendMap returns base with the values of extra appended per key
+func appendMap(base, extra map[string][]string) map[string][]string {
+	if base == nil && extra == nil {
+		return nil
+	}
+	result := make(map[string][]string, len(base)+len(extra))
+	for key, values := range base {
+		result[key] = append([]string{}, values...)
+	}
+	for key, values := range extra {
+		result[key] = append(result[key], values...)
+	}
+	return result
+}
+
+// replaceMap returns base with the entries of override replacing its own
+func replaceMap[V any](base, override map[string]V) map[string]V {
+	if base == nil && override == nil {
+		return nil
+	}
+	result := make(map[string]V, len(base)+len(override))
+	for key, value := range base {
+		result[key] = value
+	}
+	for key, value := range override {
+		result[key] = value
+	}
+	return result
+}
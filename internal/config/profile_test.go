@@ -0,0 +1,99 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+const profileTestFile = `{
+	"user_map": {"alice": ["alice-github"]},
+	"roles": {"ops": ["bob-github"]},
+	"alert_webhook": "https://hooks.example.com/base",
+	"profiles": {
+		"bastion": {
+			"hosts": ["bastion-*"],
+			"user_map": {"alice": ["@ops"], "jump": ["@ops"]},
+			"roles": {"ops": ["carol-github"]},
+			"alert_webhook": "https://hooks.example.com/bastion"
+		},
+		"build": {
+			"hosts": ["ci-*", "build-*"],
+			"user_map": {"ci": ["ci-bot"]}
+		},
+		"wide": {
+			"hosts": ["build-eu-*"]
+		}
+	}
+}`
+
+func loadProfileTestFile(t *testing.T) *File {
+	var file File
+	if err := json.Unmarshal([]byte(profileTestFile), &file); err != nil {
+		t.Fatalf("failed to parse file: %v", err)
+	}
+	return &file
+}
+
+func TestFile_SelectProfile(t *testing.T) {
+	file := loadProfileTestFile(t)
+
+	tests := []struct {
+		name      string
+		profile   string
+		hostname  string
+		want      string
+		wantError bool
+	}{
+		{name: "hostname match", hostname: "bastion-1", want: "bastion"},
+		{name: "second pattern", hostname: "ci-7", want: "build"},
+		{name: "no match", hostname: "web-1", want: ""},
+		{name: "explicit overrides hostname", profile: "build", hostname: "bastion-1", want: "build"},
+		{name: "undefined profile", profile: "prod", wantError: true},
+		{name: "ambiguous", hostname: "build-eu-1", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := file.SelectProfile(tt.profile, tt.hostname)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("SelectProfile() error = %v, wantError %v", err, tt.wantError)
+			}
+			if got != tt.want {
+				t.Errorf("SelectProfile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFile_WithProfile(t *testing.T) {
+	file := loadProfileTestFile(t)
+
+	merged, err := file.WithProfile("bastion")
+	if err != nil {
+		t.Fatalf("WithProfile() error = %v", err)
+	}
+
+	wantUserMap := map[string][]string{"alice": {"alice-github", "@ops"}, "jump": {"@ops"}}
+	if !reflect.DeepEqual(merged.UserMap, wantUserMap) {
+		t.Errorf("user_map = %v, want %v", merged.UserMap, wantUserMap)
+	}
+	if !reflect.DeepEqual(merged.Roles["ops"], []string{"carol-github"}) {
+		t.Errorf("roles = %v, want the profile's ops role", merged.Roles)
+	}
+	if merged.AlertWebhook != "https://hooks.example.com/bastion" {
+		t.Errorf("alert_webhook = %q, want the profile's", merged.AlertWebhook)
+	}
+
+	// The base file is left untouched
+	if len(file.UserMap["alice"]) != 1 || file.Roles["ops"][0] != "bob-github" {
+		t.Errorf("WithProfile() modified the base file: %v %v", file.UserMap, file.Roles)
+	}
+
+	if same, _ := file.WithProfile(""); same != file {
+		t.Error("WithProfile(\"\") should return the base file")
+	}
+	if _, err := file.WithProfile("prod"); err == nil {
+		t.Error("WithProfile() expected error for undefined profile")
+	}
+}
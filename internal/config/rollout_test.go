@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRollout_Enabled(t *testing.T) {
+	rollout := Rollout{
+		Percent: 0,
+		Hosts:   []string{"canary-*"},
+		Users:   []string{"deploy"},
+	}
+
+	tests := []struct {
+		name     string
+		hostname string
+		sshUser  string
+		want     bool
+	}{
+		{"listed user", "web-1", "deploy", true},
+		{"matching host", "canary-3", "alice", true},
+		{"neither", "web-1", "alice", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rollout.Enabled(FeatureFIPS, tt.hostname, tt.sshUser); got != tt.want {
+				t.Errorf("Enabled(%q, %q) = %v, want %v", tt.hostname, tt.sshUser, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRollout_Percent(t *testing.T) {
+	enabledAt := func(percent int) map[string]bool {
+		enabled := make(map[string]bool)
+		for i := 0; i < 1000; i++ {
+			host := fmt.Sprintf("host-%d", i)
+			if (Rollout{Percent: percent}).Enabled(FeatureLDAP, host, "alice") {
+				enabled[host] = true
+			}
+		}
+		return enabled
+	}
+
+	if n := len(enabledAt(0)); n != 0 {
+		t.Errorf("0%% rollout enabled %d hosts", n)
+	}
+	if n := len(enabledAt(100)); n != 1000 {
+		t.Errorf("100%% rollout enabled %d of 1000 hosts", n)
+	}
+
+	// Roughly the requested share, and raising the percentage only adds hosts
+	ten, fifty := enabledAt(10), enabledAt(50)
+	if len(ten) < 50 || len(ten) > 150 {
+		t.Errorf("10%% rollout enabled %d of 1000 hosts", len(ten))
+	}
+	for host := range ten {
+		if !fifty[host] {
+			t.Errorf("%s enabled at 10%% but not at 50%%", host)
+		}
+	}
+}
+
+func TestConfig_FeatureEnabled(t *testing.T) {
+	cfg := &Config{
+		Hostname: "web-1",
+		Rollout: map[string]Rollout{
+			FeatureDenyOnEmpty: {Users: []string{"deploy"}},
+		},
+	}
+
+	if !cfg.FeatureEnabled(FeatureFIPS, "alice") {
+		t.Error("FeatureEnabled() = false for a feature without rollout entry")
+	}
+	if cfg.FeatureEnabled(FeatureDenyOnEmpty, "alice") {
+		t.Error("FeatureEnabled() = true for a user outside the rollout")
+	}
+	if !cfg.FeatureEnabled(FeatureDenyOnEmpty, "deploy") {
+		t.Error("FeatureEnabled() = false for a listed user")
+	}
+}
+
+func TestConfig_ValidateRollout(t *testing.T) {
+	tests := []struct {
+		name    string
+		rollout map[string]Rollout
+		wantErr bool
+	}{
+		{"valid", map[string]Rollout{FeatureFIPS: {Percent: 25}}, false},
+		{"unknown feature", map[string]Rollout{"gitlab": {Percent: 25}}, true},
+		{"percent too high", map[string]Rollout{FeatureLDAP: {Percent: 101}}, true},
+		{"negative percent", map[string]Rollout{FeatureLDAP: {Percent: -1}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Rollout: tt.rollout}
+			if err := cfg.ValidateRollout(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRollout() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
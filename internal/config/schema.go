@@ -0,0 +1,419 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// maxSchemaErrors bounds the problems reported for one file
+const maxSchemaErrors = 10
+
+// schemaNode is the subset of JSON Schema config files are described with
+type schemaNode struct {
+	Type        string
+	Description string
+
+	// Properties are an object's known fields; AdditionalProperties is the
+	// schema of the values of any other field (nil = no other fields)
+	Properties           map[string]*schemaNode
+	AdditionalProperties *schemaNode
+
+	Items   *schemaNode
+	Enum    []string
+	Minimum *int
+	Maximum *int
+}
+
+func (s *schemaNode) MarshalJSON() ([]byte, error) {
+	out := map[string]any{"type": s.Type}
+	if s.Description != "" {
+		out["description"] = s.Description
+	}
+	if s.Type == "object" {
+		if s.Properties != nil {
+			out["properties"] = s.Properties
+		}
+		if s.AdditionalProperties != nil {
+			out["additionalProperties"] = s.AdditionalProperties
+		} else {
+			out["additionalProperties"] = false
+		}
+	}
+	if s.Items != nil {
+		out["items"] = s.Items
+	}
+	if len(s.Enum) > 0 {
+		out["enum"] = s.Enum
+	}
+	if s.Minimum != nil {
+		out["minimum"] = *s.Minimum
+	}
+	if s.Maximum != nil {
+		out["maximum"] = *s.Maximum
+	}
+	return json.Marshal(out)
+}
+
+func intPtr(n int) *int { return &n }
+
+// configSchema describes the config file format (see File)
+var configSchema = func() *schemaNode {
+	str := func(description string) *schemaNode { return &schemaNode{Type: "string", Description: description} }
+	list := func(description string) *schemaNode {
+		return &schemaNode{Type: "array", Description: description, Items: &schemaNode{Type: "string"}}
+	}
+	bySSHUser := func(description string, value *schemaNode) *schemaNode {
+		return &schemaNode{Type: "object", Description: description, Properties: map[string]*schemaNode{}, AdditionalProperties: value}
+	}
+	userMap := bySSHUser("SSH usernames (or \"*\") to GitHub usernames, @role references, gist: and deploy-keys: entries", list(""))
+
+	settings := func() map[string]*schemaNode {
+		return map[string]*schemaNode{
+			"user_map":            userMap,
+			"roles":               &schemaNode{Type: "object", Description: "Role names to GitHub usernames", Properties: map[string]*schemaNode{}, AdditionalProperties: list("")},
+			"deny_wildcard":       {Type: "boolean", Description: "Ignore \"*\" user map entries from every source"},
+			"exclude_comments":    bySSHUser("SSH usernames (or \"*\") to key comment glob patterns", list("")),
+			"exclude_existing":    bySSHUser("SSH usernames (or \"*\") to fingerprints or comment patterns of existing keys not to output", list("")),
+			"key_age":             bySSHUser("SSH usernames (or \"*\") to key age policies", keyAgeSchema()),
+			"deploy_key_command":  bySSHUser("SSH usernames (or \"*\") to the forced command of deploy keys", str("")),
+			"rollout":             &schemaNode{Type: "object", Description: "Feature names to rollout rules", Properties: map[string]*schemaNode{}, AdditionalProperties: rolloutSchema()},
+			"canary_fingerprints": list("SHA256 fingerprints of keys that must never show up"),
+			"canary_webhook":      str("URL called when a canary key is seen"),
+			"alert_webhook":       str("URL called when resolution degrades"),
+			"conditional_mappings": {
+				Type:        "array",
+				Description: "User maps applied only on matching hosts or in time windows",
+				Items: &schemaNode{Type: "object", Properties: map[string]*schemaNode{
+					"when":     str("Condition on host and class, e.g. class == \"prod\""),
+					"windows":  {Type: "array", Items: timeWindowSchema()},
+					"user_map": userMap,
+				}},
+			},
+			"mapping_conflicts": {Type: "string", Description: "What to do when sources configure an SSH user differently", Enum: []string{ConflictsIgnore, ConflictsWarn, ConflictsError}},
+		}
+	}
+
+	profile := settings()
+	profile["hosts"] = list("Hostname globs selecting the profile")
+	root := settings()
+	root["profiles"] = &schemaNode{
+		Type:                 "object",
+		Description:          "Profile names to settings overriding the base file's on matching hosts",
+		Properties:           map[string]*schemaNode{},
+		AdditionalProperties: &schemaNode{Type: "object", Properties: profile},
+	}
+	return &schemaNode{Type: "object", Description: "charon-key config file", Properties: root}
+}()
+
+func keyAgeSchema() *schemaNode {
+	return &schemaNode{Type: "object", Properties: map[string]*schemaNode{
+		"max_age_days":  {Type: "integer", Description: "Reject keys created more than this many days ago (0 = no limit)", Minimum: intPtr(0)},
+		"min_age_hours": {Type: "integer", Description: "Reject keys created less than this many hours ago", Minimum: intPtr(0)},
+	}}
+}
+
+func rolloutSchema() *schemaNode {
+	return &schemaNode{Type: "object", Properties: map[string]*schemaNode{
+		"percent": {Type: "integer", Description: "Share of hosts with the feature enabled", Minimum: intPtr(0), Maximum: intPtr(100)},
+		"hosts":   {Type: "array", Description: "Hostname globs with the feature always enabled", Items: &schemaNode{Type: "string"}},
+		"users":   {Type: "array", Description: "SSH usernames with the feature always enabled", Items: &schemaNode{Type: "string"}},
+	}}
+}
+
+func timeWindowSchema() *schemaNode {
+	return &schemaNode{Type: "object", Properties: map[string]*schemaNode{
+		"start":    {Type: "string", Description: "Start of an absolute window (RFC 3339)"},
+		"end":      {Type: "string", Description: "End of an absolute window (RFC 3339)"},
+		"days":     {Type: "array", Items: &schemaNode{Type: "string", Enum: []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}}},
+		"from":     {Type: "string", Description: "HH:MM time of day"},
+		"to":       {Type: "string", Description: "HH:MM time of day"},
+		"timezone": {Type: "string", Description: "IANA zone for days, from and to (default: UTC)"},
+	}}
+}
+
+// Schema returns the JSON Schema of the config file
+func Schema() []byte {
+	data, _ := json.Marshal(configSchema)
+	var out map[string]any
+	json.Unmarshal(data, &out)
+	out["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	out["title"] = "charon-key config"
+	data, _ = json.MarshalIndent(out, "", "  ")
+	return append(data, '\n')
+}
+
+// SchemaError is a config file problem, located in the file
+type SchemaError struct {
+	// File is the config file's path (empty if unknown)
+	File         string
+	Line, Column int
+
+	// Field is where in the config the problem is, e.g. user_map.alice[0]
+	// (empty for syntax errors)
+	Field   string
+	Message string
+}
+
+func (e *SchemaError) Error() string {
+	location := fmt.Sprintf("%d:%d", e.Line, e.Column)
+	if e.File != "" {
+		location = e.File + ":" + location
+	}
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %s", location, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", location, e.Field, e.Message)
+}
+
+// SchemaErrors are the problems found in a config file, in file order
+type SchemaErrors []*SchemaError
+
+func (e SchemaErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ValidateSchema checks a JSON config file against the schema, returning
+// SchemaErrors with the line, column and field of each problem (up to 10)
+func ValidateSchema(data []byte) error {
+	root, err := parseJSONNode(data)
+	if err != nil {
+		return SchemaErrors{err}
+	}
+	var problems SchemaErrors
+	configSchema.check(root, "", data, &problems)
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// jsonNode is a parsed JSON value and where it starts
+type jsonNode struct {
+	offset int
+	kind   string // object, array, string, number, boolean or null
+
+	keys       []string // object keys, in file order
+	keyOffsets map[string]int
+	fields     map[string]*jsonNode
+	items      []*jsonNode
+	number     json.Number
+	str        string
+}
+
+// parseJSONNode parses one JSON value, keeping the offset of every value
+func parseJSONNode(data []byte) (*jsonNode, *SchemaError) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	node, err := decodeNode(dec, data)
+	if err == nil {
+		if _, err = dec.Token(); err == io.EOF {
+			return node, nil
+		} else if err == nil {
+			err = fmt.Errorf("unexpected data after the top-level value")
+		}
+	}
+
+	line, column := lineColumn(data, int(dec.InputOffset()))
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line, column = lineColumn(data, int(syntaxErr.Offset))
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("unexpected end of file")
+	}
+	return nil, &SchemaError{Line: line, Column: column, Message: strings.TrimPrefix(err.Error(), "json: ")}
+}
+
+// decodeNode decodes the next value from dec
+func decodeNode(dec *json.Decoder, data []byte) (*jsonNode, error) {
+	offset := valueStart(data, int(dec.InputOffset()))
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	node := &jsonNode{offset: offset}
+
+	switch token := token.(type) {
+	case json.Delim:
+		if token == '[' {
+			node.kind = "array"
+			for dec.More() {
+				item, err := decodeNode(dec, data)
+				if err != nil {
+					return nil, err
+				}
+				node.items = append(node.items, item)
+			}
+		} else {
+			node.kind = "object"
+			node.fields = make(map[string]*jsonNode)
+			node.keyOffsets = make(map[string]int)
+			for dec.More() {
+				keyOffset := valueStart(data, int(dec.InputOffset()))
+				key, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				value, err := decodeNode(dec, data)
+				if err != nil {
+					return nil, err
+				}
+				name := key.(string)
+				if _, ok := node.fields[name]; !ok {
+					node.keys = append(node.keys, name)
+					node.keyOffsets[name] = keyOffset
+				}
+				node.fields[name] = value
+			}
+		}
+		_, err = dec.Token() // closing delimiter
+		return node, err
+	case string:
+		node.kind, node.str = "string", token
+	case json.Number:
+		node.kind, node.number = "number", token
+	case bool:
+		node.kind = "boolean"
+	case nil:
+		node.kind = "null"
+	}
+	return node, nil
+}
+
+// valueStart skips whitespace and separators from offset to where the next
+// value starts
+func valueStart(data []byte, offset int) int {
+	for offset < len(data) && strings.IndexByte(" \t\r\n:,", data[offset]) >= 0 {
+		offset++
+	}
+	return offset
+}
+
+// lineColumn converts a byte offset to a 1-based line and column
+func lineColumn(data []byte, offset int) (int, int) {
+	offset = min(offset, len(data))
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	column := offset - bytes.LastIndexByte(data[:offset], '\n')
+	return line, column
+}
+
+// check validates node against the schema, appending problems
+// null is accepted anywhere, like an absent field
+func (s *schemaNode) check(node *jsonNode, field string, data []byte, problems *SchemaErrors) {
+	reportAt := func(offset int, format string, args ...any) {
+		if len(*problems) < maxSchemaErrors {
+			line, column := lineColumn(data, offset)
+			*problems = append(*problems, &SchemaError{Line: line, Column: column, Field: field, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+	report := func(format string, args ...any) { reportAt(node.offset, format, args...) }
+	if node.kind == "null" {
+		return
+	}
+
+	kind := node.kind
+	if kind == "number" && s.Type == "integer" {
+		if _, err := node.number.Int64(); err == nil {
+			kind = "integer"
+		}
+	}
+	if kind != s.Type {
+		report("expected %s, got %s", article(s.Type), article(node.kind))
+		return
+	}
+
+	switch s.Type {
+	case "object":
+		for _, key := range node.keys {
+			child := joinField(field, key)
+			value := node.fields[key]
+			if property, ok := s.Properties[key]; ok {
+				property.check(value, child, data, problems)
+			} else if s.AdditionalProperties != nil {
+				s.AdditionalProperties.check(value, child, data, problems)
+			} else {
+				reportAt(node.keyOffsets[key], "unknown field %q%s", key, suggestField(key, s.Properties))
+			}
+		}
+	case "array":
+		for i, item := range node.items {
+			s.Items.check(item, fmt.Sprintf("%s[%d]", field, i), data, problems)
+		}
+	case "string":
+		if len(s.Enum) > 0 {
+			if !contains(s.Enum, node.str) {
+				report("%q is not one of %s", node.str, strings.Join(s.Enum, ", "))
+			}
+		}
+	case "integer":
+		n, _ := node.number.Int64()
+		if s.Minimum != nil && n < int64(*s.Minimum) {
+			report("%d is less than %d", n, *s.Minimum)
+		}
+		if s.Maximum != nil && n > int64(*s.Maximum) {
+			report("%d is more than %d", n, *s.Maximum)
+		}
+	}
+}
+
+// joinField appends an object key to a field path
+func joinField(field, key string) string {
+	if field == "" {
+		return key
+	}
+	return field + "." + key
+}
+
+// article prefixes a JSON type with "a" or "an"
+func article(kind string) string {
+	if kind == "object" || kind == "array" || kind == "integer" {
+		return "an " + kind
+	}
+	return "a " + kind
+}
+
+// suggestField returns ` (did you mean "x"?)` for the known field closest
+// to a misspelled one, if any is close enough
+func suggestField(key string, properties map[string]*schemaNode) string {
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if editDistance(key, name) <= 2 {
+			return fmt.Sprintf(" (did you mean %q?)", name)
+		}
+	}
+	return ""
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
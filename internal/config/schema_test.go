@@ -0,0 +1,120 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateSchema(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{
+			name: "valid",
+			data: `{
+  "user_map": {"alice": ["alice-github", "@ops"], "*": null},
+  "roles": {"ops": ["bob-github"]},
+  "key_age": {"*": {"max_age_days": 730}},
+  "rollout": {"fips": {"percent": 10, "hosts": ["canary-*"]}},
+  "conditional_mappings": [{"when": "class == \"prod\"", "windows": [{"days": ["mon"], "from": "09:00", "to": "17:00"}], "user_map": {"dba": ["@ops"]}}],
+  "profiles": {"bastion": {"hosts": ["bastion-*"], "user_map": {"ops": ["@ops"]}}},
+  "mapping_conflicts": "error"
+}`,
+		},
+		{
+			name:    "misspelled field",
+			data:    "{\n  \"usr_map\": {}\n}",
+			wantErr: `2:3: unknown field "usr_map" (did you mean "user_map"?)`,
+		},
+		{
+			name:    "wrong type",
+			data:    "{\n  \"user_map\": {\n    \"alice\": \"alice-github\"\n  }\n}",
+			wantErr: "3:14: user_map.alice: expected an array, got a string",
+		},
+		{
+			name:    "nested in a profile",
+			data:    `{"profiles": {"ci": {"key_age": {"*": {"max_age_days": -1}}}}}`,
+			wantErr: "1:56: profiles.ci.key_age.*.max_age_days: -1 is less than 0",
+		},
+		{
+			name:    "not an integer",
+			data:    `{"rollout": {"fips": {"percent": 12.5}}}`,
+			wantErr: "1:34: rollout.fips.percent: expected an integer, got a number",
+		},
+		{
+			name:    "enum",
+			data:    `{"mapping_conflicts": "fail"}`,
+			wantErr: `1:23: mapping_conflicts: "fail" is not one of ignore, warn, error`,
+		},
+		{
+			name:    "several problems",
+			data:    `{"roles": [], "canary_webhook": 1}`,
+			wantErr: "1:11: roles: expected an object, got an array; 1:33: canary_webhook: expected a string, got a number",
+		},
+		{
+			name:    "syntax error",
+			data:    "{\n  \"user_map\": {,}\n}",
+			wantErr: "2:17: invalid character ',' looking for beginning of value",
+		},
+		{
+			name:    "truncated",
+			data:    `{"user_map": {`,
+			wantErr: "1:15: unexpected end of JSON input",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSchema([]byte(tt.data))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateSchema() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateSchema() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchema_CoversFile(t *testing.T) {
+	// Every field of File must be in the schema, or valid files are rejected
+	fields := func(typ reflect.Type) []string {
+		var names []string
+		for i := 0; i < typ.NumField(); i++ {
+			if tag := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]; tag != "" {
+				names = append(names, tag)
+			}
+		}
+		return names
+	}
+
+	for _, name := range fields(reflect.TypeOf(File{})) {
+		if configSchema.Properties[name] == nil {
+			t.Errorf("field %q of File is missing from the schema", name)
+		}
+	}
+	profile := configSchema.Properties["profiles"].AdditionalProperties
+	for _, name := range fields(reflect.TypeOf(File{})) {
+		if name != "profiles" && profile.Properties[name] == nil {
+			t.Errorf("field %q of File is missing from the profile schema", name)
+		}
+	}
+	if len(configSchema.Properties) != len(fields(reflect.TypeOf(File{}))) {
+		t.Errorf("schema has %d properties, File has %d fields", len(configSchema.Properties), len(fields(reflect.TypeOf(File{}))))
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal(Schema(), &schema); err != nil {
+		t.Fatalf("Schema() is not JSON: %v", err)
+	}
+	if schema["$schema"] == nil || schema["additionalProperties"] != false {
+		t.Errorf("Schema() = %v, want a closed draft 2020-12 object schema", schema)
+	}
+}
@@ -0,0 +1,210 @@
+package config
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// snapshotPrefix starts every config snapshot, so LoadFile can tell
+// snapshots from JSON files, and snapshotMagic those in the current format;
+// bump the version when the format changes
+const (
+	snapshotPrefix = "charon-key config snapshot "
+	snapshotMagic  = snapshotPrefix + "v2\n"
+)
+
+// Validate checks the whole file ahead of time: roles, canaries, webhooks,
+// rollout, conditions and time windows, on its own and with each profile
+// applied. Problems that would otherwise only show up on matching hosts or
+// at certain times are reported at once
+func (f *File) Validate() error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		applied, err := f.WithProfile(name)
+		if err != nil {
+			return err
+		}
+		if err := applied.validate(); err != nil {
+			return fmt.Errorf("profile %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// validate checks the file without profiles
+func (f *File) validate() error {
+	if err := ValidateConflictPolicy(f.MappingConflicts); err != nil {
+		return err
+	}
+	// Conditional mappings may apply, so their role references must resolve too
+	userMap := appendMap(nil, f.UserMap)
+	for i, mapping := range f.ConditionalMappings {
+		if mapping.When != "" {
+			if _, err := ParseCondition(mapping.When); err != nil {
+				return fmt.Errorf("conditional_mappings[%d]: %w", i, err)
+			}
+		}
+		for _, window := range mapping.Windows {
+			if _, err := window.Active(time.Now()); err != nil {
+				return fmt.Errorf("conditional_mappings[%d]: %w", i, err)
+			}
+		}
+		userMap = appendMap(userMap, mapping.UserMap)
+	}
+
+	cfg := &Config{
+		UserMap:            userMap,
+		Roles:              f.Roles,
+		Rollout:            f.Rollout,
+		KeyAge:             f.KeyAge,
+		DeployKeyCommand:   f.DeployKeyCommand,
+		ExcludeExisting:    f.ExcludeExisting,
+		CanaryFingerprints: f.CanaryFingerprints,
+		CanaryWebhook:      f.CanaryWebhook,
+		AlertWebhook:       f.AlertWebhook,
+	}
+	if err := cfg.ValidateRoles(); err != nil {
+		return fmt.Errorf("invalid roles: %w", err)
+	}
+	if err := cfg.ValidateCanaries(); err != nil {
+		return fmt.Errorf("invalid canaries: %w", err)
+	}
+	if err := cfg.ValidateAlertWebhook(); err != nil {
+		return err
+	}
+	if err := cfg.ValidateRollout(); err != nil {
+		return fmt.Errorf("invalid rollout: %w", err)
+	}
+	if err := cfg.ValidateKeyAge(); err != nil {
+		return err
+	}
+	if err := cfg.ValidateDeployKeys(); err != nil {
+		return err
+	}
+	if err := cfg.ValidateExcludeExisting(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// WriteSnapshot writes the file as a binary snapshot that LoadFile reads
+// like the JSON file, replacing path atomically
+func (f *File) WriteSnapshot(path string) error {
+	var buf bytes.Buffer
+	buf.WriteString(snapshotMagic)
+	// gob drops fields it doesn't know, so the fields are listed first for
+	// older versions to refuse settings they would silently ignore
+	enc := gob.NewEncoder(&buf)
+	if err := enc.Encode(snapshotFields()); err != nil {
+		return fmt.Errorf("failed to encode config snapshot: %w", err)
+	}
+	if err := enc.Encode(f); err != nil {
+		return fmt.Errorf("failed to encode config snapshot: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".snapshot-*")
+	if err != nil {
+		return fmt.Errorf("failed to write config snapshot: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write config snapshot: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write config snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write config snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write config snapshot: %w", err)
+	}
+	return nil
+}
+
+// isSnapshot reports whether data is a config snapshot, of any version
+func isSnapshot(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(snapshotPrefix))
+}
+
+// decodeSnapshot parses a config snapshot, refusing other versions and
+// snapshots with fields this version doesn't know
+func decodeSnapshot(data []byte) (*File, error) {
+	if !bytes.HasPrefix(data, []byte(snapshotMagic)) {
+		return nil, fmt.Errorf("unsupported snapshot version, recompile it with this charon-key")
+	}
+	dec := gob.NewDecoder(bytes.NewReader(data[len(snapshotMagic):]))
+	var fields []string
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool)
+	for _, field := range snapshotFields() {
+		known[field] = true
+	}
+	var unknown []string
+	for _, field := range fields {
+		if !known[field] {
+			unknown = append(unknown, field)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("written by a newer charon-key with settings this version doesn't support (%s), recompile it with this charon-key", strings.Join(unknown, ", "))
+	}
+
+	var file File
+	if err := dec.Decode(&file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// snapshotFields returns the fields of File and the structs in it, as
+// Type.Field, sorted
+func snapshotFields() []string {
+	var fields []string
+	seen := make(map[reflect.Type]bool)
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		switch t.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Array:
+			walk(t.Elem())
+		case reflect.Map:
+			walk(t.Key())
+			walk(t.Elem())
+		case reflect.Struct:
+			if seen[t] {
+				return
+			}
+			seen[t] = true
+			for i := 0; i < t.NumField(); i++ {
+				field := t.Field(i)
+				if !field.IsExported() {
+					continue
+				}
+				fields = append(fields, t.Name()+"."+field.Name)
+				walk(field.Type)
+			}
+		}
+	}
+	walk(reflect.TypeOf(File{}))
+	sort.Strings(fields)
+	return fields
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFile_Validate(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      File
+		wantError bool
+	}{
+		{
+			name: "valid",
+			file: File{
+				UserMap: map[string][]string{"alice": {"@ops"}},
+				Roles:   map[string][]string{"ops": {"bob-github"}},
+				ConditionalMappings: []ConditionalMapping{
+					{When: `host =~ "db-*"`, UserMap: map[string][]string{"dba": {"@ops"}}},
+				},
+			},
+		},
+		{
+			name:      "undefined role",
+			file:      File{UserMap: map[string][]string{"alice": {"@ops"}}},
+			wantError: true,
+		},
+		{
+			name: "undefined role in conditional mapping",
+			file: File{ConditionalMappings: []ConditionalMapping{
+				{When: `host == "db-1"`, UserMap: map[string][]string{"dba": {"@dba"}}},
+			}},
+			wantError: true,
+		},
+		{
+			name: "invalid condition",
+			file: File{ConditionalMappings: []ConditionalMapping{
+				{When: `hostname == "db-1"`, UserMap: map[string][]string{"dba": {"bob"}}},
+			}},
+			wantError: true,
+		},
+		{
+			name: "invalid time window",
+			file: File{ConditionalMappings: []ConditionalMapping{
+				{Windows: []TimeWindow{{From: "25:00", To: "26:00"}}, UserMap: map[string][]string{"dba": {"bob"}}},
+			}},
+			wantError: true,
+		},
+		{
+			name: "invalid profile",
+			file: File{Profiles: map[string]Profile{
+				"bastion": {Hosts: []string{"bastion-*"}, File: File{Rollout: map[string]Rollout{"fips": {Percent: 200}}}},
+			}},
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.file.Validate(); (err != nil) != tt.wantError {
+				t.Errorf("Validate() error = %v, wantError %v", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestFile_WriteSnapshot(t *testing.T) {
+	file := &File{
+		UserMap:         map[string][]string{"alice": {"alice-github", "@ops"}},
+		Roles:           map[string][]string{"ops": {"bob-github"}},
+		ExcludeComments: map[string][]string{"*": {"*@laptop"}},
+		ConditionalMappings: []ConditionalMapping{
+			{
+				When:    `class == "prod"`,
+				Windows: []TimeWindow{{Days: []string{"mon"}, From: "09:00", To: "17:00"}},
+				UserMap: map[string][]string{"dba": {"@ops"}},
+			},
+		},
+		Profiles: map[string]Profile{
+			"bastion": {Hosts: []string{"bastion-*"}, File: File{UserMap: map[string][]string{"jump": {"@ops"}}}},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "config.snapshot")
+	if err := file.WriteSnapshot(path); err != nil {
+		t.Fatalf("WriteSnapshot() error = %v", err)
+	}
+
+	loaded, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	if !reflect.DeepEqual(loaded, file) {
+		t.Errorf("LoadFile() = %+v, want %+v", loaded, file)
+	}
+}
+
+func TestLoadFile_SnapshotFields(t *testing.T) {
+	// snapshot encodes a file as a version knowing fields would
+	snapshot := func(magic string, fields []string) []byte {
+		var buf bytes.Buffer
+		buf.WriteString(magic)
+		enc := gob.NewEncoder(&buf)
+		if fields != nil {
+			if err := enc.Encode(fields); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := enc.Encode(&File{UserMap: map[string][]string{"alice": {"alice-github"}}}); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"current", snapshot(snapshotMagic, snapshotFields()), ""},
+		{"older", snapshot(snapshotMagic, []string{"File.UserMap"}), ""},
+		{"newer", snapshot(snapshotMagic, append(snapshotFields(), "File.DenyEverything")), "File.DenyEverything"},
+		{"v1", snapshot(snapshotPrefix+"v1\n", nil), "unsupported snapshot version"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.snapshot")
+			if err := os.WriteFile(path, tt.data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			file, err := LoadFile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("LoadFile() error = %v, want it to mention %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadFile() error = %v", err)
+			}
+			if got := file.UserMap["alice"]; !reflect.DeepEqual(got, []string{"alice-github"}) {
+				t.Errorf("LoadFile() user map = %v", file.UserMap)
+			}
+		})
+	}
+}
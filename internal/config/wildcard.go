@@ -0,0 +1,53 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// WildcardConflicts reports the "*" mappings deny_wildcard overrides: in the
+// base file and every profile if the base file sets it, or in the base file
+// and the profile if only a profile does
+// Such mappings are ignored at runtime, which is likely not what whoever
+// added them meant
+func (f *File) WildcardConflicts() []string {
+	base := wildcardMappings("", f)
+
+	names := make([]string, 0, len(f.Profiles))
+	for name := range f.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var conflicts []string
+	if f.DenyWildcard {
+		conflicts = append(conflicts, base...)
+	}
+	for _, name := range names {
+		profile := f.Profiles[name]
+		if !f.DenyWildcard && !profile.DenyWildcard {
+			continue
+		}
+		if !f.DenyWildcard {
+			for _, place := range base {
+				conflicts = append(conflicts, fmt.Sprintf("%s (with profile %q, which sets deny_wildcard)", place, name))
+			}
+		}
+		conflicts = append(conflicts, wildcardMappings(fmt.Sprintf("profiles.%s.", name), &profile.File)...)
+	}
+	return conflicts
+}
+
+// wildcardMappings lists where a file maps "*", with prefix before each place
+func wildcardMappings(prefix string, f *File) []string {
+	var places []string
+	if _, ok := f.UserMap["*"]; ok {
+		places = append(places, prefix+`user_map["*"]`)
+	}
+	for i, mapping := range f.ConditionalMappings {
+		if _, ok := mapping.UserMap["*"]; ok {
+			places = append(places, fmt.Sprintf(`%sconditional_mappings[%d].user_map["*"]`, prefix, i))
+		}
+	}
+	return places
+}
@@ -0,0 +1,65 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFile_WildcardConflicts(t *testing.T) {
+	wildcard := map[string][]string{"*": {"@ops"}}
+	conditional := []ConditionalMapping{
+		{When: `class == "dev"`, UserMap: map[string][]string{"deploy": {"@ops"}}},
+		{When: `class == "prod"`, UserMap: wildcard},
+	}
+
+	tests := []struct {
+		name string
+		file File
+		want []string
+	}{
+		{
+			name: "wildcard allowed",
+			file: File{UserMap: wildcard},
+		},
+		{
+			name: "deny_wildcard without wildcard mappings",
+			file: File{DenyWildcard: true, UserMap: map[string][]string{"deploy": {"@ops"}}},
+		},
+		{
+			name: "deny_wildcard in the base file",
+			file: File{
+				DenyWildcard:        true,
+				UserMap:             wildcard,
+				ConditionalMappings: conditional,
+				Profiles:            map[string]Profile{"bastion": {File: File{UserMap: wildcard}}},
+			},
+			want: []string{
+				`user_map["*"]`,
+				`conditional_mappings[1].user_map["*"]`,
+				`profiles.bastion.user_map["*"]`,
+			},
+		},
+		{
+			name: "deny_wildcard in a profile",
+			file: File{
+				UserMap: wildcard,
+				Profiles: map[string]Profile{
+					"bastion": {File: File{DenyWildcard: true, ConditionalMappings: conditional}},
+					"dev":     {File: File{UserMap: wildcard}},
+				},
+			},
+			want: []string{
+				`user_map["*"] (with profile "bastion", which sets deny_wildcard)`,
+				`profiles.bastion.conditional_mappings[1].user_map["*"]`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.file.WildcardConflicts(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("WildcardConflicts() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
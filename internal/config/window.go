@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a period during which a conditional mapping is active:
+// either absolute (Start/End, e.g. an on-call shift or change window) or
+// weekly recurring (Days/From/To, e.g. business hours)
+type TimeWindow struct {
+	// Start and End bound an absolute window (RFC 3339)
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+
+	// Days are the weekdays the recurring window starts on ("mon".."sun";
+	// empty = every day)
+	Days []string `json:"days,omitempty"`
+
+	// From and To are "HH:MM" times of day; a To before From ends the next
+	// day, and an empty To or one equal to From makes a 24h window (From
+	// defaults to 00:00, so days alone means all day on those days)
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+
+	// Timezone is the IANA zone for Days/From/To (default: UTC)
+	Timezone string `json:"timezone,omitempty"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Active reports whether t falls within the window
+func (w TimeWindow) Active(t time.Time) (bool, error) {
+	absolute := w.Start != "" || w.End != ""
+	recurring := w.From != "" || w.To != "" || len(w.Days) > 0
+	if absolute == recurring {
+		return false, fmt.Errorf("time window needs either start/end or from/to")
+	}
+
+	if absolute {
+		start, err := time.Parse(time.RFC3339, w.Start)
+		if err != nil {
+			return false, fmt.Errorf("invalid window start %q: %w", w.Start, err)
+		}
+		end, err := time.Parse(time.RFC3339, w.End)
+		if err != nil {
+			return false, fmt.Errorf("invalid window end %q: %w", w.End, err)
+		}
+		return !t.Before(start) && t.Before(end), nil
+	}
+
+	location := time.UTC
+	if w.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(w.Timezone); err != nil {
+			return false, fmt.Errorf("invalid window timezone %q: %w", w.Timezone, err)
+		}
+	}
+	from := 0
+	if w.From != "" {
+		var err error
+		if from, err = parseTimeOfDay(w.From); err != nil {
+			return false, err
+		}
+	}
+	to := from
+	if w.To != "" {
+		var err error
+		if to, err = parseTimeOfDay(w.To); err != nil {
+			return false, err
+		}
+	}
+	days := make(map[time.Weekday]bool)
+	for _, day := range w.Days {
+		weekday, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return false, fmt.Errorf("invalid window day %q (valid: mon, tue, wed, thu, fri, sat, sun)", day)
+		}
+		days[weekday] = true
+	}
+	startsOn := func(day time.Weekday) bool { return len(days) == 0 || days[day] }
+
+	local := t.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	if from < to {
+		return startsOn(local.Weekday()) && minute >= from && minute < to, nil
+	}
+	// Overnight or 24h: the window started today, or started yesterday and
+	// runs on
+	yesterday := (local.Weekday() + 6) % 7
+	return (startsOn(local.Weekday()) && minute >= from) || (startsOn(yesterday) && minute < to), nil
+}
+
+// parseTimeOfDay parses "HH:MM" into minutes after midnight
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q (expected HH:MM)", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// activeWindow reports whether t falls within any of the windows
+// No windows means always active
+func activeWindow(windows []TimeWindow, t time.Time) (bool, error) {
+	if len(windows) == 0 {
+		return true, nil
+	}
+	for _, window := range windows {
+		active, err := window.Active(t)
+		if err != nil {
+			return false, err
+		}
+		if active {
+			return true, nil
+		}
+	}
+	return false, nil
+}
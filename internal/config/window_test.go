@@ -0,0 +1,149 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeWindow_Active(t *testing.T) {
+	// 2024-05-01 is a Wednesday
+	at := func(s string) time.Time {
+		t, _ := time.Parse(time.RFC3339, s)
+		return t
+	}
+
+	tests := []struct {
+		name      string
+		window    TimeWindow
+		now       time.Time
+		want      bool
+		wantError bool
+	}{
+		{
+			name:   "absolute inside",
+			window: TimeWindow{Start: "2024-05-01T08:00:00Z", End: "2024-05-08T08:00:00Z"},
+			now:    at("2024-05-03T12:00:00Z"),
+			want:   true,
+		},
+		{
+			name:   "absolute expired",
+			window: TimeWindow{Start: "2024-05-01T08:00:00Z", End: "2024-05-08T08:00:00Z"},
+			now:    at("2024-05-08T08:00:00Z"),
+			want:   false,
+		},
+		{
+			name:   "business hours",
+			window: TimeWindow{Days: []string{"mon", "tue", "wed", "thu", "fri"}, From: "09:00", To: "17:00"},
+			now:    at("2024-05-01T10:30:00Z"),
+			want:   true,
+		},
+		{
+			name:   "business hours on saturday",
+			window: TimeWindow{Days: []string{"mon", "tue", "wed", "thu", "fri"}, From: "09:00", To: "17:00"},
+			now:    at("2024-05-04T10:30:00Z"),
+			want:   false,
+		},
+		{
+			name:   "timezone",
+			window: TimeWindow{From: "09:00", To: "17:00", Timezone: "America/New_York"},
+			now:    at("2024-05-01T10:30:00Z"), // 06:30 in New York
+			want:   false,
+		},
+		{
+			name:   "overnight after midnight",
+			window: TimeWindow{Days: []string{"fri"}, From: "22:00", To: "06:00"},
+			now:    at("2024-05-04T02:00:00Z"), // Saturday, window started Friday
+			want:   true,
+		},
+		{
+			name:   "overnight wrong start day",
+			window: TimeWindow{Days: []string{"fri"}, From: "22:00", To: "06:00"},
+			now:    at("2024-05-02T02:00:00Z"), // Thursday, started Wednesday
+			want:   false,
+		},
+		{
+			name:   "days only",
+			window: TimeWindow{Days: []string{"sat", "sun"}},
+			now:    at("2024-05-04T23:59:00Z"),
+			want:   true,
+		},
+		{
+			name:   "days only on a weekday",
+			window: TimeWindow{Days: []string{"sat", "sun"}},
+			now:    at("2024-05-03T12:00:00Z"),
+			want:   false,
+		},
+		{
+			name:   "same from and to",
+			window: TimeWindow{Days: []string{"wed"}, From: "00:00", To: "00:00"},
+			now:    at("2024-05-01T12:00:00Z"),
+			want:   true,
+		},
+		{
+			name:   "same from and to runs 24h",
+			window: TimeWindow{Days: []string{"wed"}, From: "09:00", To: "09:00"},
+			now:    at("2024-05-02T08:59:00Z"), // Thursday, started Wednesday
+			want:   true,
+		},
+		{
+			name:   "same from and to ends after 24h",
+			window: TimeWindow{Days: []string{"wed"}, From: "09:00", To: "09:00"},
+			now:    at("2024-05-02T09:00:00Z"),
+			want:   false,
+		},
+		{
+			name:   "from without to",
+			window: TimeWindow{From: "09:00"},
+			now:    at("2024-05-01T08:00:00Z"),
+			want:   true,
+		},
+		{
+			name:      "mixed kinds",
+			window:    TimeWindow{Start: "2024-05-01T08:00:00Z", From: "09:00"},
+			wantError: true,
+		},
+		{
+			name:      "invalid day",
+			window:    TimeWindow{Days: []string{"monday"}, From: "09:00", To: "17:00"},
+			wantError: true,
+		},
+		{
+			name:      "invalid time",
+			window:    TimeWindow{From: "9am", To: "17:00"},
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.window.Active(tt.now)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("Active() error = %v, wantError %v", err, tt.wantError)
+			}
+			if got != tt.want {
+				t.Errorf("Active() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFile_ConditionalUserMap_Windows(t *testing.T) {
+	file := &File{
+		ConditionalMappings: []ConditionalMapping{
+			{
+				Windows: []TimeWindow{{Start: "2024-05-01T08:00:00Z", End: "2024-05-08T08:00:00Z"}},
+				UserMap: map[string][]string{"oncall": {"alice-github"}},
+			},
+		},
+	}
+
+	during, _ := time.Parse(time.RFC3339, "2024-05-02T00:00:00Z")
+	if got, err := file.ConditionalUserMap("web-1", "", during); err != nil || len(got["oncall"]) != 1 {
+		t.Errorf("ConditionalUserMap() = %v, %v, want the on-call mapping", got, err)
+	}
+
+	after, _ := time.Parse(time.RFC3339, "2024-05-09T00:00:00Z")
+	if got, err := file.ConditionalUserMap("web-1", "", after); err != nil || len(got) != 0 {
+		t.Errorf("ConditionalUserMap() = %v, %v, want no mappings after the window", got, err)
+	}
+}
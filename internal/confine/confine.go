@@ -0,0 +1,304 @@
+// Package confine generates AppArmor profiles and SELinux policy modules
+// confining charon-key to the paths it is configured with, for sshd setups
+// that run AuthorizedKeysCommand under a mandatory access control policy
+package confine
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Paths are the files charon-key touches; paths may use the sshd tokens %u
+// (SSH username) and %h (its home directory)
+type Paths struct {
+	// Executable is the charon-key binary
+	Executable string
+
+	// ReadHelper is the charon-key-read-keys binary run with --read-helper,
+	// in charon-key's confinement; its file capability needs
+	// dac_read_search
+	ReadHelper string
+
+	// Getent is set when users may be looked up with getent
+	// (--passwd-source auto or getent)
+	Getent bool
+
+	// LDAP is set when SSH users are mapped with ldapsearch (--ldap-url)
+	LDAP bool
+
+	// Keyring is set when credentials are read from the OS keyring with
+	// secret-tool (keyring: sources)
+	Keyring bool
+
+	// Unprivileged is set with --unprivileged: charon-key doesn't read other
+	// users' files itself, so only ReadHelper needs dac_read_search
+	Unprivileged bool
+
+	// Config are configuration, credential and key files, only read
+	Config []string
+
+	// CacheDir is read and written, with everything in it
+	CacheDir string
+
+	// KeyFiles are authorized_keys files only read
+	KeyFiles []string
+
+	// WrittenKeyFiles are authorized_keys, principals and --output files
+	// written for sshd to read: each is replaced through a temp file next to
+	// it, and backups may be kept beside it
+	WrittenKeyFiles []string
+
+	// Logs are log files (rotated beside themselves) and reports written
+	Logs []string
+
+	// Chown is set when written files are handed to the SSH user (sync mode)
+	Chown bool
+}
+
+// AppArmor returns an AppArmor profile for charon-key
+func AppArmor(p Paths) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# AppArmor profile for charon-key, generated by charon-key print-apparmor\n")
+	fmt.Fprintf(&b, "# Install in /etc/apparmor.d/ and load with apparmor_parser -r\n")
+	fmt.Fprintf(&b, "#include <tunables/global>\n\n")
+	fmt.Fprintf(&b, "profile charon-key %s {\n", p.Executable)
+	fmt.Fprintf(&b, "  #include <abstractions/base>\n")
+	fmt.Fprintf(&b, "  #include <abstractions/nameservice>\n")
+	fmt.Fprintf(&b, "  #include <abstractions/ssl_certs>\n\n")
+	fmt.Fprintf(&b, "  network inet stream,\n")
+	fmt.Fprintf(&b, "  network inet6 stream,\n")
+	fmt.Fprintf(&b, "  network inet dgram,\n")
+	fmt.Fprintf(&b, "  network inet6 dgram,\n")
+	if p.Keyring {
+		fmt.Fprintf(&b, "\n  # secret-tool asks the Secret Service over the session bus\n")
+		fmt.Fprintf(&b, "  #include <abstractions/dbus-session-strict>\n")
+		fmt.Fprintf(&b, "  dbus bus=session,\n")
+	}
+	if caps := capabilities(p); len(caps) > 0 {
+		fmt.Fprintf(&b, "\n")
+		for _, c := range caps {
+			fmt.Fprintf(&b, "  capability %s,\n", c)
+		}
+	}
+	fmt.Fprintf(&b, "\n  %s mr,\n", p.Executable)
+	for _, command := range commands(p) {
+		fmt.Fprintf(&b, "  /{usr/,}bin/%s ix,\n", command)
+	}
+	if p.ReadHelper != "" {
+		fmt.Fprintf(&b, "  %s ix,\n", p.ReadHelper)
+	}
+
+	section(&b, "Configuration and credentials", p.Config, func(path string) []string {
+		return []string{appArmorPath(path) + " r,"}
+	})
+	if p.CacheDir != "" {
+		dir := appArmorPath(strings.TrimRight(p.CacheDir, "/"))
+		section(&b, "Cache", []string{dir}, func(dir string) []string {
+			return []string{dir + "/ rw,", dir + "/** rwkm,"}
+		})
+	}
+	section(&b, "authorized_keys files read", p.KeyFiles, func(path string) []string {
+		return []string{appArmorPath(path) + " r,"}
+	})
+	section(&b, "Key files written, through temp files and with backups", p.WrittenKeyFiles, appArmorWritten)
+	section(&b, "Logs and reports, rotated beside themselves", p.Logs, func(path string) []string {
+		dir, base := filepath.Split(path)
+		return []string{appArmorPath(dir) + "{,.}" + appArmorPath(base) + "{,.*} rw,"}
+	})
+	fmt.Fprintf(&b, "}\n")
+	return b.String()
+}
+
+// commands returns the programs on PATH charon-key runs; the read helper
+// looks its user up too
+func commands(p Paths) []string {
+	var result []string
+	if p.Getent || p.ReadHelper != "" {
+		result = append(result, "getent")
+	}
+	if p.LDAP {
+		result = append(result, "ldapsearch")
+	}
+	if p.Keyring {
+		result = append(result, "secret-tool")
+	}
+	return result
+}
+
+// capabilities returns the capabilities charon-key needs: reading other
+// users' key files unless unprivileged (the read helper's file capability
+// is still bounded by the confinement), and handing files to them
+func capabilities(p Paths) []string {
+	var caps []string
+	if !p.Unprivileged || p.ReadHelper != "" {
+		caps = append(caps, "dac_read_search")
+	}
+	if p.Chown {
+		caps = append(caps, "chown", "dac_override", "fowner")
+	}
+	sort.Strings(caps)
+	return caps
+}
+
+// appArmorWritten returns the rules for a file replaced atomically: the
+// directory (created if missing, and listed for backups), the file, its
+// temp files and backups
+func appArmorWritten(path string) []string {
+	dir, base := filepath.Split(path)
+	return []string{
+		appArmorPath(dir) + " rw,",
+		appArmorPath(dir) + "{,.}" + appArmorPath(base) + "{,.*} rw,",
+	}
+}
+
+// appArmorPath converts the sshd tokens of a path to AppArmor globs
+func appArmorPath(path string) string {
+	return strings.NewReplacer("%%", "%", "%h", "@{HOME}", "%u", "*").Replace(path)
+}
+
+// SELinuxTE returns a reference-policy style SELinux policy module for
+// charon-key, followed by the semanage commands labeling its paths
+func SELinuxTE(p Paths) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# SELinux policy module for charon-key, generated by charon-key print-selinux-te\n")
+	fmt.Fprintf(&b, "# Build and load with:\n")
+	fmt.Fprintf(&b, "#   make -f /usr/share/selinux/devel/Makefile charon_key.pp && semodule -i charon_key.pp\n")
+	fmt.Fprintf(&b, "# then label the paths with the semanage commands at the end\n\n")
+	fmt.Fprintf(&b, "policy_module(charon_key, 1.0.0)\n\n")
+	fmt.Fprintf(&b, "gen_require(`\n\ttype sshd_t;\n\ttype ssh_home_t;\n')\n\n")
+
+	fmt.Fprintf(&b, "type charon_key_t;\ntype charon_key_exec_t;\n")
+	fmt.Fprintf(&b, "application_domain(charon_key_t, charon_key_exec_t)\n")
+	fmt.Fprintf(&b, "role system_r types charon_key_t;\n\n")
+	fmt.Fprintf(&b, "# sshd runs charon-key as its AuthorizedKeysCommand\n")
+	fmt.Fprintf(&b, "domain_auto_trans(sshd_t, charon_key_exec_t, charon_key_t)\n")
+	fmt.Fprintf(&b, "allow charon_key_t sshd_t:fd use;\n")
+	fmt.Fprintf(&b, "allow charon_key_t sshd_t:fifo_file rw_fifo_file_perms;\n")
+	if p.ReadHelper != "" {
+		fmt.Fprintf(&b, "# charon-key-read-keys runs in charon-key's domain\n")
+		fmt.Fprintf(&b, "can_exec(charon_key_t, charon_key_exec_t)\n")
+	}
+	fmt.Fprintf(&b, "\n")
+
+	fmt.Fprintf(&b, "type charon_key_conf_t;\nfiles_config_file(charon_key_conf_t)\n")
+	fmt.Fprintf(&b, "read_files_pattern(charon_key_t, charon_key_conf_t, charon_key_conf_t)\n\n")
+	fmt.Fprintf(&b, "type charon_key_cache_t;\nfiles_type(charon_key_cache_t)\n")
+	fmt.Fprintf(&b, "manage_dirs_pattern(charon_key_t, charon_key_cache_t, charon_key_cache_t)\n")
+	fmt.Fprintf(&b, "manage_files_pattern(charon_key_t, charon_key_cache_t, charon_key_cache_t)\n")
+	fmt.Fprintf(&b, "allow charon_key_t charon_key_cache_t:file { lock map };\n\n")
+	fmt.Fprintf(&b, "type charon_key_log_t;\nlogging_log_file(charon_key_log_t)\n")
+	fmt.Fprintf(&b, "manage_files_pattern(charon_key_t, charon_key_log_t, charon_key_log_t)\n\n")
+
+	fmt.Fprintf(&b, "# authorized_keys and principals files are ssh_home_t, which sshd reads\n")
+	if len(p.WrittenKeyFiles) > 0 {
+		fmt.Fprintf(&b, "manage_dirs_pattern(charon_key_t, ssh_home_t, ssh_home_t)\n")
+		fmt.Fprintf(&b, "manage_files_pattern(charon_key_t, ssh_home_t, ssh_home_t)\n")
+	} else {
+		fmt.Fprintf(&b, "read_files_pattern(charon_key_t, ssh_home_t, ssh_home_t)\n")
+	}
+	switch caps := capabilities(p); len(caps) {
+	case 0:
+	case 1:
+		fmt.Fprintf(&b, "allow charon_key_t self:capability %s;\n", caps[0])
+	default:
+		fmt.Fprintf(&b, "allow charon_key_t self:capability { %s };\n", strings.Join(caps, " "))
+	}
+	fmt.Fprintf(&b, "userdom_search_user_home_dirs(charon_key_t)\n\n")
+
+	fmt.Fprintf(&b, "# Fetching keys from GitHub, and looking users up\n")
+	fmt.Fprintf(&b, "allow charon_key_t self:tcp_socket create_stream_socket_perms;\n")
+	fmt.Fprintf(&b, "allow charon_key_t self:udp_socket create_socket_perms;\n")
+	fmt.Fprintf(&b, "corenet_tcp_connect_http_port(charon_key_t)\n")
+	fmt.Fprintf(&b, "sysnet_dns_name_resolve(charon_key_t)\n")
+	fmt.Fprintf(&b, "miscfiles_read_generic_certs(charon_key_t)\n")
+	fmt.Fprintf(&b, "auth_use_nsswitch(charon_key_t)\n")
+	fmt.Fprintf(&b, "files_read_etc_files(charon_key_t)\n")
+	if commands := commands(p); len(commands) > 0 {
+		fmt.Fprintf(&b, "# Running %s\n", strings.Join(commands, ", "))
+		fmt.Fprintf(&b, "corecmd_exec_bin(charon_key_t)\n")
+	}
+	if p.LDAP {
+		fmt.Fprintf(&b, "corenet_tcp_connect_ldap_port(charon_key_t)\n")
+	}
+	if p.Keyring {
+		fmt.Fprintf(&b, "optional_policy(`\n\tdbus_all_session_bus_client(charon_key_t)\n')\n")
+	}
+	fmt.Fprintf(&b, "\n")
+
+	fmt.Fprintf(&b, "# File contexts (paths under home directories are ssh_home_t already):\n")
+	var labeled []string
+	label := func(t, spec string) {
+		labeled = append(labeled, fmt.Sprintf("#   semanage fcontext -a -t %s '%s'", t, spec))
+	}
+	label("charon_key_exec_t", selinuxRegexp(p.Executable))
+	if p.ReadHelper != "" {
+		label("charon_key_exec_t", selinuxRegexp(p.ReadHelper))
+	}
+	for _, path := range unique(p.Config) {
+		label("charon_key_conf_t", selinuxRegexp(path))
+	}
+	if p.CacheDir != "" {
+		label("charon_key_cache_t", selinuxRegexp(strings.TrimRight(p.CacheDir, "/"))+"(/.*)?")
+	}
+	for _, path := range unique(p.Logs) {
+		// Rotated logs and temp files live beside the file
+		label("charon_key_log_t", selinuxRegexp(filepath.Dir(path))+"/\\.?"+selinuxRegexp(filepath.Base(path))+".*")
+	}
+	for _, path := range unique(append(append([]string{}, p.KeyFiles...), p.WrittenKeyFiles...)) {
+		if !strings.HasPrefix(path, "%h") {
+			label("ssh_home_t", selinuxRegexp(filepath.Dir(path))+"(/.*)?")
+		}
+	}
+	for _, line := range unique(labeled) {
+		fmt.Fprintln(&b, line)
+	}
+	fmt.Fprintf(&b, "# and restorecon -R -v each of them\n")
+	return b.String()
+}
+
+// selinuxRegexp converts a path to a file context regular expression, with
+// %u matching any SSH username and %h (inside a chroot directory) any home
+func selinuxRegexp(path string) string {
+	parts := strings.Split(path, "%u")
+	for i, part := range parts {
+		homes := strings.Split(part, "%h")
+		for j, home := range homes {
+			homes[j] = regexp.QuoteMeta(strings.ReplaceAll(home, "%%", "%"))
+		}
+		parts[i] = strings.Join(homes, "/.+")
+	}
+	return strings.Join(parts, "[^/]+")
+}
+
+// section writes a commented group of rules, one or more per path
+func section(b *strings.Builder, title string, paths []string, rules func(string) []string) {
+	paths = unique(paths)
+	if len(paths) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "\n  # %s\n", title)
+	var lines []string
+	for _, path := range paths {
+		lines = append(lines, rules(path)...)
+	}
+	for _, line := range unique(lines) {
+		fmt.Fprintf(b, "  %s\n", line)
+	}
+}
+
+// unique returns the non-empty strings, sorted and without duplicates
+func unique(items []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, item := range items {
+		if item != "" && !seen[item] {
+			seen[item] = true
+			result = append(result, item)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,137 @@
+package confine
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testPaths() Paths {
+	return Paths{
+		Executable:      "/usr/local/bin/charon-key",
+		ReadHelper:      "/usr/local/libexec/charon-key-read-keys",
+		Getent:          true,
+		LDAP:            true,
+		Config:          []string{"/etc/charon-key.json", "", "/etc/charon-key.json"},
+		CacheDir:        "/var/cache/charon-key/",
+		KeyFiles:        []string{"%h/.ssh/authorized_keys2"},
+		WrittenKeyFiles: []string{"/etc/ssh/authorized_keys/%u", "%h/.ssh/authorized_keys"},
+		Logs:            []string{"/var/log/charon-key.log"},
+		Chown:           true,
+	}
+}
+
+func TestAppArmor(t *testing.T) {
+	profile := AppArmor(testPaths())
+
+	for _, want := range []string{
+		"profile charon-key /usr/local/bin/charon-key {\n",
+		"  /usr/local/bin/charon-key mr,\n",
+		"  /usr/local/libexec/charon-key-read-keys ix,\n",
+		"  /{usr/,}bin/getent ix,\n",
+		"  /{usr/,}bin/ldapsearch ix,\n",
+		"  /var/cache/charon-key/** rwkm,\n",
+		"  @{HOME}/.ssh/authorized_keys2 r,\n",
+		"  /etc/ssh/authorized_keys/ rw,\n",
+		"  /etc/ssh/authorized_keys/{,.}*{,.*} rw,\n",
+		"  @{HOME}/.ssh/{,.}authorized_keys{,.*} rw,\n",
+		"  /var/log/{,.}charon-key.log{,.*} rw,\n",
+		"  capability chown,\n",
+	} {
+		if !strings.Contains(profile, want) {
+			t.Errorf("AppArmor() is missing %q:\n%s", want, profile)
+		}
+	}
+	if strings.Count(profile, "/etc/charon-key.json r,") != 1 {
+		t.Errorf("AppArmor() doesn't list the config file once:\n%s", profile)
+	}
+	if strings.Contains(profile, "/var/log/ rw,") {
+		t.Errorf("AppArmor() lets the log directory be written:\n%s", profile)
+	}
+
+	if strings.Contains(profile, "secret-tool") || strings.Contains(profile, "dbus") {
+		t.Errorf("AppArmor() without Keyring allows secret-tool:\n%s", profile)
+	}
+
+	readOnly := testPaths()
+	readOnly.Chown = false
+	if profile := AppArmor(readOnly); strings.Contains(profile, "capability chown") {
+		t.Errorf("AppArmor() without Chown allows chown:\n%s", profile)
+	}
+
+	keyring := testPaths()
+	keyring.Keyring = true
+	if profile := AppArmor(keyring); !strings.Contains(profile, "  /{usr/,}bin/secret-tool ix,\n") ||
+		!strings.Contains(profile, "  dbus bus=session,\n") {
+		t.Errorf("AppArmor() with Keyring doesn't allow secret-tool:\n%s", profile)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Paths
+		want []string
+	}{
+		{"root", Paths{}, []string{"dac_read_search"}},
+		{"sync", Paths{Chown: true}, []string{"chown", "dac_override", "dac_read_search", "fowner"}},
+		{"unprivileged", Paths{Unprivileged: true}, nil},
+		{"unprivileged with read helper", Paths{Unprivileged: true, ReadHelper: "/usr/local/libexec/charon-key-read-keys"}, []string{"dac_read_search"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := capabilities(tt.p); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("capabilities() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	unprivileged := Paths{Executable: "/usr/local/bin/charon-key", Unprivileged: true}
+	if profile := AppArmor(unprivileged); strings.Contains(profile, "capability") {
+		t.Errorf("AppArmor() with Unprivileged allows capabilities:\n%s", profile)
+	}
+	if module := SELinuxTE(unprivileged); strings.Contains(module, "self:capability") {
+		t.Errorf("SELinuxTE() with Unprivileged allows capabilities:\n%s", module)
+	}
+}
+
+func TestSELinuxTE(t *testing.T) {
+	module := SELinuxTE(testPaths())
+
+	for _, want := range []string{
+		"policy_module(charon_key, 1.0.0)\n",
+		"domain_auto_trans(sshd_t, charon_key_exec_t, charon_key_t)\n",
+		"can_exec(charon_key_t, charon_key_exec_t)\n",
+		"# Running getent, ldapsearch\ncorecmd_exec_bin(charon_key_t)\n",
+		"corenet_tcp_connect_ldap_port(charon_key_t)\n",
+		"semanage fcontext -a -t charon_key_exec_t '/usr/local/libexec/charon-key-read-keys'\n",
+		"manage_files_pattern(charon_key_t, ssh_home_t, ssh_home_t)\n",
+		"allow charon_key_t self:capability { chown dac_override dac_read_search fowner };\n",
+		"semanage fcontext -a -t charon_key_exec_t '/usr/local/bin/charon-key'\n",
+		"semanage fcontext -a -t charon_key_conf_t '/etc/charon-key\\.json'\n",
+		"semanage fcontext -a -t charon_key_cache_t '/var/cache/charon-key(/.*)?'\n",
+		"semanage fcontext -a -t charon_key_log_t '/var/log/\\.?charon-key\\.log.*'\n",
+		"semanage fcontext -a -t ssh_home_t '/etc/ssh/authorized_keys(/.*)?'\n",
+	} {
+		if !strings.Contains(module, want) {
+			t.Errorf("SELinuxTE() is missing %q:\n%s", want, module)
+		}
+	}
+	// Home directories are labeled by the base policy
+	if strings.Contains(module, "%h") {
+		t.Errorf("SELinuxTE() labels home directory paths:\n%s", module)
+	}
+}
+
+func TestSELinuxRegexp(t *testing.T) {
+	tests := map[string]string{
+		"/etc/ssh/authorized_keys/%u": "/etc/ssh/authorized_keys/[^/]+",
+		"/srv/keys+%%/a.b":            `/srv/keys\+%/a\.b`,
+		"/srv/sftp/%u%h/.ssh":         `/srv/sftp/[^/]+/.+/\.ssh`,
+	}
+	for path, want := range tests {
+		if got := selinuxRegexp(path); got != want {
+			t.Errorf("selinuxRegexp(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
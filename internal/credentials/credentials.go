@@ -0,0 +1,187 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Source kinds
+const (
+	// KindFile reads the secret from a file only its owner can access
+	KindFile = "file"
+	// KindEnv reads the secret from an environment variable
+	KindEnv = "env"
+	// KindKeyring reads the secret from the OS keyring (libsecret's
+	// secret-tool on Linux, the keychain on macOS)
+	KindKeyring = "keyring"
+	// KindVault reads the secret from a HashiCorp Vault KV secret
+	KindVault = "vault"
+)
+
+// KeyringTimeout bounds a keyring lookup
+const KeyringTimeout = 5 * time.Second
+
+// Source says where a secret is stored without containing it, so sources are
+// safe to pass as flags (unlike the secret itself, which would show in ps)
+// Format: "file:/etc/charon-key/token", "env:GITHUB_TOKEN",
+// "keyring:service/account" or "vault:secret/data/charon-key#github_token"
+type Source struct {
+	Kind     string
+	Location string
+}
+
+// ParseSource parses a "kind:location" source
+func ParseSource(spec string) (Source, error) {
+	kind, location, ok := strings.Cut(spec, ":")
+	if !ok || location == "" {
+		return Source{}, fmt.Errorf("invalid credential source %q (expected file:PATH, env:NAME, keyring:SERVICE/ACCOUNT or vault:PATH#FIELD)", spec)
+	}
+
+	switch kind {
+	case KindFile, KindEnv:
+	case KindKeyring:
+		service, account, ok := strings.Cut(location, "/")
+		if !ok || service == "" || account == "" {
+			return Source{}, fmt.Errorf("invalid keyring source %q (expected keyring:SERVICE/ACCOUNT)", spec)
+		}
+	case KindVault:
+		path, field, ok := strings.Cut(location, "#")
+		if !ok || path == "" || field == "" {
+			return Source{}, fmt.Errorf("invalid vault source %q (expected vault:PATH#FIELD)", spec)
+		}
+	default:
+		return Source{}, fmt.Errorf("unknown credential source kind %q (valid: file, env, keyring, vault)", kind)
+	}
+
+	return Source{Kind: kind, Location: location}, nil
+}
+
+func (s Source) String() string {
+	return s.Kind + ":" + s.Location
+}
+
+// Load reads the secret like Loader.Load, taking the Vault server (for vault
+// sources) from the environment
+func (s Source) Load() (string, error) {
+	return Loader{}.Load(s)
+}
+
+// Loader loads secrets from sources
+type Loader struct {
+	// Vault is used for vault sources (nil = VaultFromEnv)
+	Vault *Vault
+}
+
+// Load reads the secret, with surrounding whitespace trimmed
+// An empty secret is an error
+func (l Loader) Load(s Source) (string, error) {
+	var secret string
+	var err error
+	switch s.Kind {
+	case KindFile:
+		secret, err = LoadFile(s.Location)
+	case KindEnv:
+		secret = os.Getenv(s.Location)
+	case KindKeyring:
+		service, account, _ := strings.Cut(s.Location, "/")
+		secret, err = LoadKeyring(service, account)
+	case KindVault:
+		secret, err = l.loadVault(s.Location)
+	default:
+		err = fmt.Errorf("unknown credential source kind %q", s.Kind)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		return "", fmt.Errorf("credential from %s is empty", s)
+	}
+	return secret, nil
+}
+
+// loadVault reads a "PATH#FIELD" secret from Vault
+func (l Loader) loadVault(location string) (string, error) {
+	vault := l.Vault
+	if vault == nil {
+		var err error
+		if vault, err = VaultFromEnv(); err != nil {
+			return "", err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), VaultTimeout)
+	defer cancel()
+	path, field, _ := strings.Cut(location, "#")
+	return vault.Read(ctx, path, field)
+}
+
+// LoadFile reads a secret from a file, refusing files that other users could
+// read or that someone other than the owner could replace: the file must not
+// be accessible by others or writable by its group, and must be owned by the
+// current user or root
+func LoadFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to stat credential file: %w", err)
+	}
+	if mode := info.Mode().Perm(); mode&0027 != 0 {
+		return "", fmt.Errorf("credential file %s has unsafe permissions %04o (must not be accessible by others or writable by group)", path, mode)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if uid := int(stat.Uid); uid != 0 && uid != os.Geteuid() {
+			return "", fmt.Errorf("credential file %s is owned by uid %d (must be owned by %d or root)", path, uid, os.Geteuid())
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read credential file: %w", err)
+	}
+	return string(data), nil
+}
+
+// keyringCommand returns the command printing a keyring secret
+// Overridden in tests
+var keyringCommand = func(service, account string) (string, []string, error) {
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		return "secret-tool", []string{"lookup", "service", service, "account", account}, nil
+	case "darwin":
+		return "security", []string{"find-generic-password", "-s", service, "-a", account, "-w"}, nil
+	}
+	return "", nil, fmt.Errorf("keyring credentials are not supported on %s", runtime.GOOS)
+}
+
+// LoadKeyring reads a secret stored in the OS keyring under service/account
+func LoadKeyring(service, account string) (string, error) {
+	name, args, err := keyringCommand(service, account)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), KeyringTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && stderr.Len() == 0 {
+			return "", fmt.Errorf("no keyring secret for %s/%s", service, account)
+		}
+		return "", fmt.Errorf("%s failed: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
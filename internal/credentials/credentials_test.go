@@ -0,0 +1,101 @@
+package credentials
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSource(t *testing.T) {
+	tests := []struct {
+		spec      string
+		want      Source
+		wantError bool
+	}{
+		{spec: "file:/etc/charon-key/token", want: Source{Kind: KindFile, Location: "/etc/charon-key/token"}},
+		{spec: "env:GITHUB_TOKEN", want: Source{Kind: KindEnv, Location: "GITHUB_TOKEN"}},
+		{spec: "keyring:charon-key/github", want: Source{Kind: KindKeyring, Location: "charon-key/github"}},
+		{spec: "vault:secret/data/charon-key#github_token", want: Source{Kind: KindVault, Location: "secret/data/charon-key#github_token"}},
+		{spec: "vault:secret/data/charon-key", wantError: true},
+		{spec: "keyring:charon-key", wantError: true},
+		{spec: "ghp_secret", wantError: true},
+		{spec: "vault:secret/github", wantError: true},
+		{spec: "env:", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseSource(tt.spec)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("ParseSource() error = %v, wantError %v", err, tt.wantError)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSource() = %+v, want %+v", got, tt.want)
+			}
+			if !tt.wantError && got.String() != tt.spec {
+				t.Errorf("String() = %q, want %q", got.String(), tt.spec)
+			}
+		})
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+
+	safe := filepath.Join(dir, "safe")
+	if err := os.WriteFile(safe, []byte("ghp_secret\n"), 0640); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	secret, err := Source{Kind: KindFile, Location: safe}.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if secret != "ghp_secret" {
+		t.Errorf("Load() = %q, want trimmed secret", secret)
+	}
+
+	for name, mode := range map[string]os.FileMode{"world-readable": 0644, "group-writable": 0660} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("ghp_secret"), 0600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+		os.Chmod(path, mode)
+		if _, err := LoadFile(path); err == nil {
+			t.Errorf("LoadFile() expected error for %s file", name)
+		}
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("CHARON_TEST_TOKEN", " ghp_secret ")
+	secret, err := Source{Kind: KindEnv, Location: "CHARON_TEST_TOKEN"}.Load()
+	if err != nil || secret != "ghp_secret" {
+		t.Errorf("Load() = %q, %v, want ghp_secret", secret, err)
+	}
+
+	if _, err := (Source{Kind: KindEnv, Location: "CHARON_TEST_UNSET"}).Load(); err == nil {
+		t.Error("Load() expected error for unset variable")
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "secret-tool")
+	content := "#!/bin/sh\n[ \"$2\" = charon-key ] && [ \"$3\" = github ] && echo ghp_secret && exit 0\nexit 1\n"
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+
+	original := keyringCommand
+	defer func() { keyringCommand = original }()
+	keyringCommand = func(service, account string) (string, []string, error) {
+		return script, []string{"lookup", service, account}, nil
+	}
+
+	secret, err := Source{Kind: KindKeyring, Location: "charon-key/github"}.Load()
+	if err != nil || secret != "ghp_secret" {
+		t.Errorf("Load() = %q, %v, want ghp_secret", secret, err)
+	}
+	if _, err := LoadKeyring("charon-key", "gitlab"); err == nil {
+		t.Error("LoadKeyring() expected error for missing secret")
+	}
+}
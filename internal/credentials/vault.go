@@ -0,0 +1,96 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// VaultTimeout bounds a Vault request
+const VaultTimeout = 10 * time.Second
+
+// Vault reads secrets from HashiCorp Vault's KV engine (version 1 or 2)
+type Vault struct {
+	// Address is the Vault server, e.g. https://vault.example.com:8200
+	Address string
+
+	// Token authenticates to Vault
+	Token string
+
+	// Namespace is the Vault Enterprise namespace (optional)
+	Namespace string
+
+	client *http.Client
+}
+
+// NewVault creates a Vault client
+func NewVault(address, token string) *Vault {
+	return &Vault{
+		Address: strings.TrimRight(address, "/"),
+		Token:   token,
+		client:  &http.Client{Timeout: VaultTimeout},
+	}
+}
+
+// VaultFromEnv creates a Vault client from the VAULT_ADDR, VAULT_TOKEN and
+// VAULT_NAMESPACE environment variables used by the vault CLI
+func VaultFromEnv() (*Vault, error) {
+	address, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if address == "" || token == "" {
+		return nil, fmt.Errorf("vault credentials need VAULT_ADDR and VAULT_TOKEN (or --vault-addr and --vault-token-source)")
+	}
+	vault := NewVault(address, token)
+	vault.Namespace = os.Getenv("VAULT_NAMESPACE")
+	return vault, nil
+}
+
+// Read returns a field of the secret at path, e.g. path
+// "secret/data/charon-key" and field "github_token" for KV version 2
+func (v *Vault) Read(ctx context.Context, path, field string) (string, error) {
+	url := v.Address + "/v1/" + strings.TrimLeft(path, "/")
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return "", fmt.Errorf("vault returned HTTP %d for %s", resp.StatusCode, path)
+	}
+
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", fmt.Errorf("failed to parse vault response: %w", err)
+	}
+
+	// KV version 2 nests the secret under data.data, next to data.metadata
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]any); ok {
+		if _, hasMetadata := data["metadata"]; hasMetadata {
+			data = nested
+		}
+	}
+
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no string field %q", path, field)
+	}
+	return value, nil
+}
@@ -0,0 +1,63 @@
+package credentials
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVault_Read(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "s.vault" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/charon-key":
+			w.Write([]byte(`{"data": {"data": {"github_token": "ghp_v2"}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/charon-key":
+			w.Write([]byte(`{"data": {"github_token": "ghp_v1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name      string
+		token     string
+		path      string
+		field     string
+		want      string
+		wantError bool
+	}{
+		{name: "kv v2", token: "s.vault", path: "secret/data/charon-key", field: "github_token", want: "ghp_v2"},
+		{name: "kv v1", token: "s.vault", path: "kv/charon-key", field: "github_token", want: "ghp_v1"},
+		{name: "missing field", token: "s.vault", path: "kv/charon-key", field: "gitlab_token", wantError: true},
+		{name: "missing secret", token: "s.vault", path: "kv/other", field: "github_token", wantError: true},
+		{name: "bad token", token: "s.wrong", path: "kv/charon-key", field: "github_token", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewVault(server.URL, tt.token).Read(context.Background(), tt.path, tt.field)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("Read() error = %v, wantError %v", err, tt.wantError)
+			}
+			if got != tt.want {
+				t.Errorf("Read() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Loader resolves vault sources
+	source, err := ParseSource("vault:secret/data/charon-key#github_token")
+	if err != nil {
+		t.Fatalf("ParseSource() error = %v", err)
+	}
+	secret, err := Loader{Vault: NewVault(server.URL, "s.vault")}.Load(source)
+	if err != nil || secret != "ghp_v2" {
+		t.Errorf("Load() = %q, %v, want ghp_v2", secret, err)
+	}
+}
@@ -1,24 +1,146 @@
 package errors
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"syscall"
 )
 
 // ExitCode represents application exit codes
+// Values are stable: scripts and monitoring match on them. sshd only checks
+// for zero, and ignores a command's output when it exits non-zero
 type ExitCode int
 
 const (
-	ExitSuccess ExitCode = iota
-	ExitGeneralError
-	ExitInvalidKeyFormat
-	ExitConfigError
-	ExitNetworkError
-	ExitPermissionError
+	ExitSuccess          ExitCode = 0
+	ExitGeneralError     ExitCode = 1
+	ExitInvalidKeyFormat ExitCode = 2
+	ExitConfigError      ExitCode = 3
+	ExitNetworkError     ExitCode = 4
+	ExitPermissionError  ExitCode = 5
+	ExitNoKeys           ExitCode = 6
+	ExitAccessDenied     ExitCode = 7
+	ExitNotMapped        ExitCode = 8
+	ExitEmpty            ExitCode = 9
+	ExitPartialFailure   ExitCode = 10
 )
 
+// Category groups errors by what needs fixing
+type Category string
+
+const (
+	CategoryNone       Category = "none"
+	CategoryInternal   Category = "internal"
+	CategoryConfig     Category = "config"
+	CategoryNetwork    Category = "network"
+	CategoryPolicy     Category = "policy"
+	CategoryPermission Category = "permission"
+
+	// CategoryPartial is for batch runs where some items failed and others
+	// succeeded; the failed items' own errors tell what needs fixing
+	CategoryPartial Category = "partial"
+)
+
+// exitCodeInfo documents an exit code
+type exitCodeInfo struct {
+	name        string
+	category    Category
+	description string
+}
+
+var exitCodes = map[ExitCode]exitCodeInfo{
+	ExitSuccess:          {"success", CategoryNone, "Keys were written (possibly only local or break-glass keys)"},
+	ExitEmpty:            {"empty", CategoryNone, "Resolution succeeded but there were no keys, not even local ones; nothing was written"},
+	ExitGeneralError:     {"general_error", CategoryInternal, "Unexpected error, e.g. the cache directory could not be created"},
+	ExitInvalidKeyFormat: {"invalid_key_format", CategoryPolicy, "A resolved key was malformed; charon-key terminated rather than emit it (fail secure)"},
+	ExitConfigError:      {"config_error", CategoryConfig, "Invalid flags or config file, or the FIPS policy cannot be satisfied"},
+	ExitNetworkError:     {"network_error", CategoryNetwork, "Keys could not be fetched from GitHub and no cache was available"},
+	ExitPermissionError:  {"permission_error", CategoryPermission, "The SSH user's authorized_keys could not be read or written"},
+	ExitNoKeys:           {"no_keys", CategoryPolicy, "No keys resolved and --deny-on-empty denied access"},
+	ExitNotMapped:        {"not_mapped", CategoryPolicy, "charon-key check or gpg: the SSH user is not mapped to any GitHub user"},
+	ExitAccessDenied:     {"access_denied", CategoryPolicy, "The SSH user holds no approved, unexpired grant in the --access-url system"},
+	ExitPartialFailure:   {"partial_failure", CategoryPartial, "charon-key resolve: some SSH users failed and the others succeeded; if all fail, the first failure's code is used"},
+}
+
+// ExitCodes returns every exit code, in numeric order
+func ExitCodes() []ExitCode {
+	return []ExitCode{ExitSuccess, ExitGeneralError, ExitInvalidKeyFormat, ExitConfigError, ExitNetworkError, ExitPermissionError, ExitNoKeys, ExitAccessDenied, ExitNotMapped, ExitEmpty, ExitPartialFailure}
+}
+
+// String returns the exit code's stable name, e.g. "network_error"
+func (c ExitCode) String() string {
+	if info, ok := exitCodes[c]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("unknown(%d)", int(c))
+}
+
+// Category returns the category of errors exiting with this code
+func (c ExitCode) Category() Category {
+	if info, ok := exitCodes[c]; ok {
+		return info.category
+	}
+	return CategoryInternal
+}
+
+// Explain returns a one-line description of the exit code
+func (c ExitCode) Explain() (string, error) {
+	info, ok := exitCodes[c]
+	if !ok {
+		return "", fmt.Errorf("unknown exit code %d", int(c))
+	}
+	return fmt.Sprintf("%d %s (%s): %s", int(c), info.name, info.category, info.description), nil
+}
+
+// Format selects how ExitWithError reports errors on stderr
+type Format string
+
+const (
+	// FormatText reports nothing beyond the logs (the default)
+	FormatText Format = "text"
+	// FormatJSON writes a JSON error object as the last line on stderr
+	FormatJSON Format = "json"
+)
+
+// errorFormat is the format used by ExitWithError and HandleInvalidKey
+var errorFormat = FormatText
+
+// ParseFormat validates an error format name
+func ParseFormat(s string) (Format, error) {
+	switch Format(s) {
+	case FormatText, FormatJSON:
+		return Format(s), nil
+	}
+	return "", fmt.Errorf("invalid error format: %q (valid: text, json)", s)
+}
+
+// SetFormat sets how ExitWithError reports errors
+func SetFormat(format Format) {
+	errorFormat = format
+}
+
+// redact scrubs secrets from error reports (identity by default)
+var redact = func(s string) string { return s }
+
+// SetRedact sets the function scrubbing secrets from the errors and keys
+// ExitWithError and HandleInvalidKey write to stderr
+func SetRedact(fn func(string) string) {
+	redact = fn
+}
+
+// onFailure runs before ExitWithError and HandleInvalidKey exit with a
+// failure (nothing by default)
+var onFailure = func() {}
+
+// SetOnFailure sets a function run before ExitWithError and HandleInvalidKey
+// exit with a failure, e.g. to flush buffered logs
+func SetOnFailure(fn func()) {
+	onFailure = fn
+}
+
 // AppError represents an application error with exit code
 type AppError struct {
 	Message  string
@@ -26,6 +148,11 @@ type AppError struct {
 	Err      error
 }
 
+// Category returns the error's category, derived from its exit code
+func (e *AppError) Category() Category {
+	return e.ExitCode.Category()
+}
+
 func (e *AppError) Error() string {
 	if e.Err != nil {
 		return fmt.Sprintf("%s: %v", e.Message, e.Err)
@@ -52,20 +179,52 @@ func ExitWithCode(code ExitCode) {
 }
 
 // ExitWithError exits the application with the error's exit code
+// With FormatJSON, the error is first written to stderr as a JSON object,
+// unless its exit code is not a failure (such as ExitEmpty)
 func ExitWithError(err error) {
+	code := ExitGeneralError
 	var appErr *AppError
 	if errors.As(err, &appErr) {
-		os.Exit(int(appErr.ExitCode))
+		code = appErr.ExitCode
+	}
+	if code.Category() != CategoryNone {
+		onFailure()
+		if errorFormat == FormatJSON {
+			WriteJSON(os.Stderr, err, code)
+		}
 	}
-	os.Exit(int(ExitGeneralError))
+	os.Exit(int(code))
+}
+
+// jsonError is the machine-readable error object
+type jsonError struct {
+	Error    string   `json:"error"`
+	Category Category `json:"category"`
+	ExitCode int      `json:"exit_code"`
+	ExitName string   `json:"exit_name"`
+}
+
+// WriteJSON writes err as a single-line JSON error object
+func WriteJSON(w io.Writer, err error, code ExitCode) {
+	data, _ := json.Marshal(jsonError{
+		Error:    redact(err.Error()),
+		Category: code.Category(),
+		ExitCode: int(code),
+		ExitName: code.String(),
+	})
+	fmt.Fprintf(w, "%s\n", data)
 }
 
 // HandleInvalidKey handles invalid key format by terminating with SIGTERM
 // This implements "fail secure" behavior
 func HandleInvalidKey(key string, err error) {
 	// Log the error before terminating
-	fmt.Fprintf(os.Stderr, "ERROR: Invalid SSH key format: %q: %v\n", key, err)
+	onFailure()
+	fmt.Fprintf(os.Stderr, "ERROR: Invalid SSH key format: %q: %v\n", redact(key), err)
 	fmt.Fprintf(os.Stderr, "Terminating due to invalid key format (fail secure)\n")
+	if errorFormat == FormatJSON {
+		WriteJSON(os.Stderr, fmt.Errorf("invalid SSH key format: %w", err), ExitInvalidKeyFormat)
+	}
 	
 	// Send SIGTERM to ourselves
 	process, err := os.FindProcess(os.Getpid())
@@ -0,0 +1,92 @@
+package errors
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestExitCode_Stable(t *testing.T) {
+	// Exit codes are a public interface; never renumber them
+	tests := []struct {
+		code     ExitCode
+		value    int
+		name     string
+		category Category
+	}{
+		{ExitSuccess, 0, "success", CategoryNone},
+		{ExitGeneralError, 1, "general_error", CategoryInternal},
+		{ExitInvalidKeyFormat, 2, "invalid_key_format", CategoryPolicy},
+		{ExitConfigError, 3, "config_error", CategoryConfig},
+		{ExitNetworkError, 4, "network_error", CategoryNetwork},
+		{ExitPermissionError, 5, "permission_error", CategoryPermission},
+		{ExitNoKeys, 6, "no_keys", CategoryPolicy},
+		{ExitAccessDenied, 7, "access_denied", CategoryPolicy},
+		{ExitNotMapped, 8, "not_mapped", CategoryPolicy},
+		{ExitEmpty, 9, "empty", CategoryNone},
+		{ExitPartialFailure, 10, "partial_failure", CategoryPartial},
+	}
+
+	if len(ExitCodes()) != len(tests) {
+		t.Errorf("ExitCodes() returned %d codes, want %d", len(ExitCodes()), len(tests))
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if int(tt.code) != tt.value {
+				t.Errorf("value = %d, want %d", int(tt.code), tt.value)
+			}
+			if tt.code.String() != tt.name {
+				t.Errorf("String() = %q, want %q", tt.code.String(), tt.name)
+			}
+			if tt.code.Category() != tt.category {
+				t.Errorf("Category() = %q, want %q", tt.code.Category(), tt.category)
+			}
+			if _, err := tt.code.Explain(); err != nil {
+				t.Errorf("Explain() error = %v", err)
+			}
+		})
+	}
+
+	if _, err := ExitCode(42).Explain(); err == nil {
+		t.Error("Explain() expected error for unknown code")
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	err := NewAppError("failed to resolve keys", ExitNetworkError, fmt.Errorf("timeout"))
+	WriteJSON(&buf, err, err.ExitCode)
+
+	if !strings.HasSuffix(buf.String(), "\n") || strings.Count(buf.String(), "\n") != 1 {
+		t.Errorf("WriteJSON() output %q is not a single line", buf.String())
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	want := map[string]any{
+		"error":     "failed to resolve keys: timeout",
+		"category":  "network",
+		"exit_code": float64(4),
+		"exit_name": "network_error",
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, valid := range []string{"text", "json"} {
+		if _, err := ParseFormat(valid); err != nil {
+			t.Errorf("ParseFormat(%q) error = %v", valid, err)
+		}
+	}
+	if _, err := ParseFormat("yaml"); err == nil {
+		t.Error("ParseFormat(\"yaml\") expected error")
+	}
+}
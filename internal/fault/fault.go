@@ -0,0 +1,147 @@
+package fault
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// EnvVar enables fault injection like the hidden --fault flag
+const EnvVar = "CHARON_KEY_FAULT"
+
+// DefaultSlowDelay is the delay of "slow" without a duration
+const DefaultSlowDelay = 5 * time.Second
+
+// Fault kinds, simulating failures operators rehearse in staging
+const (
+	// NetworkTimeout makes GitHub requests hang until they time out
+	NetworkTimeout = "network-timeout"
+	// NetworkError makes GitHub requests fail immediately
+	NetworkError = "network-error"
+	// Slow delays GitHub requests ("slow:2s"; default 5s)
+	Slow = "slow"
+	// ServerError answers GitHub requests with HTTP 500
+	ServerError = "server-error"
+	// RateLimit answers GitHub requests with HTTP 429
+	RateLimit = "rate-limit"
+	// CorruptCache makes every cache file read as corrupt
+	CorruptCache = "corrupt-cache"
+)
+
+// Set is a parsed set of faults to inject
+type Set struct {
+	kinds     map[string]bool
+	slowDelay time.Duration
+}
+
+// Parse parses a comma-separated fault list, e.g. "slow:2s,corrupt-cache"
+func Parse(spec string) (*Set, error) {
+	s := &Set{kinds: make(map[string]bool)}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		kind, arg, hasArg := strings.Cut(item, ":")
+		switch kind {
+		case Slow:
+			s.slowDelay = DefaultSlowDelay
+			if hasArg {
+				delay, err := time.ParseDuration(arg)
+				if err != nil || delay <= 0 {
+					return nil, fmt.Errorf("invalid fault %q (expected slow:DURATION, e.g. slow:2s)", item)
+				}
+				s.slowDelay = delay
+			}
+		case NetworkTimeout, NetworkError, ServerError, RateLimit, CorruptCache:
+			if hasArg {
+				return nil, fmt.Errorf("fault %q takes no argument", kind)
+			}
+		default:
+			return nil, fmt.Errorf("unknown fault %q (valid: %s, %s, %s[:DURATION], %s, %s, %s)",
+				kind, NetworkTimeout, NetworkError, Slow, ServerError, RateLimit, CorruptCache)
+		}
+		s.kinds[kind] = true
+	}
+	return s, nil
+}
+
+// Has reports whether the fault kind is injected
+func (s *Set) Has(kind string) bool {
+	return s != nil && s.kinds[kind]
+}
+
+// String lists the injected faults
+func (s *Set) String() string {
+	var kinds []string
+	for _, kind := range []string{NetworkTimeout, NetworkError, Slow, ServerError, RateLimit, CorruptCache} {
+		if s.Has(kind) {
+			kinds = append(kinds, kind)
+		}
+	}
+	return strings.Join(kinds, ",")
+}
+
+// HasNetworkFaults reports whether any fault affects HTTP requests
+func (s *Set) HasNetworkFaults() bool {
+	return s.Has(NetworkTimeout) || s.Has(NetworkError) || s.Has(Slow) || s.Has(ServerError) || s.Has(RateLimit)
+}
+
+// Transport wraps base with the set's network faults
+func (s *Set) Transport(base http.RoundTripper) http.RoundTripper {
+	return &transport{faults: s, base: base}
+}
+
+// transport is an http.RoundTripper injecting faults
+type transport struct {
+	faults *Set
+	base   http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	if t.faults.Has(NetworkTimeout) {
+		<-ctx.Done()
+		return nil, fmt.Errorf("injected fault %s: %w", NetworkTimeout, ctx.Err())
+	}
+	if t.faults.Has(NetworkError) {
+		return nil, fmt.Errorf("injected fault %s: connection refused", NetworkError)
+	}
+	if t.faults.Has(Slow) {
+		select {
+		case <-time.After(t.faults.slowDelay):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("injected fault %s: %w", Slow, ctx.Err())
+		}
+	}
+	if t.faults.Has(ServerError) {
+		return response(req, http.StatusInternalServerError, nil), nil
+	}
+	if t.faults.Has(RateLimit) {
+		return response(req, http.StatusTooManyRequests, http.Header{
+			"Retry-After":           {"60"},
+			"X-Ratelimit-Remaining": {"0"},
+		}), nil
+	}
+	return t.base.RoundTrip(req)
+}
+
+// response synthesizes an empty-bodied response
+func response(req *http.Request, status int, header http.Header) *http.Response {
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}
+}
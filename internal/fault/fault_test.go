@@ -0,0 +1,82 @@
+package fault
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec      string
+		want      string
+		wantError bool
+	}{
+		{spec: "", want: ""},
+		{spec: "network-timeout", want: "network-timeout"},
+		{spec: "corrupt-cache, slow:2s", want: "slow,corrupt-cache"},
+		{spec: "slow", want: "slow"},
+		{spec: "slow:fast", wantError: true},
+		{spec: "rate-limit:10", wantError: true},
+		{spec: "disk-full", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := Parse(tt.spec)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("Parse() error = %v, wantError %v", err, tt.wantError)
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("Parse() = %q, want %q", got.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		spec       string
+		wantStatus int
+		wantError  bool
+	}{
+		{spec: "corrupt-cache", wantStatus: http.StatusOK},
+		{spec: "slow:10ms", wantStatus: http.StatusOK},
+		{spec: "server-error", wantStatus: http.StatusInternalServerError},
+		{spec: "rate-limit", wantStatus: http.StatusTooManyRequests},
+		{spec: "network-error", wantError: true},
+		{spec: "network-timeout", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			faults, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &http.Client{Transport: faults.Transport(http.DefaultTransport)}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+			resp, err := client.Do(req)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("Do() error = %v, wantError %v", err, tt.wantError)
+			}
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FetchDeployKeys fetches the deploy keys of a repository ("owner/repo") or
+// of every unarchived repository of an org ("org"), commented with the
+// repository's full name
+// A key deployed to several repositories is returned once, for the first
+// Requires a token with admin access to the repositories
+func (f *Fetcher) FetchDeployKeys(ctx context.Context, ref string) ([]PublicKey, error) {
+	if f.token == "" {
+		return nil, fmt.Errorf("listing deploy keys requires a GitHub token")
+	}
+
+	var repos []string
+	if owner, repo, ok := strings.Cut(ref, "/"); ok {
+		if !loginPattern.MatchString(owner) || repo == "" || strings.ContainsAny(repo, "/?#") {
+			return nil, fmt.Errorf("invalid GitHub repository %q", ref)
+		}
+		repos = []string{ref}
+	} else {
+		if !loginPattern.MatchString(ref) {
+			return nil, fmt.Errorf("invalid GitHub org %q", ref)
+		}
+		orgRepos, err := getPages[struct {
+			FullName string `json:"full_name"`
+			Archived bool   `json:"archived"`
+		}](ctx, f, fmt.Sprintf("%s/orgs/%s/repos", f.apiURL, ref))
+		if err != nil {
+			return nil, err
+		}
+		for _, repo := range orgRepos {
+			if !repo.Archived {
+				repos = append(repos, repo.FullName)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	var keys []PublicKey
+	for _, fullName := range repos {
+		owner, repo, _ := strings.Cut(fullName, "/")
+		deployKeys, err := getPages[struct {
+			ID        int64     `json:"id"`
+			Key       string    `json:"key"`
+			CreatedAt time.Time `json:"created_at"`
+		}](ctx, f, fmt.Sprintf("%s/repos/%s/%s/keys", f.apiURL, url.PathEscape(owner), url.PathEscape(repo)))
+		if err != nil {
+			return nil, fmt.Errorf("deploy keys of %s: %w", fullName, err)
+		}
+		for _, key := range deployKeys {
+			fields := strings.Fields(key.Key)
+			if len(fields) < 2 || !isValidKeyFormat(key.Key) || seen[fields[1]] {
+				continue
+			}
+			seen[fields[1]] = true
+			keys = append(keys, PublicKey{
+				Key:       fields[0] + " " + fields[1] + " " + fullName,
+				ID:        strconv.FormatInt(key.ID, 10),
+				CreatedAt: key.CreatedAt,
+			})
+		}
+	}
+	return keys, nil
+}
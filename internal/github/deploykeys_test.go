@@ -0,0 +1,79 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetcher_FetchDeployKeys(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/orgs/acme/repos":
+			w.Write([]byte(`[{"full_name": "acme/app"}, {"full_name": "acme/lib"}, {"full_name": "acme/old", "archived": true}]`))
+		case "/repos/acme/app/keys":
+			w.Write([]byte(`[{"id": 1, "key": "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI", "created_at": "2024-05-01T12:00:00Z"}]`))
+		case "/repos/acme/lib/keys":
+			w.Write([]byte(`[{"id": 2, "key": "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI"}, {"id": 3, "key": "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB"}]`))
+		case "/repos/acme/old/keys":
+			w.Write([]byte(`[{"id": 4, "key": "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAC"}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	fetcher := NewFetcher()
+	fetcher.SetAPIURL(server.URL)
+	if _, err := fetcher.FetchDeployKeys(context.Background(), "acme/app"); err == nil {
+		t.Fatal("FetchDeployKeys() without token expected error")
+	}
+	fetcher.SetToken("test-token")
+
+	tests := []struct {
+		name    string
+		ref     string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "repository",
+			ref:  "acme/app",
+			want: []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI acme/app"},
+		},
+		{
+			name: "org skips archived repositories and duplicates",
+			ref:  "acme",
+			want: []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI acme/app", "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB acme/lib"},
+		},
+		{name: "unknown repository", ref: "acme/ghost", wantErr: true},
+		{name: "invalid repository", ref: "acme/app/keys", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := fetcher.FetchDeployKeys(context.Background(), tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FetchDeployKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, key := range keys {
+				got = append(got, key.Key)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("FetchDeployKeys() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	keys, _ := fetcher.FetchDeployKeys(context.Background(), "acme/app")
+	if len(keys) != 1 || keys[0].ID != "1" || keys[0].CreatedAt.IsZero() {
+		t.Errorf("FetchDeployKeys() metadata = %+v", keys)
+	}
+}
@@ -0,0 +1,138 @@
+package github
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	stderrors "errors"
+	"net"
+	"net/http"
+)
+
+// ErrorKind is the category of a failed fetch, for callers that handle
+// failures differently (retries, stale cache fallback, exit codes)
+type ErrorKind string
+
+// Error kinds
+const (
+	// KindNotFound: the GitHub user, gist or repository doesn't exist
+	KindNotFound ErrorKind = "not_found"
+	// KindRateLimited: GitHub asked us to back off (see RateLimitError)
+	KindRateLimited ErrorKind = "rate_limited"
+	// KindTimeout: the request or its deadline timed out
+	KindTimeout ErrorKind = "timeout"
+	// KindTLS: the TLS handshake or certificate verification failed
+	KindTLS ErrorKind = "tls"
+	// KindProxy: connecting through the HTTP(S) proxy failed
+	KindProxy ErrorKind = "proxy"
+	// KindParse: the response was not what GitHub serves, e.g. a captive
+	// portal page
+	KindParse ErrorKind = "parse"
+	// KindHTTP: any other non-200 response
+	KindHTTP ErrorKind = "http"
+	// KindNetwork: any other connection failure
+	KindNetwork ErrorKind = "network"
+)
+
+// FetchError is a failed fetch with its category
+type FetchError struct {
+	Kind ErrorKind
+	Err  error
+
+	// Attempts is the number of requests made before giving up (0 if unknown)
+	Attempts int
+}
+
+func (e *FetchError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// AttemptsOf returns the number of requests a failed fetch made, from the
+// outermost *FetchError recording it (0 if unknown)
+func AttemptsOf(err error) int {
+	for err != nil {
+		var fetchErr *FetchError
+		if !stderrors.As(err, &fetchErr) {
+			return 0
+		}
+		if fetchErr.Attempts > 0 {
+			return fetchErr.Attempts
+		}
+		err = fetchErr.Err
+	}
+	return 0
+}
+
+// StatusOf returns the HTTP status of a failed fetch: that of a wrapped
+// *HTTPError or *RateLimitError, 404 for KindNotFound, or 0 if no response
+// was received
+func StatusOf(err error) int {
+	var rateErr *RateLimitError
+	if stderrors.As(err, &rateErr) {
+		return rateErr.StatusCode
+	}
+	var httpErr *HTTPError
+	if stderrors.As(err, &httpErr) {
+		return httpErr.StatusCode
+	}
+	if KindOf(err) == KindNotFound {
+		return http.StatusNotFound
+	}
+	return 0
+}
+
+// KindOf returns the category of a fetch error: that of a wrapped
+// *FetchError, or else derived from the errors it wraps; KindNetwork if
+// nothing more specific applies, and "" for a nil error
+func KindOf(err error) ErrorKind {
+	if err == nil {
+		return ""
+	}
+
+	var fetchErr *FetchError
+	if stderrors.As(err, &fetchErr) {
+		return fetchErr.Kind
+	}
+
+	var rateErr *RateLimitError
+	if stderrors.As(err, &rateErr) {
+		return KindRateLimited
+	}
+	var httpErr *HTTPError
+	if stderrors.As(err, &httpErr) {
+		if httpErr.StatusCode == http.StatusNotFound {
+			return KindNotFound
+		}
+		return KindHTTP
+	}
+
+	// Proxy failures are wrapped in an *net.OpError by net/http, and may
+	// themselves be timeouts
+	var opErr *net.OpError
+	if stderrors.As(err, &opErr) && opErr.Op == "proxyconnect" {
+		return KindProxy
+	}
+
+	var netErr net.Error
+	if stderrors.Is(err, context.DeadlineExceeded) || (stderrors.As(err, &netErr) && netErr.Timeout()) {
+		return KindTimeout
+	}
+
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalidCert x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	var verification *tls.CertificateVerificationError
+	var recordHeader tls.RecordHeaderError
+	var alert tls.AlertError
+	if stderrors.As(err, &unknownAuthority) || stderrors.As(err, &invalidCert) ||
+		stderrors.As(err, &hostname) || stderrors.As(err, &verification) ||
+		stderrors.As(err, &recordHeader) || stderrors.As(err, &alert) {
+		return KindTLS
+	}
+
+	return KindNetwork
+}
@@ -0,0 +1,109 @@
+package github
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKindOf(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorKind
+	}{
+		{"nil", nil, ""},
+		{"fetch error", fmt.Errorf("user: %w", &FetchError{Kind: KindParse, Err: fmt.Errorf("bad")}), KindParse},
+		{"rate limit", &RateLimitError{StatusCode: 429}, KindRateLimited},
+		{"not found", fmt.Errorf("wrapped: %w", &HTTPError{StatusCode: http.StatusNotFound}), KindNotFound},
+		{"other status", &HTTPError{StatusCode: http.StatusBadGateway}, KindHTTP},
+		{"deadline", fmt.Errorf("request failed: %w", context.DeadlineExceeded), KindTimeout},
+		{"proxy", fmt.Errorf("request failed: %w", &net.OpError{Op: "proxyconnect", Net: "tcp", Err: fmt.Errorf("connection refused")}), KindProxy},
+		{"unknown authority", fmt.Errorf("request failed: %w", x509.UnknownAuthorityError{}), KindTLS},
+		{"hostname mismatch", x509.HostnameError{Host: "github.com", Certificate: &x509.Certificate{}}, KindTLS},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}, KindNetwork},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := KindOf(tt.err); got != tt.want {
+				t.Errorf("KindOf(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusAndAttemptsOf(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantStatus   int
+		wantAttempts int
+	}{
+		{"nil", nil, 0, 0},
+		{"rate limit", &RateLimitError{StatusCode: 429}, 429, 0},
+		{"server error", fmt.Errorf("alice: %w", &FetchError{Kind: KindHTTP, Err: &HTTPError{StatusCode: 502}, Attempts: 4}), 502, 4},
+		{"not found", &FetchError{Kind: KindNotFound, Err: fmt.Errorf("GitHub user not found"), Attempts: 1}, 404, 1},
+		{"outer without attempts", &FetchError{Kind: KindParse, Err: &FetchError{Kind: KindParse, Err: fmt.Errorf("bad"), Attempts: 2}}, 0, 2},
+		{"network", &net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := StatusOf(tt.err); got != tt.wantStatus {
+				t.Errorf("StatusOf() = %d, want %d", got, tt.wantStatus)
+			}
+			if got := AttemptsOf(tt.err); got != tt.wantAttempts {
+				t.Errorf("AttemptsOf() = %d, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestFetcher_ErrorKinds(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		tls     bool
+		want    ErrorKind
+	}{
+		{
+			name:    "not found",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotFound) },
+			want:    KindNotFound,
+		},
+		{
+			name:    "captive portal",
+			handler: func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("<html>Please log in</html>\n")) },
+			want:    KindParse,
+		},
+		{
+			name:    "untrusted certificate",
+			handler: func(w http.ResponseWriter, r *http.Request) {},
+			tls:     true,
+			want:    KindTLS,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var server *httptest.Server
+			if tt.tls {
+				server = httptest.NewTLSServer(tt.handler)
+			} else {
+				server = httptest.NewServer(tt.handler)
+			}
+			defer server.Close()
+
+			// A single attempt, as parse errors are retried
+			_, err := NewFetcher().fetchKeysOnce(context.Background(), server.URL+"/alice.keys")
+			if got := KindOf(err); got != tt.want {
+				t.Errorf("fetchKeysOnce() error %v has kind %q, want %q", err, got, tt.want)
+			}
+		})
+	}
+}
@@ -2,29 +2,77 @@ package github
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const (
 	// BaseURL is the base URL for GitHub's SSH keys API
 	BaseURL = "https://github.com"
+	// APIURL is the base URL for GitHub's REST API
+	APIURL = "https://api.github.com"
 	// DefaultTimeout is the default HTTP client timeout
 	DefaultTimeout = 10 * time.Second
-	// MaxRetries is the maximum number of retries for transient failures
+	// MaxRetries is the default maximum number of retries for transient
+	// failures
 	MaxRetries = 3
-	// RetryDelay is the delay between retries
+	// RetryDelay is the default delay before the first retry; later retries
+	// wait a multiple of it
 	RetryDelay = 1 * time.Second
+
+	// DefaultRateLimitBackoff is how long to back off after a rate limit
+	// response that doesn't say when to retry
+	DefaultRateLimitBackoff = 60 * time.Second
+	// maxRetryAfter caps how long a Retry-After on a server error can delay
+	// a retry within one fetch
+	maxRetryAfter = 10 * time.Second
+
+	// maxIdleConnsPerHost keeps enough idle connections to github.com and
+	// api.github.com for batch runs (resolve, --sync) to reuse
+	maxIdleConnsPerHost = 8
+	// idleConnTimeout closes connections idle for longer
+	idleConnTimeout = 90 * time.Second
+	// tlsHandshakeTimeout bounds a TLS handshake within DefaultTimeout
+	tlsHandshakeTimeout = 5 * time.Second
+	// tlsSessionCacheSize is the number of TLS sessions kept for resumption
+	tlsSessionCacheSize = 8
+
+	// maxDrainBytes bounds how much of an unread response body is discarded
+	// to keep the connection reusable
+	maxDrainBytes = 64 << 10
 )
 
 // Fetcher handles fetching SSH keys from GitHub
 type Fetcher struct {
-	client  *http.Client
-	baseURL string
-	logger  interface {
+	client     *http.Client
+	baseURL    string
+	apiURL     string
+	graphQLURL string
+	gistRawURL string
+	token      string
+
+	// retries and retryDelay bound retries of transient failures (see
+	// SetRetries)
+	retries    int
+	retryDelay time.Duration
+
+	// rateLimitedUntil is when GitHub allows requests again (zero = now)
+	rateLimitedUntil time.Time
+
+	// observer is told about every HTTP request made (nil = disabled),
+	// under observerMu once the fetcher has been cloned
+	observer   RequestObserver
+	observerMu *sync.Mutex
+
+	logger interface {
 		Debug(msg string, args ...any)
 		Info(msg string, args ...any)
 		Warn(msg string, args ...any)
@@ -42,18 +90,121 @@ func (f *Fetcher) SetLogger(logger interface {
 	f.logger = logger
 }
 
+// RequestObserver is called after each HTTP request with its outcome
+// status is 0 if no response was received
+type RequestObserver func(method, url string, status int, elapsed time.Duration, err error)
+
+// SetRequestObserver sets a function told about every HTTP request, e.g. to
+// record status codes and timings for diagnostics
+func (f *Fetcher) SetRequestObserver(observer RequestObserver) {
+	f.observer = observer
+}
+
+// do sends the request, reporting it to the observer
+func (f *Fetcher) do(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := f.client.Do(req)
+	if f.observer != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		if f.observerMu != nil {
+			f.observerMu.Lock()
+			defer f.observerMu.Unlock()
+		}
+		f.observer(req.Method, req.URL.String(), status, time.Since(start), err)
+	}
+	return resp, err
+}
+
+// Clone returns a fetcher with f's settings, sharing its HTTP client and
+// connections, for fetching from another goroutine
+// f and its clones report requests to the observer one at a time
+func (f *Fetcher) Clone() *Fetcher {
+	if f.observerMu == nil {
+		f.observerMu = &sync.Mutex{}
+	}
+	clone := *f
+	return &clone
+}
+
+// SetRetries sets how many times transient failures (network errors,
+// timeouts and 5xx responses) are retried, and the delay before the first
+// retry; retry n waits n times delay
+func (f *Fetcher) SetRetries(retries int, delay time.Duration) {
+	f.retries = retries
+	f.retryDelay = delay
+}
+
+// SetTimeout sets the timeout of each HTTP request (DefaultTimeout by default)
+func (f *Fetcher) SetTimeout(timeout time.Duration) {
+	f.client.Timeout = timeout
+}
+
 // SetBaseURL sets the base URL for the fetcher (useful for testing)
 func (f *Fetcher) SetBaseURL(url string) {
 	f.baseURL = url
 }
 
+// SetTLSConfig restricts the fetcher's TLS settings
+// Returns error if the HTTP client's transport cannot be configured
+func (f *Fetcher) SetTLSConfig(tlsConfig *tls.Config) error {
+	switch t := f.client.Transport.(type) {
+	case nil:
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		f.client.Transport = transport
+	case *http.Transport:
+		// Keep resuming TLS sessions under the new settings
+		if tlsConfig.ClientSessionCache == nil && t.TLSClientConfig != nil {
+			tlsConfig.ClientSessionCache = t.TLSClientConfig.ClientSessionCache
+		}
+		t.TLSClientConfig = tlsConfig
+	default:
+		return fmt.Errorf("cannot apply TLS configuration to transport of type %T", t)
+	}
+	return nil
+}
+
+// WrapTransport replaces the HTTP client's transport with wrap(transport),
+// e.g. to inject faults; apply after SetTLSConfig
+func (f *Fetcher) WrapTransport(wrap func(http.RoundTripper) http.RoundTripper) {
+	transport := f.client.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	f.client.Transport = wrap(transport)
+}
+
+// newTransport creates the fetcher's HTTP transport: connections are kept
+// alive and TLS sessions resumed, so the requests of one run (e.g. a resolve
+// batch, or the GraphQL and .keys endpoints) share handshakes
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	transport.IdleConnTimeout = idleConnTimeout
+	transport.TLSHandshakeTimeout = tlsHandshakeTimeout
+	transport.ForceAttemptHTTP2 = true
+	transport.TLSClientConfig = &tls.Config{
+		ClientSessionCache: tls.NewLRUClientSessionCache(tlsSessionCacheSize),
+	}
+	return transport
+}
+
 // NewFetcher creates a new GitHub fetcher with default settings
 func NewFetcher() *Fetcher {
 	return &Fetcher{
 		client: &http.Client{
-			Timeout: DefaultTimeout,
+			Timeout:   DefaultTimeout,
+			Transport: newTransport(),
 		},
-		baseURL: BaseURL,
+		baseURL:    BaseURL,
+		apiURL:     APIURL,
+		graphQLURL: GraphQLURL,
+		gistRawURL: GistRawURL,
+		retries:    MaxRetries,
+		retryDelay: RetryDelay,
 	}
 }
 
@@ -61,8 +212,13 @@ func NewFetcher() *Fetcher {
 // Useful for testing with mock clients
 func NewFetcherWithClient(client *http.Client) *Fetcher {
 	return &Fetcher{
-		client:  client,
-		baseURL: BaseURL,
+		client:     client,
+		baseURL:    BaseURL,
+		apiURL:     APIURL,
+		graphQLURL: GraphQLURL,
+		gistRawURL: GistRawURL,
+		retries:    MaxRetries,
+		retryDelay: RetryDelay,
 	}
 }
 
@@ -70,25 +226,46 @@ func NewFetcherWithClient(client *http.Client) *Fetcher {
 // Returns the keys as a slice of strings (one key per line)
 // Returns error if the request fails or the user doesn't exist
 func (f *Fetcher) FetchKeys(username string) ([]string, error) {
+	return f.FetchKeysContext(context.Background(), username)
+}
+
+// FetchKeysContext is like FetchKeys but gives up (including any pending
+// retries) once ctx is done
+// Failures are a *RateLimitError, an *HTTPError or a *FetchError; KindOf
+// tells their category
+func (f *Fetcher) FetchKeysContext(ctx context.Context, username string) ([]string, error) {
 	if username == "" {
 		return nil, fmt.Errorf("GitHub username cannot be empty")
 	}
 
 	url := fmt.Sprintf("%s/%s.keys", f.baseURL, username)
 
+	// Honor an earlier rate limit instead of making things worse
+	if wait := time.Until(f.rateLimitedUntil); wait > 0 {
+		return nil, &RateLimitError{URL: url, RetryAfter: wait}
+	}
+
 	var keys []string
 	var lastErr error
 
 	// Retry logic for transient failures
-	for attempt := 0; attempt <= MaxRetries; attempt++ {
+	for attempt := 0; attempt <= f.retries; attempt++ {
 		if attempt > 0 {
 			if f.logger != nil {
 				f.logger.Debug("retrying GitHub fetch", "username", username, "attempt", attempt)
 			}
-			time.Sleep(RetryDelay * time.Duration(attempt))
+			delay := f.retryDelay * time.Duration(attempt)
+			if httpErr, ok := lastErr.(*HTTPError); ok && httpErr.RetryAfter > delay {
+				delay = min(httpErr.RetryAfter, maxRetryAfter)
+			}
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, &FetchError{Kind: KindTimeout, Err: fmt.Errorf("gave up fetching keys: %w (last error: %v)", ctx.Err(), lastErr), Attempts: attempt}
+			}
 		}
 
-		keys, lastErr = f.fetchKeysOnce(url)
+		keys, lastErr = f.fetchKeysOnce(ctx, url)
 		if lastErr == nil {
 			if f.logger != nil {
 				f.logger.Debug("successfully fetched keys", "username", username, "keys_count", len(keys))
@@ -96,16 +273,28 @@ func (f *Fetcher) FetchKeys(username string) ([]string, error) {
 			return keys, nil
 		}
 
-		// Don't retry on 404 (user not found) or other client errors
-		if httpErr, ok := lastErr.(*HTTPError); ok {
-			if httpErr.StatusCode == http.StatusNotFound {
-				if f.logger != nil {
-					f.logger.Warn("GitHub user not found", "username", username)
-				}
-				return nil, fmt.Errorf("GitHub user %q not found", username)
+		switch KindOf(lastErr) {
+		case KindRateLimited:
+			// Retrying now only burns quota, let the caller fall back to
+			// its cache
+			rateErr := lastErr.(*RateLimitError)
+			f.rateLimitedUntil = time.Now().Add(rateErr.Backoff())
+			if f.logger != nil {
+				f.logger.Warn("rate limited by GitHub", "username", username, "status_code", rateErr.StatusCode, "retry_after", rateErr.Backoff())
 			}
+			return nil, rateErr
+
+		case KindNotFound:
+			// Don't retry on 404 (user not found)
+			if f.logger != nil {
+				f.logger.Warn("GitHub user not found", "username", username)
+			}
+			return nil, &FetchError{Kind: KindNotFound, Err: fmt.Errorf("GitHub user %q not found", username), Attempts: attempt + 1}
+
+		case KindHTTP:
+			httpErr := lastErr.(*HTTPError)
 			// Retry on 5xx errors (server errors)
-			if httpErr.StatusCode >= 500 && attempt < MaxRetries {
+			if httpErr.StatusCode >= 500 && attempt < f.retries {
 				if f.logger != nil {
 					f.logger.Warn("GitHub server error, retrying", "username", username, "status_code", httpErr.StatusCode, "attempt", attempt)
 				}
@@ -116,10 +305,25 @@ func (f *Fetcher) FetchKeys(username string) ([]string, error) {
 				f.logger.Error("GitHub client error", "username", username, "status_code", httpErr.StatusCode, "error", lastErr)
 			}
 			return nil, lastErr
+
+		case KindTLS:
+			// Certificate problems don't go away by retrying
+			if f.logger != nil {
+				f.logger.Error("TLS error fetching keys", "username", username, "error", lastErr)
+			}
+			return nil, &FetchError{Kind: KindTLS, Err: lastErr, Attempts: attempt + 1}
+		}
+
+		// Out of time: retrying would only fail again
+		if ctx.Err() != nil {
+			if f.logger != nil {
+				f.logger.Warn("GitHub fetch deadline exceeded", "username", username, "error", lastErr)
+			}
+			return nil, &FetchError{Kind: KindTimeout, Err: fmt.Errorf("gave up fetching keys: %w", lastErr), Attempts: attempt + 1}
 		}
 
 		// Retry on network errors/timeouts if we have retries left
-		if attempt < MaxRetries {
+		if attempt < f.retries {
 			if f.logger != nil {
 				f.logger.Warn("network error, retrying", "username", username, "error", lastErr, "attempt", attempt)
 			}
@@ -128,15 +332,16 @@ func (f *Fetcher) FetchKeys(username string) ([]string, error) {
 	}
 
 	if f.logger != nil {
-		f.logger.Error("failed to fetch keys after retries", "username", username, "attempts", MaxRetries+1, "error", lastErr)
+		f.logger.Error("failed to fetch keys after retries", "username", username, "attempts", f.retries+1, "error", lastErr)
 	}
 
-	return nil, fmt.Errorf("failed to fetch keys after %d attempts: %w", MaxRetries+1, lastErr)
+	err := fmt.Errorf("failed to fetch keys after %d attempts: %w", f.retries+1, lastErr)
+	return nil, &FetchError{Kind: KindOf(err), Err: err, Attempts: f.retries + 1}
 }
 
 // fetchKeysOnce performs a single HTTP request to fetch keys
-func (f *Fetcher) fetchKeysOnce(url string) ([]string, error) {
-	req, err := http.NewRequest("GET", url, nil)
+func (f *Fetcher) fetchKeysOnce(ctx context.Context, url string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -144,25 +349,25 @@ func (f *Fetcher) fetchKeysOnce(url string) ([]string, error) {
 	// Set User-Agent to identify our tool
 	req.Header.Set("User-Agent", "charon-key/1.0")
 
-	resp, err := f.client.Do(req)
+	resp, err := f.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
-	defer resp.Body.Close()
+	defer func() {
+		// Drain what's left so the keep-alive connection can be reused
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainBytes))
+		resp.Body.Close()
+	}()
 
 	// Check for HTTP errors
 	if resp.StatusCode != http.StatusOK {
-		return nil, &HTTPError{
-			StatusCode: resp.StatusCode,
-			URL:        url,
-			Message:    fmt.Sprintf("HTTP %d: %s", resp.StatusCode, resp.Status),
-		}
+		return nil, responseError(resp, url)
 	}
 
 	// Parse keys from response body
 	keys, err := parseKeys(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse keys: %w", err)
+		return nil, &FetchError{Kind: KindParse, Err: fmt.Errorf("failed to parse keys: %w", err)}
 	}
 
 	return keys, nil
@@ -219,6 +424,9 @@ func isValidKeyFormat(key string) bool {
 		"ecdsa-sha2-nistp384",
 		"ecdsa-sha2-nistp521",
 		"ssh-dss", // DSA (deprecated but still seen)
+		// FIDO security keys
+		"sk-ssh-ed25519@openssh.com",
+		"sk-ecdsa-sha2-nistp256@openssh.com",
 	}
 
 	for _, prefix := range validPrefixes {
@@ -257,6 +465,7 @@ func (f *Fetcher) FetchKeysForUsers(usernames []string) ([]string, error) {
 	for key := range allKeys {
 		result = append(result, key)
 	}
+	sort.Strings(result) // Map iteration order is random; keep output stable
 
 	// If all requests failed, return error
 	if len(result) == 0 && len(errors) == len(usernames) {
@@ -274,9 +483,86 @@ type HTTPError struct {
 	StatusCode int
 	URL        string
 	Message    string
+
+	// RetryAfter is the server's Retry-After hint (0 if none)
+	RetryAfter time.Duration
 }
 
 func (e *HTTPError) Error() string {
 	return e.Message
 }
 
+// RateLimitError is returned when GitHub rate limits requests (429, or 403
+// with rate limit headers, e.g. secondary rate limits). Unlike other errors
+// it should not be retried before RetryAfter.
+type RateLimitError struct {
+	StatusCode int
+	URL        string
+
+	// RetryAfter is how long GitHub asked us to wait (0 if it didn't say)
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("rate limited by GitHub, retry after %s", e.Backoff().Round(time.Second))
+	}
+	return fmt.Sprintf("rate limited by GitHub (HTTP %d), retry after %s", e.StatusCode, e.Backoff().Round(time.Second))
+}
+
+// Backoff returns how long to wait before contacting GitHub again
+func (e *RateLimitError) Backoff() time.Duration {
+	if e.RetryAfter > 0 {
+		return e.RetryAfter
+	}
+	return DefaultRateLimitBackoff
+}
+
+// responseError converts a non-200 response into a *RateLimitError or an
+// *HTTPError
+func responseError(resp *http.Response, url string) error {
+	retryAfter := parseRetryAfter(resp.Header, time.Now())
+
+	rateLimited := resp.StatusCode == http.StatusTooManyRequests ||
+		(resp.StatusCode == http.StatusForbidden &&
+			(resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0"))
+	if rateLimited {
+		return &RateLimitError{
+			StatusCode: resp.StatusCode,
+			URL:        url,
+			RetryAfter: retryAfter,
+		}
+	}
+
+	return &HTTPError{
+		StatusCode: resp.StatusCode,
+		URL:        url,
+		Message:    fmt.Sprintf("HTTP %d: %s", resp.StatusCode, resp.Status),
+		RetryAfter: retryAfter,
+	}
+}
+
+// parseRetryAfter reads how long to wait from Retry-After (seconds or an
+// HTTP date) or, for exhausted primary rate limits, X-RateLimit-Reset
+// Returns 0 if the headers don't say
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if date, err := http.ParseTime(value); err == nil && date.After(now) {
+			return date.Sub(now)
+		}
+	}
+
+	if header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			if wait := time.Unix(reset, 0).Sub(now); wait > 0 {
+				return wait
+			}
+		}
+	}
+
+	return 0
+}
+
@@ -1,6 +1,8 @@
 package github
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -22,6 +24,32 @@ func TestNewFetcher(t *testing.T) {
 	}
 }
 
+func TestFetcher_Transport(t *testing.T) {
+	fetcher := NewFetcher()
+	transport, ok := fetcher.client.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("Transport = %T, want *http.Transport", fetcher.client.Transport)
+	}
+	cache := transport.TLSClientConfig.ClientSessionCache
+	if cache == nil {
+		t.Fatal("TLS session cache not set")
+	}
+	if transport.MaxIdleConnsPerHost != maxIdleConnsPerHost {
+		t.Errorf("MaxIdleConnsPerHost = %d, want %d", transport.MaxIdleConnsPerHost, maxIdleConnsPerHost)
+	}
+
+	// Restricting TLS keeps resuming sessions
+	if err := fetcher.SetTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}); err != nil {
+		t.Fatalf("SetTLSConfig() error = %v", err)
+	}
+	if transport.TLSClientConfig.MinVersion != tls.VersionTLS12 {
+		t.Error("SetTLSConfig() did not apply the TLS settings")
+	}
+	if transport.TLSClientConfig.ClientSessionCache != cache {
+		t.Error("SetTLSConfig() dropped the TLS session cache")
+	}
+}
+
 func TestFetcher_FetchKeys(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -288,6 +316,9 @@ func TestIsValidKeyFormat(t *testing.T) {
 		{"ecdsa-sha2-nistp384", "ecdsa-sha2-nistp384 AAAAE2VjZHNhLXNoYTItbmlzdHAzODQAAAAI test@example.com", true},
 		{"ecdsa-sha2-nistp521", "ecdsa-sha2-nistp521 AAAAE2VjZHNhLXNoYTItbmlzdHA1MjEAAAAI test@example.com", true},
 		{"ssh-dss", "ssh-dss AAAAB3NzaC1kc3MAAACBA test@example.com", true},
+		{"sk-ssh-ed25519", "sk-ssh-ed25519@openssh.com AAAAGnNrLXNzaC1lZDI1NTE5QG9wZW5zc2guY29t test@example.com", true},
+		{"sk-ecdsa-sha2-nistp256", "sk-ecdsa-sha2-nistp256@openssh.com AAAAInNrLWVjZHNhLXNoYTItbmlzdHAyNTZAb3BlbnNzaC5jb20 test@example.com", true},
+		{"unknown sk type", "sk-unknown AAAA test@example.com", false},
 		{"comment", "# This is a comment", false},
 		{"empty", "", false},
 		{"whitespace", "   ", false},
@@ -346,6 +377,70 @@ func TestFetcher_RetryLogic(t *testing.T) {
 	}
 }
 
+func TestFetcher_SetRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		retries      int
+		wantAttempts int
+	}{
+		{name: "no retries", retries: 0, wantAttempts: 1},
+		{name: "one retry", retries: 1, wantAttempts: 2},
+		{name: "more than the default", retries: 5, wantAttempts: 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				w.WriteHeader(http.StatusBadGateway)
+			}))
+			defer server.Close()
+
+			fetcher := NewFetcher()
+			fetcher.baseURL = server.URL
+			fetcher.SetRetries(tt.retries, time.Millisecond)
+
+			if _, err := fetcher.FetchKeys("testuser"); err == nil {
+				t.Fatal("FetchKeys() error = nil, want an error")
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestFetcher_RequestObserver(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB test@example.com\n"))
+	}))
+	defer server.Close()
+
+	var statuses []int
+	fetcher := NewFetcher()
+	fetcher.baseURL = server.URL
+	fetcher.SetRequestObserver(func(method, url string, status int, elapsed time.Duration, err error) {
+		if method != "GET" || url != server.URL+"/testuser.keys" {
+			t.Errorf("observed %s %s", method, url)
+		}
+		statuses = append(statuses, status)
+	})
+
+	if _, err := fetcher.FetchKeys("testuser"); err != nil {
+		t.Fatalf("FetchKeys() error = %v", err)
+	}
+	if len(statuses) != 2 || statuses[0] != http.StatusInternalServerError || statuses[1] != http.StatusOK {
+		t.Errorf("observed statuses %v, want [500 200]", statuses)
+	}
+}
+
 func TestFetcher_Timeout(t *testing.T) {
 	// Create a server that delays response
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -365,3 +460,122 @@ func TestFetcher_Timeout(t *testing.T) {
 	}
 }
 
+
+func TestFetcher_FetchKeysContext_Cancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	fetcher := NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := fetcher.FetchKeysContext(ctx, "testuser")
+	if err == nil {
+		t.Fatal("FetchKeysContext() expected error")
+	}
+	if elapsed := time.Since(start); elapsed > RetryDelay*2 {
+		t.Errorf("FetchKeysContext() took %v, retries ignored the deadline", elapsed)
+	}
+}
+
+func TestFetcher_RateLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		headers     map[string]string
+		wantLimited bool
+		wantAfter   time.Duration
+	}{
+		{"429 with Retry-After", http.StatusTooManyRequests, map[string]string{"Retry-After": "30"}, true, 30 * time.Second},
+		{"429 without hint", http.StatusTooManyRequests, nil, true, DefaultRateLimitBackoff},
+		{"403 secondary rate limit", http.StatusForbidden, map[string]string{"Retry-After": "120"}, true, 120 * time.Second},
+		{"403 primary rate limit", http.StatusForbidden, map[string]string{"X-RateLimit-Remaining": "0"}, true, DefaultRateLimitBackoff},
+		{"plain 403", http.StatusForbidden, nil, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				for k, v := range tt.headers {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			fetcher := NewFetcher()
+			fetcher.baseURL = server.URL
+
+			_, err := fetcher.FetchKeys("testuser")
+			rateErr, limited := err.(*RateLimitError)
+			if limited != tt.wantLimited {
+				t.Fatalf("FetchKeys() error = %v (%T), rate limited = %v, want %v", err, err, limited, tt.wantLimited)
+			}
+			if requests != 1 {
+				t.Errorf("made %d requests, want 1 (no retries)", requests)
+			}
+			if !limited {
+				return
+			}
+			if rateErr.Backoff() != tt.wantAfter {
+				t.Errorf("Backoff() = %v, want %v", rateErr.Backoff(), tt.wantAfter)
+			}
+
+			// Later fetches back off without contacting GitHub
+			if _, err := fetcher.FetchKeys("otheruser"); err == nil || requests != 1 {
+				t.Errorf("FetchKeys() during back-off: error = %v, requests = %d", err, requests)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    time.Duration
+	}{
+		{"seconds", map[string]string{"Retry-After": "5"}, 5 * time.Second},
+		{"http date", map[string]string{"Retry-After": "Wed, 01 May 2024 12:01:00 GMT"}, time.Minute},
+		{"rate limit reset", map[string]string{"X-RateLimit-Remaining": "0", "X-RateLimit-Reset": fmt.Sprint(now.Add(90 * time.Second).Unix())}, 90 * time.Second},
+		{"remaining quota", map[string]string{"X-RateLimit-Remaining": "10", "X-RateLimit-Reset": fmt.Sprint(now.Add(time.Hour).Unix())}, 0},
+		{"garbage", map[string]string{"Retry-After": "soon"}, 0},
+		{"none", nil, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for k, v := range tt.headers {
+				header.Set(k, v)
+			}
+			if got := parseRetryAfter(header, now); got != tt.want {
+				t.Errorf("parseRetryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkParseKeys(b *testing.B) {
+	var body strings.Builder
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&body, "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI%040d\n", i)
+	}
+	data := body.String()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseKeys(strings.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// GistRawURL is the base URL of raw gist files
+	GistRawURL = "https://gist.githubusercontent.com"
+
+	// maxGistBytes bounds the size of a gist file
+	maxGistBytes = 1 << 20
+)
+
+// gistIDPattern matches gist IDs (hex, 20 characters for old gists)
+var gistIDPattern = regexp.MustCompile(`^[0-9a-f]{20,32}$`)
+
+// SetGistRawURL sets the base URL raw gist URLs must start with (useful for
+// testing)
+func (f *Fetcher) SetGistRawURL(url string) {
+	f.gistRawURL = url
+}
+
+// FetchGistKeys fetches SSH public keys from a gist, given by ID or by the
+// raw URL of one of its files
+// A gist ID serves the keys in all of the gist's files (in file name order)
+// at their latest revision; a raw URL serves the one file it names
+func (f *Fetcher) FetchGistKeys(ctx context.Context, ref string) ([]string, error) {
+	if gistIDPattern.MatchString(ref) {
+		return f.fetchGistByID(ctx, ref)
+	}
+	if err := f.checkGistRawURL(ref); err != nil {
+		return nil, err
+	}
+	content, err := f.getGistFile(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	return parseKeys(strings.NewReader(content))
+}
+
+// fetchGistByID fetches the files of a gist from the REST API, reading
+// files too large to be inlined from their raw URLs
+func (f *Fetcher) fetchGistByID(ctx context.Context, id string) ([]string, error) {
+	endpoint := fmt.Sprintf("%s/gists/%s", f.apiURL, id)
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "charon-key/1.0")
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if f.token != "" {
+		req.Header.Set("Authorization", "bearer "+f.token)
+	}
+
+	resp, err := f.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("gist %s not found", id)
+	default:
+		return nil, responseError(resp, endpoint)
+	}
+
+	var gist struct {
+		Files map[string]struct {
+			Content   string `json:"content"`
+			Truncated bool   `json:"truncated"`
+			RawURL    string `json:"raw_url"`
+		} `json:"files"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&gist); err != nil {
+		return nil, fmt.Errorf("failed to parse gist %s: %w", id, err)
+	}
+
+	names := make([]string, 0, len(gist.Files))
+	for name := range gist.Files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var content strings.Builder
+	for _, name := range names {
+		file := gist.Files[name]
+		if file.Truncated {
+			if err := f.checkGistRawURL(file.RawURL); err != nil {
+				return nil, fmt.Errorf("gist %s file %s: %w", id, name, err)
+			}
+			if file.Content, err = f.getGistFile(ctx, file.RawURL); err != nil {
+				return nil, fmt.Errorf("gist %s file %s: %w", id, name, err)
+			}
+		}
+		content.WriteString(file.Content)
+		content.WriteString("\n")
+	}
+
+	keys, err := parseKeys(strings.NewReader(content.String()))
+	if err != nil {
+		return nil, fmt.Errorf("gist %s: %w", id, err)
+	}
+	return keys, nil
+}
+
+// checkGistRawURL rejects URLs outside raw gist storage, so a gist entry
+// can't be used to serve keys from anywhere
+func (f *Fetcher) checkGistRawURL(rawURL string) error {
+	if !strings.HasPrefix(rawURL, f.gistRawURL+"/") {
+		return fmt.Errorf("%q is neither a gist ID nor a raw gist URL under %s", rawURL, f.gistRawURL)
+	}
+	return nil
+}
+
+// getGistFile fetches a raw gist file
+func (f *Fetcher) getGistFile(ctx context.Context, rawURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "charon-key/1.0")
+
+	resp, err := f.do(req)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("gist file %s not found", rawURL)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp, rawURL)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxGistBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if len(data) > maxGistBytes {
+		return "", fmt.Errorf("gist file %s exceeds %d bytes", rawURL, maxGistBytes)
+	}
+	return string(data), nil
+}
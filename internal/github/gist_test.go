@@ -0,0 +1,76 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetcher_FetchGistKeys(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gists/aa5a315d61ae9438b18d":
+			w.Write([]byte(`{"files": {
+				"b.pub": {"content": "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI ci@example.com\n", "truncated": false},
+				"a.pub": {"content": "", "truncated": true, "raw_url": "` + server.URL + `/raw/ci-bot/aa5a315d61ae9438b18d/raw/a.pub"}
+			}}`))
+		case "/gists/bb5a315d61ae9438b18d":
+			w.Write([]byte(`{"files": {"notes.md": {"content": "# machine keys\n", "truncated": false}}}`))
+		case "/raw/ci-bot/aa5a315d61ae9438b18d/raw/a.pub":
+			w.Write([]byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB deploy@example.com\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	fetcher := NewFetcher()
+	fetcher.SetAPIURL(server.URL)
+	fetcher.SetGistRawURL(server.URL + "/raw")
+
+	tests := []struct {
+		name    string
+		ref     string
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "gist ID with a truncated file",
+			ref:  "aa5a315d61ae9438b18d",
+			want: []string{
+				"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB deploy@example.com",
+				"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI ci@example.com",
+			},
+		},
+		{
+			name: "raw URL",
+			ref:  server.URL + "/raw/ci-bot/aa5a315d61ae9438b18d/raw/a.pub",
+			want: []string{"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB deploy@example.com"},
+		},
+		{name: "gist without keys", ref: "bb5a315d61ae9438b18d", wantErr: "no valid SSH keys"},
+		{name: "unknown gist", ref: "cc5a315d61ae9438b18d", wantErr: "not found"},
+		{name: "URL outside raw gists", ref: server.URL + "/alice.keys", wantErr: "neither a gist ID"},
+		{name: "not a gist ID", ref: "alice", wantErr: "neither a gist ID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fetcher.FetchGistKeys(context.Background(), tt.ref)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("FetchGistKeys() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FetchGistKeys() error = %v", err)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("FetchGistKeys() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
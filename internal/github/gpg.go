@@ -0,0 +1,56 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// gpgArmorHeader starts an ASCII-armored OpenPGP public key block
+	gpgArmorHeader = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+
+	// maxGPGBytes bounds the size of a user's GPG keys
+	maxGPGBytes = 1 << 20
+)
+
+// FetchGPGKeys fetches the ASCII-armored GPG public keys of a GitHub user
+// from <base>/<user>.gpg, e.g. for verifying commit signatures
+func (f *Fetcher) FetchGPGKeys(ctx context.Context, username string) (string, error) {
+	if username == "" {
+		return "", fmt.Errorf("GitHub username cannot be empty")
+	}
+
+	url := fmt.Sprintf("%s/%s.gpg", f.baseURL, username)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "charon-key/1.0")
+
+	resp, err := f.do(req)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError(resp, url)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxGPGBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	if len(data) > maxGPGBytes {
+		return "", fmt.Errorf("GPG keys of %s exceed %d bytes", username, maxGPGBytes)
+	}
+
+	armored := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(armored, gpgArmorHeader) {
+		return "", fmt.Errorf("response for %s is not an armored GPG public key block", username)
+	}
+	return armored + "\n", nil
+}
@@ -0,0 +1,54 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFetcher_FetchGPGKeys(t *testing.T) {
+	armored := "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nxsBNBGRk\n-----END PGP PUBLIC KEY BLOCK-----"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/alice.gpg":
+			w.Write([]byte(armored + "\n\n"))
+		case "/html.gpg":
+			w.Write([]byte("<html>maintenance</html>"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	fetcher := NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+
+	tests := []struct {
+		name     string
+		username string
+		want     string
+		wantErr  string
+	}{
+		{"armored keys", "alice", armored + "\n", ""},
+		{"not armored", "html", "", "not an armored"},
+		{"unknown user", "ghost", "", "404"},
+		{"empty username", "", "", "cannot be empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fetcher.FetchGPGKeys(context.Background(), tt.username)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("FetchGPGKeys() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("FetchGPGKeys() = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// GraphQLURL is GitHub's GraphQL API endpoint
+	GraphQLURL = "https://api.github.com/graphql"
+	// GraphQLBatchSize is the number of users fetched per GraphQL request
+	GraphQLBatchSize = 50
+
+	// graphQLKeysPerUser is the number of keys requested per user; users
+	// with more are left to the per-user endpoint
+	graphQLKeysPerUser = 100
+)
+
+// SetToken sets the GitHub token used for GraphQL bulk fetches
+// Without a token, FetchKeysBulk is unavailable (GraphQL requires auth)
+func (f *Fetcher) SetToken(token string) {
+	f.token = token
+}
+
+// HasToken reports whether a GitHub token is configured
+func (f *Fetcher) HasToken() bool {
+	return f.token != ""
+}
+
+// SetGraphQLURL sets the GraphQL endpoint (useful for testing)
+func (f *Fetcher) SetGraphQLURL(url string) {
+	f.graphQLURL = url
+}
+
+// PublicKey is a key returned by the GraphQL API, with GitHub's ID for the
+// key object so it can be traced back to the user's key settings, and when
+// the key was added to GitHub
+type PublicKey struct {
+	Key       string
+	ID        string
+	CreatedAt time.Time
+}
+
+// FetchKeysBulk fetches SSH keys for many GitHub users with one GraphQL
+// request per GraphQLBatchSize users instead of one HTTP call per user
+// The result only contains users whose keys were fully fetched; unknown
+// users and users with too many keys are omitted, so callers can fall back
+// to FetchKeysContext for them
+func (f *Fetcher) FetchKeysBulk(ctx context.Context, usernames []string) (map[string][]PublicKey, error) {
+	if f.token == "" {
+		return nil, fmt.Errorf("GraphQL bulk fetch requires a GitHub token")
+	}
+
+	if wait := time.Until(f.rateLimitedUntil); wait > 0 {
+		return nil, &RateLimitError{URL: f.graphQLURL, RetryAfter: wait}
+	}
+
+	result := make(map[string][]PublicKey, len(usernames))
+	for start := 0; start < len(usernames); start += GraphQLBatchSize {
+		end := min(start+GraphQLBatchSize, len(usernames))
+		if err := f.fetchKeysBatch(ctx, usernames[start:end], result); err != nil {
+			if rateErr, ok := err.(*RateLimitError); ok {
+				f.rateLimitedUntil = time.Now().Add(rateErr.Backoff())
+			}
+			return nil, err
+		}
+	}
+
+	if f.logger != nil {
+		f.logger.Debug("bulk fetched keys", "requested", len(usernames), "resolved", len(result))
+	}
+	return result, nil
+}
+
+// graphQLResponse is the subset of a GraphQL response we read
+type graphQLResponse struct {
+	Data map[string]*struct {
+		PublicKeys struct {
+			TotalCount int `json:"totalCount"`
+			Nodes      []struct {
+				ID        string    `json:"id"`
+				Key       string    `json:"key"`
+				CreatedAt time.Time `json:"createdAt"`
+			} `json:"nodes"`
+		} `json:"publicKeys"`
+	} `json:"data"`
+	Errors []struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// fetchKeysBatch fetches one batch of users into result
+// Usernames are passed as variables, never interpolated into the query
+func (f *Fetcher) fetchKeysBatch(ctx context.Context, usernames []string, result map[string][]PublicKey) error {
+	var params, fields []string
+	variables := make(map[string]string, len(usernames))
+	for i, username := range usernames {
+		alias := fmt.Sprintf("u%d", i)
+		params = append(params, fmt.Sprintf("$%s: String!", alias))
+		fields = append(fields, fmt.Sprintf("%s: user(login: $%s) { publicKeys(first: %d) { totalCount nodes { id key createdAt } } }", alias, alias, graphQLKeysPerUser))
+		variables[alias] = username
+	}
+	query := fmt.Sprintf("query(%s) { %s }", strings.Join(params, ", "), strings.Join(fields, " "))
+
+	body, err := json.Marshal(map[string]any{"query": query, "variables": variables})
+	if err != nil {
+		return fmt.Errorf("failed to encode GraphQL request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", f.graphQLURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "charon-key/1.0")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "bearer "+f.token)
+
+	resp, err := f.do(req)
+	if err != nil {
+		return fmt.Errorf("GraphQL request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp, f.graphQLURL)
+	}
+
+	var response graphQLResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("failed to parse GraphQL response: %w", err)
+	}
+
+	// Unknown users come back as null with a NOT_FOUND error; anything else
+	// without data means the whole query failed
+	if response.Data == nil && len(response.Errors) > 0 {
+		return fmt.Errorf("GraphQL query failed: %s", response.Errors[0].Message)
+	}
+
+	for i, username := range usernames {
+		user := response.Data[fmt.Sprintf("u%d", i)]
+		if user == nil || user.PublicKeys.TotalCount > len(user.PublicKeys.Nodes) {
+			continue
+		}
+		keys := make([]PublicKey, 0, len(user.PublicKeys.Nodes))
+		for _, node := range user.PublicKeys.Nodes {
+			if key := strings.TrimSpace(node.Key); isValidKeyFormat(key) {
+				keys = append(keys, PublicKey{Key: key, ID: node.ID, CreatedAt: node.CreatedAt})
+			}
+		}
+		result[username] = keys
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// graphQLServer answers bulk key queries from a fixed set of users
+func graphQLServer(t *testing.T, users map[string][]string, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if r.Header.Get("Authorization") != "bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Query     string            `json:"query"`
+			Variables map[string]string `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("invalid GraphQL request: %v", err)
+		}
+
+		data := make(map[string]any)
+		for alias, login := range req.Variables {
+			keys, ok := users[login]
+			if !ok {
+				data[alias] = nil
+				continue
+			}
+			var nodes []map[string]string
+			for i, key := range keys {
+				nodes = append(nodes, map[string]string{"id": fmt.Sprintf("%s-%d", login, i), "key": key})
+			}
+			data[alias] = map[string]any{"publicKeys": map[string]any{"totalCount": len(keys), "nodes": nodes}}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": data})
+	}))
+}
+
+func TestFetcher_FetchKeysBulk(t *testing.T) {
+	users := map[string][]string{
+		"alice": {"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIalice"},
+		"bob":   {"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB", "not a key"},
+	}
+	var usernames []string
+	for i := 0; i < GraphQLBatchSize; i++ {
+		name := fmt.Sprintf("user%d", i)
+		users[name] = nil
+		usernames = append(usernames, name)
+	}
+	usernames = append(usernames, "alice", "bob", "ghost")
+
+	requests := 0
+	server := graphQLServer(t, users, &requests)
+	defer server.Close()
+
+	fetcher := NewFetcher()
+	fetcher.SetGraphQLURL(server.URL)
+
+	if _, err := fetcher.FetchKeysBulk(context.Background(), usernames); err == nil {
+		t.Fatal("FetchKeysBulk() without token expected error")
+	}
+
+	fetcher.SetToken("test-token")
+	result, err := fetcher.FetchKeysBulk(context.Background(), usernames)
+	if err != nil {
+		t.Fatalf("FetchKeysBulk() error = %v", err)
+	}
+
+	if requests != 2 {
+		t.Errorf("FetchKeysBulk() made %d requests, want 2 batches", requests)
+	}
+	if len(result["alice"]) != 1 || len(result["bob"]) != 1 {
+		t.Errorf("FetchKeysBulk() = %v, want one valid key each for alice and bob", result)
+	}
+	if len(result["bob"]) == 1 && result["bob"][0].ID != "bob-0" {
+		t.Errorf("FetchKeysBulk() bob key ID = %q, want bob-0", result["bob"][0].ID)
+	}
+	if _, ok := result["ghost"]; ok {
+		t.Error("FetchKeysBulk() returned keys for an unknown user")
+	}
+	if keys, ok := result["user0"]; !ok || len(keys) != 0 {
+		t.Errorf("FetchKeysBulk() user0 = %v, %v; want present with no keys", keys, ok)
+	}
+}
+
+func TestFetcher_FetchKeysBulk_HTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	fetcher := NewFetcher()
+	fetcher.SetGraphQLURL(server.URL)
+	fetcher.SetToken("test-token")
+
+	_, err := fetcher.FetchKeysBulk(context.Background(), []string{"alice", "bob"})
+	if httpErr, ok := err.(*HTTPError); !ok || httpErr.StatusCode != http.StatusBadGateway {
+		t.Errorf("FetchKeysBulk() error = %v, want HTTP 502", err)
+	}
+	if !strings.Contains(err.Error(), "502") {
+		t.Errorf("FetchKeysBulk() error = %q", err)
+	}
+}
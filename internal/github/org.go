@@ -0,0 +1,158 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+// loginPattern matches valid GitHub logins (and org names)
+var loginPattern = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?$`)
+
+// SetAPIURL sets the REST API base URL (useful for testing)
+func (f *Fetcher) SetAPIURL(url string) {
+	f.apiURL = url
+}
+
+// IsOrgMember reports whether username is an active member of the GitHub
+// org; invited members who haven't accepted yet are not
+// Requires a token of an org member, since membership may be private
+func (f *Fetcher) IsOrgMember(ctx context.Context, org, username string) (bool, error) {
+	if f.token == "" {
+		return false, fmt.Errorf("org membership lookup requires a GitHub token")
+	}
+	if !loginPattern.MatchString(org) {
+		return false, fmt.Errorf("invalid GitHub org %q", org)
+	}
+	if !loginPattern.MatchString(username) {
+		return false, nil // Can't be a GitHub login
+	}
+
+	endpoint := fmt.Sprintf("%s/orgs/%s/memberships/%s", f.apiURL, url.PathEscape(org), url.PathEscape(username))
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", "charon-key/1.0")
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "bearer "+f.token)
+
+	resp, err := f.do(req)
+	if err != nil {
+		return false, fmt.Errorf("org membership request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainBytes))
+		return false, nil
+	default:
+		return false, responseError(resp, endpoint)
+	}
+
+	var membership struct {
+		State string `json:"state"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&membership); err != nil {
+		return false, fmt.Errorf("failed to parse org membership: %w", err)
+	}
+	return membership.State == "active", nil
+}
+
+// orgPageSize is the number of entries requested per REST API page
+const orgPageSize = 100
+
+// ListOrgMembers returns the logins of the org's members
+func (f *Fetcher) ListOrgMembers(ctx context.Context, org string) ([]string, error) {
+	if !loginPattern.MatchString(org) {
+		return nil, fmt.Errorf("invalid GitHub org %q", org)
+	}
+	members, err := getPages[struct {
+		Login string `json:"login"`
+	}](ctx, f, fmt.Sprintf("%s/orgs/%s/members", f.apiURL, org))
+	if err != nil {
+		return nil, err
+	}
+
+	logins := make([]string, 0, len(members))
+	for _, member := range members {
+		logins = append(logins, member.Login)
+	}
+	return logins, nil
+}
+
+// ListOrgTeams returns the org's teams, keyed by team slug, with the logins
+// of their members (including members of child teams)
+func (f *Fetcher) ListOrgTeams(ctx context.Context, org string) (map[string][]string, error) {
+	if !loginPattern.MatchString(org) {
+		return nil, fmt.Errorf("invalid GitHub org %q", org)
+	}
+	teams, err := getPages[struct {
+		Slug string `json:"slug"`
+	}](ctx, f, fmt.Sprintf("%s/orgs/%s/teams", f.apiURL, org))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]string, len(teams))
+	for _, team := range teams {
+		members, err := getPages[struct {
+			Login string `json:"login"`
+		}](ctx, f, fmt.Sprintf("%s/orgs/%s/teams/%s/members", f.apiURL, org, url.PathEscape(team.Slug)))
+		if err != nil {
+			return nil, fmt.Errorf("team %s: %w", team.Slug, err)
+		}
+		logins := make([]string, 0, len(members))
+		for _, member := range members {
+			logins = append(logins, member.Login)
+		}
+		result[team.Slug] = logins
+	}
+	return result, nil
+}
+
+// getPages fetches every page of a REST API list endpoint
+func getPages[T any](ctx context.Context, f *Fetcher, endpoint string) ([]T, error) {
+	if f.token == "" {
+		return nil, fmt.Errorf("listing %s requires a GitHub token", endpoint)
+	}
+
+	var all []T
+	for page := 1; ; page++ {
+		pageURL := fmt.Sprintf("%s?per_page=%d&page=%d", endpoint, orgPageSize, page)
+		req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		req.Header.Set("User-Agent", "charon-key/1.0")
+		req.Header.Set("Accept", "application/vnd.github+json")
+		req.Header.Set("Authorization", "bearer "+f.token)
+
+		resp, err := f.do(req)
+		if err != nil {
+			return nil, fmt.Errorf("request failed: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			err := responseError(resp, pageURL)
+			resp.Body.Close()
+			return nil, err
+		}
+
+		var entries []T
+		err = json.NewDecoder(resp.Body).Decode(&entries)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", pageURL, err)
+		}
+		all = append(all, entries...)
+		if len(entries) < orgPageSize {
+			return all, nil
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetcher_IsOrgMember(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/orgs/acme/memberships/alice":
+			w.Write([]byte(`{"state": "active", "role": "member"}`))
+		case "/orgs/acme/memberships/bob":
+			w.Write([]byte(`{"state": "pending", "role": "member"}`))
+		case "/orgs/broken/memberships/alice":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	fetcher := NewFetcher()
+	fetcher.SetAPIURL(server.URL)
+	if _, err := fetcher.IsOrgMember(context.Background(), "acme", "alice"); err == nil {
+		t.Fatal("IsOrgMember() without token expected error")
+	}
+	fetcher.SetToken("test-token")
+
+	tests := []struct {
+		name     string
+		org      string
+		username string
+		want     bool
+		wantErr  bool
+	}{
+		{"active member", "acme", "alice", true, false},
+		{"pending invitation", "acme", "bob", false, false},
+		{"not a member", "acme", "mallory", false, false},
+		{"not a GitHub login", "acme", "svc_backup", false, false},
+		{"invalid org", "acme/../x", "alice", false, true},
+		{"server error", "broken", "alice", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := fetcher.IsOrgMember(context.Background(), tt.org, tt.username)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("IsOrgMember() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("IsOrgMember() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFetcher_ListOrgTeams(t *testing.T) {
+	var members []map[string]string
+	for i := 0; i < 150; i++ {
+		members = append(members, map[string]string{"login": fmt.Sprintf("user%d", i)})
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := r.URL.Query().Get("page")
+		switch r.URL.Path {
+		case "/orgs/acme/members":
+			if page == "1" {
+				json.NewEncoder(w).Encode(members[:100])
+			} else {
+				json.NewEncoder(w).Encode(members[100:])
+			}
+		case "/orgs/acme/teams":
+			w.Write([]byte(`[{"slug": "ops"}, {"slug": "platform-eng"}]`))
+		case "/orgs/acme/teams/ops/members":
+			w.Write([]byte(`[{"login": "alice"}, {"login": "bob"}]`))
+		case "/orgs/acme/teams/platform-eng/members":
+			w.Write([]byte(`[]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	fetcher := NewFetcher()
+	fetcher.SetAPIURL(server.URL)
+	fetcher.SetToken("test-token")
+
+	logins, err := fetcher.ListOrgMembers(context.Background(), "acme")
+	if err != nil {
+		t.Fatalf("ListOrgMembers() error = %v", err)
+	}
+	if len(logins) != 150 || logins[149] != "user149" {
+		t.Errorf("ListOrgMembers() returned %d members, want all 150 across pages", len(logins))
+	}
+
+	teams, err := fetcher.ListOrgTeams(context.Background(), "acme")
+	if err != nil {
+		t.Fatalf("ListOrgTeams() error = %v", err)
+	}
+	if len(teams) != 2 || len(teams["ops"]) != 2 || teams["ops"][0] != "alice" {
+		t.Errorf("ListOrgTeams() = %v, want ops with alice and bob", teams)
+	}
+	if members, ok := teams["platform-eng"]; !ok || len(members) != 0 {
+		t.Errorf("ListOrgTeams() platform-eng = %v, %v; want present with no members", members, ok)
+	}
+
+	if _, err := fetcher.ListOrgTeams(context.Background(), "missing"); err == nil {
+		t.Error("ListOrgTeams() for an unknown org expected error")
+	}
+}
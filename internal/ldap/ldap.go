@@ -0,0 +1,151 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultCommand is the OpenLDAP client used to query the directory
+	DefaultCommand = "ldapsearch"
+	// DefaultFilter selects the entry for an SSH user (%s is the escaped username)
+	DefaultFilter = "(uid=%s)"
+	// DefaultAttribute holds the GitHub username(s) of an entry
+	DefaultAttribute = "githubUsername"
+	// DefaultTimeout bounds a single directory query
+	DefaultTimeout = 5 * time.Second
+)
+
+// Source resolves SSH users to GitHub users by reading an attribute from LDAP
+// Queries are made with ldapsearch so no LDAP client library is needed
+type Source struct {
+	// URL is the LDAP server URL (e.g. ldaps://ldap.example.com)
+	URL string
+
+	// BaseDN is the search base (e.g. ou=people,dc=example,dc=com)
+	BaseDN string
+
+	// Filter is the search filter; %s is replaced by the escaped SSH username
+	Filter string
+
+	// Attribute is the attribute holding GitHub usernames
+	Attribute string
+
+	// BindDN and PasswordFile are used for authenticated binds (optional)
+	BindDN       string
+	PasswordFile string
+
+	// Command is the ldapsearch binary to run
+	Command string
+
+	// Timeout bounds a single query
+	Timeout time.Duration
+}
+
+// NewSource creates an LDAP mapping source with default filter, attribute,
+// command and timeout
+func NewSource(url, baseDN string) *Source {
+	return &Source{
+		URL:       url,
+		BaseDN:    baseDN,
+		Filter:    DefaultFilter,
+		Attribute: DefaultAttribute,
+		Command:   DefaultCommand,
+		Timeout:   DefaultTimeout,
+	}
+}
+
+// LookupGitHubUsers returns the GitHub usernames stored on the SSH user's
+// directory entry. Returns an empty slice if the user has no entry or no
+// attribute values.
+func (s *Source) LookupGitHubUsers(sshUsername string) ([]string, error) {
+	if sshUsername == "" {
+		return nil, fmt.Errorf("SSH username cannot be empty")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	args := []string{"-x", "-LLL", "-H", s.URL, "-b", s.BaseDN}
+	if s.BindDN != "" {
+		args = append(args, "-D", s.BindDN)
+		if s.PasswordFile != "" {
+			args = append(args, "-y", s.PasswordFile)
+		}
+	}
+	args = append(args, fmt.Sprintf(s.Filter, EscapeFilter(sshUsername)), s.Attribute)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.Command, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ldapsearch failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return ParseLDIF(&stdout, s.Attribute)
+}
+
+// ParseLDIF extracts all values of an attribute from LDIF output
+// Handles folded lines and base64-encoded ("attr:: ...") values
+func ParseLDIF(r io.Reader, attribute string) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Continuation lines start with a single space
+		if strings.HasPrefix(line, " ") && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read LDIF: %w", err)
+	}
+
+	values := []string{}
+	for _, line := range lines {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(name, attribute) {
+			continue
+		}
+
+		if encoded, isBase64 := strings.CutPrefix(value, ":"); isBase64 {
+			decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64 value for %s: %w", attribute, err)
+			}
+			value = string(decoded)
+		}
+
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values, nil
+}
+
+// EscapeFilter escapes special characters in an LDAP filter value (RFC 4515)
+func EscapeFilter(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,101 @@
+package ldap
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLDIF(t *testing.T) {
+	ldif := `dn: uid=alice,ou=people,dc=example,dc=com
+githubUsername: alice-github
+githubUsername:: c2hhcmVkLWdpdGh1Yg==
+githubusername: alice-very-long-github-us
+ ername
+
+`
+	got, err := ParseLDIF(bytes.NewBufferString(ldif), "githubUsername")
+	if err != nil {
+		t.Fatalf("ParseLDIF() error = %v", err)
+	}
+
+	want := []string{"alice-github", "shared-github", "alice-very-long-github-username"}
+	if len(got) != len(want) {
+		t.Fatalf("ParseLDIF() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ParseLDIF()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestParseLDIF_NoEntry(t *testing.T) {
+	got, err := ParseLDIF(bytes.NewBufferString(""), "githubUsername")
+	if err != nil {
+		t.Fatalf("ParseLDIF() error = %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("ParseLDIF() = %v, want empty", got)
+	}
+}
+
+func TestEscapeFilter(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"alice", "alice"},
+		{"*", "\\2a"},
+		{"a)(uid=*", "a\\29\\28uid=\\2a"},
+		{"back\\slash", "back\\5cslash"},
+	}
+
+	for _, tt := range tests {
+		if got := EscapeFilter(tt.input); got != tt.want {
+			t.Errorf("EscapeFilter(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestSource_LookupGitHubUsers(t *testing.T) {
+	// Stand-in for ldapsearch that checks the filter and prints an entry
+	script := filepath.Join(t.TempDir(), "ldapsearch")
+	content := `#!/bin/sh
+for arg in "$@"; do
+	if [ "$arg" = "(uid=alice)" ]; then
+		printf 'dn: uid=alice,dc=example,dc=com\ngithubUsername: alice-github\n'
+		exit 0
+	fi
+done
+exit 0
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+
+	source := NewSource("ldap://localhost", "dc=example,dc=com")
+	source.Command = script
+
+	users, err := source.LookupGitHubUsers("alice")
+	if err != nil {
+		t.Fatalf("LookupGitHubUsers() error = %v", err)
+	}
+	if len(users) != 1 || users[0] != "alice-github" {
+		t.Errorf("LookupGitHubUsers() = %v, want [alice-github]", users)
+	}
+
+	users, err = source.LookupGitHubUsers("bob")
+	if err != nil {
+		t.Fatalf("LookupGitHubUsers() error = %v", err)
+	}
+	if len(users) != 0 {
+		t.Errorf("LookupGitHubUsers() = %v, want empty", users)
+	}
+
+	source.Command = filepath.Join(t.TempDir(), "missing")
+	if _, err := source.LookupGitHubUsers("alice"); err == nil {
+		t.Error("LookupGitHubUsers() expected error when ldapsearch is missing")
+	}
+}
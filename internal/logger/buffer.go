@@ -0,0 +1,86 @@
+package logger
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// DebugBuffer keeps the last records below the log level in memory, so
+// they can be written out only when an invocation fails
+type DebugBuffer struct {
+	mu      sync.Mutex
+	records [][]byte
+	next    int
+}
+
+// bufferHandler passes records at or above the log level to the primary
+// handler and formats the others into a DebugBuffer
+type bufferHandler struct {
+	primary slog.Handler
+	buffer  slog.Handler
+}
+
+// NewBufferedLogger creates a logger like NewLoggerTo that also keeps the
+// last size records below the level (down to debug) in the returned buffer
+func NewBufferedLogger(level string, w io.Writer, size int) (*Logger, *DebugBuffer) {
+	buffer := &DebugBuffer{records: make([][]byte, size)}
+	handler := &bufferHandler{
+		primary: slog.NewTextHandler(w, handlerOptions(parseLevel(level))),
+		buffer:  slog.NewTextHandler(buffer, handlerOptions(slog.LevelDebug)),
+	}
+	return &Logger{Logger: slog.New(handler)}, buffer
+}
+
+func (h *bufferHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.primary.Enabled(ctx, level) || h.buffer.Enabled(ctx, level)
+}
+
+func (h *bufferHandler) Handle(ctx context.Context, record slog.Record) error {
+	if h.primary.Enabled(ctx, record.Level) {
+		return h.primary.Handle(ctx, record)
+	}
+	return h.buffer.Handle(ctx, record)
+}
+
+func (h *bufferHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &bufferHandler{primary: h.primary.WithAttrs(attrs), buffer: h.buffer.WithAttrs(attrs)}
+}
+
+func (h *bufferHandler) WithGroup(name string) slog.Handler {
+	return &bufferHandler{primary: h.primary.WithGroup(name), buffer: h.buffer.WithGroup(name)}
+}
+
+// Write stores one formatted record, replacing the oldest once full
+func (b *DebugBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.records) == 0 {
+		return len(p), nil
+	}
+	b.records[b.next] = append([]byte(nil), p...)
+	b.next = (b.next + 1) % len(b.records)
+	return len(p), nil
+}
+
+// Dump writes the buffered records to w, oldest first, and empties the
+// buffer
+func (b *DebugBuffer) Dump(w io.Writer) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i := range b.records {
+		record := b.records[(b.next+i)%len(b.records)]
+		if record == nil {
+			continue // not filled yet
+		}
+		if _, err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	b.records = make([][]byte, len(b.records))
+	b.next = 0
+	return nil
+}
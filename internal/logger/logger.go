@@ -1,18 +1,43 @@
 package logger
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
 	"log/slog"
 	"os"
 )
 
+// LevelAudit is above every configurable level, so audit events are always
+// logged; it is rendered as "AUDIT"
+const LevelAudit = slog.LevelError + 4
+
 // Logger wraps slog.Logger with convenience methods
 type Logger struct {
 	*slog.Logger
+
+	// correlationID is included in every record (empty = none)
+	correlationID string
 }
 
 // NewLogger creates a new logger with the specified level
 // Logs to stderr (for SSH daemon capture)
 func NewLogger(level string) *Logger {
+	return NewLoggerTo(level, os.Stderr)
+}
+
+// NewLoggerTo creates a new logger with the specified level writing to w,
+// e.g. stderr and a RotatingFile
+func NewLoggerTo(level string, w io.Writer) *Logger {
+	handler := slog.NewTextHandler(w, handlerOptions(parseLevel(level)))
+	logger := slog.New(handler)
+
+	return &Logger{Logger: logger}
+}
+
+// parseLevel converts a level name to a slog level (info if unknown)
+func parseLevel(level string) slog.Level {
 	var logLevel slog.Level
 
 	switch level {
@@ -27,15 +52,23 @@ func NewLogger(level string) *Logger {
 	default:
 		logLevel = slog.LevelInfo // Default to info
 	}
+	return logLevel
+}
 
-	opts := &slog.HandlerOptions{
+// handlerOptions returns the text handler options for a level, rendering
+// LevelAudit as "AUDIT"
+func handlerOptions(logLevel slog.Level) *slog.HandlerOptions {
+	return &slog.HandlerOptions{
 		Level: logLevel,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && len(groups) == 0 {
+				if level, ok := a.Value.Any().(slog.Level); ok && level == LevelAudit {
+					a.Value = slog.StringValue("AUDIT")
+				}
+			}
+			return a
+		},
 	}
-
-	handler := slog.NewTextHandler(os.Stderr, opts)
-	logger := slog.New(handler)
-
-	return &Logger{Logger: logger}
 }
 
 // Debug logs a debug message
@@ -58,8 +91,31 @@ func (l *Logger) Error(msg string, args ...any) {
 	l.Logger.Error(msg, args...)
 }
 
+// Audit logs a high-severity security event, regardless of the log level
+func (l *Logger) Audit(msg string, args ...any) {
+	l.Logger.Log(context.Background(), LevelAudit, msg, args...)
+}
+
 // With returns a logger with the given attributes
 func (l *Logger) With(args ...any) *Logger {
-	return &Logger{Logger: l.Logger.With(args...)}
+	return &Logger{Logger: l.Logger.With(args...), correlationID: l.correlationID}
+}
+
+// WithCorrelationID returns a logger adding a "correlation_id" attribute to
+// every record, so all records of one login attempt can be grepped together
+func (l *Logger) WithCorrelationID(id string) *Logger {
+	return &Logger{Logger: l.Logger.With("correlation_id", id), correlationID: id}
+}
+
+// CorrelationID returns the logger's correlation ID (empty if none)
+func (l *Logger) CorrelationID() string {
+	return l.correlationID
+}
+
+// NewCorrelationID returns a random 16-character hex ID
+func NewCorrelationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
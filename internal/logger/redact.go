@@ -0,0 +1,81 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/dgarifullin/charon-key/internal/redact"
+)
+
+// redactHandler scrubs record messages and attribute values before passing
+// records on
+type redactHandler struct {
+	next     slog.Handler
+	redactor *redact.Redactor
+}
+
+// WithRedactor returns a logger scrubbing every record with r (see
+// redact.Redactor)
+func (l *Logger) WithRedactor(r *redact.Redactor) *Logger {
+	return &Logger{Logger: slog.New(&redactHandler{next: l.Handler(), redactor: r}), correlationID: l.correlationID}
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactHandler) Handle(ctx context.Context, record slog.Record) error {
+	scrubbed := slog.NewRecord(record.Time, record.Level, h.redactor.String(record.Message), record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		scrubbed.AddAttrs(h.attr(attr))
+		return true
+	})
+	return h.next.Handle(ctx, scrubbed)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	scrubbed := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		scrubbed[i] = h.attr(attr)
+	}
+	return &redactHandler{next: h.next.WithAttrs(scrubbed), redactor: h.redactor}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{next: h.next.WithGroup(name), redactor: h.redactor}
+}
+
+// attr scrubs an attribute's value; values other than strings, errors and
+// string lists are scrubbed as their printed form, if that changes it
+func (h *redactHandler) attr(attr slog.Attr) slog.Attr {
+	attr.Value = attr.Value.Resolve()
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		attr.Value = slog.StringValue(h.redactor.Value(attr.Key, attr.Value.String()))
+	case slog.KindGroup:
+		group := attr.Value.Group()
+		scrubbed := make([]slog.Attr, len(group))
+		for i, member := range group {
+			scrubbed[i] = h.attr(member)
+		}
+		attr.Value = slog.GroupValue(scrubbed...)
+	case slog.KindAny:
+		switch value := attr.Value.Any().(type) {
+		case error:
+			attr.Value = slog.StringValue(h.redactor.Value(attr.Key, value.Error()))
+		case []string:
+			scrubbed := make([]string, len(value))
+			for i, s := range value {
+				scrubbed[i] = h.redactor.Value(attr.Key, s)
+			}
+			attr.Value = slog.AnyValue(scrubbed)
+		default:
+			printed := fmt.Sprint(value)
+			if scrubbed := h.redactor.Value(attr.Key, printed); scrubbed != printed {
+				attr.Value = slog.StringValue(scrubbed)
+			}
+		}
+	}
+	return attr
+}
@@ -0,0 +1,121 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// RotatingFile is a log file rotated by size and by age, shared safely by
+// concurrent charon-key processes appending to it
+// Rotated files are renamed to <path>.1 (the newest) up to <path>.<Keep>
+type RotatingFile struct {
+	// Path is the log file
+	Path string
+
+	// MaxSize rotates the file before it would grow beyond this many bytes
+	// (0 = no size limit)
+	MaxSize int64
+
+	// MaxAge rotates the file once the current period of this length ends,
+	// e.g. at midnight UTC for 24h (0 = no age limit)
+	MaxAge time.Duration
+
+	// Keep is the number of rotated files kept
+	Keep int
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+// Write appends p to the log file, rotating it first if needed
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if info, err := f.file.Stat(); err == nil && f.due(info, len(p)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	return f.file.Write(p)
+}
+
+// Close closes the log file
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// open opens the log file for appending, creating it with mode 0600
+func (f *RotatingFile) open() error {
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	f.file = file
+	return nil
+}
+
+// due reports whether the file must be rotated before writing n bytes:
+// it would exceed MaxSize, or was last written in an earlier MaxAge period
+func (f *RotatingFile) due(info os.FileInfo, n int) bool {
+	if info.Size() == 0 {
+		return false
+	}
+	if f.MaxSize > 0 && info.Size()+int64(n) > f.MaxSize {
+		return true
+	}
+	return f.MaxAge > 0 && !info.ModTime().Truncate(f.MaxAge).Equal(time.Now().Truncate(f.MaxAge))
+}
+
+// rotate shifts the rotated files and reopens the log file, under an
+// exclusive lock so concurrent processes rotate it once
+func (f *RotatingFile) rotate() error {
+	if err := syscall.Flock(int(f.file.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock log file: %w", err)
+	}
+
+	// Another process may have rotated the file while we waited
+	var err error
+	current, currentErr := os.Stat(f.Path)
+	info, infoErr := f.file.Stat()
+	if currentErr == nil && infoErr == nil && os.SameFile(current, info) {
+		if f.Keep > 0 {
+			os.Remove(f.rotated(f.Keep))
+			for i := f.Keep - 1; i >= 1; i-- {
+				os.Rename(f.rotated(i), f.rotated(i+1))
+			}
+			err = os.Rename(f.Path, f.rotated(1))
+		} else {
+			err = os.Remove(f.Path)
+		}
+	}
+
+	syscall.Flock(int(f.file.Fd()), syscall.LOCK_UN)
+	f.file.Close()
+	f.file = nil
+	if err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	return f.open()
+}
+
+// rotated returns the path of the nth rotated file
+func (f *RotatingFile) rotated(n int) string {
+	return fmt.Sprintf("%s.%d", f.Path, n)
+}
@@ -0,0 +1,88 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile_Size(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "charon-key.log")
+	f := &RotatingFile{Path: path, MaxSize: 20, Keep: 2}
+	defer f.Close()
+
+	for _, line := range []string{"first record\n", "second record\n", "third record\n", "fourth record\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	want := map[string]string{
+		path:        "fourth record\n",
+		path + ".1": "third record\n",
+		path + ".2": "second record\n",
+	}
+	for file, content := range want {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error = %v", file, err)
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), data, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists, want only Keep rotated files", filepath.Base(path))
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("log file mode = %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+}
+
+func TestRotatingFile_Age(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "charon-key.log")
+	if err := os.WriteFile(path, []byte("yesterday\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	yesterday := time.Now().Add(-24 * time.Hour)
+	if err := os.Chtimes(path, yesterday, yesterday); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &RotatingFile{Path: path, MaxAge: 24 * time.Hour, Keep: 1}
+	defer f.Close()
+	f.Write([]byte("today\n"))
+	f.Write([]byte("today again\n"))
+
+	rotated, _ := os.ReadFile(path + ".1")
+	current, _ := os.ReadFile(path)
+	if string(rotated) != "yesterday\n" || string(current) != "today\ntoday again\n" {
+		t.Errorf("rotated = %q, current = %q; want yesterday's records rotated", rotated, current)
+	}
+}
+
+func TestRotatingFile_RotatedByAnotherProcess(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "charon-key.log")
+	first := &RotatingFile{Path: path, MaxSize: 30, Keep: 3}
+	second := &RotatingFile{Path: path, MaxSize: 30, Keep: 3}
+	defer first.Close()
+	defer second.Close()
+
+	first.Write([]byte("process one, record 1\n"))
+	second.Write([]byte("process two, record 1\n")) // rotates
+	first.Write([]byte("process one, record 2\n"))  // must not rotate again
+
+	current, _ := os.ReadFile(path)
+	rotated, _ := os.ReadFile(path + ".1")
+	if string(rotated) != "process one, record 1\n" {
+		t.Errorf("rotated = %q, want the first record only", rotated)
+	}
+	if !strings.HasPrefix(string(current), "process two, record 1\n") {
+		t.Errorf("current = %q, want it to start with the second process's record", current)
+	}
+	if _, err := os.Stat(path + ".2"); !os.IsNotExist(err) {
+		t.Error("file was rotated twice")
+	}
+}
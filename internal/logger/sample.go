@@ -0,0 +1,117 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// sampleHandler drops records to keep high-traffic logging in check: debug
+// and info records are sampled per message, and records of every level but
+// audit are rate limited per message
+type sampleHandler struct {
+	next  slog.Handler
+	state *sampleState
+}
+
+// sampleState is shared by a handler and those derived from it with
+// WithAttrs and WithGroup, so counts are per message regardless of
+// attributes
+type sampleState struct {
+	mu sync.Mutex
+
+	// every keeps the first and every Nth debug or info record of a
+	// message (0 or 1 = all)
+	every int
+
+	// perSecond is the number of records of a message logged per second,
+	// the rest being dropped (0 = unlimited)
+	perSecond int
+
+	now      func() time.Time
+	messages map[string]*messageCount
+}
+
+// messageCount tracks one message's records
+type messageCount struct {
+	seen        int
+	windowStart time.Time
+	inWindow    int
+	dropped     int
+}
+
+// WithSampling returns a logger keeping the first and every Nth debug or
+// info record of each message (e.g. "cache hit" while resolving every user)
+// and at most perSecond records of each message per second, so debug logging
+// during an incident doesn't saturate the journal; 0 disables either
+// The first record logged after some were rate limited carries their number
+// as "dropped". Audit records are never dropped
+func (l *Logger) WithSampling(every, perSecond int) *Logger {
+	if every <= 1 && perSecond <= 0 {
+		return l
+	}
+	state := &sampleState{every: every, perSecond: perSecond, now: time.Now, messages: make(map[string]*messageCount)}
+	return &Logger{Logger: slog.New(&sampleHandler{next: l.Handler(), state: state}), correlationID: l.correlationID}
+}
+
+func (h *sampleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *sampleHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level >= LevelAudit {
+		return h.next.Handle(ctx, record)
+	}
+	dropped, ok := h.state.admit(record.Message, record.Level)
+	if !ok {
+		return nil
+	}
+	if dropped > 0 {
+		record = record.Clone()
+		record.AddAttrs(slog.Int("dropped", dropped))
+	}
+	return h.next.Handle(ctx, record)
+}
+
+func (h *sampleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &sampleHandler{next: h.next.WithAttrs(attrs), state: h.state}
+}
+
+func (h *sampleHandler) WithGroup(name string) slog.Handler {
+	return &sampleHandler{next: h.next.WithGroup(name), state: h.state}
+}
+
+// admit reports whether a record of the message is logged, and if so how
+// many records of it were rate limited since the last one logged
+func (s *sampleState) admit(message string, level slog.Level) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count, ok := s.messages[message]
+	if !ok {
+		count = &messageCount{}
+		s.messages[message] = count
+	}
+
+	count.seen++
+	if s.every > 1 && level < slog.LevelWarn && (count.seen-1)%s.every != 0 {
+		return 0, false
+	}
+
+	if s.perSecond > 0 {
+		now := s.now()
+		if now.Sub(count.windowStart) >= time.Second {
+			count.windowStart, count.inWindow = now, 0
+		}
+		if count.inWindow >= s.perSecond {
+			count.dropped++
+			return 0, false
+		}
+		count.inWindow++
+	}
+
+	dropped := count.dropped
+	count.dropped = 0
+	return dropped, true
+}
@@ -0,0 +1,129 @@
+package mockgithub
+
+import (
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRateLimitWindow is the window --rate-limit requests are counted in
+const DefaultRateLimitWindow = time.Minute
+
+// usernamePattern matches valid GitHub usernames, so fixture paths can't
+// escape the fixtures directory
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]*[A-Za-z0-9])?$`)
+
+// Server serves GitHub's /<user>.keys and /<user>.gpg endpoints from fixture
+// files, with optional fault injection, for integration tests of sshd setups
+// The keys of user alice are read from <FixturesDir>/alice.keys (or
+// alice.gpg) on every request; a missing file is a 404, like an unknown
+// GitHub user
+type Server struct {
+	// FixturesDir holds one <user>.keys (and optionally <user>.gpg) file per
+	// GitHub user
+	FixturesDir string
+
+	// Latency delays every response
+	Latency time.Duration
+
+	// ErrorRate is the fraction of requests answered with HTTP 500 (0 to 1)
+	ErrorRate float64
+
+	// RateLimit is the number of requests allowed per RateLimitWindow before
+	// answering HTTP 429 with Retry-After (0 = unlimited)
+	RateLimit       int
+	RateLimitWindow time.Duration
+
+	mu          sync.Mutex
+	windowStart time.Time
+	requests    int
+	random      *rand.Rand
+}
+
+// NewServer creates a server for the fixtures directory without faults
+func NewServer(fixturesDir string) *Server {
+	return &Server{
+		FixturesDir:     fixturesDir,
+		RateLimitWindow: DefaultRateLimitWindow,
+		random:          rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Latency > 0 {
+		select {
+		case <-time.After(s.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	ext := filepath.Ext(name)
+	username := strings.TrimSuffix(name, ext)
+	if (ext != ".keys" && ext != ".gpg") || !usernamePattern.MatchString(username) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if retryAfter, limited := s.rateLimited(time.Now()); limited {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	if s.fail() {
+		http.Error(w, "injected server error", http.StatusInternalServerError)
+		return
+	}
+
+	keys, err := os.ReadFile(filepath.Join(s.FixturesDir, username+ext))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(keys)
+}
+
+// rateLimited counts the request and reports whether it exceeds the rate
+// limit, with the seconds until the window resets
+func (s *Server) rateLimited(now time.Time) (int, bool) {
+	if s.RateLimit <= 0 {
+		return 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.windowStart) >= s.RateLimitWindow {
+		s.windowStart = now
+		s.requests = 0
+	}
+	s.requests++
+	if s.requests <= s.RateLimit {
+		return 0, false
+	}
+	remaining := s.windowStart.Add(s.RateLimitWindow).Sub(now)
+	return int(remaining.Seconds()) + 1, true
+}
+
+// fail reports whether to inject a server error into this request
+func (s *Server) fail() bool {
+	if s.ErrorRate <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.random.Float64() < s.ErrorRate
+}
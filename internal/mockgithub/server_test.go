@@ -0,0 +1,114 @@
+package mockgithub
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	key := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl alice\n"
+	if err := os.WriteFile(filepath.Join(dir, "alice.keys"), []byte(key), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gpg := "-----BEGIN PGP PUBLIC KEY BLOCK-----\n-----END PGP PUBLIC KEY BLOCK-----\n"
+	if err := os.WriteFile(filepath.Join(dir, "alice.gpg"), []byte(gpg), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "fixture", path: "/alice.keys", wantStatus: http.StatusOK, wantBody: key},
+		{name: "gpg fixture", path: "/alice.gpg", wantStatus: http.StatusOK, wantBody: gpg},
+		{name: "unknown user", path: "/bob.keys", wantStatus: http.StatusNotFound},
+		{name: "other extension", path: "/alice.txt", wantStatus: http.StatusNotFound},
+		{name: "not a keys endpoint", path: "/alice", wantStatus: http.StatusNotFound},
+		{name: "path traversal", path: "/..%2Fetc%2Fpasswd.keys", wantStatus: http.StatusNotFound},
+	}
+
+	server := httptest.NewServer(NewServer(dir))
+	defer server.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(server.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestServer_Faults(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "alice.keys"), []byte("ssh-ed25519 AAAA alice\n"), 0644)
+
+	get := func(s *Server) *http.Response {
+		server := httptest.NewServer(s)
+		defer server.Close()
+		resp, err := http.Get(server.URL + "/alice.keys")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		s := NewServer(dir)
+		s.ErrorRate = 1
+		if resp := get(s); resp.StatusCode != http.StatusInternalServerError {
+			t.Errorf("status = %d, want 500", resp.StatusCode)
+		}
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		s := NewServer(dir)
+		s.Latency = 50 * time.Millisecond
+		start := time.Now()
+		get(s)
+		if elapsed := time.Since(start); elapsed < s.Latency {
+			t.Errorf("response after %s, want at least %s", elapsed, s.Latency)
+		}
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		s := NewServer(dir)
+		s.RateLimit = 2
+		server := httptest.NewServer(s)
+		defer server.Close()
+
+		var statuses []int
+		for i := 0; i < 3; i++ {
+			resp, err := http.Get(server.URL + "/alice.keys")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			statuses = append(statuses, resp.StatusCode)
+			if i == 2 && resp.Header.Get("Retry-After") == "" {
+				t.Error("Retry-After header missing on 429")
+			}
+		}
+		if statuses[0] != 200 || statuses[1] != 200 || statuses[2] != http.StatusTooManyRequests {
+			t.Errorf("statuses = %v, want [200 200 429]", statuses)
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package policy
+
+import (
+	"crypto/fips140"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Policy restricts which SSH keys are accepted
+type Policy struct {
+	// Name identifies the policy in logs (e.g. "fips")
+	Name string
+
+	// AllowedTypes lists accepted key algorithms (e.g. "ssh-ed25519")
+	// Empty means all types are accepted
+	AllowedTypes []string
+
+	// MinRSABits is the minimum RSA modulus size in bits (0 = no minimum)
+	MinRSABits int
+}
+
+// FIPS returns the approved-algorithm preset for regulated environments.
+// Only RSA (>= 2048 bits), ECDSA over NIST curves and Ed25519 (FIPS 186-5)
+// keys are accepted; DSA is rejected.
+func FIPS() *Policy {
+	return &Policy{
+		Name: "fips",
+		AllowedTypes: []string{
+			"ssh-rsa",
+			"ecdsa-sha2-nistp256",
+			"ecdsa-sha2-nistp384",
+			"ecdsa-sha2-nistp521",
+			"ssh-ed25519",
+		},
+		MinRSABits: 2048,
+	}
+}
+
+// Check returns nil if the key is accepted by the policy, or an error
+// describing why it was rejected
+func (p *Policy) Check(key string) error {
+	fields := strings.Fields(key)
+	if len(fields) < 2 {
+		return fmt.Errorf("malformed key")
+	}
+	keyType := fields[0]
+
+	if len(p.AllowedTypes) > 0 && !contains(p.AllowedTypes, keyType) {
+		return fmt.Errorf("key type %q not allowed by %s policy", keyType, p.Name)
+	}
+
+	if keyType == "ssh-rsa" && p.MinRSABits > 0 {
+		bits, err := RSABits(fields[1])
+		if err != nil {
+			return fmt.Errorf("failed to read RSA key size: %w", err)
+		}
+		if bits < p.MinRSABits {
+			return fmt.Errorf("RSA key has %d bits, %s policy requires at least %d", bits, p.Name, p.MinRSABits)
+		}
+	}
+
+	return nil
+}
+
+// Filter splits keys into those accepted and those rejected by the policy
+func (p *Policy) Filter(keys []string) (accepted []string, rejected map[string]error) {
+	rejected = make(map[string]error)
+	for _, key := range keys {
+		if err := p.Check(key); err != nil {
+			rejected[key] = err
+			continue
+		}
+		accepted = append(accepted, key)
+	}
+	return accepted, rejected
+}
+
+// RSABits returns the modulus size of a base64-encoded ssh-rsa key blob
+func RSABits(blob string) (int, error) {
+	data, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		return 0, fmt.Errorf("invalid base64: %w", err)
+	}
+
+	// Wire format: string "ssh-rsa", mpint e, mpint n
+	var fields [][]byte
+	for i := 0; i < 3; i++ {
+		if len(data) < 4 {
+			return 0, fmt.Errorf("truncated key blob")
+		}
+		n := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		if uint32(len(data)) < n {
+			return 0, fmt.Errorf("truncated key blob")
+		}
+		fields = append(fields, data[:n])
+		data = data[n:]
+	}
+
+	if string(fields[0]) != "ssh-rsa" {
+		return 0, fmt.Errorf("blob is not an ssh-rsa key")
+	}
+
+	return new(big.Int).SetBytes(fields[2]).BitLen(), nil
+}
+
+// CheckFIPSRuntime returns an error if the process is not running with the
+// Go FIPS 140-3 cryptographic module enabled (GODEBUG=fips140=on)
+func CheckFIPSRuntime() error {
+	if !fips140.Enabled() {
+		return fmt.Errorf("FIPS 140-3 mode is not enabled (run with GODEBUG=fips140=on)")
+	}
+	return nil
+}
+
+// FIPSTLSConfig returns a TLS configuration restricted to approved protocol
+// versions, cipher suites and curves
+func FIPSTLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		},
+		CurvePreferences: []tls.CurveID{
+			tls.CurveP256,
+			tls.CurveP384,
+			tls.CurveP521,
+		},
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
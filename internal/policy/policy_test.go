@@ -0,0 +1,125 @@
+package policy
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// rsaKeyLine builds an authorized_keys line for a freshly generated RSA key
+func rsaKeyLine(t *testing.T, bits int) string {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	var blob []byte
+	appendString := func(b []byte) {
+		var l [4]byte
+		binary.BigEndian.PutUint32(l[:], uint32(len(b)))
+		blob = append(blob, l[:]...)
+		blob = append(blob, b...)
+	}
+	appendString([]byte("ssh-rsa"))
+	appendString([]byte{0x01, 0x00, 0x01})
+	appendString(append([]byte{0}, priv.N.Bytes()...))
+
+	return "ssh-rsa " + base64.StdEncoding.EncodeToString(blob) + " test@example.com"
+}
+
+func TestRSABits(t *testing.T) {
+	line := rsaKeyLine(t, 1024)
+	bits, err := RSABits(strings.Fields(line)[1])
+	if err != nil {
+		t.Fatalf("RSABits() error = %v", err)
+	}
+	if bits != 1024 {
+		t.Errorf("RSABits() = %d, want 1024", bits)
+	}
+
+	if _, err := RSABits("not-base64!"); err == nil {
+		t.Error("RSABits() expected error for invalid base64")
+	}
+	if _, err := RSABits("AAAAB3NzaC1yc2EAAAADAQABAAAB"); err == nil {
+		t.Error("RSABits() expected error for truncated blob")
+	}
+}
+
+func TestPolicy_Check(t *testing.T) {
+	fips := FIPS()
+
+	tests := []struct {
+		name      string
+		key       string
+		wantError bool
+	}{
+		{
+			name:      "ed25519 accepted",
+			key:       "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI test@example.com",
+			wantError: false,
+		},
+		{
+			name:      "ecdsa accepted",
+			key:       "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTY test@example.com",
+			wantError: false,
+		},
+		{
+			name:      "dsa rejected",
+			key:       "ssh-dss AAAAB3NzaC1kc3MAAACBA test@example.com",
+			wantError: true,
+		},
+		{
+			name:      "small RSA rejected",
+			key:       rsaKeyLine(t, 1024),
+			wantError: true,
+		},
+		{
+			name:      "RSA 2048 accepted",
+			key:       rsaKeyLine(t, 2048),
+			wantError: false,
+		},
+		{
+			name:      "malformed key",
+			key:       "ssh-ed25519",
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := fips.Check(tt.key)
+			if (err != nil) != tt.wantError {
+				t.Errorf("Check() error = %v, wantError %v", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestPolicy_Filter(t *testing.T) {
+	keys := []string{
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI a@example.com",
+		"ssh-dss AAAAB3NzaC1kc3MAAACBA b@example.com",
+	}
+
+	accepted, rejected := FIPS().Filter(keys)
+	if len(accepted) != 1 || accepted[0] != keys[0] {
+		t.Errorf("Filter() accepted = %v, want [%q]", accepted, keys[0])
+	}
+	if _, ok := rejected[keys[1]]; !ok || len(rejected) != 1 {
+		t.Errorf("Filter() rejected = %v, want only %q", rejected, keys[1])
+	}
+}
+
+func TestFIPSTLSConfig(t *testing.T) {
+	cfg := FIPSTLSConfig()
+	if cfg.MinVersion == 0 {
+		t.Error("FIPSTLSConfig() MinVersion not set")
+	}
+	if len(cfg.CipherSuites) == 0 {
+		t.Error("FIPSTLSConfig() CipherSuites not restricted")
+	}
+}
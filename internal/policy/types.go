@@ -0,0 +1,72 @@
+package policy
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// keyTypeAliases are the short key type names accepted by ParseKeyTypes
+var keyTypeAliases = map[string][]string{
+	"rsa":        {"ssh-rsa"},
+	"dsa":        {"ssh-dss"},
+	"ed25519":    {"ssh-ed25519"},
+	"ecdsa":      {"ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521"},
+	"sk-ed25519": {"sk-ssh-ed25519@openssh.com"},
+	"sk-ecdsa":   {"sk-ecdsa-sha2-nistp256@openssh.com"},
+}
+
+// ParseKeyTypes parses a comma-separated list of key types, given by short
+// name (rsa, dsa, ed25519, ecdsa, sk-ed25519, sk-ecdsa) or by full SSH name
+// (e.g. "ecdsa-sha2-nistp384"), into Policy.AllowedTypes
+func ParseKeyTypes(list string) ([]string, error) {
+	var types []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		switch {
+		case name == "":
+			continue
+		case keyTypeAliases[strings.ToLower(name)] != nil:
+			types = append(types, keyTypeAliases[strings.ToLower(name)]...)
+		case strings.HasPrefix(name, "ssh-") || strings.HasPrefix(name, "ecdsa-sha2-") || strings.HasPrefix(name, "sk-"):
+			types = append(types, name)
+		default:
+			return nil, fmt.Errorf("unknown key type %q", name)
+		}
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("no key types given")
+	}
+	return types, nil
+}
+
+// ValidateKey decodes the key data of an authorized_keys line and checks
+// that it is a well-formed key of the type the line names, which the
+// prefix check applied to every key doesn't
+func ValidateKey(key string) error {
+	fields := strings.Fields(key)
+	if len(fields) < 2 {
+		return fmt.Errorf("malformed key")
+	}
+	data, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return fmt.Errorf("invalid base64: %w", err)
+	}
+	if len(data) < 4 {
+		return fmt.Errorf("truncated key blob")
+	}
+	n := binary.BigEndian.Uint32(data)
+	if uint32(len(data)-4) < n {
+		return fmt.Errorf("truncated key blob")
+	}
+	if blobType := string(data[4 : 4+n]); blobType != fields[0] {
+		return fmt.Errorf("key data is a %q key, not %q", blobType, fields[0])
+	}
+	if fields[0] == "ssh-rsa" {
+		if _, err := RSABits(fields[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeyTypes(t *testing.T) {
+	tests := []struct {
+		name      string
+		list      string
+		want      []string
+		wantError bool
+	}{
+		{
+			name: "short names",
+			list: "ed25519, ecdsa",
+			want: []string{"ssh-ed25519", "ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521"},
+		},
+		{
+			name: "full names",
+			list: "RSA,ecdsa-sha2-nistp384,sk-ssh-ed25519@openssh.com",
+			want: []string{"ssh-rsa", "ecdsa-sha2-nistp384", "sk-ssh-ed25519@openssh.com"},
+		},
+		{name: "unknown", list: "ed25519,dsa2", wantError: true},
+		{name: "empty", list: " , ", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKeyTypes(tt.list)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("ParseKeyTypes(%q) error = %v, wantError %v", tt.list, err, tt.wantError)
+			}
+			if !tt.wantError && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseKeyTypes(%q) = %v, want %v", tt.list, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		key       string
+		wantError bool
+	}{
+		{
+			name: "ed25519",
+			key:  "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl a@example.com",
+		},
+		{
+			name: "rsa",
+			key:  rsaKeyLine(t, 1024),
+		},
+		{
+			name:      "type mismatch",
+			key:       "ssh-rsa AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl a@example.com",
+			wantError: true,
+		},
+		{
+			name:      "invalid base64",
+			key:       "ssh-ed25519 not-base64! a@example.com",
+			wantError: true,
+		},
+		{
+			name:      "truncated",
+			key:       "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB a@example.com",
+			wantError: true,
+		},
+		{
+			name:      "missing key data",
+			key:       "ssh-ed25519",
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateKey(tt.key); (err != nil) != tt.wantError {
+				t.Errorf("ValidateKey() error = %v, wantError %v", err, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Package redact scrubs secrets and, optionally, usernames from text that
+// ends up in logs and error reports
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Kinds of data a Redactor can scrub
+const (
+	KindTokens    = "tokens"
+	KindKeys      = "keys"
+	KindUsernames = "usernames"
+)
+
+// Redacted replaces secrets
+const Redacted = "[REDACTED]"
+
+var (
+	// tokenPatterns match GitHub and Vault tokens, bearer credentials and
+	// passwords in URLs; the first group, if any, is kept
+	tokenPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9_]{20,}|github_pat_[A-Za-z0-9_]{20,})`),
+		regexp.MustCompile(`\bhv[sbr]\.[A-Za-z0-9_-]{20,}`),
+		regexp.MustCompile(`(?i)\b((?:bearer|token)\s+)[A-Za-z0-9._~+/=-]{16,}`),
+		regexp.MustCompile(`(://[^/:@\s]*:)[^/@\s]+@`),
+	}
+
+	// keyBlobPattern matches SSH public key blobs (their base64 always
+	// starts with "AAAA", the big-endian length of the type name)
+	keyBlobPattern = regexp.MustCompile(`AAAA[A-Za-z0-9+/]{16,}={0,2}`)
+
+	// base64Pattern matches anything that could be key data, for values
+	// known to be keys even when malformed
+	base64Pattern = regexp.MustCompile(`[A-Za-z0-9+/]{16,}={0,2}`)
+)
+
+// usernameKeys are log attributes holding usernames
+var usernameKeys = map[string]bool{
+	"ssh_username": true, "ssh_user": true, "github_user": true, "github_users": true,
+	"username": true, "user": true, "users": true, "login": true,
+}
+
+// keyKeys are log attributes holding SSH keys
+var keyKeys = map[string]bool{"key": true, "keys": true, "local": true, "github": true}
+
+// Redactor scrubs the kinds of data it is configured for
+// The zero value scrubs nothing
+type Redactor struct {
+	Tokens    bool
+	Keys      bool
+	Usernames bool
+}
+
+// Default scrubs tokens and key blobs
+func Default() *Redactor {
+	return &Redactor{Tokens: true, Keys: true}
+}
+
+// Parse parses a comma-separated list of kinds ("tokens,keys,usernames"),
+// or "none"
+func Parse(s string) (*Redactor, error) {
+	r := &Redactor{}
+	if s == "none" {
+		return r, nil
+	}
+	for _, kind := range strings.Split(s, ",") {
+		switch strings.TrimSpace(kind) {
+		case KindTokens:
+			r.Tokens = true
+		case KindKeys:
+			r.Keys = true
+		case KindUsernames:
+			r.Usernames = true
+		case "":
+		default:
+			return nil, fmt.Errorf("invalid redaction %q (valid: %s, %s, %s or none)", kind, KindTokens, KindKeys, KindUsernames)
+		}
+	}
+	return r, nil
+}
+
+// String scrubs tokens and key blobs from free text
+// Key blobs are replaced by their fingerprint, so keys can still be told
+// apart in logs
+func (r *Redactor) String(s string) string {
+	if r == nil {
+		return s
+	}
+	if r.Tokens {
+		for _, pattern := range tokenPatterns {
+			s = pattern.ReplaceAllString(s, "${1}"+Redacted)
+		}
+	}
+	if r.Keys {
+		s = keyBlobPattern.ReplaceAllStringFunc(s, fingerprint)
+	}
+	return s
+}
+
+// Value scrubs the value of a log attribute named key: like String, and
+// also any key-like data of key attributes and, with Usernames, the whole
+// value of username attributes
+func (r *Redactor) Value(key, value string) string {
+	if r == nil {
+		return value
+	}
+	if r.Usernames && usernameKeys[key] {
+		return Pseudonym(value)
+	}
+	value = r.String(value)
+	if r.Keys && keyKeys[key] {
+		value = redactBase64(value)
+	}
+	return value
+}
+
+// redactBase64 redacts base64 runs other than the fingerprints String
+// already put in place of key blobs
+func redactBase64(s string) string {
+	var out strings.Builder
+	last := 0
+	for _, match := range base64Pattern.FindAllStringIndex(s, -1) {
+		if strings.HasSuffix(s[:match[0]], "[SHA256:") {
+			continue
+		}
+		out.WriteString(s[last:match[0]])
+		out.WriteString(Redacted)
+		last = match[1]
+	}
+	out.WriteString(s[last:])
+	return out.String()
+}
+
+// Pseudonym replaces a username by a stable hash, so records of one user
+// can still be correlated
+func Pseudonym(name string) string {
+	if name == "" {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	return "user:" + hex.EncodeToString(sum[:4])
+}
+
+// fingerprint returns the bracketed SHA256 fingerprint of a key blob
+func fingerprint(blob string) string {
+	data, err := base64.StdEncoding.DecodeString(blob)
+	if err != nil {
+		return Redacted
+	}
+	sum := sha256.Sum256(data)
+	return "[SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]) + "]"
+}
@@ -0,0 +1,104 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// SARIF result levels
+const (
+	LevelError   = "error"
+	LevelWarning = "warning"
+	LevelNote    = "note"
+)
+
+// sarifSchema is the SARIF 2.1.0 JSON schema
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+// Rule describes a kind of finding
+type Rule struct {
+	ID          string
+	Description string
+}
+
+// Finding is one result of a report, e.g. a stale key in a file
+type Finding struct {
+	RuleID  string
+	Level   string
+	Message string
+
+	// Location is the file the finding is about (empty = none)
+	Location string
+
+	// Properties are extra key/value pairs, e.g. "fingerprint"
+	Properties map[string]string
+}
+
+// WriteSARIF writes findings as a SARIF 2.1.0 log with one run of the tool
+func WriteSARIF(w io.Writer, tool, version string, rules []Rule, findings []Finding) error {
+	type message struct {
+		Text string `json:"text"`
+	}
+	type location struct {
+		PhysicalLocation struct {
+			ArtifactLocation struct {
+				URI string `json:"uri"`
+			} `json:"artifactLocation"`
+		} `json:"physicalLocation"`
+	}
+	type result struct {
+		RuleID     string            `json:"ruleId"`
+		Level      string            `json:"level"`
+		Message    message           `json:"message"`
+		Locations  []location        `json:"locations,omitempty"`
+		Properties map[string]string `json:"properties,omitempty"`
+	}
+	type rule struct {
+		ID               string  `json:"id"`
+		ShortDescription message `json:"shortDescription"`
+	}
+
+	sarifRules := make([]rule, 0, len(rules))
+	for _, r := range rules {
+		sarifRules = append(sarifRules, rule{ID: r.ID, ShortDescription: message{r.Description}})
+	}
+	sort.Slice(sarifRules, func(i, j int) bool { return sarifRules[i].ID < sarifRules[j].ID })
+
+	results := make([]result, 0, len(findings))
+	for _, f := range findings {
+		res := result{RuleID: f.RuleID, Level: f.Level, Message: message{f.Message}, Properties: f.Properties}
+		if f.Location != "" {
+			var loc location
+			loc.PhysicalLocation.ArtifactLocation.URI = fileURI(f.Location)
+			res.Locations = []location{loc}
+		}
+		results = append(results, res)
+	}
+
+	log := map[string]any{
+		"version": "2.1.0",
+		"$schema": sarifSchema,
+		"runs": []any{map[string]any{
+			"tool": map[string]any{"driver": map[string]any{
+				"name":    tool,
+				"version": version,
+				"rules":   sarifRules,
+			}},
+			"results": results,
+		}},
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(log)
+}
+
+// fileURI turns an absolute path into a file URI; other locations are
+// used as given
+func fileURI(path string) string {
+	if len(path) > 0 && path[0] == '/' {
+		return "file://" + path
+	}
+	return path
+}
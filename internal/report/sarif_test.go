@@ -0,0 +1,79 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestWriteSARIF(t *testing.T) {
+	rules := []Rule{
+		{ID: "unknown-key", Description: "Key added by hand"},
+		{ID: "stale-key", Description: "Key no longer served"},
+	}
+	findings := []Finding{
+		{
+			RuleID:     "stale-key",
+			Level:      LevelWarning,
+			Message:    "deploy: stale key SHA256:abc",
+			Location:   "/home/deploy/.ssh/authorized_keys",
+			Properties: map[string]string{"fingerprint": "SHA256:abc"},
+		},
+		{RuleID: "unknown-key", Level: LevelNote, Message: "no location"},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteSARIF(&buf, "charon-key", "1.0.0", rules, findings); err != nil {
+		t.Fatalf("WriteSARIF() error = %v", err)
+	}
+
+	var log struct {
+		Version string `json:"version"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Name  string `json:"name"`
+					Rules []struct {
+						ID string `json:"id"`
+					} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []struct {
+				RuleID    string `json:"ruleId"`
+				Level     string `json:"level"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+				Properties map[string]string `json:"properties"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatalf("WriteSARIF() wrote invalid JSON: %v", err)
+	}
+
+	if log.Version != "2.1.0" || len(log.Runs) != 1 {
+		t.Fatalf("WriteSARIF() = version %q with %d runs, want 2.1.0 with 1", log.Version, len(log.Runs))
+	}
+	run := log.Runs[0]
+	if run.Tool.Driver.Name != "charon-key" || len(run.Tool.Driver.Rules) != 2 || run.Tool.Driver.Rules[0].ID != "stale-key" {
+		t.Errorf("WriteSARIF() driver = %+v, want charon-key with sorted rules", run.Tool.Driver)
+	}
+	if len(run.Results) != 2 {
+		t.Fatalf("WriteSARIF() wrote %d results, want 2", len(run.Results))
+	}
+	stale := run.Results[0]
+	if stale.RuleID != "stale-key" || stale.Level != LevelWarning || stale.Properties["fingerprint"] != "SHA256:abc" {
+		t.Errorf("WriteSARIF() result = %+v", stale)
+	}
+	if len(stale.Locations) != 1 || stale.Locations[0].PhysicalLocation.ArtifactLocation.URI != "file:///home/deploy/.ssh/authorized_keys" {
+		t.Errorf("WriteSARIF() locations = %+v", stale.Locations)
+	}
+	if len(run.Results[1].Locations) != 0 {
+		t.Errorf("WriteSARIF() result without location has locations %+v", run.Results[1].Locations)
+	}
+}
@@ -1,12 +1,20 @@
 package resolver
 
 import (
+	"context"
+	stderrors "errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/dgarifullin/charon-key/internal/cache"
 	"github.com/dgarifullin/charon-key/internal/config"
 	"github.com/dgarifullin/charon-key/internal/github"
 	"github.com/dgarifullin/charon-key/internal/logger"
+	"github.com/dgarifullin/charon-key/internal/revoke"
+	"github.com/dgarifullin/charon-key/internal/ssh"
 )
 
 // Resolver handles the key resolution logic
@@ -15,117 +23,712 @@ type Resolver struct {
 	fetcher *github.Fetcher
 	cache   *cache.Manager
 	logger  *logger.Logger
+	options ResolverOptions
+	mapping MappingSource
+
+	// fetched memoizes per-GitHub-user results for the life of the resolver,
+	// so SSH users sharing GitHub users (e.g. bot accounts) in one invocation
+	// hit GitHub once. Keyed by lowercased username (GitHub is case-insensitive)
+	fetched map[string]fetchResult
+
+	// metadata holds per-key metadata from GitHub's API for users whose
+	// keys came from the authenticated API, keyed like fetched
+	metadata map[string]map[string]cache.KeyMetadata
+
+	// stats counts this resolver's work for the invocation summary
+	stats Stats
+
+	// onStaleCache is notified when expired cache is served because GitHub
+	// failed (nil = disabled)
+	onStaleCache func(githubUser string, cachedAt time.Time, err error)
+
+	// revoked are the fingerprints and GitHub users never served (nil = none)
+	revoked *revoke.List
+
+	// cacheFor returns the cache of an SSH user when each SSH user has its
+	// own (nil = every SSH user shares cache), and userCaches memoizes it
+	cacheFor   func(sshUsername string) (*cache.Manager, error)
+	userCaches map[string]*cache.Manager
+}
+
+// scope is where the GitHub users of an SSH user are cached and memoized
+type scope struct {
+	cache *cache.Manager
+
+	// prefix starts the keys of fetched and metadata ("" = shared by every
+	// SSH user)
+	prefix string
+}
+
+// key returns the memoization key of a GitHub user in the scope
+func (s scope) key(githubUser string) string {
+	return s.prefix + strings.ToLower(githubUser)
+}
+
+// fetchResult is the memoized outcome of resolving one GitHub user
+type fetchResult struct {
+	keys      []string
+	fetchedAt time.Time
+	err       error
+}
+
+// Stats summarizes the work done by a resolver
+type Stats struct {
+	// GitHubUsers is the number of distinct GitHub users resolved
+	GitHubUsers int
+
+	// CacheHits counts GitHub users served from a fresh cache entry
+	CacheHits int
+
+	// Fetches counts GitHub users fetched from GitHub (including failures)
+	// and FetchTime is the time spent doing so
+	Fetches   int
+	FetchTime time.Duration
+
+	// StaleCache counts GitHub users served from an expired cache entry
+	// because fetching failed
+	StaleCache int
+}
+
+// Stats returns the resolver's counters
+func (r *Resolver) Stats() Stats {
+	return r.stats
+}
+
+// MappingSource resolves SSH users to GitHub users dynamically (e.g. LDAP),
+// in addition to the static user map
+type MappingSource interface {
+	LookupGitHubUsers(sshUsername string) ([]string, error)
+}
+
+// SetMappingSource sets a dynamic mapping source consulted on every resolution
+func (r *Resolver) SetMappingSource(source MappingSource) {
+	r.mapping = source
+}
+
+// SetStaleCacheHandler sets a function called whenever an expired cache entry
+// is served because fetching from GitHub failed
+func (r *Resolver) SetStaleCacheHandler(handler func(githubUser string, cachedAt time.Time, err error)) {
+	r.onStaleCache = handler
+}
+
+// SetRevocations sets the revocation list; revoked GitHub users aren't
+// resolved and revoked keys are dropped, whether fetched, cached or in their
+// grace period
+func (r *Resolver) SetRevocations(list *revoke.List) {
+	r.revoked = list
+}
+
+// SetCacheIsolation gives each SSH user its own cache, returned by cacheFor
+// (e.g. cache.Manager.ForSSHUser), and its own memoized results, so an SSH
+// user is never served keys resolved for another one
+// Prefetch does nothing then, as it resolves GitHub users for no SSH user
+func (r *Resolver) SetCacheIsolation(cacheFor func(sshUsername string) (*cache.Manager, error)) {
+	r.cacheFor = cacheFor
+	r.userCaches = make(map[string]*cache.Manager)
+}
+
+// memoPrefix returns the memoization key prefix of an SSH user
+func (r *Resolver) memoPrefix(sshUsername string) string {
+	if r.cacheFor == nil || sshUsername == "" {
+		return ""
+	}
+	return sshUsername + "\x00"
+}
+
+// scopeFor returns the scope an SSH user's GitHub users are resolved in
+// (shared for the empty username of wildcard-only mappings)
+func (r *Resolver) scopeFor(sshUsername string) (scope, error) {
+	if r.cacheFor == nil || sshUsername == "" {
+		return scope{cache: r.cache}, nil
+	}
+	userCache, ok := r.userCaches[sshUsername]
+	if !ok {
+		var err error
+		if userCache, err = r.cacheFor(sshUsername); err != nil {
+			return scope{}, err
+		}
+		r.userCaches[sshUsername] = userCache
+	}
+	return scope{cache: userCache, prefix: r.memoPrefix(sshUsername)}, nil
 }
 
 // NewResolver creates a new resolver with the given components
 func NewResolver(cfg *config.Config, fetcher *github.Fetcher, cacheManager *cache.Manager, log *logger.Logger) *Resolver {
 	return &Resolver{
-		config:  cfg,
-		fetcher: fetcher,
-		cache:   cacheManager,
-		logger:  log,
+		config:   cfg,
+		fetcher:  fetcher,
+		cache:    cacheManager,
+		logger:   log,
+		options:  DefaultResolverOptions(),
+		fetched:  make(map[string]fetchResult),
+		metadata: make(map[string]map[string]cache.KeyMetadata),
 	}
 }
 
+// Key is a resolved SSH key together with where it came from
+type Key struct {
+	// Line is the key as served by GitHub ("type blob [comment]")
+	Line string
+
+	// GitHubUser is the GitHub account the key was resolved from, or the
+	// "gist:" or "deploy-keys:" entry for keys sourced from gists and deploy
+	// keys
+	GitHubUser string
+
+	// FetchedAt is when the key was fetched from GitHub
+	FetchedAt time.Time
+
+	// GitHubKeyID is GitHub's ID for the key object, and CreatedAt when the
+	// key was added to GitHub; both are known only when the key came from
+	// the authenticated API
+	GitHubKeyID string
+	CreatedAt   time.Time
+}
+
+// Source returns where the key came from, e.g. "github:alice",
+// "gist:aa5a315d61ae9438b18d" or "deploy-keys:acme/app"
+func (k Key) Source() string {
+	if !config.IsGitHubUser(k.GitHubUser) {
+		return k.GitHubUser
+	}
+	return "github:" + k.GitHubUser
+}
+
+// Provenance returns a human-readable note describing the key's source
+// e.g. "via charon-key github:alice 2024-05-01", with "key:<id>" before
+// the date when the GitHub key ID is known
+func (k Key) Provenance() string {
+	if k.GitHubKeyID != "" {
+		return fmt.Sprintf("via charon-key %s key:%s %s", k.Source(), k.GitHubKeyID, k.FetchedAt.Format("2006-01-02"))
+	}
+	return fmt.Sprintf("via charon-key %s %s", k.Source(), k.FetchedAt.Format("2006-01-02"))
+}
+
 // ResolveKeys resolves SSH keys for the given SSH username
 // Returns all authorized keys (merged from all GitHub users)
 // If sshUsername is empty, will try to use wildcard mapping if available
 func (r *Resolver) ResolveKeys(sshUsername string) ([]string, error) {
+	sources, err := r.ResolveKeySources(sshUsername)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(sources))
+	for _, key := range sources {
+		result = append(result, key.Line)
+	}
+	return result, nil
+}
+
+// ResolveKeySources resolves SSH keys for the given SSH username, keeping
+// track of which GitHub user each key came from
+// A key served by several GitHub users is attributed to the first of them
+func (r *Resolver) ResolveKeySources(sshUsername string) ([]Key, error) {
+	var result []Key
+	err := r.StreamKeySources(sshUsername, func(key Key) error {
+		result = append(result, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sortKeys(result)
+	return result, nil
+}
+
+// StreamKeySources resolves SSH keys for the given SSH username like
+// ResolveKeySources, but passes each key to emit as soon as its GitHub user
+// is resolved instead of collecting them. Keys come in mapping order, sorted
+// within each GitHub user. An error from emit stops resolution.
+func (r *Resolver) StreamKeySources(sshUsername string, emit func(Key) error) error {
 	// Empty username is allowed if wildcard mapping exists
 	// We'll check for wildcard in GetGitHubUsers
 
 	r.logger.Debug("resolving keys", "ssh_username", sshUsername)
 
 	// Step 1: Look up GitHub user(s) from mapping
-	githubUsers := r.config.GetGitHubUsers(sshUsername)
+	githubUsers := r.lookupGitHubUsers(sshUsername)
 	if len(githubUsers) == 0 {
 		r.logger.Error("no GitHub users mapped", "ssh_username", sshUsername)
-		return nil, fmt.Errorf("no GitHub users mapped for SSH user %q", sshUsername)
+		return fmt.Errorf("no GitHub users mapped for SSH user %q", sshUsername)
 	}
 
 	r.logger.Debug("found GitHub users", "ssh_username", sshUsername, "github_users", githubUsers)
 
+	s, err := r.scopeFor(sshUsername)
+	if err != nil {
+		r.logger.Error("failed to open the SSH user's cache", "ssh_username", sshUsername, "error", err)
+		return fmt.Errorf("failed to open the cache of SSH user %q: %w", sshUsername, err)
+	}
+
+	// Overall budget for resolving all mapped users
+	ctx := context.Background()
+	if r.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.options.Timeout)
+		defer cancel()
+	}
+
+	// Fetch uncached users in bulk when possible; the loop below picks
+	// the results up and handles whatever is left one user at a time
+	r.prefetch(ctx, s, githubUsers)
+
 	// Step 2: Resolve keys for all GitHub users
-	allKeys := make(map[string]bool) // Use map to deduplicate
+	seen := make(map[string]bool) // Deduplicate across GitHub users
+	emitted := 0
 	var errors []string
+	kinds := make(map[github.ErrorKind]bool)
 
 	for _, githubUser := range githubUsers {
-		keys, err := r.resolveKeysForGitHubUser(githubUser)
+		if config.IsGitHubUser(githubUser) && r.revoked.GitHubUserRevoked(githubUser) {
+			r.logger.Warn("GitHub user revoked, not serving its keys", "ssh_username", sshUsername, "github_user", githubUser)
+			continue
+		}
+		keys, fetchedAt, err := r.resolveCoalesced(ctx, s, githubUser)
 		if err != nil {
 			errors = append(errors, fmt.Sprintf("%s: %v", githubUser, err))
+			kinds[github.KindOf(err)] = true
 			continue // Continue with other users even if one fails
 		}
 
-		// Merge keys (deduplicate)
-		for _, key := range keys {
-			allKeys[key] = true
+		// Keys removed within the grace period follow the current ones
+		removed := r.removedKeys(s, githubUser)
+		lines := keys
+		for _, key := range removed {
+			lines = append(lines[:len(lines):len(lines)], key.Key)
 		}
-	}
 
-	// Convert map to slice
-	result := make([]string, 0, len(allKeys))
-	for key := range allKeys {
-		result = append(result, key)
+		// Drop duplicates and keys excluded for this SSH user
+		metadata := r.metadata[s.key(githubUser)]
+		batch := make([]Key, 0, len(lines))
+		for i, line := range lines {
+			if seen[line] {
+				continue
+			}
+			seen[line] = true
+			if r.config.FeatureEnabled(config.FeatureExcludeComments, sshUsername) && r.config.IsExcludedComment(sshUsername, keyComment(line)) {
+				r.logger.Info("key excluded by comment pattern", "ssh_username", sshUsername, "github_user", githubUser, "comment", keyComment(line))
+				continue
+			}
+			if r.keyRevoked(line) {
+				r.logger.Warn("key revoked, not serving it", "ssh_username", sshUsername, "github_user", githubUser, "key", line)
+				continue
+			}
+			if i >= len(keys) {
+				removedAt := removed[i-len(keys)].RemovedAt
+				r.logger.Warn("serving key removed from GitHub during grace period", "ssh_username", sshUsername, "github_user", githubUser,
+					"key", line, "removed_at", removedAt, "grace_until", removedAt.Add(r.options.GracePeriod))
+			}
+			batch = append(batch, Key{
+				Line:        line,
+				GitHubUser:  githubUser,
+				FetchedAt:   fetchedAt,
+				GitHubKeyID: metadata[line].GitHubID,
+				CreatedAt:   metadata[line].CreatedAt,
+			})
+		}
+		sortKeys(batch)
+
+		for _, key := range batch {
+			if err := emit(key); err != nil {
+				return err
+			}
+			emitted++
+		}
 	}
 
 	// If all requests failed, return error
-	if len(result) == 0 && len(errors) == len(githubUsers) {
+	if emitted == 0 && len(errors) == len(githubUsers) {
 		r.logger.Error("failed to resolve keys for all GitHub users", "ssh_username", sshUsername, "errors", joinErrors(errors))
-		return nil, fmt.Errorf("failed to resolve keys for all GitHub users: %s", joinErrors(errors))
+		err := fmt.Errorf("failed to resolve keys for all GitHub users: %s", joinErrors(errors))
+		// Keep the category when every user failed the same way
+		if len(kinds) == 1 {
+			for kind := range kinds {
+				err = &github.FetchError{Kind: kind, Err: err}
+			}
+		}
+		return err
 	}
 
 	if len(errors) > 0 {
-		r.logger.Warn("partial failure resolving keys", "ssh_username", sshUsername, "errors", joinErrors(errors), "keys_resolved", len(result))
+		r.logger.Warn("partial failure resolving keys", "ssh_username", sshUsername, "errors", joinErrors(errors), "keys_resolved", emitted)
 	}
 
-	r.logger.Debug("resolved keys", "ssh_username", sshUsername, "total_keys", len(result))
+	r.logger.Debug("resolved keys", "ssh_username", sshUsername, "total_keys", emitted)
 
 	// Return partial results if some succeeded
-	return result, nil
+	return nil
+}
+
+// keyRevoked reports whether a key's fingerprint is on the revocation list
+func (r *Resolver) keyRevoked(line string) bool {
+	if r.revoked.Len() == 0 {
+		return false
+	}
+	fingerprint, err := ssh.Fingerprint(line)
+	return err == nil && r.revoked.KeyRevoked(fingerprint)
+}
+
+// removedKeys returns the keys removed from a GitHub user within the grace
+// period (none without one, and for gists and deploy keys)
+func (r *Resolver) removedKeys(s scope, githubUser string) []cache.RemovedKey {
+	if r.options.GracePeriod <= 0 || !config.IsGitHubUser(githubUser) {
+		return nil
+	}
+	removed, err := s.cache.RemovedKeys(githubUser, r.options.GracePeriod)
+	if err != nil {
+		r.logger.Warn("failed to read removed keys", "github_user", githubUser, "error", err)
+		return nil
+	}
+	return removed
+}
+
+// GitHubUsers returns the GitHub users mapped to an SSH user, from the
+// static user map and the dynamic mapping source
+func (r *Resolver) GitHubUsers(sshUsername string) []string {
+	return r.lookupGitHubUsers(sshUsername)
+}
+
+// lookupGitHubUsers combines the static user map with the dynamic mapping
+// source, if any. A failing dynamic source is logged and skipped so the
+// static mapping keeps working.
+// GitHub usernames differing only in case name the same account (and the
+// same upstream URL), so only the first spelling is kept.
+func (r *Resolver) lookupGitHubUsers(sshUsername string) []string {
+	githubUsers := r.config.GetGitHubUsers(sshUsername)
+	if r.mapping != nil && sshUsername != "" && r.config.FeatureEnabled(config.FeatureLDAP, sshUsername) {
+		dynamicUsers, err := r.mapping.LookupGitHubUsers(sshUsername)
+		if err != nil {
+			r.logger.Warn("dynamic mapping lookup failed", "ssh_username", sshUsername, "error", err)
+		} else {
+			r.logger.Debug("dynamic mapping lookup", "ssh_username", sshUsername, "github_users", dynamicUsers)
+			githubUsers = append(githubUsers, dynamicUsers...)
+		}
+	}
+
+	seen := make(map[string]bool)
+	result := make([]string, 0, len(githubUsers))
+	for _, user := range githubUsers {
+		if name := strings.ToLower(user); !seen[name] {
+			seen[name] = true
+			result = append(result, user)
+		}
+	}
+	return result
+}
+
+// resolveCoalesced resolves a GitHub user at most once per resolver
+// Results from timed-out or cancelled resolutions are not reused, since a
+// later SSH user gets a fresh time budget
+func (r *Resolver) resolveCoalesced(ctx context.Context, s scope, githubUser string) ([]string, time.Time, error) {
+	name := s.key(githubUser)
+	if result, ok := r.fetched[name]; ok {
+		r.logger.Debug("reusing keys resolved earlier in this invocation", "github_user", githubUser)
+		return result.keys, result.fetchedAt, result.err
+	}
+
+	keys, fetchedAt, err := r.resolveKeysForGitHubUser(ctx, s, githubUser)
+	r.stats.GitHubUsers++
+	if err == nil || ctx.Err() == nil {
+		r.fetched[name] = fetchResult{keys: keys, fetchedAt: fetchedAt, err: err}
+	}
+	return keys, fetchedAt, err
+}
+
+// FetchFailure returns why resolving a GitHub user failed earlier in this
+// invocation (nil if it succeeded or wasn't resolved), for per-user error
+// reports
+func (r *Resolver) FetchFailure(sshUsername, githubUser string) error {
+	return r.fetched[scope{prefix: r.memoPrefix(sshUsername)}.key(githubUser)].err
+}
+
+// staleEvent is an expired cache entry served while prefetching, reported
+// to onStaleCache once prefetching is done
+type staleEvent struct {
+	githubUser string
+	cachedAt   time.Time
+	err        error
+}
+
+// Prefetch resolves GitHub users with up to workers fetches in parallel
+// (after bulk fetching what it can, as StreamKeySources does), so SSH users
+// resolved afterwards find their results memoized and are output in order
+// Failures are memoized too and reported when the SSH users are resolved
+func (r *Resolver) Prefetch(githubUsers []string, workers int) {
+	if r.cacheFor != nil {
+		r.logger.Debug("not prefetching, each SSH user has its own cache")
+		return
+	}
+	shared := scope{cache: r.cache}
+	ctx := context.Background()
+	if r.options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.options.Timeout)
+		defer cancel()
+	}
+	r.prefetch(ctx, shared, githubUsers)
+
+	seen := make(map[string]bool)
+	var pending []string
+	for _, githubUser := range githubUsers {
+		name := strings.ToLower(githubUser)
+		if _, ok := r.fetched[name]; !ok && !seen[name] {
+			seen[name] = true
+			pending = append(pending, githubUser)
+		}
+	}
+	workers = min(workers, len(pending))
+	if workers < 2 {
+		return // Resolved one at a time when the SSH users are
+	}
+	r.logger.Debug("prefetching GitHub users", "github_users", len(pending), "workers", workers)
+
+	// Each worker resolves with its own copy of the resolver's state, merged
+	// back once all are done
+	jobs := make(chan string)
+	children := make([]*Resolver, workers)
+	stale := make([][]staleEvent, workers)
+	var wg sync.WaitGroup
+	for i := range children {
+		child := &Resolver{
+			config:   r.config,
+			fetcher:  r.fetcher.Clone(),
+			cache:    r.cache,
+			logger:   r.logger,
+			options:  r.options,
+			fetched:  make(map[string]fetchResult),
+			metadata: make(map[string]map[string]cache.KeyMetadata),
+		}
+		child.onStaleCache = func(githubUser string, cachedAt time.Time, err error) {
+			stale[i] = append(stale[i], staleEvent{githubUser: githubUser, cachedAt: cachedAt, err: err})
+		}
+		children[i] = child
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for githubUser := range jobs {
+				child.resolveCoalesced(ctx, shared, githubUser)
+			}
+		}()
+	}
+	for _, githubUser := range pending {
+		jobs <- githubUser
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i, child := range children {
+		for name, result := range child.fetched {
+			r.fetched[name] = result
+		}
+		for name, metadata := range child.metadata {
+			r.metadata[name] = metadata
+		}
+		r.stats.GitHubUsers += child.stats.GitHubUsers
+		r.stats.CacheHits += child.stats.CacheHits
+		r.stats.Fetches += child.stats.Fetches
+		r.stats.FetchTime += child.stats.FetchTime
+		r.stats.StaleCache += child.stats.StaleCache
+		if r.onStaleCache != nil {
+			for _, event := range stale[i] {
+				r.onStaleCache(event.githubUser, event.cachedAt, event.err)
+			}
+		}
+	}
+}
+
+// prefetch resolves GitHub users (not gists or deploy keys) that have no
+// fresh cache entry with GraphQL bulk requests, if the fetcher has a token and more than one user
+// needs fetching (or any user, with the KeyMetadata option). Results are
+// memoized and cached; users the bulk fetch couldn't resolve, or a failed
+// bulk fetch, fall back to the per-user path.
+func (r *Resolver) prefetch(ctx context.Context, s scope, githubUsers []string) {
+	if !r.fetcher.HasToken() {
+		return
+	}
+
+	var pending []string
+	for _, githubUser := range githubUsers {
+		if _, ok := r.fetched[s.key(githubUser)]; ok || !config.IsGitHubUser(githubUser) {
+			continue
+		}
+		if entry, isExpired, err := s.cache.ReadEntry(githubUser); !r.options.Refresh && err == nil && entry != nil && len(entry.Keys) > 0 && !isExpired {
+			continue
+		}
+		pending = append(pending, githubUser)
+	}
+	if len(pending) == 0 || (len(pending) < 2 && !r.options.KeyMetadata) {
+		return // A single user is just as fast over the per-user endpoint
+	}
+
+	if time.Now().Before(r.cache.RateLimitedUntil()) {
+		return
+	}
+
+	r.logger.Info("fetching keys from GitHub in bulk", "github_users", len(pending))
+	start := time.Now()
+	results, err := r.fetcher.FetchKeysBulk(ctx, pending)
+	r.stats.FetchTime += time.Since(start)
+	r.recordRateLimit(err)
+	if err != nil {
+		r.logger.Warn("bulk fetch failed, fetching users one at a time", "error", err)
+		return
+	}
+
+	fetchedAt := time.Now()
+	for githubUser, publicKeys := range results {
+		keys, metadata := splitMetadata(publicKeys)
+		if err := s.cache.WriteWithMetadata(githubUser, keys, metadata); err != nil {
+			r.logger.Warn("failed to write cache", "github_user", githubUser, "error", err)
+		}
+		r.fetched[s.key(githubUser)] = fetchResult{keys: keys, fetchedAt: fetchedAt}
+		r.metadata[s.key(githubUser)] = metadata
+		r.stats.GitHubUsers++
+		r.stats.Fetches++
+	}
+}
+
+// splitMetadata separates keys returned by the GraphQL API from their metadata
+func splitMetadata(publicKeys []github.PublicKey) ([]string, map[string]cache.KeyMetadata) {
+	keys := make([]string, 0, len(publicKeys))
+	metadata := make(map[string]cache.KeyMetadata, len(publicKeys))
+	for _, key := range publicKeys {
+		keys = append(keys, key.Key)
+		metadata[key.Key] = cache.KeyMetadata{GitHubID: key.ID, CreatedAt: key.CreatedAt}
+	}
+	return keys, metadata
+}
+
+// recordRateLimit persists a GitHub rate limit in the cache directory, so
+// later charon-key processes back off too instead of burning retries
+func (r *Resolver) recordRateLimit(err error) {
+	var rateErr *github.RateLimitError
+	if !stderrors.As(err, &rateErr) {
+		return
+	}
+	until := time.Now().Add(rateErr.Backoff())
+	if err := r.cache.SetRateLimitedUntil(until); err != nil {
+		r.logger.Warn("failed to record rate limit", "error", err)
+	}
+}
+
+// recordFetch adds a fetch's outcome to the GitHub user's fetch history in
+// the cache directory, for per-user and per-provider SLOs
+func (r *Resolver) recordFetch(githubUser string, start time.Time, err error) {
+	record := cache.FetchRecord{At: start, Duration: time.Since(start), OK: err == nil}
+	if err != nil {
+		record.ErrorKind = string(github.KindOf(err))
+	}
+	if err := r.cache.RecordFetch(githubUser, record); err != nil {
+		r.logger.Debug("failed to record fetch", "github_user", githubUser, "error", err)
+	}
 }
 
 // resolveKeysForGitHubUser resolves keys for a single GitHub user
 // Implements the full flow: cache check -> fetch if needed -> update cache
-func (r *Resolver) resolveKeysForGitHubUser(githubUser string) ([]string, error) {
+// Returns the keys and the time they were fetched from GitHub
+func (r *Resolver) resolveKeysForGitHubUser(ctx context.Context, s scope, githubUser string) ([]string, time.Time, error) {
 	// Step 1: Check cache
-	cachedKeys, isExpired, err := r.cache.Read(githubUser)
+	var cachedKeys []string
+	var cachedAt time.Time
+	entry, isExpired, err := s.cache.ReadEntry(githubUser)
 	if err != nil {
 		// Cache read error (not a cache miss) - log but continue
 		r.logger.Debug("cache read error", "github_user", githubUser, "error", err)
 		// We'll try to fetch fresh keys
+	} else if entry != nil {
+		cachedKeys = entry.Keys
+		cachedAt = entry.Timestamp
+		r.metadata[s.key(githubUser)] = entry.Metadata
 	}
 
 	// Step 2: If cache exists and not expired, return cached keys
-	if cachedKeys != nil && len(cachedKeys) > 0 && !isExpired {
+	// (unless refreshing; the entry is still kept as an offline fallback)
+	switch {
+	case r.options.Refresh:
+		r.logger.Debug("cache bypassed (refresh)", "github_user", githubUser)
+	case cachedKeys != nil && len(cachedKeys) > 0 && !isExpired:
 		r.logger.Debug("cache hit", "github_user", githubUser, "keys_count", len(cachedKeys))
-		return cachedKeys, nil
+		r.stats.CacheHits++
+		return cachedKeys, cachedAt, nil
+	case cachedKeys != nil && len(cachedKeys) > 0:
+		r.logger.Debug("cache expired", "github_user", githubUser)
+	default:
+		r.logger.Debug("cache miss", "github_user", githubUser)
 	}
 
-	if cachedKeys != nil && len(cachedKeys) > 0 && isExpired {
-		r.logger.Debug("cache expired", "github_user", githubUser)
+	// Per-user deadline so one slow user can't starve the others, waiting
+	// for the lock below included
+	if r.options.UserTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.options.UserTimeout)
+		defer cancel()
+	}
+
+	// Serialize fetches for this user across concurrent charon-key processes.
+	// Whoever gets the lock first fetches; the others find a fresh cache.
+	unlock, err := lockCache(ctx, s.cache, githubUser)
+	if err != nil {
+		r.logger.Debug("cache lock unavailable, fetching without it", "github_user", githubUser, "error", err)
 	} else {
-		r.logger.Debug("cache miss", "github_user", githubUser)
+		defer unlock()
+		if entry, isExpired, err := s.cache.ReadEntry(githubUser); !r.options.Refresh && err == nil && entry != nil && len(entry.Keys) > 0 && !isExpired {
+			r.logger.Debug("cache refreshed by concurrent process", "github_user", githubUser, "keys_count", len(entry.Keys))
+			r.stats.CacheHits++
+			r.metadata[s.key(githubUser)] = entry.Metadata
+			return entry.Keys, entry.Timestamp, nil
+		}
 	}
 
 	// Step 3: Fetch from GitHub (cache expired or missing)
-	r.logger.Info("fetching keys from GitHub", "github_user", githubUser)
-	keys, err := r.fetcher.FetchKeys(githubUser)
+	// Honor a rate limit recorded by an earlier process; otherwise fetch
+	var keys []string
+	var metadata map[string]cache.KeyMetadata
+	if until := r.cache.RateLimitedUntil(); time.Now().Before(until) {
+		err = &github.RateLimitError{RetryAfter: time.Until(until)}
+	} else {
+		r.logger.Info("fetching keys from GitHub", "github_user", githubUser)
+		start := time.Now()
+		keys, metadata, err = r.fetchKeys(ctx, githubUser)
+		r.stats.Fetches++
+		r.stats.FetchTime += time.Since(start)
+		r.recordRateLimit(err)
+		r.recordFetch(githubUser, start, err)
+	}
+	fetchedAt := time.Now()
 	if err != nil {
-		r.logger.Warn("failed to fetch keys from GitHub", "github_user", githubUser, "error", err)
+		kind := github.KindOf(err)
+		r.logger.Warn("failed to fetch keys from GitHub", "github_user", githubUser, "error", err, "error_kind", kind)
+		// A deleted or renamed account's old keys must not keep granting
+		// access, so only other failures fall back to the expired cache
+		if kind == github.KindNotFound && cachedKeys != nil && len(cachedKeys) > 0 {
+			r.logger.Warn("GitHub user not found, not using expired cache", "github_user", githubUser)
+		}
 		// Network error - try to use expired cache if available
-		if cachedKeys != nil && len(cachedKeys) > 0 {
+		if r.options.UseExpiredCache && kind != github.KindNotFound && cachedKeys != nil && len(cachedKeys) > 0 {
 			// Use expired cache as fallback (offline mode)
 			r.logger.Info("using expired cache as fallback", "github_user", githubUser, "keys_count", len(cachedKeys))
-			return cachedKeys, nil
+			r.stats.StaleCache++
+			if r.onStaleCache != nil {
+				r.onStaleCache(githubUser, cachedAt, err)
+			}
+			return cachedKeys, cachedAt, nil
 		}
 		// No cache available, return error
-		return nil, fmt.Errorf("failed to fetch keys from GitHub and no cache available: %w", err)
+		return nil, time.Time{}, fmt.Errorf("failed to fetch keys from GitHub and no cache available: %w", err)
 	}
 
 	r.logger.Info("fetched keys from GitHub", "github_user", githubUser, "keys_count", len(keys))
 
+	// Only deploy keys come with key IDs; the per-user endpoint and gists
+	// report none
+	r.metadata[s.key(githubUser)] = metadata
+
 	// Step 4: Update cache with fresh keys
-	if err := r.cache.Write(githubUser, keys); err != nil {
+	if err := s.cache.WriteWithMetadata(githubUser, keys, metadata); err != nil {
 		// Cache write error - log but don't fail the request
 		r.logger.Warn("failed to write cache", "github_user", githubUser, "error", err)
 		// Keys are still valid, just not cached
@@ -133,7 +736,84 @@ func (r *Resolver) resolveKeysForGitHubUser(githubUser string) ([]string, error)
 		r.logger.Debug("cache updated", "github_user", githubUser)
 	}
 
-	return keys, nil
+	return keys, fetchedAt, nil
+}
+
+// RefreshGitHubUser fetches a GitHub user's keys and rewrites the cache
+// entry, even if it is fresh. On failure the existing entry is left alone
+// With a token, keys are fetched with the GraphQL API so the entry keeps
+// its key metadata
+func (r *Resolver) RefreshGitHubUser(ctx context.Context, githubUser string) error {
+	unlock, err := lockCache(ctx, r.cache, githubUser)
+	if err != nil {
+		r.logger.Debug("cache lock unavailable, fetching without it", "github_user", githubUser, "error", err)
+	} else {
+		defer unlock()
+	}
+
+	if until := r.cache.RateLimitedUntil(); time.Now().Before(until) {
+		return &github.RateLimitError{RetryAfter: time.Until(until)}
+	}
+
+	start := time.Now()
+	keys, metadata, err := r.fetchWithMetadata(ctx, githubUser)
+	r.stats.Fetches++
+	r.stats.FetchTime += time.Since(start)
+	r.recordRateLimit(err)
+	r.recordFetch(githubUser, start, err)
+	if err != nil {
+		return err
+	}
+
+	if err := r.cache.WriteWithMetadata(githubUser, keys, metadata); err != nil {
+		return fmt.Errorf("failed to write cache: %w", err)
+	}
+	r.logger.Debug("cache refreshed", "github_user", githubUser, "keys_count", len(keys))
+	return nil
+}
+
+// lockCache takes the cache lock for a GitHub user, waiting at most as long
+// as a fetch could take, and never past ctx's deadline
+func lockCache(ctx context.Context, c *cache.Manager, githubUser string) (func(), error) {
+	ctx, cancel := context.WithTimeout(ctx, github.DefaultTimeout)
+	defer cancel()
+	return c.Lock(ctx, githubUser)
+}
+
+// fetchWithMetadata fetches a GitHub user's keys with the GraphQL API if
+// the fetcher has a token, falling back to the per-user endpoint (without
+// metadata) for users the GraphQL API can't resolve or without a token
+func (r *Resolver) fetchWithMetadata(ctx context.Context, githubUser string) ([]string, map[string]cache.KeyMetadata, error) {
+	if r.fetcher.HasToken() && config.IsGitHubUser(githubUser) {
+		results, err := r.fetcher.FetchKeysBulk(ctx, []string{githubUser})
+		if err != nil {
+			return nil, nil, err
+		}
+		if publicKeys, ok := results[githubUser]; ok {
+			keys, metadata := splitMetadata(publicKeys)
+			return keys, metadata, nil
+		}
+	}
+	return r.fetchKeys(ctx, githubUser)
+}
+
+// fetchKeys fetches the keys of a user map entry: a GitHub user's from the
+// per-user endpoint, a gist's, or deploy keys (the only ones with metadata)
+func (r *Resolver) fetchKeys(ctx context.Context, githubUser string) ([]string, map[string]cache.KeyMetadata, error) {
+	if ref, ok := strings.CutPrefix(githubUser, config.GistPrefix); ok {
+		keys, err := r.fetcher.FetchGistKeys(ctx, ref)
+		return keys, nil, err
+	}
+	if ref, ok := strings.CutPrefix(githubUser, config.DeployKeysPrefix); ok {
+		publicKeys, err := r.fetcher.FetchDeployKeys(ctx, ref)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys, metadata := splitMetadata(publicKeys)
+		return keys, metadata, nil
+	}
+	keys, err := r.fetcher.FetchKeysContext(ctx, githubUser)
+	return keys, nil, err
 }
 
 // ResolveKeysForSSHUser resolves keys for the SSH username from config
@@ -145,6 +825,34 @@ func (r *Resolver) ResolveKeysForSSHUser() ([]string, error) {
 	return r.ResolveKeys(r.config.SSHUsername)
 }
 
+// keyComment returns the comment of a "type blob [comment]" key line
+func keyComment(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return ""
+	}
+	return strings.Join(fields[2:], " ")
+}
+
+// sortKeys orders keys by source then fingerprint so output is stable
+// across runs (map iteration order is random)
+func sortKeys(keys []Key) {
+	sort.SliceStable(keys, func(i, j int) bool {
+		if keys[i].GitHubUser != keys[j].GitHubUser {
+			return keys[i].GitHubUser < keys[j].GitHubUser
+		}
+		return sortKey(keys[i].Line) < sortKey(keys[j].Line)
+	})
+}
+
+// sortKey returns the key's fingerprint, or the raw line if it has none
+func sortKey(line string) string {
+	if fingerprint, err := ssh.Fingerprint(line); err == nil {
+		return fingerprint
+	}
+	return line
+}
+
 // joinErrors joins multiple error messages
 func joinErrors(errors []string) string {
 	if len(errors) == 0 {
@@ -165,13 +873,43 @@ type ResolverOptions struct {
 	// UseExpiredCache controls whether to use expired cache when GitHub is unreachable
 	// Default: true (offline mode support)
 	UseExpiredCache bool
+
+	// Timeout bounds the time spent resolving all mapped GitHub users
+	// Default: 0 (no limit beyond the HTTP client timeout)
+	Timeout time.Duration
+
+	// Refresh skips reading fresh cache entries and always fetches from
+	// GitHub (the cache is still written, and used as offline fallback)
+	// Default: false
+	Refresh bool
+
+	// UserTimeout bounds the time spent fetching a single GitHub user,
+	// so one slow user doesn't consume the whole Timeout budget
+	// Default: 0 (no per-user limit)
+	UserTimeout time.Duration
+
+	// KeyMetadata fetches even a single uncached user with the GraphQL API
+	// (if the fetcher has a token), so key IDs and creation times are known
+	// Default: false
+	KeyMetadata bool
+
+	// GracePeriod keeps serving keys removed from a GitHub user for this
+	// long, so an accidental deletion doesn't lock its owner out
+	// Default: 0 (removed keys are dropped at once)
+	GracePeriod time.Duration
+}
+
+// DefaultResolverOptions returns the options used by NewResolver
+func DefaultResolverOptions() ResolverOptions {
+	return ResolverOptions{
+		UseExpiredCache: true,
+	}
 }
 
 // NewResolverWithOptions creates a resolver with custom options
 func NewResolverWithOptions(cfg *config.Config, fetcher *github.Fetcher, cacheManager *cache.Manager, log *logger.Logger, opts ResolverOptions) *Resolver {
 	resolver := NewResolver(cfg, fetcher, cacheManager, log)
-	// Options can be applied here if needed in the future
-	_ = opts
+	resolver.options = opts
 	return resolver
 }
 
@@ -1,9 +1,12 @@
 package resolver
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -11,6 +14,8 @@ import (
 	"github.com/dgarifullin/charon-key/internal/config"
 	"github.com/dgarifullin/charon-key/internal/github"
 	"github.com/dgarifullin/charon-key/internal/logger"
+	"github.com/dgarifullin/charon-key/internal/revoke"
+	"github.com/dgarifullin/charon-key/internal/ssh"
 )
 
 func TestNewResolver(t *testing.T) {
@@ -311,3 +316,914 @@ func TestResolver_Deduplication(t *testing.T) {
 }
 
 
+
+func TestResolver_ResolveKeySources(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		if r.URL.Path == "/user1.keys" {
+			w.Write([]byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB shared@example.com\n"))
+			return
+		}
+		w.Write([]byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB shared@example.com\nssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI own@example.com\n"))
+	}))
+	defer server.Close()
+
+	cacheManager, _ := cache.NewManager(t.TempDir(), 5*time.Minute)
+
+	cfg := &config.Config{
+		UserMap: map[string][]string{
+			"alice": {"user1", "user2"},
+		},
+		CacheTTL: 5 * time.Minute,
+	}
+
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+
+	resolver := NewResolver(cfg, fetcher, cacheManager, logger.NewLogger("error"))
+
+	sources, err := resolver.ResolveKeySources("alice")
+	if err != nil {
+		t.Fatalf("ResolveKeySources() error = %v", err)
+	}
+	if len(sources) != 2 {
+		t.Fatalf("ResolveKeySources() returned %d keys, want 2", len(sources))
+	}
+
+	for _, source := range sources {
+		want := "user2"
+		if strings.HasPrefix(source.Line, "ssh-rsa") {
+			want = "user1" // Shared key is attributed to the first mapped user
+		}
+		if source.GitHubUser != want {
+			t.Errorf("key %q attributed to %q, want %q", source.Line, source.GitHubUser, want)
+		}
+		if source.FetchedAt.IsZero() {
+			t.Errorf("key %q has no fetch time", source.Line)
+		}
+	}
+}
+
+func TestKey_Provenance(t *testing.T) {
+	key := Key{
+		Line:       "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI alice@example.com",
+		GitHubUser: "alice",
+		FetchedAt:  time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+
+	want := "via charon-key github:alice 2024-05-01"
+	if got := key.Provenance(); got != want {
+		t.Errorf("Provenance() = %q, want %q", got, want)
+	}
+
+	key.GitHubKeyID = "MDk6UHVibGljS2V5MQ=="
+	want = "via charon-key github:alice key:MDk6UHVibGljS2V5MQ== 2024-05-01"
+	if got := key.Provenance(); got != want {
+		t.Errorf("Provenance() = %q, want %q", got, want)
+	}
+}
+
+func TestResolver_GistSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/alice.keys":
+			w.Write([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI alice@example.com\n"))
+		case "/gists/aa5a315d61ae9438b18d":
+			w.Write([]byte(`{"files": {"keys": {"content": "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB ci@example.com\n"}}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cacheManager, _ := cache.NewManager(t.TempDir(), 5*time.Minute)
+	cfg := &config.Config{
+		UserMap:  map[string][]string{"deploy": {"alice", "gist:aa5a315d61ae9438b18d"}},
+		CacheTTL: 5 * time.Minute,
+	}
+
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+	fetcher.SetAPIURL(server.URL)
+
+	resolver := NewResolver(cfg, fetcher, cacheManager, logger.NewLogger("error"))
+	sources, err := resolver.ResolveKeySources("deploy")
+	if err != nil {
+		t.Fatalf("ResolveKeySources() error = %v", err)
+	}
+	if len(sources) != 2 {
+		t.Fatalf("ResolveKeySources() returned %d keys, want 2", len(sources))
+	}
+
+	for _, source := range sources {
+		want := "github:alice"
+		if strings.HasPrefix(source.Line, "ssh-rsa") {
+			want = "gist:aa5a315d61ae9438b18d"
+		}
+		if source.Source() != want {
+			t.Errorf("key %q source = %q, want %q", source.Line, source.Source(), want)
+		}
+	}
+
+	if keys, _, _ := cacheManager.Read("gist:aa5a315d61ae9438b18d"); len(keys) != 1 {
+		t.Errorf("cached gist keys = %v, want 1 key", keys)
+	}
+}
+
+func TestResolver_DeployKeys(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/acme/app/keys" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`[{"id": 7, "key": "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI", "created_at": "2024-05-01T12:00:00Z"}]`))
+	}))
+	defer server.Close()
+
+	cacheManager, _ := cache.NewManager(t.TempDir(), 5*time.Minute)
+	cfg := &config.Config{
+		UserMap:  map[string][]string{"ci": {"deploy-keys:acme/app"}},
+		CacheTTL: 5 * time.Minute,
+	}
+
+	fetcher := github.NewFetcher()
+	fetcher.SetAPIURL(server.URL)
+	fetcher.SetToken("test-token")
+
+	resolver := NewResolver(cfg, fetcher, cacheManager, logger.NewLogger("error"))
+	sources, err := resolver.ResolveKeySources("ci")
+	if err != nil {
+		t.Fatalf("ResolveKeySources() error = %v", err)
+	}
+	if len(sources) != 1 {
+		t.Fatalf("ResolveKeySources() returned %d keys, want 1", len(sources))
+	}
+	key := sources[0]
+	if key.Line != "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI acme/app" || key.Source() != "deploy-keys:acme/app" {
+		t.Errorf("key = %q from %q", key.Line, key.Source())
+	}
+	if key.GitHubKeyID != "7" || key.CreatedAt.IsZero() {
+		t.Errorf("key metadata = %q, %v", key.GitHubKeyID, key.CreatedAt)
+	}
+}
+
+func TestResolver_StableOrder(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		switch r.URL.Path {
+		case "/zed.keys":
+			w.Write([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOmzDbXw5GTZ09PuUndofA+zG4l2zb/pAJUQIg9GKwpb zed@example.com\n"))
+		default:
+			w.Write([]byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB a@example.com\nssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAC b@example.com\n"))
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		UserMap: map[string][]string{
+			"alice": {"zed", "amy"},
+		},
+		CacheTTL: 5 * time.Minute,
+	}
+
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+
+	var first []string
+	for i := 0; i < 5; i++ {
+		cacheManager, _ := cache.NewManager(t.TempDir(), 5*time.Minute)
+		resolver := NewResolver(cfg, fetcher, cacheManager, logger.NewLogger("error"))
+
+		keys, err := resolver.ResolveKeys("alice")
+		if err != nil {
+			t.Fatalf("ResolveKeys() error = %v", err)
+		}
+		if first == nil {
+			first = keys
+			continue
+		}
+		if strings.Join(keys, "\n") != strings.Join(first, "\n") {
+			t.Fatalf("ResolveKeys() order changed between runs: %v vs %v", keys, first)
+		}
+	}
+
+	// Keys from "amy" sort before keys from "zed"
+	if !strings.HasSuffix(first[2], "zed@example.com") {
+		t.Errorf("ResolveKeys() = %v, want zed's key last", first)
+	}
+}
+
+func TestResolver_UserTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.keys" {
+			select {
+			case <-time.After(5 * time.Second):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB fast@example.com\n"))
+	}))
+	defer server.Close()
+
+	cacheManager, _ := cache.NewManager(t.TempDir(), 5*time.Minute)
+
+	cfg := &config.Config{
+		UserMap: map[string][]string{
+			"alice": {"slow", "fast"},
+		},
+		CacheTTL: 5 * time.Minute,
+	}
+
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+
+	opts := DefaultResolverOptions()
+	opts.Timeout = 3 * time.Second
+	opts.UserTimeout = 200 * time.Millisecond
+	resolver := NewResolverWithOptions(cfg, fetcher, cacheManager, logger.NewLogger("error"), opts)
+
+	start := time.Now()
+	keys, err := resolver.ResolveKeys("alice")
+	if err != nil {
+		t.Fatalf("ResolveKeys() error = %v", err)
+	}
+	if len(keys) != 1 || !strings.HasSuffix(keys[0], "fast@example.com") {
+		t.Errorf("ResolveKeys() = %v, want only the fast user's key", keys)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("ResolveKeys() took %v, slow user was not cut off", elapsed)
+	}
+}
+
+func TestResolver_LockHonorsTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB bob@example.com\n"))
+	}))
+	defer server.Close()
+
+	cacheManager, _ := cache.NewManager(t.TempDir(), 5*time.Minute)
+
+	// Another process holds bob's lock for longer than the overall timeout
+	unlock, err := cacheManager.Lock(context.Background(), "bob")
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+	defer unlock()
+
+	cfg := &config.Config{
+		UserMap:  map[string][]string{"alice": {"bob"}},
+		CacheTTL: 5 * time.Minute,
+	}
+
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+
+	opts := DefaultResolverOptions()
+	opts.Timeout = 300 * time.Millisecond
+	resolver := NewResolverWithOptions(cfg, fetcher, cacheManager, logger.NewLogger("error"), opts)
+
+	start := time.Now()
+	resolver.ResolveKeys("alice")
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("ResolveKeys() took %v, waited for the cache lock past the timeout", elapsed)
+	}
+}
+
+// staticMapping is a MappingSource backed by a map
+type staticMapping struct {
+	users map[string][]string
+	err   error
+}
+
+func (m *staticMapping) LookupGitHubUsers(sshUsername string) ([]string, error) {
+	return m.users[sshUsername], m.err
+}
+
+func TestResolver_MappingSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		username := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".keys")
+		w.Write([]byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB " + username + "@example.com\n"))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		UserMap: map[string][]string{
+			"alice": {"alice-github"},
+		},
+		CacheTTL: 5 * time.Minute,
+	}
+
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+
+	tests := []struct {
+		name     string
+		mapping  *staticMapping
+		ssh      string
+		wantKeys int
+	}{
+		{"dynamic only", &staticMapping{users: map[string][]string{"bob": {"bob-github"}}}, "bob", 1},
+		{"static and dynamic combined", &staticMapping{users: map[string][]string{"alice": {"alice-github", "ldap-github"}}}, "alice", 2},
+		{"failing source falls back to static", &staticMapping{err: fmt.Errorf("directory unreachable")}, "alice", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cacheManager, _ := cache.NewManager(t.TempDir(), 5*time.Minute)
+			resolver := NewResolver(cfg, fetcher, cacheManager, logger.NewLogger("error"))
+			resolver.SetMappingSource(tt.mapping)
+
+			keys, err := resolver.ResolveKeys(tt.ssh)
+			if err != nil {
+				t.Fatalf("ResolveKeys() error = %v", err)
+			}
+			if len(keys) != tt.wantKeys {
+				t.Errorf("ResolveKeys() = %v, want %d keys", keys, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestResolver_CoalescesGitHubUsers(t *testing.T) {
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests[strings.ToLower(r.URL.Path)]++
+		if strings.EqualFold(r.URL.Path, "/gone-bot.keys") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIshared bot@example.com\n"))
+	}))
+	defer server.Close()
+
+	cacheManager, _ := cache.NewManager(t.TempDir(), 5*time.Minute)
+	cfg := &config.Config{
+		UserMap: map[string][]string{
+			"alice":  {"deploy-bot", "Deploy-Bot", "gone-bot"},
+			"deploy": {"deploy-bot", "gone-bot"},
+		},
+	}
+
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+	resolver := NewResolver(cfg, fetcher, cacheManager, logger.NewLogger("error"))
+
+	for _, sshUser := range []string{"alice", "deploy"} {
+		keys, err := resolver.ResolveKeys(sshUser)
+		if err != nil {
+			t.Fatalf("ResolveKeys(%q) error = %v", sshUser, err)
+		}
+		if len(keys) != 1 {
+			t.Errorf("ResolveKeys(%q) returned %d keys, want 1", sshUser, len(keys))
+		}
+	}
+
+	// Case variants are one account, and failures aren't retried per SSH user
+	for path, count := range requests {
+		if count != 1 {
+			t.Errorf("%s requested %d times, want 1", path, count)
+		}
+	}
+}
+
+func TestResolver_StreamKeySources(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/zed.keys":
+			w.Write([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIzed zed@example.com\nssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB shared@example.com\n"))
+		case "/amy.keys":
+			w.Write([]byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB shared@example.com\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cacheManager, _ := cache.NewManager(t.TempDir(), 5*time.Minute)
+	cfg := &config.Config{
+		UserMap: map[string][]string{"alice": {"zed", "amy"}},
+	}
+
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+	resolver := NewResolver(cfg, fetcher, cacheManager, logger.NewLogger("error"))
+
+	var streamed []Key
+	err := resolver.StreamKeySources("alice", func(key Key) error {
+		streamed = append(streamed, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamKeySources() error = %v", err)
+	}
+
+	// Mapping order, not sorted by GitHub user; duplicates go to the first user
+	if len(streamed) != 2 {
+		t.Fatalf("StreamKeySources() emitted %d keys, want 2", len(streamed))
+	}
+	for _, key := range streamed {
+		if key.GitHubUser != "zed" {
+			t.Errorf("key %q attributed to %q, want zed", key.Line, key.GitHubUser)
+		}
+	}
+
+	// An emit error stops resolution
+	stop := fmt.Errorf("stop")
+	if err := resolver.StreamKeySources("alice", func(Key) error { return stop }); err != stop {
+		t.Errorf("StreamKeySources() error = %v, want %v", err, stop)
+	}
+}
+
+func TestResolver_BulkPrefetch(t *testing.T) {
+	restRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/graphql" {
+			w.Write([]byte(`{"data": {
+				"u0": {"publicKeys": {"totalCount": 1, "nodes": [{"id": "key-one", "key": "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIone", "createdAt": "2024-05-01T12:00:00Z"}]}},
+				"u1": {"publicKeys": {"totalCount": 1, "nodes": [{"key": "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAItwo"}]}},
+				"u2": null
+			}}`))
+			return
+		}
+		restRequests++
+		w.Write([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIthree\n"))
+	}))
+	defer server.Close()
+
+	cacheManager, _ := cache.NewManager(t.TempDir(), 5*time.Minute)
+	cfg := &config.Config{
+		UserMap: map[string][]string{"alice": {"one", "two", "three"}},
+	}
+
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+	fetcher.SetGraphQLURL(server.URL + "/graphql")
+	fetcher.SetToken("test-token")
+	resolver := NewResolver(cfg, fetcher, cacheManager, logger.NewLogger("error"))
+
+	keys, err := resolver.ResolveKeySources("alice")
+	if err != nil {
+		t.Fatalf("ResolveKeySources() error = %v", err)
+	}
+	if len(keys) != 3 {
+		t.Errorf("ResolveKeySources() returned %d keys, want 3", len(keys))
+	}
+
+	// Only the user the bulk query didn't resolve goes over REST
+	if restRequests != 1 {
+		t.Errorf("made %d per-user requests, want 1", restRequests)
+	}
+
+	// Key IDs from the API are kept, in memory and in the cache
+	ids := make(map[string]string)
+	for _, key := range keys {
+		ids[key.GitHubUser] = key.GitHubKeyID
+	}
+	if ids["one"] != "key-one" || ids["three"] != "" {
+		t.Errorf("ResolveKeySources() key IDs = %v, want key-one for one and none for three", ids)
+	}
+	cached := NewResolver(cfg, fetcher, cacheManager, logger.NewLogger("error"))
+	keys, _ = cached.ResolveKeySources("alice")
+	for _, key := range keys {
+		if key.GitHubUser == "one" && (key.GitHubKeyID != "key-one" || key.CreatedAt.Format(time.RFC3339) != "2024-05-01T12:00:00Z") {
+			t.Errorf("cached key metadata = %q, %v; want key-one created 2024-05-01T12:00:00Z", key.GitHubKeyID, key.CreatedAt)
+		}
+	}
+}
+
+func TestResolver_KeyMetadata(t *testing.T) {
+	graphQLRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/graphql" {
+			graphQLRequests++
+			w.Write([]byte(`{"data": {"u0": {"publicKeys": {"totalCount": 1, "nodes": [
+				{"id": "key-one", "key": "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIone", "createdAt": "2024-05-01T12:00:00Z"}
+			]}}}}`))
+			return
+		}
+		t.Errorf("unexpected per-user request %s", r.URL.Path)
+	}))
+	defer server.Close()
+
+	cacheManager, _ := cache.NewManager(t.TempDir(), 5*time.Minute)
+	cfg := &config.Config{
+		UserMap: map[string][]string{"alice": {"one"}},
+	}
+
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+	fetcher.SetGraphQLURL(server.URL + "/graphql")
+	fetcher.SetToken("test-token")
+	opts := DefaultResolverOptions()
+	opts.KeyMetadata = true
+	resolver := NewResolverWithOptions(cfg, fetcher, cacheManager, logger.NewLogger("error"), opts)
+
+	// A single user goes over GraphQL too, so its key has a creation time
+	keys, err := resolver.ResolveKeySources("alice")
+	if err != nil {
+		t.Fatalf("ResolveKeySources() error = %v", err)
+	}
+	if len(keys) != 1 || keys[0].CreatedAt.IsZero() {
+		t.Errorf("ResolveKeySources() = %+v, want one key with a creation time", keys)
+	}
+
+	// Refreshing keeps the metadata
+	if err := resolver.RefreshGitHubUser(context.Background(), "one"); err != nil {
+		t.Fatalf("RefreshGitHubUser() error = %v", err)
+	}
+	if entry, _, _ := cacheManager.ReadEntry("one"); entry == nil || entry.Metadata[keys[0].Line].GitHubID != "key-one" {
+		t.Errorf("refreshed cache entry = %+v, want key metadata", entry)
+	}
+	if graphQLRequests != 2 {
+		t.Errorf("made %d GraphQL requests, want 2", graphQLRequests)
+	}
+}
+
+func TestResolver_RateLimitFallsBackToCache(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	cacheManager, _ := cache.NewManager(t.TempDir(), time.Nanosecond)
+	cacheManager.Write("user1", []string{"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB cached@example.com"})
+	time.Sleep(time.Millisecond) // Let the entry expire
+
+	cfg := &config.Config{
+		UserMap: map[string][]string{"alice": {"user1"}},
+	}
+
+	// Each resolver stands in for a separate charon-key process
+	var stale []string
+	for i := 0; i < 2; i++ {
+		fetcher := github.NewFetcher()
+		fetcher.SetBaseURL(server.URL)
+		resolver := NewResolver(cfg, fetcher, cacheManager, logger.NewLogger("error"))
+		resolver.SetStaleCacheHandler(func(githubUser string, cachedAt time.Time, err error) {
+			stale = append(stale, githubUser)
+		})
+
+		keys, err := resolver.ResolveKeys("alice")
+		if err != nil {
+			t.Fatalf("ResolveKeys() error = %v", err)
+		}
+		if len(keys) != 1 {
+			t.Errorf("ResolveKeys() returned %d keys, want the expired cache", len(keys))
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("made %d requests, want 1 (later processes honor the back-off)", requests)
+	}
+	if len(stale) != 2 || stale[0] != "user1" {
+		t.Errorf("stale cache handler called for %v, want user1 twice", stale)
+	}
+}
+
+func TestResolver_NotFoundSkipsExpiredCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	cacheManager, _ := cache.NewManager(t.TempDir(), time.Nanosecond)
+	cacheManager.Write("deleted", []string{"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB old@example.com"})
+	time.Sleep(time.Millisecond) // Let the entry expire
+
+	cfg := &config.Config{
+		UserMap: map[string][]string{"alice": {"deleted"}},
+	}
+
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+	resolver := NewResolver(cfg, fetcher, cacheManager, logger.NewLogger("error"))
+
+	keys, err := resolver.ResolveKeys("alice")
+	if err == nil || len(keys) != 0 {
+		t.Errorf("ResolveKeys() = %v, %v, want an error and no keys for a deleted account", keys, err)
+	}
+	if kind := github.KindOf(err); kind != github.KindNotFound {
+		t.Errorf("KindOf(%v) = %q, want %q", err, kind, github.KindNotFound)
+	}
+}
+
+func TestResolver_Refresh(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAInew new@example.com\n"))
+	}))
+	defer server.Close()
+
+	cacheManager, _ := cache.NewManager(t.TempDir(), time.Hour)
+	cacheManager.Write("user1", []string{"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB offboarded@example.com"})
+
+	cfg := &config.Config{
+		UserMap: map[string][]string{"alice": {"user1"}},
+	}
+
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+	opts := DefaultResolverOptions()
+	opts.Refresh = true
+	resolver := NewResolverWithOptions(cfg, fetcher, cacheManager, logger.NewLogger("error"), opts)
+
+	keys, err := resolver.ResolveKeys("alice")
+	if err != nil {
+		t.Fatalf("ResolveKeys() error = %v", err)
+	}
+	if len(keys) != 1 || !strings.Contains(keys[0], "new@example.com") {
+		t.Errorf("ResolveKeys() = %v, want the freshly fetched key", keys)
+	}
+
+	// The cache is updated for later, non-refreshing runs
+	cached, _, _ := cacheManager.Read("user1")
+	if len(cached) != 1 || !strings.Contains(cached[0], "new@example.com") {
+		t.Errorf("cache = %v, want the freshly fetched key", cached)
+	}
+}
+
+func TestResolver_RefreshGitHubUser(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAInew new@example.com\n"))
+	}))
+	defer server.Close()
+
+	cacheManager, _ := cache.NewManager(t.TempDir(), time.Hour)
+	cacheManager.Write("user1", []string{"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB old@example.com"})
+
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+	resolver := NewResolver(&config.Config{}, fetcher, cacheManager, logger.NewLogger("error"))
+
+	if err := resolver.RefreshGitHubUser(context.Background(), "user1"); err != nil {
+		t.Fatalf("RefreshGitHubUser() error = %v", err)
+	}
+	cached, _, _ := cacheManager.Read("user1")
+	if len(cached) != 1 || !strings.Contains(cached[0], "new@example.com") {
+		t.Errorf("cache = %v, want the freshly fetched key", cached)
+	}
+
+	// A failed refresh keeps the entry
+	status = http.StatusNotFound
+	if err := resolver.RefreshGitHubUser(context.Background(), "user1"); err == nil {
+		t.Error("RefreshGitHubUser() error = nil, want the fetch error")
+	}
+	if cached, _, _ := cacheManager.Read("user1"); len(cached) != 1 {
+		t.Errorf("cache = %v, want the entry kept", cached)
+	}
+}
+
+func TestResolver_Stats(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIfetched fetched@example.com\n"))
+	}))
+	defer server.Close()
+
+	cacheManager, _ := cache.NewManager(t.TempDir(), time.Hour)
+	cacheManager.Write("cached", []string{"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB cached@example.com"})
+
+	cfg := &config.Config{
+		UserMap: map[string][]string{"alice": {"cached", "fetched"}, "bob": {"fetched"}},
+	}
+
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+	resolver := NewResolver(cfg, fetcher, cacheManager, logger.NewLogger("error"))
+
+	for _, sshUser := range []string{"alice", "bob"} {
+		if _, err := resolver.ResolveKeys(sshUser); err != nil {
+			t.Fatalf("ResolveKeys(%q) error = %v", sshUser, err)
+		}
+	}
+
+	// bob's GitHub user was resolved for alice already
+	stats := resolver.Stats()
+	if stats.GitHubUsers != 2 || stats.CacheHits != 1 || stats.Fetches != 1 || stats.StaleCache != 0 {
+		t.Errorf("Stats() = %+v, want 2 GitHub users, 1 cache hit, 1 fetch", stats)
+	}
+	if stats.FetchTime <= 0 {
+		t.Errorf("Stats() FetchTime = %v, want > 0", stats.FetchTime)
+	}
+}
+
+func TestResolver_Prefetch(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[strings.ToLower(r.URL.Path)]++
+		mu.Unlock()
+		if r.URL.Path == "/gone.keys" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		user := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".keys")
+		fmt.Fprintf(w, "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI%s %s@example.com\n", user, user)
+	}))
+	defer server.Close()
+
+	cacheManager, _ := cache.NewManager(t.TempDir(), time.Hour)
+	cfg := &config.Config{
+		UserMap: map[string][]string{
+			"alice": {"alice", "shared"},
+			"bob":   {"bob", "Shared"},
+			"carol": {"carol"},
+			"dave":  {"gone"},
+		},
+	}
+
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+	resolver := NewResolver(cfg, fetcher, cacheManager, logger.NewLogger("error"))
+
+	var githubUsers []string
+	for _, sshUser := range []string{"alice", "bob", "carol", "dave"} {
+		githubUsers = append(githubUsers, resolver.GitHubUsers(sshUser)...)
+	}
+	resolver.Prefetch(githubUsers, 4)
+
+	for _, sshUser := range []string{"alice", "bob", "carol"} {
+		keys, err := resolver.ResolveKeys(sshUser)
+		if err != nil {
+			t.Fatalf("ResolveKeys(%q) error = %v", sshUser, err)
+		}
+		if len(keys) != 2 && sshUser != "carol" {
+			t.Errorf("ResolveKeys(%q) returned %d keys, want 2", sshUser, len(keys))
+		}
+	}
+	if _, err := resolver.ResolveKeys("dave"); err == nil {
+		t.Error("ResolveKeys(dave) error = nil, want the prefetch failure")
+	}
+	if err := resolver.FetchFailure("", "gone"); github.KindOf(err) != github.KindNotFound {
+		t.Errorf("FetchFailure(gone) = %v, want a not_found error", err)
+	}
+	if err := resolver.FetchFailure("", "alice"); err != nil {
+		t.Errorf("FetchFailure(alice) = %v, want nil", err)
+	}
+
+	// Every GitHub user was fetched once, by the workers
+	for path, count := range requests {
+		if count != 1 {
+			t.Errorf("%s requested %d times, want 1", path, count)
+		}
+	}
+	if len(requests) != 5 {
+		t.Errorf("%d GitHub users requested, want 5", len(requests))
+	}
+	if stats := resolver.Stats(); stats.GitHubUsers != 5 || stats.Fetches != 5 {
+		t.Errorf("Stats() = %+v, want 5 GitHub users, 5 fetches", stats)
+	}
+}
+
+func BenchmarkResolver_WarmCache(b *testing.B) {
+	cacheManager, err := cache.NewManager(b.TempDir(), time.Hour)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	var githubUsers []string
+	for i := 0; i < 10; i++ {
+		user := fmt.Sprintf("github-user-%d", i)
+		githubUsers = append(githubUsers, user)
+		keys := []string{fmt.Sprintf("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI%040d %s", i, user)}
+		if err := cacheManager.Write(user, keys); err != nil {
+			b.Fatal(err)
+		}
+	}
+	cfg := &config.Config{UserMap: map[string][]string{"deploy": githubUsers}}
+	log := logger.NewLogger("error")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// A new resolver per login, like one AuthorizedKeysCommand process
+		r := NewResolver(cfg, github.NewFetcher(), cacheManager, log)
+		if _, err := r.ResolveKeySources("deploy"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestResolver_GracePeriod(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAInew new@example.com\n"))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		UserMap: map[string][]string{"alice": {"user1"}},
+	}
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+
+	for _, grace := range []time.Duration{0, time.Hour} {
+		cacheManager, _ := cache.NewManager(t.TempDir(), time.Hour)
+		cacheManager.Write("user1", []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIold old@example.com"})
+
+		opts := DefaultResolverOptions()
+		opts.Refresh = true
+		opts.GracePeriod = grace
+		resolver := NewResolverWithOptions(cfg, fetcher, cacheManager, logger.NewLogger("error"), opts)
+
+		keys, err := resolver.ResolveKeys("alice")
+		if err != nil {
+			t.Fatalf("ResolveKeys() error = %v", err)
+		}
+		want := 1
+		if grace > 0 {
+			want = 2 // The removed key is still served
+		}
+		if len(keys) != want {
+			t.Errorf("ResolveKeys() with grace period %s = %v, want %d keys", grace, keys, want)
+		}
+	}
+}
+
+func TestResolver_Revocations(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".keys")
+		fmt.Fprintf(w, "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI%s %s@example.com\n", user, user)
+	}))
+	defer server.Close()
+
+	cacheManager, _ := cache.NewManager(t.TempDir(), time.Hour)
+	// A cached key removed from GitHub is in its grace period
+	cacheManager.Write("alice", []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIold old@example.com"})
+	cfg := &config.Config{
+		UserMap: map[string][]string{"deploy": {"alice", "bob", "eve"}},
+	}
+
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+	opts := DefaultResolverOptions()
+	opts.Refresh = true
+	opts.GracePeriod = time.Hour
+	resolver := NewResolverWithOptions(cfg, fetcher, cacheManager, logger.NewLogger("error"), opts)
+
+	oldFingerprint, _ := ssh.Fingerprint("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIold")
+	eveFingerprint, _ := ssh.Fingerprint("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIeve")
+	list, err := revoke.Parse(strings.NewReader("github:Bob\n" + oldFingerprint + "\n" + eveFingerprint + "\n"))
+	if err != nil {
+		t.Fatalf("revoke.Parse() error = %v", err)
+	}
+	resolver.SetRevocations(list)
+
+	keys, err := resolver.ResolveKeys("deploy")
+	if err != nil {
+		t.Fatalf("ResolveKeys() error = %v", err)
+	}
+	if len(keys) != 1 || !strings.Contains(keys[0], "alice@example.com") {
+		t.Errorf("ResolveKeys() = %v, want only alice's current key", keys)
+	}
+}
+
+func TestResolver_CacheIsolation(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAInew new@example.com\n"))
+	}))
+	defer server.Close()
+
+	cacheManager, _ := cache.NewManager(t.TempDir(), time.Hour)
+	// A shared entry is never served to SSH users with their own cache
+	cacheManager.Write("user1", []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIshared shared@example.com"})
+	cfg := &config.Config{
+		UserMap: map[string][]string{"alice": {"user1"}, "bob": {"user1"}},
+	}
+	fetcher := github.NewFetcher()
+	fetcher.SetBaseURL(server.URL)
+	newResolver := func() *Resolver {
+		resolver := NewResolver(cfg, fetcher, cacheManager, logger.NewLogger("error"))
+		resolver.SetCacheIsolation(func(sshUsername string) (*cache.Manager, error) {
+			return cacheManager.ForSSHUser(sshUsername, -1)
+		})
+		return resolver
+	}
+
+	resolver := newResolver()
+	for _, sshUser := range []string{"alice", "bob"} {
+		keys, err := resolver.ResolveKeys(sshUser)
+		if err != nil || len(keys) != 1 || !strings.Contains(keys[0], "new@example.com") {
+			t.Errorf("ResolveKeys(%s) = %v, %v, want the fetched key", sshUser, keys, err)
+		}
+	}
+	if requests != 2 {
+		t.Errorf("GitHub requests = %d, want one per SSH user", requests)
+	}
+
+	// Each SSH user's entry is cached for it
+	if _, err := newResolver().ResolveKeys("alice"); err != nil || requests != 2 {
+		t.Errorf("ResolveKeys(alice) again: error = %v, requests = %d, want a cache hit", err, requests)
+	}
+	if keys, _, _ := cacheManager.Read("user1"); len(keys) != 1 || !strings.Contains(keys[0], "shared@example.com") {
+		t.Errorf("shared entry = %v, want it untouched", keys)
+	}
+}
@@ -0,0 +1,229 @@
+// Package revoke reads and edits the revocation list: key fingerprints and
+// GitHub users whose keys must never be served, whatever the cache or the
+// grace period hold
+package revoke
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultPath is where the revocation list is read from unless
+// --revocation-file says otherwise; a missing file revokes nothing
+const DefaultPath = "/etc/charon-key/revoked"
+
+// githubPrefix starts GitHub user entries; other entries are fingerprints
+const githubPrefix = "github:"
+
+// List is a parsed revocation list
+// A nil List revokes nothing
+type List struct {
+	// entries maps each normalized entry to its comment (e.g. when and why
+	// it was revoked)
+	entries map[string]string
+}
+
+// Entry is a revoked fingerprint or GitHub user, with its comment
+type Entry struct {
+	Entry   string
+	Comment string
+}
+
+// ParseEntry checks and normalizes a revocation entry: a SHA256 key
+// fingerprint as printed by ssh-keygen -l, or "github:<user>"
+func ParseEntry(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(strings.ToLower(s), githubPrefix):
+		user := s[len(githubPrefix):]
+		if user == "" || strings.ContainsAny(user, " \t/") {
+			return "", fmt.Errorf("invalid GitHub user in %q", s)
+		}
+		// GitHub usernames are case-insensitive
+		return githubPrefix + strings.ToLower(user), nil
+	case strings.HasPrefix(s, "SHA256:") && len(s) > len("SHA256:") && !strings.ContainsAny(s, " \t"):
+		return s, nil
+	default:
+		return "", fmt.Errorf("invalid revocation entry %q (expected SHA256:<fingerprint> or github:<user>)", s)
+	}
+}
+
+// Parse reads a revocation list: one entry per line, optionally followed by
+// a "#" comment; blank lines and comment lines are ignored
+func Parse(r io.Reader) (*List, error) {
+	list := &List{entries: make(map[string]string)}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line, comment, _ := strings.Cut(scanner.Text(), "#")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry, err := ParseEntry(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		list.entries[entry] = strings.TrimSpace(comment)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read revocation list: %w", err)
+	}
+	return list, nil
+}
+
+// Load reads the revocation list at path; a missing file is an empty list
+func Load(path string) (*List, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &List{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revocation list: %w", err)
+	}
+	list, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return list, nil
+}
+
+// Len returns the number of entries
+func (l *List) Len() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.entries)
+}
+
+// KeyRevoked reports whether a key fingerprint is revoked
+func (l *List) KeyRevoked(fingerprint string) bool {
+	if l == nil {
+		return false
+	}
+	_, ok := l.entries[fingerprint]
+	return ok
+}
+
+// GitHubUserRevoked reports whether every key of a GitHub user is revoked
+func (l *List) GitHubUserRevoked(githubUser string) bool {
+	if l == nil {
+		return false
+	}
+	_, ok := l.entries[githubPrefix+strings.ToLower(githubUser)]
+	return ok
+}
+
+// Entries returns the entries, sorted
+func (l *List) Entries() []Entry {
+	if l == nil {
+		return nil
+	}
+	entries := make([]Entry, 0, len(l.entries))
+	for entry, comment := range l.entries {
+		entries = append(entries, Entry{Entry: entry, Comment: comment})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Entry < entries[j].Entry })
+	return entries
+}
+
+// Add appends entries not revoked yet to the revocation list at path,
+// commented with the time and reason, creating the file if needed
+// Returns the number of entries added
+func Add(path string, entries []string, reason string, at time.Time) (int, error) {
+	list, err := Load(path)
+	if err != nil {
+		return 0, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("failed to read revocation list: %w", err)
+	}
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+
+	comment := at.UTC().Format(time.RFC3339)
+	if reason = strings.TrimSpace(strings.ReplaceAll(reason, "\n", " ")); reason != "" {
+		comment += " " + reason
+	}
+	added := 0
+	for _, s := range entries {
+		entry, err := ParseEntry(s)
+		if err != nil {
+			return 0, err
+		}
+		if _, ok := list.entries[entry]; ok {
+			continue
+		}
+		if list.entries == nil {
+			list.entries = make(map[string]string)
+		}
+		list.entries[entry] = comment
+		data = append(data, fmt.Sprintf("%s # %s\n", entry, comment)...)
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	return added, write(path, data)
+}
+
+// Remove drops entries from the revocation list at path, keeping every
+// other line as it is
+// Returns the number of entries removed
+func Remove(path string, entries []string) (int, error) {
+	drop := make(map[string]bool, len(entries))
+	for _, s := range entries {
+		entry, err := ParseEntry(s)
+		if err != nil {
+			return 0, err
+		}
+		drop[entry] = true
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read revocation list: %w", err)
+	}
+
+	var kept bytes.Buffer
+	removed := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if entry, err := ParseEntry(line); err == nil && drop[entry] {
+			removed++
+			continue
+		}
+		kept.WriteString(scanner.Text() + "\n")
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, write(path, kept.Bytes())
+}
+
+// write atomically replaces the revocation list, so logins never read a
+// partial file
+func write(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create revocation list directory: %w", err)
+	}
+	tmp := fmt.Sprintf("%s.tmp-%d", path, os.Getpid())
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write revocation list: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write revocation list: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package revoke
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantKey     string
+		wantUser    string
+		wantEntries int
+		wantError   bool
+	}{
+		{
+			name: "entries with comments",
+			input: "# revoked\n\nSHA256:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU # lost laptop\n" +
+				"github:Mallory # 2024-05-01T00:00:00Z offboarded\n",
+			wantKey:     "SHA256:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU",
+			wantUser:    "mallory",
+			wantEntries: 2,
+		},
+		{name: "empty", input: "", wantEntries: 0},
+		{name: "bare username", input: "mallory\n", wantError: true},
+		{name: "empty GitHub user", input: "github:\n", wantError: true},
+		{name: "MD5 fingerprint", input: "MD5:aa:bb\n", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := Parse(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantError {
+				t.Fatalf("Parse() error = %v, wantError %v", err, tt.wantError)
+			}
+			if tt.wantError {
+				return
+			}
+			if list.Len() != tt.wantEntries {
+				t.Errorf("Len() = %d, want %d", list.Len(), tt.wantEntries)
+			}
+			if tt.wantKey != "" && !list.KeyRevoked(tt.wantKey) {
+				t.Errorf("KeyRevoked(%q) = false, want true", tt.wantKey)
+			}
+			if tt.wantUser != "" && !list.GitHubUserRevoked(strings.ToUpper(tt.wantUser)) {
+				t.Errorf("GitHubUserRevoked(%q) = false, want true", tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestList_Nil(t *testing.T) {
+	var list *List
+	if list.KeyRevoked("SHA256:abc") || list.GitHubUserRevoked("alice") || list.Len() != 0 {
+		t.Error("a nil list revokes something")
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "etc", "revoked")
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	if list, err := Load(path); err != nil || list.Len() != 0 {
+		t.Fatalf("Load() of a missing file = %v, %v, want an empty list", list, err)
+	}
+
+	added, err := Add(path, []string{"github:Mallory", "SHA256:abc"}, "lost laptop", at)
+	if err != nil || added != 2 {
+		t.Fatalf("Add() = %d, %v, want 2", added, err)
+	}
+	if added, _ := Add(path, []string{"github:mallory"}, "", at); added != 0 {
+		t.Errorf("Add() of a revoked entry = %d, want 0", added)
+	}
+	if _, err := Add(path, []string{"mallory"}, "", at); err == nil {
+		t.Error("Add() of an invalid entry error = nil")
+	}
+
+	list, err := Load(path)
+	if err != nil || !list.GitHubUserRevoked("mallory") || !list.KeyRevoked("SHA256:abc") {
+		t.Fatalf("Load() = %+v, %v, want both entries", list.Entries(), err)
+	}
+	if entries := list.Entries(); entries[0].Comment != "2024-05-01T12:00:00Z lost laptop" {
+		t.Errorf("Entries()[0].Comment = %q, want the time and reason", entries[0].Comment)
+	}
+
+	removed, err := Remove(path, []string{"GitHub:MALLORY"})
+	if err != nil || removed != 1 {
+		t.Fatalf("Remove() = %d, %v, want 1", removed, err)
+	}
+	list, _ = Load(path)
+	if list.GitHubUserRevoked("mallory") || !list.KeyRevoked("SHA256:abc") {
+		t.Errorf("after Remove() entries = %+v, want only the fingerprint", list.Entries())
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("revocation list mode = %v, %v, want 0644", info.Mode(), err)
+	}
+}
@@ -2,43 +2,183 @@ package ssh
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
 // Manager handles SSH authorized_keys operations
 type Manager struct {
 	authorizedKeysPath string
+
+	// username is the user's name, passed to the read helper (empty when
+	// created with NewManagerWithPath)
+	username string
+
+	// home is the user's home directory, which %h expands to
+	home string
+
+	// uid and gid own the authorized_keys file (-1 = unknown)
+	uid int
+	gid int
+
+	// dedup decides which copy MergeKeys keeps of duplicate keys
+	dedup DedupStrategy
+
+	// extraKeyFiles are read by ReadExistingKeys after authorized_keys
+	extraKeyFiles []string
+
+	// unprivileged makes key files that can't be opened be read through
+	// readHelper, or skipped if there is none (see SetUnprivileged)
+	unprivileged bool
+	readHelper   string
+}
+
+// SetExtraKeyFiles sets further files whose keys ReadExistingKeys returns
+// after authorized_keys', e.g. authorized_keys2 or a site-wide file in
+// /etc/ssh, like the extra paths of sshd's AuthorizedKeysFile
+// Patterns may use the ExpandPath tokens; relative paths are relative to
+// the home directory
+func (m *Manager) SetExtraKeyFiles(patterns []string, username string) {
+	m.extraKeyFiles = nil
+	for _, pattern := range patterns {
+		path := m.ExpandPath(pattern, username)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(m.home, path)
+		}
+		if path != m.authorizedKeysPath {
+			m.extraKeyFiles = append(m.extraKeyFiles, path)
+		}
+	}
+}
+
+// SetAuthorizedKeysFile moves the authorized_keys file from
+// ~/.ssh/authorized_keys to pattern, like sshd's AuthorizedKeysFile (e.g.
+// /etc/ssh/authorized_keys/%u); the ExpandPath tokens are expanded and
+// relative paths are relative to the home directory
+func (m *Manager) SetAuthorizedKeysFile(pattern, username string) {
+	path := m.ExpandPath(pattern, username)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(m.home, path)
+	}
+	m.authorizedKeysPath = path
+}
+
+// SetChroot resolves the home directory, and with it authorized_keys,
+// inside a chroot directory, for accounts (e.g. sftp-only ones) whose passwd
+// home directory is the path inside their jail; the pattern may use the
+// ExpandPath tokens, like sshd's ChrootDirectory
+// Call it before SetAuthorizedKeysFile and SetExtraKeyFiles, so their %h and
+// relative paths are inside the chroot too
+func (m *Manager) SetChroot(pattern, username string) {
+	root := m.ExpandPath(pattern, username)
+	m.home = filepath.Join(root, m.home)
+	m.authorizedKeysPath = filepath.Join(m.home, ".ssh", "authorized_keys")
+}
+
+// KeyFiles returns the files ReadExistingKeys reads, authorized_keys first
+func (m *Manager) KeyFiles() []string {
+	return append([]string{m.authorizedKeysPath}, m.extraKeyFiles...)
+}
+
+// DedupStrategy decides which copy MergeKeys keeps of a key that is both in
+// authorized_keys and served by GitHub
+type DedupStrategy string
+
+const (
+	// DedupPreferLocal keeps the authorized_keys line, with its options and
+	// comment (the default)
+	DedupPreferLocal DedupStrategy = "prefer-local"
+
+	// DedupPreferGitHub keeps the GitHub line in place of the authorized_keys
+	// line, dropping the local options
+	DedupPreferGitHub DedupStrategy = "prefer-github"
+
+	// DedupKeepBoth keeps both lines, noting the duplicate in the GitHub
+	// copy's comment; sshd applies the first, local, line
+	DedupKeepBoth DedupStrategy = "keep-both-with-comment"
+)
+
+// duplicateNote is appended to GitHub copies kept by DedupKeepBoth
+const duplicateNote = "charon-key:duplicate-of-local-key"
+
+// ParseDedupStrategy parses a dedup strategy name ("" = prefer-local)
+func ParseDedupStrategy(s string) (DedupStrategy, error) {
+	switch strategy := DedupStrategy(s); strategy {
+	case "":
+		return DedupPreferLocal, nil
+	case DedupPreferLocal, DedupPreferGitHub, DedupKeepBoth:
+		return strategy, nil
+	}
+	return "", fmt.Errorf("invalid dedup strategy %q (expected prefer-local, prefer-github or keep-both-with-comment)", s)
+}
+
+// SetDedupStrategy sets which copy MergeKeys keeps of duplicate keys
+func (m *Manager) SetDedupStrategy(strategy DedupStrategy) {
+	m.dedup = strategy
+}
+
+// Duplicate is a GitHub key that MergeKeys found in authorized_keys too
+type Duplicate struct {
+	// Local and GitHub are the two lines
+	Local  string
+	GitHub string
+
+	// Strategy is how the duplicate was resolved
+	Strategy DedupStrategy
 }
 
 // NewManager creates a new SSH manager
 // If username is empty, uses current user
 func NewManager(username string) (*Manager, error) {
-	var homeDir string
+	return NewManagerWithSource(username, PasswdAuto)
+}
+
+// NewManagerWithSource creates a new SSH manager, looking up the user's home
+// directory with the given passwd source
+// If username is empty, uses current user
+func NewManagerWithSource(username string, source PasswdSource) (*Manager, error) {
+	var u *user.User
+	var err error
 
 	if username == "" {
 		// Use current user
-		currentUser, err := user.Current()
+		u, err = user.Current()
 		if err != nil {
 			return nil, fmt.Errorf("failed to get current user: %w", err)
 		}
-		homeDir = currentUser.HomeDir
 	} else {
 		// Look up specified user
-		u, err := user.Lookup(username)
+		u, err = LookupUser(username, source)
 		if err != nil {
 			return nil, fmt.Errorf("failed to lookup user %q: %w", username, err)
 		}
-		homeDir = u.HomeDir
 	}
 
-	authorizedKeysPath := filepath.Join(homeDir, ".ssh", "authorized_keys")
+	authorizedKeysPath := filepath.Join(u.HomeDir, ".ssh", "authorized_keys")
+
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		uid = -1
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		gid = -1
+	}
 
 	return &Manager{
 		authorizedKeysPath: authorizedKeysPath,
+		username:           u.Username,
+		home:               u.HomeDir,
+		uid:                uid,
+		gid:                gid,
 	}, nil
 }
 
@@ -47,6 +187,9 @@ func NewManager(username string) (*Manager, error) {
 func NewManagerWithPath(path string) *Manager {
 	return &Manager{
 		authorizedKeysPath: path,
+		home:               filepath.Dir(filepath.Dir(path)),
+		uid:                -1,
+		gid:                -1,
 	}
 }
 
@@ -55,33 +198,83 @@ func (m *Manager) GetAuthorizedKeysPath() string {
 	return m.authorizedKeysPath
 }
 
-// ReadExistingKeys reads existing keys from the authorized_keys file
-// Returns empty slice if file doesn't exist (not an error)
-// Returns error only if file exists but cannot be read
+// ReadExistingKeys reads existing keys from the authorized_keys file, then
+// from the extra key files (see SetExtraKeyFiles)
+// Keys in the charon-key managed block (written by sync mode) are skipped,
+// since they are regenerated from GitHub on every run
+// Returns empty slice if no file exists (not an error)
+// Returns error only if a file exists but cannot be read
 func (m *Manager) ReadExistingKeys() ([]string, error) {
-	file, err := os.Open(m.authorizedKeysPath)
+	keys := []string{}
+	for _, path := range m.KeyFiles() {
+		fileKeys, err := m.readKeyFile(path, func(managed bool) bool { return !managed })
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue // File doesn't exist, nothing to merge
+			}
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keys = append(keys, fileKeys...)
+	}
+	return keys, nil
+}
+
+// ReadManagedKeys reads only the keys in the charon-key managed block of the
+// authorized_keys file, i.e. the keys sync mode wrote last
+// Returns empty slice if file doesn't exist (not an error)
+func (m *Manager) ReadManagedKeys() ([]string, error) {
+	keys, err := m.readKeyFile(m.authorizedKeysPath, func(managed bool) bool { return managed })
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []string{}, nil // File doesn't exist, return empty slice
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
 		}
-		return nil, fmt.Errorf("failed to open authorized_keys file: %w", err)
+		return nil, err
+	}
+	return keys, nil
+}
+
+// ReadKeyFile reads keys from a file in authorized_keys format
+// Empty lines and comments are skipped
+func ReadKeyFile(path string) ([]string, error) {
+	return readKeyFile(path, func(bool) bool { return true })
+}
+
+// readKeyFile reads the keys of a file for which include, told whether the
+// key is in the managed block, returns true
+func readKeyFile(path string, include func(managed bool) bool) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key file: %w", err)
 	}
 	defer file.Close()
+	return parseKeyFile(file, include)
+}
 
+// parseKeyFile parses keys in authorized_keys format like readKeyFile
+func parseKeyFile(r io.Reader, include func(managed bool) bool) ([]string, error) {
 	var keys []string
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
+	inManaged := false
 
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
+		switch line {
+		case ManagedBlockBegin:
+			inManaged = true
+			continue
+		case ManagedBlockEnd:
+			inManaged = false
+			continue
+		}
 		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
+		if line == "" || strings.HasPrefix(line, "#") || !include(inManaged) {
 			continue
 		}
 		keys = append(keys, line)
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("failed to read authorized_keys file: %w", err)
+		return nil, fmt.Errorf("failed to read key file: %w", err)
 	}
 
 	return keys, nil
@@ -90,9 +283,24 @@ func (m *Manager) ReadExistingKeys() ([]string, error) {
 // MergeKeys merges GitHub keys with existing authorized_keys
 // Deduplicates keys and returns them in a consistent format
 func (m *Manager) MergeKeys(githubKeys []string, existingKeys []string) []string {
-	// Use map to deduplicate (key content as key)
+	merged, _ := m.MergeKeysReport(githubKeys, existingKeys)
+	return merged
+}
+
+// MergeKeysReport merges keys like MergeKeys, and also returns the GitHub
+// keys that were in authorized_keys too, resolved by the dedup strategy
+func (m *Manager) MergeKeysReport(githubKeys []string, existingKeys []string) ([]string, []Duplicate) {
+	// Use map to deduplicate (key content as key), remembering where each
+	// existing key ended up in the result
 	keyMap := make(map[string]bool)
+	localIndex := make(map[string]int)
 	var result []string
+	var duplicates []Duplicate
+
+	strategy := m.dedup
+	if strategy == "" {
+		strategy = DedupPreferLocal
+	}
 
 	// Add existing keys first (preserve order)
 	for _, key := range existingKeys {
@@ -104,6 +312,7 @@ func (m *Manager) MergeKeys(githubKeys []string, existingKeys []string) []string
 		normalized := normalizeKey(key)
 		if normalized != "" && !keyMap[normalized] {
 			keyMap[normalized] = true
+			localIndex[normalized] = len(result)
 			result = append(result, key) // Keep original format
 		}
 	}
@@ -115,32 +324,76 @@ func (m *Manager) MergeKeys(githubKeys []string, existingKeys []string) []string
 			continue
 		}
 		normalized := normalizeKey(key)
-		if normalized != "" && !keyMap[normalized] {
+		if normalized == "" {
+			continue
+		}
+		if i, ok := localIndex[normalized]; ok {
+			duplicates = append(duplicates, Duplicate{Local: result[i], GitHub: key, Strategy: strategy})
+			delete(localIndex, normalized) // Later copies are GitHub duplicates
+			switch strategy {
+			case DedupPreferGitHub:
+				result[i] = key
+			case DedupKeepBoth:
+				result = append(result, AnnotateKey(key, duplicateNote))
+			}
+			continue
+		}
+		if !keyMap[normalized] {
 			keyMap[normalized] = true
 			result = append(result, key)
 		}
 	}
 
-	return result
+	return result, duplicates
 }
 
-// normalizeKey normalizes a key for comparison (removes comments and extra whitespace)
-// This helps with deduplication
+// normalizeKey normalizes a key for comparison (removes options, comments
+// and extra whitespace)
+// This helps with deduplication; lines that don't parse are returned as-is
 func normalizeKey(key string) string {
 	key = strings.TrimSpace(key)
 	if key == "" {
 		return ""
 	}
 
-	// SSH keys typically have format: "key-type key-data [comment]"
-	// We extract just the key-type and key-data for comparison
-	parts := strings.Fields(key)
-	if len(parts) < 2 {
+	parsed, err := ParseAuthorizedKey(key)
+	if err != nil {
 		return key // Malformed, return as-is
 	}
+	return parsed.Normalized()
+}
+
+// isKeyType reports whether s looks like an SSH key type
+// (ssh-*, ecdsa-sha2-*, sk-* including certificate variants)
+func isKeyType(s string) bool {
+	return strings.HasPrefix(s, "ssh-") || strings.HasPrefix(s, "ecdsa-sha2-") || strings.HasPrefix(s, "sk-")
+}
+
+// Fingerprint returns the SHA256 fingerprint of a key in the format used by
+// ssh-keygen -l (e.g. "SHA256:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU")
+func Fingerprint(key string) (string, error) {
+	parsed, err := ParseAuthorizedKey(key)
+	if err != nil {
+		return "", fmt.Errorf("malformed key: %w", err)
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(parsed.Blob)
+	if err != nil {
+		return "", fmt.Errorf("invalid key data: %w", err)
+	}
+
+	sum := sha256.Sum256(blob)
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]), nil
+}
 
-	// Return key-type and key-data (first two parts)
-	return strings.Join(parts[:2], " ")
+// AnnotateKey appends a note to the key's comment
+// The key type and data are left untouched, so deduplication is unaffected
+func AnnotateKey(key, note string) string {
+	key = strings.TrimSpace(key)
+	if note == "" {
+		return key
+	}
+	return key + " " + note
 }
 
 // FormatKeys formats keys for SSH daemon output (one key per line)
@@ -1,6 +1,7 @@
 package ssh
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -138,6 +139,56 @@ func TestManager_ReadExistingKeys_FileNotExists(t *testing.T) {
 	}
 }
 
+func TestManager_ReadExistingKeys_ExtraFiles(t *testing.T) {
+	home := t.TempDir()
+	siteDir := t.TempDir()
+	os.MkdirAll(filepath.Join(home, ".ssh"), 0700)
+	os.WriteFile(filepath.Join(home, ".ssh", "authorized_keys"), []byte("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB primary\n"), 0600)
+	os.WriteFile(filepath.Join(home, ".ssh", "authorized_keys2"), []byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI legacy\n"), 0600)
+	os.WriteFile(filepath.Join(siteDir, "alice"), []byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAJ site\n"), 0644)
+
+	manager := NewManagerWithPath(filepath.Join(home, ".ssh", "authorized_keys"))
+	manager.SetExtraKeyFiles([]string{".ssh/authorized_keys2", siteDir + "/%u", "%h/.ssh/authorized_keys3", "%h/.ssh/authorized_keys"}, "alice")
+
+	if files := manager.KeyFiles(); len(files) != 4 {
+		t.Errorf("KeyFiles() = %v, want authorized_keys and 3 extra files", files)
+	}
+
+	keys, err := manager.ReadExistingKeys()
+	if err != nil {
+		t.Fatalf("ReadExistingKeys() error = %v", err)
+	}
+	want := []string{
+		"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB primary",
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI legacy",
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAJ site",
+	}
+	if strings.Join(keys, "\n") != strings.Join(want, "\n") {
+		t.Errorf("ReadExistingKeys() = %q, want %q", keys, want)
+	}
+}
+
+func TestReadKeyFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "break-glass.keys")
+	content := "# break-glass\nssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI ops@example.com\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+
+	keys, err := ReadKeyFile(path)
+	if err != nil {
+		t.Fatalf("ReadKeyFile() error = %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI ops@example.com" {
+		t.Errorf("ReadKeyFile() = %v", keys)
+	}
+
+	if _, err := ReadKeyFile(filepath.Join(tmpDir, "missing")); err == nil {
+		t.Error("ReadKeyFile() expected error for missing file")
+	}
+}
+
 func TestManager_MergeKeys(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -273,6 +324,11 @@ func TestNormalizeKey(t *testing.T) {
 		{"extra spaces", "ssh-rsa   AAAAB3NzaC1yc2EAAAADAQABAAAB   test@example.com", "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB"},
 		{"empty", "", ""},
 		{"whitespace", "   ", ""},
+		{"with options", `no-pty,from="10.0.0.0/8" ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB test@example.com`, "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB"},
+		{"quoted option with spaces", `command="echo hello world" ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI test@example.com`, "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI"},
+		{"escaped quote in option", `command="echo \"a b\"" ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI`, "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI"},
+		{"security key", "sk-ssh-ed25519@openssh.com AAAAGnNrLXNzaC1lZDI1NTE5 test@example.com", "sk-ssh-ed25519@openssh.com AAAAGnNrLXNzaC1lZDI1NTE5"},
+		{"unknown key type kept as-is", "foo AAAAB3NzaC1yc2EAAAADAQABAAAB bar", "foo AAAAB3NzaC1yc2EAAAADAQABAAAB bar"},
 	}
 
 	for _, tt := range tests {
@@ -314,3 +370,117 @@ func TestManager_GetAllKeys(t *testing.T) {
 	}
 }
 
+
+func TestAnnotateKey(t *testing.T) {
+	got := AnnotateKey("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI alice@laptop ", "via charon-key github:alice 2024-05-01")
+	want := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI alice@laptop via charon-key github:alice 2024-05-01"
+	if got != want {
+		t.Errorf("AnnotateKey() = %q, want %q", got, want)
+	}
+
+	if normalizeKey(got) != "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI" {
+		t.Errorf("annotated key normalizes to %q", normalizeKey(got))
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	// Expected value from ssh-keygen -lf
+	key := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOmzDbXw5GTZ09PuUndofA+zG4l2zb/pAJUQIg9GKwpb test@example.com"
+	want := "SHA256:8y6uPxLJR647sJetYkwdUqh1fGZBso4tYk22aTTDxqg"
+
+	got, err := Fingerprint(key)
+	if err != nil {
+		t.Fatalf("Fingerprint() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("Fingerprint() = %q, want %q", got, want)
+	}
+
+	if _, err := Fingerprint("ssh-ed25519"); err == nil {
+		t.Error("Fingerprint() expected error for malformed key")
+	}
+	if _, err := Fingerprint("ssh-ed25519 not!base64"); err == nil {
+		t.Error("Fingerprint() expected error for invalid key data")
+	}
+}
+
+func TestManager_MergeKeys_PreservesOptions(t *testing.T) {
+	manager := NewManagerWithPath("/tmp/test")
+
+	existing := []string{
+		`command="/usr/bin/backup",no-pty ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI backup@example.com`,
+		`command="/usr/bin/backup",no-pty ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAJ other@example.com`,
+	}
+	githubKeys := []string{
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI github@example.com",
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAK new@example.com",
+	}
+
+	merged := manager.MergeKeys(githubKeys, existing)
+
+	// Keys sharing the same options are distinct keys, not duplicates
+	if len(merged) != 3 {
+		t.Fatalf("MergeKeys() = %v, want 3 keys", merged)
+	}
+	// The restricted local copy wins over the unrestricted GitHub copy
+	if merged[0] != existing[0] || merged[1] != existing[1] {
+		t.Errorf("MergeKeys() dropped options: %v", merged)
+	}
+	if merged[2] != githubKeys[1] {
+		t.Errorf("MergeKeys()[2] = %q, want %q", merged[2], githubKeys[1])
+	}
+}
+
+func TestManager_MergeKeysReport(t *testing.T) {
+	local := `command="/usr/bin/backup",no-pty ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI backup@example.com`
+	other := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAJ other@example.com"
+	github := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI github@example.com"
+	newKey := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAK new@example.com"
+
+	tests := []struct {
+		name     string
+		strategy DedupStrategy
+		want     []string
+	}{
+		{"default", "", []string{local, other, newKey}},
+		{"prefer-local", DedupPreferLocal, []string{local, other, newKey}},
+		{"prefer-github", DedupPreferGitHub, []string{github, other, newKey}},
+		{"keep-both-with-comment", DedupKeepBoth, []string{local, other, github + " charon-key:duplicate-of-local-key", newKey}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := NewManagerWithPath("/tmp/test")
+			manager.SetDedupStrategy(tt.strategy)
+
+			merged, duplicates := manager.MergeKeysReport([]string{github, newKey}, []string{local, other})
+			if strings.Join(merged, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("MergeKeysReport() = %q, want %q", merged, tt.want)
+			}
+			if len(duplicates) != 1 || duplicates[0].Local != local || duplicates[0].GitHub != github {
+				t.Errorf("MergeKeysReport() duplicates = %+v", duplicates)
+			}
+		})
+	}
+
+	if _, err := ParseDedupStrategy("prefer-newest"); err == nil {
+		t.Error("ParseDedupStrategy() with an unknown strategy error = nil")
+	}
+}
+
+func BenchmarkManager_MergeKeys(b *testing.B) {
+	var githubKeys, existingKeys []string
+	for i := 0; i < 50; i++ {
+		githubKeys = append(githubKeys, fmt.Sprintf("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI%040d github-%d", i, i))
+	}
+	for i := 0; i < 100; i++ {
+		existingKeys = append(existingKeys, fmt.Sprintf("ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB%040d local-%d", i, i))
+	}
+	existingKeys = append(existingKeys, githubKeys[:10]...)
+	manager := NewManagerWithPath(filepath.Join(b.TempDir(), "authorized_keys"))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		manager.MergeKeys(githubKeys, existingKeys)
+	}
+}
@@ -0,0 +1,62 @@
+package ssh
+
+// Drift is how an authorized_keys file differs from the keys charon-key
+// would serve for its user now
+type Drift struct {
+	// Missing are served keys the file lacks, e.g. keys added on GitHub
+	// since sync mode last ran
+	Missing []string
+
+	// Stale are keys in the managed block that would no longer be served,
+	// e.g. keys removed from GitHub
+	Stale []string
+
+	// Unknown are keys outside the managed block that charon-key doesn't
+	// serve, i.e. keys added to the file by hand
+	Unknown []string
+}
+
+// HasDrift reports whether any keys differ
+func (d Drift) HasDrift() bool {
+	return len(d.Missing) > 0 || len(d.Stale) > 0 || len(d.Unknown) > 0
+}
+
+// CompareKeys compares the served keys with the managed and unmanaged keys
+// of an authorized_keys file; keys are compared by type and data only, so
+// options and comments don't matter
+func CompareKeys(served, managed, unmanaged []string) Drift {
+	servedSet := keySet(served)
+	present := keySet(managed)
+	for key := range keySet(unmanaged) {
+		present[key] = true
+	}
+
+	var drift Drift
+	for _, key := range served {
+		if !present[normalizeKey(key)] {
+			drift.Missing = append(drift.Missing, key)
+		}
+	}
+	for _, key := range managed {
+		if !servedSet[normalizeKey(key)] {
+			drift.Stale = append(drift.Stale, key)
+		}
+	}
+	for _, key := range unmanaged {
+		if !servedSet[normalizeKey(key)] {
+			drift.Unknown = append(drift.Unknown, key)
+		}
+	}
+	return drift
+}
+
+// keySet returns the normalized keys as a set
+func keySet(keys []string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if normalized := normalizeKey(key); normalized != "" {
+			set[normalized] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,66 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompareKeys(t *testing.T) {
+	served := []string{
+		"ssh-ed25519 AAAAone alice@laptop",
+		"ssh-ed25519 AAAAtwo alice@desktop",
+		"ssh-ed25519 AAAAlocal",
+	}
+	managed := []string{
+		"ssh-ed25519 AAAAone alice@laptop",
+		"ssh-ed25519 AAAAold alice@retired",
+	}
+	unmanaged := []string{
+		`from="10.0.0.0/8" ssh-ed25519 AAAAlocal backup`,
+		"ssh-rsa AAAAhand added-by-hand",
+	}
+
+	drift := CompareKeys(served, managed, unmanaged)
+	want := Drift{
+		Missing: []string{"ssh-ed25519 AAAAtwo alice@desktop"},
+		Stale:   []string{"ssh-ed25519 AAAAold alice@retired"},
+		Unknown: []string{"ssh-rsa AAAAhand added-by-hand"},
+	}
+	if !reflect.DeepEqual(drift, want) {
+		t.Errorf("CompareKeys() = %+v, want %+v", drift, want)
+	}
+	if !drift.HasDrift() {
+		t.Error("HasDrift() = false, want true")
+	}
+
+	if drift := CompareKeys(served[:1], served[:1], nil); drift.HasDrift() {
+		t.Errorf("CompareKeys() of matching keys = %+v, want no drift", drift)
+	}
+}
+
+func TestManager_ReadManagedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "authorized_keys")
+	content := "ssh-rsa AAAAlocal local\n" +
+		ManagedBlockBegin + "\n" +
+		"ssh-ed25519 AAAAmanaged managed\n" +
+		ManagedBlockEnd + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	manager := NewManagerWithPath(path)
+	managed, err := manager.ReadManagedKeys()
+	if err != nil {
+		t.Fatalf("ReadManagedKeys() error = %v", err)
+	}
+	if !reflect.DeepEqual(managed, []string{"ssh-ed25519 AAAAmanaged managed"}) {
+		t.Errorf("ReadManagedKeys() = %v", managed)
+	}
+
+	missing := NewManagerWithPath(filepath.Join(t.TempDir(), "missing"))
+	if keys, err := missing.ReadManagedKeys(); err != nil || len(keys) != 0 {
+		t.Errorf("ReadManagedKeys() of a missing file = %v, %v", keys, err)
+	}
+}
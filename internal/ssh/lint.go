@@ -0,0 +1,130 @@
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/dgarifullin/charon-key/internal/policy"
+)
+
+// Lint checks
+const (
+	LintMalformed     = "malformed"
+	LintDuplicate     = "duplicate"
+	LintWeakAlgorithm = "weak-algorithm"
+	LintRiskyOption   = "risky-option"
+)
+
+// Lint severities
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// minLintRSABits is the RSA key size below which keys are reported as weak
+const minLintRSABits = 2048
+
+// LintIssue is a problem found in an authorized_keys file
+type LintIssue struct {
+	// Line is the 1-based line number
+	Line int `json:"line"`
+
+	// Check is the kind of problem (LintMalformed, ...) and Severity whether
+	// sshd rejects the line (LintError) or accepts it (LintWarning)
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+
+	Message     string `json:"message"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+// knownOptions are the authorized_keys options sshd understands; lines
+// with any other option are ignored by sshd
+var knownOptions = map[string]bool{
+	"agent-forwarding": true, "cert-authority": true, "command": true,
+	"environment": true, "expiry-time": true, "from": true,
+	"no-agent-forwarding": true, "no-port-forwarding": true, "no-pty": true,
+	"no-touch-required": true, "no-user-rc": true, "no-x11-forwarding": true,
+	"permitlisten": true, "permitopen": true, "port-forwarding": true,
+	"principals": true, "pty": true, "restrict": true, "tunnel": true,
+	"user-rc": true, "verify-required": true, "x11-forwarding": true,
+}
+
+// LintAuthorizedKeys reports malformed lines, duplicate keys, weak
+// algorithms and risky options in an authorized_keys file
+// Empty lines, comments and the managed block markers are skipped
+func LintAuthorizedKeys(r io.Reader) ([]LintIssue, error) {
+	var issues []LintIssue
+	firstLine := make(map[string]int)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024) // RSA keys with options can be long
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		add := func(check, severity, fingerprint, format string, args ...any) {
+			issues = append(issues, LintIssue{
+				Line:        lineNo,
+				Check:       check,
+				Severity:    severity,
+				Message:     fmt.Sprintf(format, args...),
+				Fingerprint: fingerprint,
+			})
+		}
+
+		key, err := ParseAuthorizedKey(line)
+		if err != nil {
+			add(LintMalformed, LintError, "", "%v", err)
+			continue
+		}
+		fingerprint, err := Fingerprint(line)
+		if err != nil {
+			add(LintMalformed, LintError, "", "%v", err)
+			continue
+		}
+
+		if first, ok := firstLine[key.Normalized()]; ok {
+			add(LintDuplicate, LintWarning, fingerprint, "duplicate of the key on line %d; sshd applies the options of the first", first)
+		} else {
+			firstLine[key.Normalized()] = lineNo
+		}
+
+		switch key.Type {
+		case "ssh-dss":
+			add(LintWeakAlgorithm, LintWarning, fingerprint, "DSA keys are deprecated and disabled by default since OpenSSH 7.0")
+		case "ssh-rsa":
+			if bits, err := policy.RSABits(key.Blob); err != nil {
+				add(LintMalformed, LintError, fingerprint, "failed to read RSA key size: %v", err)
+			} else if bits < minLintRSABits {
+				add(LintWeakAlgorithm, LintWarning, fingerprint, "RSA key has %d bits, fewer than %d", bits, minLintRSABits)
+			}
+		}
+
+		for _, option := range key.Options {
+			name, value, _ := strings.Cut(option, "=")
+			name = strings.ToLower(name)
+			value = strings.Trim(value, `"`)
+			switch {
+			case !knownOptions[name]:
+				add(LintMalformed, LintError, fingerprint, "unknown option %q; sshd ignores the line", name)
+			case name == "environment":
+				add(LintRiskyOption, LintWarning, fingerprint, "environment=%q sets variables for the session (with PermitUserEnvironment)", value)
+			case name == "tunnel":
+				add(LintRiskyOption, LintWarning, fingerprint, "tunnel=%q allows tun device forwarding", value)
+			case (name == "permitopen" || name == "permitlisten") && strings.Contains(value, "*"):
+				add(LintRiskyOption, LintWarning, fingerprint, "%s=%q allows forwarding to any host or port", name, value)
+			case name == "cert-authority":
+				add(LintRiskyOption, LintWarning, fingerprint, "cert-authority trusts every certificate the key signs")
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read authorized_keys: %w", err)
+	}
+	return issues, nil
+}
@@ -0,0 +1,69 @@
+package ssh
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// rsaBlob builds an ssh-rsa key blob with a modulus of the given size
+func rsaBlob(bits int) string {
+	var blob []byte
+	appendString := func(b []byte) {
+		var l [4]byte
+		binary.BigEndian.PutUint32(l[:], uint32(len(b)))
+		blob = append(blob, l[:]...)
+		blob = append(blob, b...)
+	}
+	modulus := make([]byte, bits/8)
+	modulus[0] = 0x80
+	appendString([]byte("ssh-rsa"))
+	appendString([]byte{0x01, 0x00, 0x01})
+	appendString(append([]byte{0}, modulus...))
+	return base64.StdEncoding.EncodeToString(blob)
+}
+
+func TestLintAuthorizedKeys(t *testing.T) {
+	ed25519 := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOmzDbXw5GTZ09PuUndofA+zG4l2zb/pAJUQIg9GKwpb"
+
+	tests := []struct {
+		name  string
+		line  string
+		check string
+	}{
+		{"clean key", ed25519 + " alice@laptop", ""},
+		{"restricted key", `restrict,command="/usr/bin/backup" ` + ed25519, ""},
+		{"unknown key type", "foo AAAA bar", LintMalformed},
+		{"invalid key data", "ssh-ed25519 not!base64", LintMalformed},
+		{"unknown option", "no-such-option " + ed25519, LintMalformed},
+		{"short RSA key", "ssh-rsa " + rsaBlob(1024), LintWeakAlgorithm},
+		{"DSA key", "ssh-dss AAAAB3NzaC1kc3MAAACB", LintWeakAlgorithm},
+		{"environment option", `environment="LD_PRELOAD=/tmp/x.so" ` + ed25519, LintRiskyOption},
+		{"wildcard permitopen", `permitopen="*:*" ` + ed25519, LintRiskyOption},
+		{"scoped permitopen", `permitopen="db.internal:5432" ` + ed25519, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues, err := LintAuthorizedKeys(strings.NewReader("# comment\n\n" + tt.line + "\n"))
+			if err != nil {
+				t.Fatalf("LintAuthorizedKeys() error = %v", err)
+			}
+			if tt.check == "" {
+				if len(issues) > 0 {
+					t.Errorf("LintAuthorizedKeys() = %+v, want no issues", issues)
+				}
+				return
+			}
+			if len(issues) != 1 || issues[0].Check != tt.check || issues[0].Line != 3 {
+				t.Errorf("LintAuthorizedKeys() = %+v, want one %s issue on line 3", issues, tt.check)
+			}
+		})
+	}
+
+	issues, _ := LintAuthorizedKeys(strings.NewReader(ed25519 + " a\n" + "no-pty " + ed25519 + " b\n" + "ssh-rsa " + rsaBlob(2048) + "\n"))
+	if len(issues) != 1 || issues[0].Check != LintDuplicate || issues[0].Line != 2 {
+		t.Errorf("LintAuthorizedKeys() duplicates = %+v, want one on line 2", issues)
+	}
+}
@@ -0,0 +1,111 @@
+package ssh
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AuthorizedKey is a parsed authorized_keys line:
+// "[options] key-type key-data [comment]"
+type AuthorizedKey struct {
+	// Options are the line's options, e.g. `command="echo hi"` and "no-pty"
+	Options []string
+
+	// Type and Blob are the key type and base64 key data
+	Type string
+	Blob string
+
+	// Comment is the rest of the line, with its inner spacing kept
+	Comment string
+}
+
+// ParseAuthorizedKey parses one authorized_keys line the way sshd does: a
+// line starting with a key type has no options; otherwise it starts with
+// comma-separated options, which end at the first whitespace outside double
+// quotes. The key data is not decoded (see Fingerprint).
+func ParseAuthorizedKey(line string) (AuthorizedKey, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return AuthorizedKey{}, fmt.Errorf("empty line or comment")
+	}
+
+	var key AuthorizedKey
+	rest := line
+	if first, _ := cutField(line); !isKeyType(first) {
+		options, after, err := parseOptions(line)
+		if err != nil {
+			return AuthorizedKey{}, err
+		}
+		key.Options, rest = options, after
+	}
+
+	key.Type, rest = cutField(rest)
+	if !isKeyType(key.Type) {
+		return AuthorizedKey{}, fmt.Errorf("unknown key type %q", key.Type)
+	}
+	key.Blob, key.Comment = cutField(rest)
+	if key.Blob == "" {
+		return AuthorizedKey{}, fmt.Errorf("missing key data")
+	}
+	return key, nil
+}
+
+// Normalized returns the key type and data, identifying the key regardless
+// of options and comment
+func (k AuthorizedKey) Normalized() string {
+	return k.Type + " " + k.Blob
+}
+
+// String formats the key as an authorized_keys line
+func (k AuthorizedKey) String() string {
+	line := k.Normalized()
+	if len(k.Options) > 0 {
+		line = strings.Join(k.Options, ",") + " " + line
+	}
+	if k.Comment != "" {
+		line += " " + k.Comment
+	}
+	return line
+}
+
+// parseOptions splits the leading options of a line at commas outside
+// double quotes, returning them and the rest of the line
+func parseOptions(line string) (options []string, rest string, err error) {
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && inQuotes && i+1 < len(line):
+			i++ // Skip escaped character
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == ',' && !inQuotes:
+			if i == start {
+				return nil, "", fmt.Errorf("empty option at column %d", i+1)
+			}
+			options = append(options, line[start:i])
+			start = i + 1
+		case (c == ' ' || c == '\t') && !inQuotes:
+			if i == start {
+				return nil, "", fmt.Errorf("empty option at column %d", i+1)
+			}
+			options = append(options, line[start:i])
+			return options, strings.TrimSpace(line[i:]), nil
+		}
+	}
+
+	if inQuotes {
+		return nil, "", fmt.Errorf("unterminated quote in options")
+	}
+	return nil, "", fmt.Errorf("no key after options")
+}
+
+// cutField returns the first whitespace-separated field of s and the rest,
+// trimmed
+func cutField(s string) (field, rest string) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i:])
+	}
+	return s, ""
+}
@@ -0,0 +1,77 @@
+package ssh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAuthorizedKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    AuthorizedKey
+		wantErr string
+	}{
+		{
+			name: "plain key",
+			line: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI alice@laptop",
+			want: AuthorizedKey{Type: "ssh-ed25519", Blob: "AAAAC3NzaC1lZDI1NTE5AAAAI", Comment: "alice@laptop"},
+		},
+		{
+			name: "comment spacing kept",
+			line: "ssh-ed25519\tAAAAC3NzaC1lZDI1NTE5AAAAI  via charon-key  github:alice ",
+			want: AuthorizedKey{Type: "ssh-ed25519", Blob: "AAAAC3NzaC1lZDI1NTE5AAAAI", Comment: "via charon-key  github:alice"},
+		},
+		{
+			name: "options with quoted commas and spaces",
+			line: `command="echo a, b",no-pty,from="10.0.0.0/8,192.168.0.0/16" ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB backup`,
+			want: AuthorizedKey{
+				Options: []string{`command="echo a, b"`, "no-pty", `from="10.0.0.0/8,192.168.0.0/16"`},
+				Type:    "ssh-rsa",
+				Blob:    "AAAAB3NzaC1yc2EAAAADAQABAAAB",
+				Comment: "backup",
+			},
+		},
+		{
+			name: "escaped quote in option",
+			line: `command="echo \"a b\"" sk-ssh-ed25519@openssh.com AAAAGnNrLXNzaC1lZDI1NTE5`,
+			want: AuthorizedKey{Options: []string{`command="echo \"a b\""`}, Type: "sk-ssh-ed25519@openssh.com", Blob: "AAAAGnNrLXNzaC1lZDI1NTE5"},
+		},
+		{name: "unknown key type", line: "foo AAAAB3NzaC1yc2EAAAADAQABAAAB bar", wantErr: "unknown key type"},
+		{name: "missing key data", line: "restrict ssh-ed25519", wantErr: "missing key data"},
+		{name: "unterminated quote", line: `command="echo ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI`, wantErr: "unterminated quote"},
+		{name: "empty option", line: "no-pty,,restrict ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI", wantErr: "empty option"},
+		{name: "options only", line: "restrict", wantErr: "no key after options"},
+		{name: "comment line", line: "# ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI", wantErr: "comment"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseAuthorizedKey(tt.line)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("ParseAuthorizedKey() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseAuthorizedKey() error = %v", err)
+			}
+			if strings.Join(got.Options, "|") != strings.Join(tt.want.Options, "|") || got.Type != tt.want.Type ||
+				got.Blob != tt.want.Blob || got.Comment != tt.want.Comment {
+				t.Errorf("ParseAuthorizedKey() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthorizedKey_String(t *testing.T) {
+	line := `no-pty,command="echo hi" ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI alice@laptop`
+	key, err := ParseAuthorizedKey(line)
+	if err != nil {
+		t.Fatalf("ParseAuthorizedKey() error = %v", err)
+	}
+	if key.String() != line {
+		t.Errorf("String() = %q, want %q", key.String(), line)
+	}
+}
@@ -0,0 +1,102 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"os/user"
+	"strings"
+	"time"
+)
+
+// PasswdSource selects how user accounts are looked up
+type PasswdSource string
+
+const (
+	// PasswdAuto uses os/user and falls back to getent if the user is unknown
+	PasswdAuto PasswdSource = "auto"
+	// PasswdOS uses os/user only (reads /etc/passwd in pure-Go builds)
+	PasswdOS PasswdSource = "os"
+	// PasswdGetent uses getent, which goes through NSS (LDAP, SSSD, ...)
+	PasswdGetent PasswdSource = "getent"
+)
+
+// getentCommand is the getent binary (overridable in tests)
+var getentCommand = "getent"
+
+// getentTimeout bounds a single getent lookup
+const getentTimeout = 5 * time.Second
+
+// ParsePasswdSource validates a passwd source name
+func ParsePasswdSource(s string) (PasswdSource, error) {
+	switch source := PasswdSource(strings.ToLower(s)); source {
+	case PasswdAuto, PasswdOS, PasswdGetent:
+		return source, nil
+	default:
+		return "", fmt.Errorf("invalid passwd source: %q (valid: auto, os, getent)", s)
+	}
+}
+
+// LookupUser looks up a user account using the given source
+// os/user misses directory-backed (LDAP/SSSD) users when built without cgo,
+// so getent is used to go through NSS like sshd does
+func LookupUser(username string, source PasswdSource) (*user.User, error) {
+	switch source {
+	case PasswdOS:
+		return user.Lookup(username)
+	case PasswdGetent:
+		return lookupGetent(username)
+	default:
+		u, err := user.Lookup(username)
+		if err == nil {
+			return u, nil
+		}
+		if u, getentErr := lookupGetent(username); getentErr == nil {
+			return u, nil
+		}
+		return nil, err
+	}
+}
+
+// lookupGetent looks up a user with `getent passwd`
+func lookupGetent(username string) (*user.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), getentTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, getentCommand, "passwd", username)
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		// getent exits 2 when the key is not found
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 2 {
+			return nil, user.UnknownUserError(username)
+		}
+		return nil, fmt.Errorf("getent passwd %s failed: %w", username, err)
+	}
+
+	return parsePasswdLine(strings.TrimSpace(stdout.String()))
+}
+
+// parsePasswdLine parses a passwd(5) entry: name:password:uid:gid:gecos:home:shell
+func parsePasswdLine(line string) (*user.User, error) {
+	// getent may print several entries; the first one wins
+	line, _, _ = strings.Cut(line, "\n")
+
+	fields := strings.Split(line, ":")
+	if len(fields) != 7 {
+		return nil, fmt.Errorf("malformed passwd entry: %q", line)
+	}
+	if fields[0] == "" || fields[5] == "" {
+		return nil, fmt.Errorf("passwd entry missing name or home directory: %q", line)
+	}
+
+	name, _, _ := strings.Cut(fields[4], ",") // GECOS: full name is the first field
+	return &user.User{
+		Username: fields[0],
+		Uid:      fields[2],
+		Gid:      fields[3],
+		Name:     name,
+		HomeDir:  fields[5],
+	}, nil
+}
@@ -0,0 +1,78 @@
+package ssh
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+)
+
+func TestParsePasswdLine(t *testing.T) {
+	u, err := parsePasswdLine("alice:*:10001:10001:Alice Example,Room 1:/home/alice:/bin/bash")
+	if err != nil {
+		t.Fatalf("parsePasswdLine() error = %v", err)
+	}
+	if u.Username != "alice" || u.Uid != "10001" || u.Gid != "10001" || u.HomeDir != "/home/alice" || u.Name != "Alice Example" {
+		t.Errorf("parsePasswdLine() = %+v", u)
+	}
+
+	if _, err := parsePasswdLine("alice:*:10001"); err == nil {
+		t.Error("parsePasswdLine() expected error for truncated entry")
+	}
+	if _, err := parsePasswdLine("alice:*:10001:10001:::/bin/sh"); err == nil {
+		t.Error("parsePasswdLine() expected error for missing home directory")
+	}
+}
+
+func TestParsePasswdSource(t *testing.T) {
+	for _, valid := range []string{"auto", "os", "getent", "GETENT"} {
+		if _, err := ParsePasswdSource(valid); err != nil {
+			t.Errorf("ParsePasswdSource(%q) error = %v", valid, err)
+		}
+	}
+	if _, err := ParsePasswdSource("ldap"); err == nil {
+		t.Error("ParsePasswdSource() expected error for unknown source")
+	}
+}
+
+func TestLookupUser_Getent(t *testing.T) {
+	// Stand-in for getent that knows one directory-backed user
+	script := filepath.Join(t.TempDir(), "getent")
+	content := `#!/bin/sh
+if [ "$2" = "ldapuser" ]; then
+	echo "ldapuser:*:20001:20001:LDAP User:/home/ldapuser:/bin/sh"
+	exit 0
+fi
+exit 2
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+
+	original := getentCommand
+	getentCommand = script
+	defer func() { getentCommand = original }()
+
+	u, err := LookupUser("ldapuser", PasswdGetent)
+	if err != nil {
+		t.Fatalf("LookupUser(getent) error = %v", err)
+	}
+	if u.HomeDir != "/home/ldapuser" {
+		t.Errorf("LookupUser(getent) HomeDir = %q, want /home/ldapuser", u.HomeDir)
+	}
+
+	// auto falls back to getent for users os/user doesn't know
+	if _, err := LookupUser("ldapuser", PasswdAuto); err != nil {
+		t.Errorf("LookupUser(auto) error = %v", err)
+	}
+
+	// os never consults getent
+	if _, err := LookupUser("ldapuser", PasswdOS); err == nil {
+		t.Error("LookupUser(os) found a user only getent knows")
+	}
+
+	_, err = LookupUser("nobody-here", PasswdGetent)
+	if _, ok := err.(user.UnknownUserError); !ok {
+		t.Errorf("LookupUser(getent) error = %v, want UnknownUserError", err)
+	}
+}
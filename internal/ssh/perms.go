@@ -0,0 +1,181 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// PermissionIssue describes a path whose permissions or ownership would make
+// sshd (with StrictModes, the default) ignore the authorized_keys file
+type PermissionIssue struct {
+	Path    string
+	Problem string
+
+	// rel is the path relative to the top audited directory (see auditPaths)
+	rel string
+
+	// mode and uid are the path's current permissions and owner
+	mode os.FileMode
+	uid  int
+
+	// symlink is set for paths that are symbolic links, which are neither
+	// followed nor fixed: the user could point them at anyone's files
+	symlink bool
+}
+
+func (i PermissionIssue) String() string {
+	return fmt.Sprintf("%s: %s", i.Path, i.Problem)
+}
+
+// AuditPermissions checks the authorized_keys file, the .ssh directory and
+// the home directory the way sshd's StrictModes does: none may be writable
+// by group or others, and each must be owned by the user or root
+// Paths that don't exist are skipped; symbolic links below the home
+// directory are reported, and nothing below them is checked
+func (m *Manager) AuditPermissions() ([]PermissionIssue, error) {
+	top, rels := m.auditPaths()
+	root, err := os.OpenRoot(top)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", top, err)
+	}
+	defer root.Close()
+
+	// Walk down from the home directory, so a symlinked .ssh is reported
+	// instead of followed to the file it points at
+	var issues []PermissionIssue
+	for i := len(rels) - 1; i >= 0; i-- {
+		rel := rels[i]
+		path := filepath.Join(top, rel)
+		info, err := root.Lstat(rel)
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			issues = append([]PermissionIssue{{
+				Path:    path,
+				Problem: "is a symbolic link, not checked or fixed",
+				rel:     rel,
+				symlink: true,
+			}}, issues...)
+			break
+		}
+
+		uid := -1
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid = int(stat.Uid)
+		}
+
+		var found []PermissionIssue
+		if info.Mode().Perm()&0022 != 0 {
+			found = append(found, PermissionIssue{
+				Path:    path,
+				Problem: fmt.Sprintf("writable by group or others (mode %04o)", info.Mode().Perm()),
+				rel:     rel,
+				mode:    info.Mode().Perm(),
+				uid:     uid,
+			})
+		}
+		if m.uid >= 0 && uid >= 0 && uid != m.uid && uid != 0 {
+			found = append(found, PermissionIssue{
+				Path:    path,
+				Problem: fmt.Sprintf("owned by uid %d instead of %d or root", uid, m.uid),
+				rel:     rel,
+				mode:    info.Mode().Perm(),
+				uid:     uid,
+			})
+		}
+		issues = append(found, issues...)
+	}
+
+	return issues, nil
+}
+
+// FixPermissions resolves the issues reported by AuditPermissions by removing
+// group/other write permission and giving wrongly owned paths to the user
+// Changes are made through a file descriptor opened without following
+// symbolic links; symbolic links and the home directory's owner are left
+// alone and reported as errors
+func (m *Manager) FixPermissions(issues []PermissionIssue) error {
+	top, _ := m.auditPaths()
+	root, err := os.OpenRoot(top)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", top, err)
+	}
+	defer root.Close()
+
+	var errs []error
+	for _, issue := range issues {
+		chown := m.uid >= 0 && issue.uid >= 0 && issue.uid != m.uid && issue.uid != 0
+		switch {
+		case issue.symlink:
+			errs = append(errs, fmt.Errorf("refusing to fix %s: it is a symbolic link", issue.Path))
+			continue
+		case chown && issue.rel == ".":
+			errs = append(errs, fmt.Errorf("refusing to change the owner of %s", issue.Path))
+			continue
+		}
+		if err := fixPath(root, issue, chown, m.uid); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fixPath removes group/other write permission from a path, and gives it to
+// uid if chown is set, provided it is still the regular file or directory
+// AuditPermissions found rather than a symbolic link swapped in since
+func fixPath(root *os.Root, issue PermissionIssue, chown bool, uid int) error {
+	before, err := root.Lstat(issue.rel)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", issue.Path, err)
+	}
+	if !before.Mode().IsRegular() && !before.IsDir() {
+		return fmt.Errorf("refusing to fix %s: not a regular file or directory", issue.Path)
+	}
+	file, err := root.OpenFile(issue.rel, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", issue.Path, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", issue.Path, err)
+	}
+	if !os.SameFile(before, info) {
+		return fmt.Errorf("refusing to fix %s: it changed while being fixed", issue.Path)
+	}
+
+	if info.Mode().Perm()&0022 != 0 {
+		if err := file.Chmod(info.Mode().Perm() &^ 0022); err != nil {
+			return fmt.Errorf("failed to fix permissions of %s: %w", issue.Path, err)
+		}
+	}
+	if chown {
+		if err := file.Chown(uid, -1); err != nil {
+			return fmt.Errorf("failed to fix ownership of %s: %w", issue.Path, err)
+		}
+	}
+	return nil
+}
+
+// auditPaths returns the top directory sshd checks (the home directory) and
+// the paths it checks relative to it, from the file up to the top directory
+func (m *Manager) auditPaths() (string, []string) {
+	sshDir := filepath.Dir(m.authorizedKeysPath)
+	top := filepath.Dir(sshDir)
+	return top, []string{
+		filepath.Join(filepath.Base(sshDir), filepath.Base(m.authorizedKeysPath)),
+		filepath.Base(sshDir),
+		".",
+	}
+}
@@ -0,0 +1,161 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestManager_AuditPermissions(t *testing.T) {
+	home := filepath.Join(t.TempDir(), "alice")
+	sshDir := filepath.Join(home, ".ssh")
+	authKeysPath := filepath.Join(sshDir, "authorized_keys")
+	if err := os.MkdirAll(sshDir, 0700); err != nil {
+		t.Fatalf("Failed to create .ssh: %v", err)
+	}
+	if err := os.WriteFile(authKeysPath, []byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI\n"), 0600); err != nil {
+		t.Fatalf("Failed to write authorized_keys: %v", err)
+	}
+	manager := NewManagerWithPath(authKeysPath)
+
+	issues, err := manager.AuditPermissions()
+	if err != nil {
+		t.Fatalf("AuditPermissions() error = %v", err)
+	}
+	if len(issues) != 0 {
+		t.Fatalf("AuditPermissions() = %v, want no issues", issues)
+	}
+
+	// os.Chmod bypasses the umask, so these modes stick
+	if err := os.Chmod(authKeysPath, 0666); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+	if err := os.Chmod(sshDir, 0770); err != nil {
+		t.Fatalf("Chmod() error = %v", err)
+	}
+
+	issues, err = manager.AuditPermissions()
+	if err != nil {
+		t.Fatalf("AuditPermissions() error = %v", err)
+	}
+	if len(issues) != 2 || issues[0].Path != authKeysPath || issues[1].Path != sshDir {
+		t.Fatalf("AuditPermissions() = %v, want issues for the file and .ssh", issues)
+	}
+
+	if err := manager.FixPermissions(issues); err != nil {
+		t.Fatalf("FixPermissions() error = %v", err)
+	}
+	info, _ := os.Stat(authKeysPath)
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("authorized_keys mode = %o, want 644", info.Mode().Perm())
+	}
+	issues, _ = manager.AuditPermissions()
+	if len(issues) != 0 {
+		t.Errorf("AuditPermissions() after fix = %v, want no issues", issues)
+	}
+}
+
+func TestManager_FixPermissionsSymlinks(t *testing.T) {
+	tests := []struct {
+		name string
+		link func(sshDir, victimDir string) error
+	}{
+		{
+			name: "authorized_keys",
+			link: func(sshDir, victimDir string) error {
+				if err := os.MkdirAll(sshDir, 0700); err != nil {
+					return err
+				}
+				return os.Symlink(filepath.Join(victimDir, "authorized_keys"), filepath.Join(sshDir, "authorized_keys"))
+			},
+		},
+		{
+			name: ".ssh",
+			link: func(sshDir, victimDir string) error {
+				return os.Symlink(victimDir, sshDir)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			home := filepath.Join(tmpDir, "alice")
+			victimDir := filepath.Join(tmpDir, "victim")
+			victim := filepath.Join(victimDir, "authorized_keys")
+			if err := os.MkdirAll(home, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.MkdirAll(victimDir, 0700); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(victim, []byte("secret\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(victim, 0666); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.link(filepath.Join(home, ".ssh"), victimDir); err != nil {
+				t.Fatal(err)
+			}
+			manager := NewManagerWithPath(filepath.Join(home, ".ssh", "authorized_keys"))
+
+			issues, err := manager.AuditPermissions()
+			if err != nil {
+				t.Fatalf("AuditPermissions() error = %v", err)
+			}
+			if len(issues) != 1 || !issues[0].symlink {
+				t.Fatalf("AuditPermissions() = %v, want only the symbolic link", issues)
+			}
+			if err := manager.FixPermissions(issues); err == nil {
+				t.Error("FixPermissions() of a symbolic link error = nil")
+			}
+			if info, _ := os.Stat(victim); info.Mode().Perm() != 0666 {
+				t.Errorf("symlink target mode = %o, want it untouched", info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestManager_FixPermissionsOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing owners needs root")
+	}
+	home := filepath.Join(t.TempDir(), "alice")
+	sshDir := filepath.Join(home, ".ssh")
+	if err := os.MkdirAll(sshDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{home, sshDir} {
+		if err := os.Chown(path, 12345, -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manager := NewManagerWithPath(filepath.Join(sshDir, "authorized_keys"))
+	manager.uid = 1000
+
+	issues, err := manager.AuditPermissions()
+	if err != nil || len(issues) != 2 {
+		t.Fatalf("AuditPermissions() = %v, %v, want .ssh and the home directory", issues, err)
+	}
+	if err := manager.FixPermissions(issues); err == nil {
+		t.Error("FixPermissions() changing the home directory's owner error = nil")
+	}
+	if uid := fileOwner(t, sshDir); uid != 1000 {
+		t.Errorf(".ssh owner = %d, want 1000", uid)
+	}
+	if uid := fileOwner(t, home); uid != 12345 {
+		t.Errorf("home directory owner = %d, want it unchanged", uid)
+	}
+}
+
+// fileOwner returns the uid owning path
+func fileOwner(t *testing.T, path string) int {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(info.Sys().(*syscall.Stat_t).Uid)
+}
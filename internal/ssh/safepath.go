@@ -0,0 +1,123 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// openUserDir opens dir, a directory holding one of a user's key files, for
+// reads or writes on the user's behalf, without letting the user redirect
+// them elsewhere: below the home directory (or from / for directories
+// outside it), every component must be a real directory owned by uid or
+// root, or a symbolic link only root could have made (e.g. /etc on macOS)
+// The path of the home directory itself comes from passwd and is trusted
+func openUserDir(dir, home string, uid int) (*os.Root, error) {
+	start, rel := string(filepath.Separator), strings.TrimPrefix(filepath.Clean(dir), string(filepath.Separator))
+	if r, ok := relIn(home, dir); ok {
+		start, rel = home, r
+	}
+
+	root, err := os.OpenRoot(start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", start, err)
+	}
+	if rel == "." || rel == "" {
+		return root, nil
+	}
+	path := start
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		path = filepath.Join(path, name)
+		sub, err := openUserSubdir(root, name, path, uid)
+		root.Close()
+		if err != nil {
+			return nil, err
+		}
+		root = sub
+	}
+	return root, nil
+}
+
+// relIn returns path relative to dir, if it is in dir
+func relIn(dir, path string) (string, bool) {
+	if dir == "" {
+		return "", false
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// openUserSubdir opens the directory name in root, checking it as
+// openUserDir does; path is its full path, for errors
+func openUserSubdir(root *os.Root, name, path string, uid int) (*os.Root, error) {
+	info, err := root.Lstat(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && info.Mode()&os.ModeSymlink != 0 && stat.Uid == 0 {
+		if info, err = root.Stat(name); err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+	}
+	if err := checkUserOwned(info, path, uid); err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("refusing %s: not a directory", path)
+	}
+	sub, err := root.OpenRoot(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	// A symbolic link swapped in after Lstat would open another directory
+	opened, err := sub.Stat(".")
+	if err != nil || !os.SameFile(info, opened) {
+		sub.Close()
+		return nil, fmt.Errorf("refusing %s: it changed while being opened", path)
+	}
+	return sub, nil
+}
+
+// openUserFile opens the regular file name in root (see openUserDir) for
+// reading, refusing symbolic links and files not owned by uid or root; path
+// is its full path, for errors
+func openUserFile(root *os.Root, name, path string, uid int) (*os.File, error) {
+	info, err := root.Lstat(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if err := checkUserOwned(info, path, uid); err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("refusing %s: not a regular file", path)
+	}
+	file, err := root.OpenFile(name, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	opened, err := file.Stat()
+	if err != nil || !os.SameFile(info, opened) {
+		file.Close()
+		return nil, fmt.Errorf("refusing %s: it changed while being opened", path)
+	}
+	return file, nil
+}
+
+// checkUserOwned refuses symbolic links, and paths owned by anyone but uid
+// or root (any owner if uid is unknown)
+func checkUserOwned(info os.FileInfo, path string, uid int) error {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("refusing %s: it is a symbolic link", path)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if ok && uid >= 0 && int(stat.Uid) != uid && stat.Uid != 0 {
+		return fmt.Errorf("refusing %s: owned by uid %d instead of %d or root", path, stat.Uid, uid)
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// ManagedBlockBegin marks the start of the keys written by sync mode
+	ManagedBlockBegin = "# BEGIN charon-key managed keys"
+	// ManagedBlockEnd marks the end of the keys written by sync mode
+	ManagedBlockEnd = "# END charon-key managed keys"
+
+	// ManagedPrincipalsBegin marks the start of the principals written by sync mode
+	ManagedPrincipalsBegin = "# BEGIN charon-key managed principals"
+	// ManagedPrincipalsEnd marks the end of the principals written by sync mode
+	ManagedPrincipalsEnd = "# END charon-key managed principals"
+
+	// backupSuffix is inserted between the file name and the backup timestamp
+	backupSuffix = ".charon-key-backup."
+	// backupTimeFormat sorts lexically in chronological order
+	backupTimeFormat = "20060102T150405.000000000Z"
+)
+
+// WriteOptions controls how authorized_keys files are written
+type WriteOptions struct {
+	// Mode is the permission of the written file (default 0600)
+	Mode os.FileMode
+
+	// Backups is the number of timestamped backups of the previous file to
+	// keep next to it (0 = no backups)
+	Backups int
+}
+
+// DefaultWriteOptions returns the options sshd's StrictModes is happy with
+func DefaultWriteOptions() WriteOptions {
+	return WriteOptions{
+		Mode:    0600,
+		Backups: 3,
+	}
+}
+
+// SyncKeys writes GitHub keys into the managed block of the authorized_keys
+// file, leaving every line outside the block untouched. The previous managed
+// block is replaced, so keys removed from GitHub disappear from the file.
+func (m *Manager) SyncKeys(githubKeys []string, opts WriteOptions) error {
+	current, err := m.readFile(m.authorizedKeysPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read authorized_keys file: %w", err)
+	}
+
+	// Existing keys win over GitHub copies of the same key
+	data, err := replaceManagedBlock(current, githubKeys, ManagedBlockBegin, ManagedBlockEnd, normalizeKey)
+	if err != nil {
+		return fmt.Errorf("refusing to rewrite %s: %w", m.authorizedKeysPath, err)
+	}
+	return m.WriteAuthorizedKeys(data, opts)
+}
+
+// SyncPrincipals writes principals into the managed block of an
+// AuthorizedPrincipalsFile, the same way SyncKeys maintains authorized_keys
+// The file's directory must already exist; it is not created, since
+// principals files often live outside the user's home (e.g. /etc/ssh)
+func (m *Manager) SyncPrincipals(path string, principals []string, opts WriteOptions) error {
+	current, err := m.readFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read principals file: %w", err)
+	}
+
+	data, err := replaceManagedBlock(current, principals, ManagedPrincipalsBegin, ManagedPrincipalsEnd, strings.TrimSpace)
+	if err != nil {
+		return fmt.Errorf("refusing to rewrite %s: %w", path, err)
+	}
+	return m.writeFile(path, data, opts)
+}
+
+// ExpandPath expands the sshd_config tokens %u (username), %h (home
+// directory) and %% in a path, like sshd does for AuthorizedPrincipalsFile
+func (m *Manager) ExpandPath(pattern, username string) string {
+	return strings.NewReplacer("%%", "%", "%u", username, "%h", m.home).Replace(pattern)
+}
+
+// replaceManagedBlock returns content with its managed block replaced by
+// lines; lines already present outside the block (compared by key) are skipped
+func replaceManagedBlock(content []byte, lines []string, begin, end string, key func(string) string) ([]byte, error) {
+	existing := make(map[string]bool)
+	unmanaged, err := stripManagedBlock(content, begin, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(unmanaged), "\n") {
+		if k := key(line); k != "" && !strings.HasPrefix(k, "#") {
+			existing[k] = true
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.Write(unmanaged)
+	if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+		buf.WriteString("\n")
+	}
+	buf.WriteString(begin + "\n")
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || existing[key(line)] {
+			continue
+		}
+		existing[key(line)] = true
+		buf.WriteString(line + "\n")
+	}
+	buf.WriteString(end + "\n")
+
+	return buf.Bytes(), nil
+}
+
+// WriteAuthorizedKeys atomically replaces the authorized_keys file:
+// the .ssh directory is created with 0700 if missing (directories outside
+// the home directory must exist), the previous file is backed up, and the
+// new content is written to a temp file, given the configured mode and the
+// user's ownership, then renamed into place
+// Directories below the home directory must be real directories owned by
+// the user or root, so a symlinked .ssh can't redirect the write
+func (m *Manager) WriteAuthorizedKeys(data []byte, opts WriteOptions) error {
+	dir := filepath.Dir(m.authorizedKeysPath)
+	if filepath.Dir(dir) == m.home {
+		if err := m.createHomeDir(filepath.Base(dir)); err != nil {
+			return err
+		}
+	}
+
+	return m.writeFile(m.authorizedKeysPath, data, opts)
+}
+
+// createHomeDir creates the directory name in the home directory with 0700,
+// owned by the user, unless it exists
+func (m *Manager) createHomeDir(name string) error {
+	home, err := os.OpenRoot(m.home)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", m.home, err)
+	}
+	defer home.Close()
+
+	path := filepath.Join(m.home, name)
+	if err := home.Mkdir(name, 0700); errors.Is(err, os.ErrExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if m.uid < 0 && m.gid < 0 {
+		return nil
+	}
+	if err := home.Lchown(name, m.uid, m.gid); err != nil {
+		return fmt.Errorf("failed to set ownership of %s: %w", path, err)
+	}
+	return nil
+}
+
+// readFile reads one of the user's files like os.ReadFile, without
+// following symbolic links (see openUserDir and openUserFile)
+func (m *Manager) readFile(path string) ([]byte, error) {
+	root, err := openUserDir(filepath.Dir(path), m.home, m.uid)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	return readUserFile(root, filepath.Base(path), path, m.uid)
+}
+
+// readUserFile reads the file name in root (see openUserFile)
+func readUserFile(root *os.Root, name, path string, uid int) ([]byte, error) {
+	file, err := openUserFile(root, name, path, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// writeFile backs up path (if its content changes) and atomically replaces
+// it with data, owned by the user, through its directory opened with
+// openUserDir
+func (m *Manager) writeFile(path string, data []byte, opts WriteOptions) error {
+	if opts.Mode == 0 {
+		opts.Mode = 0600
+	}
+
+	root, err := openUserDir(filepath.Dir(path), m.home, m.uid)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	name := filepath.Base(path)
+
+	// Only back up when the content actually changes, so periodic syncs
+	// don't rotate the useful backups away
+	current, err := readUserFile(root, name, path, m.uid)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if opts.Backups > 0 && err == nil && !bytes.Equal(current, data) {
+		if err := m.backup(root, path, current, opts.Backups); err != nil {
+			return err
+		}
+	}
+
+	return writeFileIn(root, name, path, data, opts.Mode, m.uid, m.gid)
+}
+
+// WriteOutput atomically replaces path (e.g. the --output file) with data,
+// keeping charon-key's ownership; in the home directory, it is written
+// through its directory opened with openUserDir, like authorized_keys
+func (m *Manager) WriteOutput(path string, data []byte, mode os.FileMode) error {
+	if _, ok := relIn(m.home, path); !ok {
+		return WriteFileAtomic(path, data, mode, -1, -1)
+	}
+	root, err := openUserDir(filepath.Dir(path), m.home, m.uid)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	return writeFileIn(root, filepath.Base(path), path, data, mode, -1, -1)
+}
+
+// WriteFileAtomic writes data to a temp file in the target's directory, sets
+// its mode and ownership (uid/gid of -1 are left unchanged), syncs it and
+// renames it over path, so readers never see a partially written file
+func WriteFileAtomic(path string, data []byte, mode os.FileMode, uid, gid int) error {
+	root, err := os.OpenRoot(filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filepath.Dir(path), err)
+	}
+	defer root.Close()
+	return writeFileIn(root, filepath.Base(path), path, data, mode, uid, gid)
+}
+
+// writeFileIn is WriteFileAtomic for the file name in root; path is its
+// full path, for errors
+func writeFileIn(root *os.Root, name, path string, data []byte, mode os.FileMode, uid, gid int) error {
+	tmpName := fmt.Sprintf(".%s.tmp-%d-%d", name, os.Getpid(), time.Now().UnixNano())
+	tmp, err := root.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer root.Remove(tmpName) // No-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if uid >= 0 || gid >= 0 {
+		if err := tmp.Chown(uid, gid); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to set ownership: %w", err)
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if err := root.Rename(tmpName, name); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Backups returns the backup files of the authorized_keys file, oldest first
+func (m *Manager) Backups() ([]string, error) {
+	backups, err := filepath.Glob(m.authorizedKeysPath + backupSuffix + "*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// backupsIn returns the names of the backup files of the file name in root,
+// oldest first
+func backupsIn(root *os.Root, name string) ([]string, error) {
+	dir, err := root.Open(".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+	var backups []string
+	for _, n := range names {
+		if strings.HasPrefix(n, name+backupSuffix) {
+			backups = append(backups, n)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// backup saves data (the current content of path, in the directory root) to
+// a timestamped backup and removes the oldest backups beyond keep
+func (m *Manager) backup(root *os.Root, path string, data []byte, keep int) error {
+	backupPath := path + backupSuffix + time.Now().UTC().Format(backupTimeFormat)
+	if err := writeFileIn(root, filepath.Base(backupPath), backupPath, data, 0600, m.uid, m.gid); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	backups, err := backupsIn(root, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	for len(backups) > keep {
+		if err := root.Remove(backups[0]); err != nil {
+			return fmt.Errorf("failed to remove old backup: %w", err)
+		}
+		backups = backups[1:]
+	}
+
+	return nil
+}
+
+// stripManagedBlock returns the file content without the managed block
+// delimited by begin and end, with other lines kept whatever their length
+// A begin marker without an end is an error, as the lines after it may be
+// the user's
+func stripManagedBlock(content []byte, begin, end string) ([]byte, error) {
+	var buf bytes.Buffer
+	inManaged := false
+
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		switch string(bytes.TrimSpace(line)) {
+		case begin:
+			inManaged = true
+			continue
+		case end:
+			inManaged = false
+			continue
+		}
+		if !inManaged {
+			buf.Write(line)
+			if !bytes.HasSuffix(line, []byte("\n")) {
+				buf.WriteString("\n")
+			}
+		}
+	}
+	if inManaged {
+		return nil, fmt.Errorf("managed block %q has no end marker", begin)
+	}
+
+	return buf.Bytes(), nil
+}
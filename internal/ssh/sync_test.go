@@ -0,0 +1,348 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestManager_SyncKeys(t *testing.T) {
+	tmpDir := t.TempDir()
+	authKeysPath := filepath.Join(tmpDir, ".ssh", "authorized_keys")
+	manager := NewManagerWithPath(authKeysPath)
+
+	opts := DefaultWriteOptions()
+	opts.Backups = 2
+
+	// First sync creates .ssh and the file
+	first := []string{
+		"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB old@example.com",
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI keep@example.com",
+	}
+	if err := manager.SyncKeys(first, opts); err != nil {
+		t.Fatalf("SyncKeys() error = %v", err)
+	}
+
+	dirInfo, err := os.Stat(filepath.Dir(authKeysPath))
+	if err != nil {
+		t.Fatalf("Stat(.ssh) error = %v", err)
+	}
+	if dirInfo.Mode().Perm() != 0700 {
+		t.Errorf(".ssh mode = %o, want 700", dirInfo.Mode().Perm())
+	}
+
+	fileInfo, err := os.Stat(authKeysPath)
+	if err != nil {
+		t.Fatalf("Stat(authorized_keys) error = %v", err)
+	}
+	if fileInfo.Mode().Perm() != 0600 {
+		t.Errorf("authorized_keys mode = %o, want 600", fileInfo.Mode().Perm())
+	}
+
+	// Hand-added keys outside the block survive later syncs
+	content, _ := os.ReadFile(authKeysPath)
+	content = append([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAL local@example.com\n"), content...)
+	if err := os.WriteFile(authKeysPath, content, 0600); err != nil {
+		t.Fatalf("Failed to add local key: %v", err)
+	}
+
+	// Second sync drops the key removed from GitHub
+	second := []string{"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI keep@example.com"}
+	if err := manager.SyncKeys(second, opts); err != nil {
+		t.Fatalf("SyncKeys() error = %v", err)
+	}
+
+	data, _ := os.ReadFile(authKeysPath)
+	got := string(data)
+	if strings.Contains(got, "old@example.com") {
+		t.Error("SyncKeys() kept a key that was removed from GitHub")
+	}
+	if !strings.Contains(got, "local@example.com") || !strings.Contains(got, "keep@example.com") {
+		t.Errorf("SyncKeys() content = %q, missing expected keys", got)
+	}
+	if strings.Count(got, ManagedBlockBegin) != 1 {
+		t.Errorf("SyncKeys() content has %d managed blocks, want 1", strings.Count(got, ManagedBlockBegin))
+	}
+
+	// Managed keys are not reported as existing keys
+	existing, err := manager.ReadExistingKeys()
+	if err != nil {
+		t.Fatalf("ReadExistingKeys() error = %v", err)
+	}
+	if len(existing) != 1 || !strings.Contains(existing[0], "local@example.com") {
+		t.Errorf("ReadExistingKeys() = %v, want only the local key", existing)
+	}
+
+	backups, err := manager.Backups()
+	if err != nil {
+		t.Fatalf("Backups() error = %v", err)
+	}
+	if len(backups) != 1 {
+		t.Errorf("Backups() = %v, want 1 backup", backups)
+	}
+}
+
+func TestManager_SyncKeys_Unmanaged(t *testing.T) {
+	longKey := `command="` + strings.Repeat("x", 100*1024) + `" ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAL long@example.com`
+	synced := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI keep@example.com"
+
+	tests := []struct {
+		name      string
+		content   string
+		wantError bool
+	}{
+		{
+			name:    "line longer than 64KB",
+			content: longKey + "\n" + ManagedBlockBegin + "\nssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB old@example.com\n" + ManagedBlockEnd + "\nssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAM after@example.com\n",
+		},
+		{
+			name:      "begin marker without end",
+			content:   ManagedBlockBegin + "\nssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB old@example.com\nssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAM after@example.com\n",
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authKeysPath := filepath.Join(t.TempDir(), ".ssh", "authorized_keys")
+			if err := os.MkdirAll(filepath.Dir(authKeysPath), 0700); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(authKeysPath, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			manager := NewManagerWithPath(authKeysPath)
+
+			err := manager.SyncKeys([]string{synced}, DefaultWriteOptions())
+			data, _ := os.ReadFile(authKeysPath)
+			got := string(data)
+			if tt.wantError {
+				if err == nil {
+					t.Error("SyncKeys() succeeded, want an error")
+				}
+				if got != tt.content {
+					t.Errorf("SyncKeys() rewrote the file to %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SyncKeys() error = %v", err)
+			}
+			if !strings.Contains(got, longKey+"\n") || !strings.Contains(got, "after@example.com") || !strings.Contains(got, synced) {
+				t.Errorf("SyncKeys() dropped lines, content has %d bytes", len(got))
+			}
+			if strings.Contains(got, "old@example.com") {
+				t.Error("SyncKeys() kept the previous managed block")
+			}
+		})
+	}
+}
+
+func TestManager_SyncKeys_BackupRotation(t *testing.T) {
+	authKeysPath := filepath.Join(t.TempDir(), "authorized_keys")
+	manager := NewManagerWithPath(authKeysPath)
+
+	opts := DefaultWriteOptions()
+	opts.Backups = 2
+
+	keys := []string{
+		"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB a@example.com",
+		"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAC b@example.com",
+		"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAD c@example.com",
+		"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAE d@example.com",
+	}
+	for _, key := range keys {
+		if err := manager.SyncKeys([]string{key}, opts); err != nil {
+			t.Fatalf("SyncKeys() error = %v", err)
+		}
+	}
+
+	// Unchanged content doesn't rotate backups
+	if err := manager.SyncKeys([]string{keys[3]}, opts); err != nil {
+		t.Fatalf("SyncKeys() error = %v", err)
+	}
+
+	backups, _ := manager.Backups()
+	if len(backups) != 2 {
+		t.Fatalf("Backups() returned %d backups, want 2", len(backups))
+	}
+	newest, _ := os.ReadFile(backups[1])
+	if !strings.Contains(string(newest), "c@example.com") {
+		t.Errorf("newest backup = %q, want the previous content", newest)
+	}
+}
+
+func TestManager_SyncPrincipals(t *testing.T) {
+	tmpDir := t.TempDir()
+	manager := NewManagerWithPath(filepath.Join(tmpDir, "alice", ".ssh", "authorized_keys"))
+
+	path := manager.ExpandPath(filepath.Join(tmpDir, "principals-%u"), "alice")
+	if path != filepath.Join(tmpDir, "principals-alice") {
+		t.Fatalf("ExpandPath() = %q", path)
+	}
+	if got := manager.ExpandPath("%h/.ssh/principals", "alice"); got != filepath.Join(tmpDir, "alice", ".ssh", "principals") {
+		t.Errorf("ExpandPath(%%h) = %q", got)
+	}
+
+	// Hand-maintained principals are kept and not duplicated
+	if err := os.WriteFile(path, []byte("break-glass\nops\n"), 0600); err != nil {
+		t.Fatalf("Failed to write principals file: %v", err)
+	}
+
+	opts := DefaultWriteOptions()
+	if err := manager.SyncPrincipals(path, []string{"ops", "alice-github"}, opts); err != nil {
+		t.Fatalf("SyncPrincipals() error = %v", err)
+	}
+	if err := manager.SyncPrincipals(path, []string{"ops", "bob-github"}, opts); err != nil {
+		t.Fatalf("SyncPrincipals() error = %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	want := "break-glass\nops\n" + ManagedPrincipalsBegin + "\nbob-github\n" + ManagedPrincipalsEnd + "\n"
+	if string(data) != want {
+		t.Errorf("SyncPrincipals() content = %q, want %q", data, want)
+	}
+
+	// Missing directories are not created
+	missing := filepath.Join(tmpDir, "missing", "principals")
+	if err := manager.SyncPrincipals(missing, []string{"ops"}, opts); err == nil {
+		t.Error("SyncPrincipals() expected error for missing directory")
+	}
+}
+
+func TestManager_SetAuthorizedKeysFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	manager := NewManagerWithPath(filepath.Join(tmpDir, "alice", ".ssh", "authorized_keys"))
+
+	manager.SetAuthorizedKeysFile(".ssh/authorized_keys2", "alice")
+	if got := manager.GetAuthorizedKeysPath(); got != filepath.Join(tmpDir, "alice", ".ssh", "authorized_keys2") {
+		t.Errorf("relative path = %q, want it under the home directory", got)
+	}
+
+	// Directories outside the home directory are not created
+	manager.SetAuthorizedKeysFile(filepath.Join(tmpDir, "etc", "%u"), "alice")
+	if got := manager.GetAuthorizedKeysPath(); got != filepath.Join(tmpDir, "etc", "alice") {
+		t.Fatalf("absolute path = %q", got)
+	}
+	key := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl a@example.com"
+	if err := manager.SyncKeys([]string{key}, DefaultWriteOptions()); err == nil {
+		t.Error("SyncKeys() into a missing directory outside home error = nil")
+	}
+	os.Mkdir(filepath.Join(tmpDir, "etc"), 0755)
+	if err := manager.SyncKeys([]string{key}, DefaultWriteOptions()); err != nil {
+		t.Fatalf("SyncKeys() error = %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(tmpDir, "etc", "alice")); !strings.Contains(string(data), key) {
+		t.Errorf("authorized keys file = %q, want the key", data)
+	}
+}
+
+func TestManager_SetChroot(t *testing.T) {
+	tmpDir := t.TempDir()
+	manager := &Manager{authorizedKeysPath: "/home/alice/.ssh/authorized_keys", home: "/home/alice", uid: -1, gid: -1}
+
+	manager.SetChroot(filepath.Join(tmpDir, "%u"), "alice")
+	home := filepath.Join(tmpDir, "alice", "home", "alice")
+	if got := manager.GetAuthorizedKeysPath(); got != filepath.Join(home, ".ssh", "authorized_keys") {
+		t.Errorf("GetAuthorizedKeysPath() = %q, want it inside the chroot", got)
+	}
+	if got := manager.ExpandPath("%h/.ssh/principals", "alice"); got != filepath.Join(home, ".ssh", "principals") {
+		t.Errorf("ExpandPath() = %q, want %%h inside the chroot", got)
+	}
+	manager.SetExtraKeyFiles([]string{".ssh/authorized_keys2"}, "alice")
+	if got := manager.KeyFiles(); len(got) != 2 || got[1] != filepath.Join(home, ".ssh", "authorized_keys2") {
+		t.Errorf("KeyFiles() = %v, want relative paths inside the chroot", got)
+	}
+
+	// .ssh is created in the chrooted home directory
+	if err := os.MkdirAll(home, 0755); err != nil {
+		t.Fatal(err)
+	}
+	key := "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl a@example.com"
+	if err := manager.SyncKeys([]string{key}, DefaultWriteOptions()); err != nil {
+		t.Fatalf("SyncKeys() error = %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(home, ".ssh", "authorized_keys")); !strings.Contains(string(data), key) {
+		t.Errorf("chrooted authorized_keys = %q, want the key", data)
+	}
+}
+
+func TestManager_SyncSymlinks(t *testing.T) {
+	tests := []struct {
+		name  string
+		link  string // relative to the home directory, pointing at the victim directory or file
+		write func(m *Manager, home string) error
+	}{
+		{
+			name:  "symlinked .ssh",
+			link:  ".ssh",
+			write: func(m *Manager, home string) error { return m.SyncKeys([]string{testKey}, DefaultWriteOptions()) },
+		},
+		{
+			name:  "symlinked authorized_keys",
+			link:  ".ssh/authorized_keys",
+			write: func(m *Manager, home string) error { return m.SyncKeys([]string{testKey}, DefaultWriteOptions()) },
+		},
+		{
+			name: "symlinked principals directory",
+			link: ".ssh",
+			write: func(m *Manager, home string) error {
+				return m.SyncPrincipals(m.ExpandPath("%h/.ssh/principals", "alice"), []string{"ops"}, DefaultWriteOptions())
+			},
+		},
+		{
+			name: "symlinked output directory",
+			link: "out",
+			write: func(m *Manager, home string) error {
+				return m.WriteOutput(filepath.Join(home, "out", "keys"), []byte(testKey+"\n"), 0644)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			home := filepath.Join(tmpDir, "alice")
+			victimDir := filepath.Join(tmpDir, "victim")
+			for _, dir := range []string{filepath.Join(home, ".ssh"), victimDir} {
+				if err := os.MkdirAll(dir, 0700); err != nil {
+					t.Fatal(err)
+				}
+			}
+			victim := filepath.Join(victimDir, "authorized_keys")
+			if err := os.WriteFile(victim, []byte("secret\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			link := filepath.Join(home, tt.link)
+			target := victimDir
+			if tt.link == ".ssh/authorized_keys" {
+				target = victim
+			} else if err := os.RemoveAll(link); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink(target, link); err != nil {
+				t.Fatal(err)
+			}
+			// Root's own links are trusted, so make it the user's
+			if os.Geteuid() == 0 {
+				if err := os.Lchown(link, 12345, -1); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			manager := NewManagerWithPath(filepath.Join(home, ".ssh", "authorized_keys"))
+			if err := tt.write(manager, home); err == nil {
+				t.Error("write through a symbolic link error = nil")
+			}
+			entries, _ := os.ReadDir(victimDir)
+			if len(entries) != 1 {
+				t.Errorf("victim directory has %d entries, want only the original file", len(entries))
+			}
+			if data, _ := os.ReadFile(victim); string(data) != "secret\n" {
+				t.Errorf("victim file = %q, want it untouched", data)
+			}
+		})
+	}
+}
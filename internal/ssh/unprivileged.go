@@ -0,0 +1,154 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// readHelperTimeout bounds a read helper invocation
+const readHelperTimeout = 5 * time.Second
+
+// KeyFilePatternsPath lists the key files charon-key-read-keys reads besides
+// DefaultKeyFilePatterns, one AuthorizedKeysFile-style pattern per line
+// (e.g. those of --authorized-keys-file and --existing-keys-file); it must
+// be owned by root and writable only by root
+const KeyFilePatternsPath = "/etc/charon-key/read-keys.conf"
+
+// DefaultKeyFilePatterns are the key files sshd reads by default, which
+// charon-key-read-keys always reads
+var DefaultKeyFilePatterns = []string{".ssh/authorized_keys", ".ssh/authorized_keys2"}
+
+// SetUnprivileged makes the manager read key files it has no permission to
+// open, e.g. other users' authorized_keys when charon-key runs as a
+// dedicated user, through helper (charon-key-read-keys, given
+// CAP_DAC_READ_SEARCH); with no helper, such files are skipped as if missing
+func (m *Manager) SetUnprivileged(helper string) {
+	m.unprivileged = true
+	m.readHelper = helper
+}
+
+// readKeyFile reads a key file like the package-level readKeyFile, falling
+// back to the read helper in unprivileged mode
+func (m *Manager) readKeyFile(path string, include func(managed bool) bool) ([]string, error) {
+	keys, err := readKeyFile(path, include)
+	if !m.unprivileged || !errors.Is(err, os.ErrPermission) {
+		return keys, err
+	}
+	if m.readHelper == "" {
+		return nil, fmt.Errorf("skipped unreadable key file: %w", os.ErrNotExist)
+	}
+	return readKeyFileWithHelper(m.readHelper, m.username, path, include)
+}
+
+// readKeyFileWithHelper reads a key file of username through a read helper,
+// which prints it and exits 2 if it doesn't exist
+func readKeyFileWithHelper(helper, username, path string, include func(managed bool) bool) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), readHelperTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, helper, username, path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 2 {
+			return nil, fmt.Errorf("failed to open key file: %w", os.ErrNotExist)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("read helper %s failed: %s: %w", helper, msg, err)
+		}
+		return nil, fmt.Errorf("read helper %s failed: %w", helper, err)
+	}
+	return parseKeyFile(&stdout, include)
+}
+
+// LoadKeyFilePatterns reads the key file patterns of charon-key-read-keys
+// (see KeyFilePatternsPath), refusing a file anyone but root could have
+// written; a missing file lists none
+func LoadKeyFilePatterns(path string) ([]string, error) {
+	file, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key file patterns: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat key file patterns: %w", err)
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !info.Mode().IsRegular() || !ok || stat.Uid != 0 || info.Mode().Perm()&0022 != 0 {
+		return nil, fmt.Errorf("refusing %s: must be a regular file owned and only writable by root", path)
+	}
+	return parseKeyFilePatterns(file)
+}
+
+// parseKeyFilePatterns parses key file patterns, one per line; blank lines
+// and "#" comments are ignored
+func parseKeyFilePatterns(r io.Reader) ([]string, error) {
+	var patterns []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if line = strings.TrimSpace(line); line != "" {
+			patterns = append(patterns, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read key file patterns: %w", err)
+	}
+	return patterns, nil
+}
+
+// OpenUserKeyFile opens a key file of u for charon-key-read-keys, which can
+// read any file and so must only read key files: path must be the expansion
+// of DefaultKeyFilePatterns or patterns for u (with the ExpandPath tokens;
+// relative to the home directory), owned by u or root, and reached without
+// symbolic links below the home directory (see openUserDir)
+func OpenUserKeyFile(u *user.User, path string, patterns []string) (*os.File, error) {
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid %q of %s", u.Uid, u.Username)
+	}
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+		return nil, fmt.Errorf("refusing %s: not a clean absolute path", path)
+	}
+
+	m := &Manager{home: u.HomeDir, uid: uid, gid: -1}
+	allowed := false
+	for _, pattern := range append(append([]string{}, DefaultKeyFilePatterns...), patterns...) {
+		expanded := m.ExpandPath(pattern, u.Username)
+		if !filepath.IsAbs(expanded) {
+			expanded = filepath.Join(m.home, expanded)
+		}
+		if filepath.Clean(expanded) == path {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, fmt.Errorf("refusing %s: not a key file of %s", path, u.Username)
+	}
+
+	root, err := openUserDir(filepath.Dir(path), m.home, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer root.Close()
+	return openUserFile(root, filepath.Base(path), path, uid)
+}
@@ -0,0 +1,185 @@
+package ssh
+
+import (
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const testKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl a@example.com"
+
+// writeReadHelper writes a stand-in for charon-key-read-keys
+func writeReadHelper(t *testing.T, dir string) string {
+	t.Helper()
+	helper := filepath.Join(dir, "read-keys")
+	script := "#!/bin/sh\n[ \"$2\" != /fail ] || { echo boom >&2; exit 1; }\n[ -e \"$2\" ] || exit 2\ncat \"$2\"\n"
+	if err := os.WriteFile(helper, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return helper
+}
+
+func TestReadKeyFileWithHelper(t *testing.T) {
+	tmpDir := t.TempDir()
+	helper := writeReadHelper(t, tmpDir)
+	path := filepath.Join(tmpDir, "authorized_keys")
+	content := "# comment\n" + testKey + "\n" + ManagedBlockBegin + "\nssh-ed25519 MANAGED\n" + ManagedBlockEnd + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := readKeyFileWithHelper(helper, "alice", path, func(managed bool) bool { return !managed })
+	if err != nil || !reflect.DeepEqual(keys, []string{testKey}) {
+		t.Errorf("readKeyFileWithHelper() = %v, %v, want the unmanaged key", keys, err)
+	}
+	if _, err := readKeyFileWithHelper(helper, "alice", filepath.Join(tmpDir, "missing"), func(bool) bool { return true }); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("readKeyFileWithHelper() of a missing file error = %v, want os.ErrNotExist", err)
+	}
+	if _, err := readKeyFileWithHelper(helper, "alice", "/fail", func(bool) bool { return true }); err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Errorf("readKeyFileWithHelper() of a failing helper error = %v, want a failure", err)
+	}
+}
+
+func TestManager_SetUnprivileged(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read every file")
+	}
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, ".ssh", "authorized_keys")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(testKey+"\n"), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	manager := NewManagerWithPath(path)
+	if _, err := manager.ReadExistingKeys(); err == nil {
+		t.Error("ReadExistingKeys() of an unreadable file error = nil")
+	}
+
+	manager.SetUnprivileged("")
+	if keys, err := manager.ReadExistingKeys(); err != nil || len(keys) != 0 {
+		t.Errorf("ReadExistingKeys() without a helper = %v, %v, want the file skipped", keys, err)
+	}
+
+	// A helper running as the same user can't read the file either
+	manager.SetUnprivileged(writeReadHelper(t, tmpDir))
+	if _, err := manager.ReadExistingKeys(); err == nil {
+		t.Error("ReadExistingKeys() through a failing helper error = nil")
+	}
+}
+
+func TestParseKeyFilePatterns(t *testing.T) {
+	patterns, err := parseKeyFilePatterns(strings.NewReader("# charon-key\n\n/etc/ssh/keys/%u # site-wide\n  .ssh/extra_keys\n"))
+	if err != nil || !reflect.DeepEqual(patterns, []string{"/etc/ssh/keys/%u", ".ssh/extra_keys"}) {
+		t.Errorf("parseKeyFilePatterns() = %v, %v", patterns, err)
+	}
+}
+
+func TestOpenUserKeyFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	home := filepath.Join(tmpDir, "alice")
+	u := &user.User{Username: "alice", Uid: strconv.Itoa(os.Getuid()), HomeDir: home}
+	for _, dir := range []string{filepath.Join(home, ".ssh"), filepath.Join(home, "linked"), filepath.Join(tmpDir, "keys")} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, path := range []string{
+		filepath.Join(home, ".ssh", "authorized_keys"),
+		filepath.Join(home, ".bashrc"),
+		filepath.Join(home, "linked", "authorized_keys"),
+		filepath.Join(tmpDir, "keys", "alice"),
+		filepath.Join(tmpDir, "secret"),
+	} {
+		if err := os.WriteFile(path, []byte(testKey+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(tmpDir, "secret"), filepath.Join(home, ".ssh", "authorized_keys2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("linked", filepath.Join(home, ".linked")); err != nil {
+		t.Fatal(err)
+	}
+	// Only links root made are followed, and the user made these
+	if os.Geteuid() == 0 {
+		for _, link := range []string{filepath.Join(home, ".ssh", "authorized_keys2"), filepath.Join(home, ".linked")} {
+			if err := os.Lchown(link, 12345, -1); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	patterns := []string{filepath.Join(tmpDir, "keys", "%u"), ".linked/authorized_keys"}
+
+	tests := []struct {
+		name      string
+		path      string
+		wantError bool
+	}{
+		{name: "authorized_keys", path: filepath.Join(home, ".ssh", "authorized_keys")},
+		{name: "configured pattern", path: filepath.Join(tmpDir, "keys", "alice")},
+		{name: "another user's file", path: filepath.Join(tmpDir, "keys", "bob"), wantError: true},
+		{name: "not a key file", path: filepath.Join(home, ".bashrc"), wantError: true},
+		{name: "system file", path: "/etc/shadow", wantError: true},
+		{name: "unclean path", path: filepath.Join(home, ".ssh") + "/../.ssh/authorized_keys", wantError: true},
+		{name: "symlinked file", path: filepath.Join(home, ".ssh", "authorized_keys2"), wantError: true},
+		{name: "symlinked directory", path: filepath.Join(home, ".linked", "authorized_keys"), wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := OpenUserKeyFile(u, tt.path, patterns)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("OpenUserKeyFile() error = %v, wantError %v", err, tt.wantError)
+			}
+			if err == nil {
+				file.Close()
+			}
+		})
+	}
+
+	if err := os.Remove(filepath.Join(home, ".ssh", "authorized_keys")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenUserKeyFile(u, filepath.Join(home, ".ssh", "authorized_keys"), nil); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("OpenUserKeyFile() of a missing file error = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestOpenUserKeyFile_Owner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing owners needs root")
+	}
+	home := filepath.Join(t.TempDir(), "alice")
+	path := filepath.Join(home, ".ssh", "authorized_keys")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(testKey+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	u := &user.User{Username: "alice", Uid: "1000", HomeDir: home}
+
+	if err := os.Chown(path, 1000, -1); err != nil {
+		t.Fatal(err)
+	}
+	file, err := OpenUserKeyFile(u, path, nil)
+	if err != nil {
+		t.Fatalf("OpenUserKeyFile() of the user's file error = %v", err)
+	}
+	file.Close()
+
+	if err := os.Chown(path, 12345, -1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenUserKeyFile(u, path, nil); err == nil {
+		t.Error("OpenUserKeyFile() of another user's file error = nil")
+	}
+}
@@ -0,0 +1,45 @@
+package ssh
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// KeyWriter writes keys to an io.Writer as they arrive, one per line, so
+// large key sets don't have to be held in memory before output starts
+// Keys already written (compared without options and comment) are skipped
+type KeyWriter struct {
+	w     io.Writer
+	seen  map[string]bool
+	count int
+}
+
+// NewKeyWriter creates a KeyWriter writing to w
+func NewKeyWriter(w io.Writer) *KeyWriter {
+	return &KeyWriter{
+		w:    w,
+		seen: make(map[string]bool),
+	}
+}
+
+// Write writes one key unless it is empty or a duplicate
+func (kw *KeyWriter) Write(key string) error {
+	key = strings.TrimSpace(key)
+	normalized := normalizeKey(key)
+	if normalized == "" || kw.seen[normalized] {
+		return nil
+	}
+	kw.seen[normalized] = true
+
+	if _, err := io.WriteString(kw.w, key+"\n"); err != nil {
+		return fmt.Errorf("failed to write key: %w", err)
+	}
+	kw.count++
+	return nil
+}
+
+// Count returns the number of keys written
+func (kw *KeyWriter) Count() int {
+	return kw.count
+}
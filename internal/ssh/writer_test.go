@@ -0,0 +1,33 @@
+package ssh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestKeyWriter(t *testing.T) {
+	var buf strings.Builder
+	kw := NewKeyWriter(&buf)
+
+	keys := []string{
+		"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB local@example.com",
+		"",
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI github@example.com",
+		"ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB github-copy@example.com",
+		`restrict ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI`,
+	}
+	for _, key := range keys {
+		if err := kw.Write(key); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	want := "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAB local@example.com\n" +
+		"ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAI github@example.com\n"
+	if buf.String() != want {
+		t.Errorf("KeyWriter output = %q, want %q", buf.String(), want)
+	}
+	if kw.Count() != 2 {
+		t.Errorf("Count() = %d, want 2", kw.Count())
+	}
+}